@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// The line separating the subject from the body in a draft.
+const draftSeparator = "--- Write the text below this line, leave it empty to cancel ---"
+
+// Return the editor to use, from $VISUAL or $EDITOR.
+func editorCommand() string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if e := os.Getenv(env); e != "" {
+			return e
+		}
+	}
+	return "vi"
+}
+
+// Run the editor on a draft with a given subject. The terminal must
+// not be in raw mode.
+func editDraft(subject string) (string, error) {
+	f, err := os.CreateTemp("", "kom-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = fmt.Fprintf(f, "Subject: %s\n%s\n", subject, draftSeparator)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Run the editor through the shell, as $EDITOR may have
+	// arguments.
+	cmd := exec.Command("/bin/sh", "-c", editorCommand()+` "$1"`, "sh", f.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Running %s: %w", editorCommand(), err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	return string(b), err
+}
+
+// Errors from parseDraft.
+var (
+	errDraftEmpty     = errors.New("Empty text, not sent")
+	errDraftSeparator = errors.New("The separator line is missing, not sent")
+)
+
+// Split an edited draft into subject and body.
+func parseDraft(draft string) (string, string, error) {
+	ix := strings.Index(draft, draftSeparator)
+	if ix < 0 {
+		return "", "", errDraftSeparator
+	}
+
+	var subject string
+	for _, line := range strings.Split(draft[:ix], "\n") {
+		if s := strings.TrimPrefix(line, "Subject:"); s != line {
+			subject = strings.TrimSpace(s)
+		}
+	}
+
+	body := strings.TrimPrefix(draft[ix+len(draftSeparator):], "\n")
+	body = strings.TrimRight(body, " \t\n")
+	if body == "" {
+		return "", "", errDraftEmpty
+	}
+	return subject, body, nil
+}
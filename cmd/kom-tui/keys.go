@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A key press, either a character or one of the special keys below.
+type key rune
+
+const (
+	keyUp key = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEscape
+)
+
+const (
+	keyCtrlC     = key(3)
+	keyCtrlL     = key(12)
+	keyEnter     = key('\r')
+	keyCtrlU     = key(21)
+	keyBackspace = key(127)
+)
+
+// Escape sequences sent by the special keys, without the leading
+// escape. Terminals differ in what they send for Home and End.
+var escapeKeys = map[string]key{
+	"[A":  keyUp,
+	"[B":  keyDown,
+	"[C":  keyRight,
+	"[D":  keyLeft,
+	"OA":  keyUp,
+	"OB":  keyDown,
+	"OC":  keyRight,
+	"OD":  keyLeft,
+	"[5~": keyPageUp,
+	"[6~": keyPageDown,
+	"[H":  keyHome,
+	"[F":  keyEnd,
+	"OH":  keyHome,
+	"OF":  keyEnd,
+	"[1~": keyHome,
+	"[4~": keyEnd,
+	"[7~": keyHome,
+	"[8~": keyEnd,
+}
+
+// Split input from the terminal into key presses. An escape sequence
+// is expected to arrive in a single read, as it does in practice.
+func decodeKeys(b []byte) []key {
+	var rv []key
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			if k, n := decodeEscape(b[1:]); n > 0 {
+				rv = append(rv, k)
+				b = b[1+n:]
+				continue
+			}
+			rv = append(rv, keyEscape)
+			b = b[1:]
+			continue
+		}
+
+		r, n := utf8.DecodeRune(b)
+		switch r {
+		case '\n':
+			r = rune(keyEnter)
+		case 8:
+			r = rune(keyBackspace)
+		}
+		rv = append(rv, key(r))
+		b = b[n:]
+	}
+	return rv
+}
+
+// Decode the escape sequence at the start of b, returning the key and
+// the length of the sequence, or zero if it is not one we know.
+func decodeEscape(b []byte) (key, int) {
+	if len(b) < 2 || (b[0] != '[' && b[0] != 'O') {
+		return 0, 0
+	}
+	end := 1
+	for end < len(b) && end < 8 {
+		c := b[end]
+		end++
+		if c < '0' || c > '9' {
+			break
+		}
+	}
+	if k, ok := escapeKeys[string(b[:end])]; ok {
+		return k, end
+	}
+	return 0, 0
+}
+
+// A keyReader reads key presses from the terminal and sends them on a
+// channel. It can be paused, while another program uses the terminal.
+type keyReader struct {
+	term *terminal
+	keys chan key
+
+	// Held while reading, and while paused.
+	lock sync.Mutex
+}
+
+func newKeyReader(t *terminal) *keyReader {
+	kr := &keyReader{term: t, keys: make(chan key, 64)}
+	go kr.run()
+	return kr
+}
+
+func (kr *keyReader) run() {
+	defer close(kr.keys)
+
+	buf := make([]byte, 64)
+	for {
+		kr.lock.Lock()
+		ready, err := kr.term.waitInput(100 * time.Millisecond)
+		n := 0
+		if err == nil && ready {
+			n, err = kr.term.read(buf)
+		}
+		kr.lock.Unlock()
+
+		if err != nil {
+			return
+		}
+		for _, k := range decodeKeys(buf[:n]) {
+			kr.keys <- k
+		}
+	}
+}
+
+// Stop reading keys, until resume is called.
+func (kr *keyReader) pause() {
+	kr.lock.Lock()
+}
+
+func (kr *keyReader) resume() {
+	kr.lock.Unlock()
+}
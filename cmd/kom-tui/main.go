@@ -0,0 +1,101 @@
+// kom-tui is a full-screen terminal client for LysKOM, with the
+// classic flow of reading the next unread text, comments first, and
+// going on to the next conference when done.
+//
+// Usage:
+//
+//	kom-tui [-server host:port] [-user name] [-invisible] [-log file]
+//
+// The server, user and password may also be given in the environment,
+// as KOM_SERVER, KOM_USER and KOM_PASSWORD. Without a password, it is
+// asked for. Press ? in the client for the keys.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+)
+
+// Return the value of a flag, or of an environment variable if the
+// flag is not set.
+func flagOrEnv(flagValue, env string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv(env)
+}
+
+func main() {
+	server := flag.String("server", "", "The LysKOM server, as host or host:port, default $KOM_SERVER or localhost")
+	user := flag.String("user", "", "The person to log in as, default $KOM_USER")
+	invisible := flag.Bool("invisible", false, "Log in invisibly")
+	logFile := flag.String("log", "", "File to log to, as logging to the terminal would mess up the screen")
+	flag.Parse()
+
+	log.SetOutput(io.Discard)
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kom-tui: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+		log.SetLevel(log.DebugLevel)
+	}
+
+	addr := flagOrEnv(*server, "KOM_SERVER")
+	if addr == "" {
+		addr = "localhost"
+	}
+	if !strings.Contains(addr, ":") {
+		addr += ":4894"
+	}
+	name := flagOrEnv(*user, "KOM_USER")
+	if name == "" {
+		fmt.Fprintf(os.Stderr, "kom-tui: no user given, use -user or KOM_USER\n")
+		os.Exit(2)
+	}
+
+	term, err := openTerminal(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kom-tui: %v\n", err)
+		os.Exit(1)
+	}
+
+	password := os.Getenv("KOM_PASSWORD")
+	if password == "" {
+		fmt.Printf("Password for %s: ", name)
+		password, err = term.readPassword()
+		fmt.Println()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kom-tui: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	k, err := protocol.NewKomClient(addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kom-tui: %v\n", err)
+		os.Exit(1)
+	}
+	defer k.Close()
+	if err := k.Login(name, password, *invisible); err != nil {
+		fmt.Fprintf(os.Stderr, "kom-tui: logging in as %s: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	err = newUI(k, term).run()
+	k.Logout()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kom-tui: %v\n", err)
+		os.Exit(1)
+	}
+}
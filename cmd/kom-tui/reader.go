@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The reader keeps track of where we are: the conference being read,
+// its unread texts, and the comments to read before going on with the
+// rest of the conference.
+type reader struct {
+	k      *protocol.KomClient
+	engine *protocol.UnreadEngine
+
+	confs  []types.ConfNo
+	confIx int
+	unread []protocol.UnreadText
+
+	// Unread comments and footnotes of the texts read, a stack, so
+	// that a whole comment chain is read before going on.
+	comments []protocol.UnreadText
+
+	// The text last shown, and what we know about it.
+	current *textView
+}
+
+// A text, as shown on the screen.
+type textView struct {
+	no          types.TextNo
+	stat        types.TextStat
+	text        types.Text
+	recipients  []types.MiscInfo
+	commentTo   []types.TextNo
+	footnoteTo  []types.TextNo
+	commentedIn []types.TextNo
+	footnotedIn []types.TextNo
+}
+
+func newReader(k *protocol.KomClient) *reader {
+	return &reader{
+		k:      k,
+		engine: k.UnreadEngine(),
+		confIx: -1,
+	}
+}
+
+// Return the name of a person or conference.
+func (r *reader) name(conf types.ConfNo) string {
+	if conf == 0 {
+		return "everyone"
+	}
+	name, err := r.k.ConfName(conf)
+	if err != nil {
+		return fmt.Sprintf("Conference %d (unknown)", conf)
+	}
+	return name
+}
+
+// Return the conference being read, or zero.
+func (r *reader) conf() types.ConfNo {
+	if r.confIx < 0 || r.confIx >= len(r.confs) {
+		return 0
+	}
+	return r.confs[r.confIx]
+}
+
+// Return the number of unread texts left in the current conference.
+func (r *reader) unreadCount() int {
+	rv := 0
+	for _, u := range r.unread {
+		if !r.engine.IsRead(u) {
+			rv++
+		}
+	}
+	return rv
+}
+
+// Go to the next conference with unread texts, after the current one,
+// starting over from the first membership if needed. Returns false if
+// there are no unread texts anywhere.
+func (r *reader) nextConf() (bool, error) {
+	if err := r.engine.Flush(); err != nil {
+		return false, err
+	}
+
+	if r.confIx < 0 || r.confIx >= len(r.confs)-1 {
+		// Read the memberships again at the end of the list, to
+		// find conferences joined since.
+		memberships, err := r.engine.Memberships()
+		if err != nil {
+			return false, err
+		}
+		current := r.conf()
+		r.confs = r.confs[:0]
+		r.confIx = -1
+		for _, m := range memberships {
+			r.confs = append(r.confs, m.Conference)
+			if m.Conference == current {
+				r.confIx = len(r.confs) - 1
+			}
+		}
+	}
+
+	for n := 0; n < len(r.confs); n++ {
+		ix := (r.confIx + 1 + n) % len(r.confs)
+		unread, err := r.engine.UnreadIn(r.confs[ix])
+		if err != nil {
+			return false, err
+		}
+		if len(unread) > 0 {
+			r.confIx = ix
+			r.unread = unread
+			r.comments = nil
+			return true, nil
+		}
+	}
+
+	r.unread = nil
+	r.comments = nil
+	return false, nil
+}
+
+// Go to a conference, even if it has no unread texts.
+func (r *reader) gotoConf(conf types.ConfNo) error {
+	if err := r.engine.Flush(); err != nil {
+		return err
+	}
+	unread, err := r.engine.UnreadIn(conf)
+	if err != nil {
+		return err
+	}
+
+	r.confIx = -1
+	for ix, c := range r.confs {
+		if c == conf {
+			r.confIx = ix
+		}
+	}
+	if r.confIx < 0 {
+		r.confs = append(r.confs, conf)
+		r.confIx = len(r.confs) - 1
+	}
+	r.unread = unread
+	r.comments = nil
+	return nil
+}
+
+// Return the next text to read in the current conference, unread
+// comments to the texts already read first.
+func (r *reader) next() (protocol.UnreadText, bool) {
+	for len(r.comments) > 0 {
+		u := r.comments[len(r.comments)-1]
+		r.comments = r.comments[:len(r.comments)-1]
+		if !r.engine.IsRead(u) {
+			return u, true
+		}
+	}
+	for _, u := range r.unread {
+		if !r.engine.IsRead(u) {
+			return u, true
+		}
+	}
+	return protocol.UnreadText{}, false
+}
+
+// Show an unread text, marking it as read and queueing its unread
+// comments.
+func (r *reader) read(u protocol.UnreadText) (*textView, error) {
+	tv, err := r.show(u.GlobalNo)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.engine.MarkRead(u); err != nil {
+		return tv, err
+	}
+
+	// Footnotes are read before comments, each in order. The
+	// stack is popped from the end.
+	order := append(append([]types.TextNo{}, tv.footnotedIn...), tv.commentedIn...)
+	for ix := len(order) - 1; ix >= 0; ix-- {
+		for _, c := range r.unread {
+			if c.GlobalNo == order[ix] && !r.engine.IsRead(c) {
+				r.comments = append(r.comments, c)
+			}
+		}
+	}
+	return tv, nil
+}
+
+// Fetch a text for showing, making it the current text.
+func (r *reader) show(no types.TextNo) (*textView, error) {
+	stat, err := r.k.GetTextStat(no)
+	if err != nil {
+		return nil, err
+	}
+	text, err := r.k.GetTextContent(no)
+	if err != nil {
+		return nil, err
+	}
+
+	tv := &textView{no: no, stat: stat, text: text}
+	for _, mi := range stat.MiscInfo {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient, types.CCRecipient, types.BCCRecipient:
+			tv.recipients = append(tv.recipients, mi)
+		case types.LocalNo:
+			if n := len(tv.recipients); n > 0 {
+				tv.recipients[n-1].LocalNo = mi.LocalNo
+			}
+		case types.CommentTo:
+			tv.commentTo = append(tv.commentTo, mi.CommentTo)
+		case types.FootnoteTo:
+			tv.footnoteTo = append(tv.footnoteTo, mi.FootnoteTo)
+		case types.CommentIn:
+			tv.commentedIn = append(tv.commentedIn, mi.CommentedIn)
+		case types.FootnoteIn:
+			tv.footnotedIn = append(tv.footnotedIn, mi.FootnotedIn)
+		}
+	}
+	r.current = tv
+	return tv, nil
+}
+
+// Return the lines showing a text.
+func (r *reader) format(tv *textView) []string {
+	rv := []string{
+		fmt.Sprintf("%d %s /%d lines/ %s", tv.no, tv.stat.CreationTime.Format("2006-01-02 15:04"), tv.stat.Lines, r.name(tv.stat.Author)),
+	}
+	for _, no := range tv.commentTo {
+		rv = append(rv, fmt.Sprintf("Comment to text %d", no))
+	}
+	for _, no := range tv.footnoteTo {
+		rv = append(rv, fmt.Sprintf("Footnote to text %d", no))
+	}
+	for _, mi := range tv.recipients {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient:
+			rv = append(rv, fmt.Sprintf("Recipient: %s <%d>", r.name(mi.Recipient), mi.LocalNo))
+		case types.CCRecipient:
+			rv = append(rv, fmt.Sprintf("CC recipient: %s <%d>", r.name(mi.CCRecipient), mi.LocalNo))
+		case types.BCCRecipient:
+			rv = append(rv, fmt.Sprintf("BCC recipient: %s <%d>", r.name(mi.BCCRecipient), mi.LocalNo))
+		}
+	}
+	rv = append(rv, "Subject: "+tv.text.Subject, "------------------------------------------------------------")
+	if tv.text.IsText() {
+		rv = append(rv, tv.text.PlainBody())
+	} else {
+		rv = append(rv, fmt.Sprintf("[%s, %d bytes]", tv.text.MediaType(), len(tv.text.Body)))
+	}
+	rv = append(rv, fmt.Sprintf("(%d) -----------------------------------", tv.no))
+	for _, no := range tv.commentedIn {
+		rv = append(rv, fmt.Sprintf("Comment in text %d", no))
+	}
+	for _, no := range tv.footnotedIn {
+		rv = append(rv, fmt.Sprintf("Footnote in text %d", no))
+	}
+	return rv
+}
+
+// Return the recipients a comment to a text should have.
+func (tv *textView) commentRecipients() []types.MiscInfo {
+	var rv []types.MiscInfo
+	for _, mi := range tv.recipients {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient:
+			rv = append(rv, types.RecipientMisc(mi.Recipient))
+		case types.CCRecipient:
+			rv = append(rv, types.CCRecipientMisc(mi.CCRecipient))
+		}
+	}
+	return rv
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/internal/komdtest"
+	"github.com/vatine/komandgo/pkg/komd"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The texts written by newTestReader.
+type testTexts struct {
+	author        types.ConfNo
+	film, books   types.ConfNo
+	first, second types.TextNo
+	comment       types.TextNo
+	book          types.TextNo
+}
+
+// Connect a client to srv over a net.Pipe, logged in as user.
+func testClient(t *testing.T, srv *komd.Server, user, password string) *protocol.KomClient {
+	t.Helper()
+
+	k, err := protocol.NewKomClientConn(komdtest.Pipe(srv))
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	if err := k.Login(user, password, false); err != nil {
+		t.Fatalf("unexpected error logging in as %s, %v", user, err)
+	}
+	return k
+}
+
+// Return a reader for Ingrid, with two texts and a comment to the
+// first of them unread in Filmklubben, and a text unread in
+// Bokklubben, a membership of lower priority. The comment is written
+// last, so it comes last in local number order.
+func newTestReader(t *testing.T) (*reader, testTexts) {
+	t.Helper()
+
+	store, ingrid, film := komdtest.NewStore()
+	var tt testTexts
+	var err error
+	tt.film = film
+	tt.author, err = store.CreatePerson("Humphrey Bogart", "casablanca")
+	if err == nil {
+		tt.books, err = store.CreateConf("Bokklubben", types.ExtendedConfType{}, tt.author)
+	}
+	if err == nil {
+		err = store.AddMember(tt.books, ingrid, 50)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	srv := komd.NewServer(store)
+	t.Cleanup(func() { srv.Close() })
+
+	w := testClient(t, srv, "humphrey", "casablanca")
+	write := func(contents string, misc ...types.MiscInfo) types.TextNo {
+		no, err := w.CreateText(contents, misc, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating a text, %v", err)
+		}
+		return no
+	}
+	tt.first = write("Casablanca\nPlay it, Sam.", types.RecipientMisc(film))
+	tt.second = write("Notorious\nA Hitchcock film.", types.RecipientMisc(film))
+	tt.comment = write("Casablanca\nAs time goes by.", types.RecipientMisc(film), types.CommentToMisc(tt.first))
+	tt.book = write("Gösta Berling\nA saga.", types.RecipientMisc(tt.books))
+
+	return newReader(testClient(t, srv, "ingrid", "secret")), tt
+}
+
+// Read everything, checking that comments are read before the rest of
+// the conference and that conferences are read in priority order.
+func TestReaderOrder(t *testing.T) {
+	r, tt := newTestReader(t)
+
+	steps := []struct {
+		conf  types.ConfNo
+		texts []types.TextNo
+	}{
+		{tt.film, []types.TextNo{tt.first, tt.comment, tt.second}},
+		{tt.books, []types.TextNo{tt.book}},
+	}
+	for _, step := range steps {
+		ok, err := r.nextConf()
+		if err != nil || !ok {
+			t.Fatalf("saw %v/%v going to the next conference, want %d", ok, err, step.conf)
+		}
+		if r.conf() != step.conf {
+			t.Errorf("saw conference %d, want %d", r.conf(), step.conf)
+		}
+		for ix, want := range step.texts {
+			if n := r.unreadCount(); n != len(step.texts)-ix {
+				t.Errorf("conference %d, saw %d unread, want %d", step.conf, n, len(step.texts)-ix)
+			}
+			u, ok := r.next()
+			if !ok || u.GlobalNo != want {
+				t.Fatalf("conference %d, saw text %d/%v, want %d", step.conf, u.GlobalNo, ok, want)
+			}
+			if _, err := r.read(u); err != nil {
+				t.Fatalf("unexpected error reading text %d, %v", want, err)
+			}
+		}
+		if u, ok := r.next(); ok {
+			t.Errorf("conference %d, saw text %d after the last one", step.conf, u.GlobalNo)
+		}
+		if n := r.unreadCount(); n != 0 {
+			t.Errorf("conference %d, saw %d unread after reading everything", step.conf, n)
+		}
+	}
+
+	if ok, err := r.nextConf(); ok || err != nil {
+		t.Errorf("saw %v/%v with nothing left to read, want false", ok, err)
+	}
+}
+
+// Going on to the next conference leaves unread comments behind, and
+// the conference is found again once the others have been read.
+func TestReaderNextConfWraps(t *testing.T) {
+	r, tt := newTestReader(t)
+
+	if ok, err := r.nextConf(); !ok || err != nil || r.conf() != tt.film {
+		t.Fatalf("saw %v/%v/%d, want conference %d", ok, err, r.conf(), tt.film)
+	}
+	u, _ := r.next()
+	if _, err := r.read(u); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(r.comments) != 1 || r.comments[0].GlobalNo != tt.comment {
+		t.Errorf("saw comment stack %+v, want text %d", r.comments, tt.comment)
+	}
+
+	if ok, err := r.nextConf(); !ok || err != nil || r.conf() != tt.books {
+		t.Fatalf("saw %v/%v/%d, want conference %d", ok, err, r.conf(), tt.books)
+	}
+	if len(r.comments) != 0 {
+		t.Errorf("saw comment stack %+v in a new conference, want it empty", r.comments)
+	}
+	u, _ = r.next()
+	if _, err := r.read(u); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if ok, err := r.nextConf(); !ok || err != nil || r.conf() != tt.film {
+		t.Fatalf("saw %v/%v/%d, want conference %d again", ok, err, r.conf(), tt.film)
+	}
+	if n := r.unreadCount(); n != 2 {
+		t.Errorf("saw %d unread, want 2", n)
+	}
+}
+
+// Texts are shown with the names of their author and recipients.
+func TestReaderFormat(t *testing.T) {
+	r, tt := newTestReader(t)
+
+	tv, err := r.show(tt.comment)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	got := strings.Join(r.format(tv), "\n")
+	for _, want := range []string{"Humphrey Bogart", "Recipient: Filmklubben", "Comment to text", "Subject: Casablanca", "As time goes by."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("saw %q, want it to contain %q", got, want)
+		}
+	}
+
+	if name := r.name(0); name != "everyone" {
+		t.Errorf("saw %q for conference 0, want everyone", name)
+	}
+	if name := r.name(4711); name != "Conference 4711 (unknown)" {
+		t.Errorf("saw %q for a missing conference", name)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences used for drawing.
+const (
+	escClear      = "\x1b[2J"
+	escClearLine  = "\x1b[K"
+	escReverse    = "\x1b[7m"
+	escBold       = "\x1b[1m"
+	escReset      = "\x1b[0m"
+	escHideCursor = "\x1b[?25l"
+	escShowCursor = "\x1b[?25h"
+	escAltScreen  = "\x1b[?1049h"
+	escMainScreen = "\x1b[?1049l"
+)
+
+// The number of lines of events shown at the bottom of the screen.
+const eventLines = 3
+
+// A screen is drawn as a status line, a pane with the text being
+// read, a few lines of events and a prompt line.
+type screen struct {
+	out    *bufio.Writer
+	width  int
+	height int
+}
+
+func newScreen(w io.Writer) *screen {
+	return &screen{out: bufio.NewWriter(w), width: 80, height: 24}
+}
+
+// Return the number of lines in the text pane.
+func (s *screen) paneHeight() int {
+	if h := s.height - eventLines - 3; h > 1 {
+		return h
+	}
+	return 1
+}
+
+// Move the cursor to a line and column, both counted from one.
+func (s *screen) moveTo(line, col int) {
+	fmt.Fprintf(s.out, "\x1b[%d;%dH", line, col)
+}
+
+// Draw a line, cut off at the screen width.
+func (s *screen) line(no int, attr, text string) {
+	s.moveTo(no, 1)
+	s.out.WriteString(attr)
+	s.out.WriteString(truncate(cleanLine(text), s.width))
+	s.out.WriteString(escClearLine)
+	if attr != "" {
+		s.out.WriteString(escReset)
+	}
+}
+
+// Draw a line in reverse video, padded to the full width.
+func (s *screen) bar(no int, text string) {
+	text = truncate(text, s.width)
+	s.line(no, escReverse, text+strings.Repeat(" ", s.width-utf8.RuneCountInString(text)))
+}
+
+// What to draw.
+type view struct {
+	status string
+	pane   []string
+	events []string
+	prompt string
+	input  bool
+}
+
+func (s *screen) draw(v view) {
+	s.out.WriteString(escHideCursor)
+	s.bar(1, v.status)
+
+	pane := s.paneHeight()
+	for ix := 0; ix < pane; ix++ {
+		text := ""
+		if ix < len(v.pane) {
+			text = v.pane[ix]
+		}
+		s.line(2+ix, "", text)
+	}
+
+	s.bar(2+pane, "")
+	for ix := 0; ix < eventLines; ix++ {
+		text := ""
+		if n := len(v.events) - eventLines + ix; n >= 0 && n < len(v.events) {
+			text = v.events[n]
+		}
+		s.line(3+pane+ix, "", text)
+	}
+
+	s.line(s.height, escBold, v.prompt)
+	if v.input {
+		s.moveTo(s.height, utf8.RuneCountInString(v.prompt)+1)
+		s.out.WriteString(escShowCursor)
+	}
+	s.out.Flush()
+}
+
+// Cut a string off at a number of characters.
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+// Break text into lines no wider than width, at spaces if possible.
+func wrap(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+
+	var rv []string
+	for _, line := range strings.Split(text, "\n") {
+		line = cleanLine(strings.TrimRight(line, "\r"))
+		for utf8.RuneCountInString(line) > width {
+			runes := []rune(line)
+			cut := width
+			for ix := width; ix > width/2; ix-- {
+				if runes[ix] == ' ' {
+					cut = ix
+					break
+				}
+			}
+			rv = append(rv, string(runes[:cut]))
+			line = strings.TrimLeft(string(runes[cut:]), " ")
+		}
+		rv = append(rv, line)
+	}
+	return rv
+}
+
+// Expand tabs to eight columns, and replace other control characters,
+// which would confuse the terminal.
+func cleanLine(s string) string {
+	var b strings.Builder
+	col := 0
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			n := 8 - col%8
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		case r < ' ' || r == 0x7f || (r >= 0x80 && r < 0xa0):
+			r = '?'
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
@@ -0,0 +1,45 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var errNoTerminal = errors.New("Terminal handling is not supported on this system")
+
+// A terminal, not supported on this system.
+type terminal struct{}
+
+func openTerminal(f *os.File) (*terminal, error) {
+	return nil, errNoTerminal
+}
+
+func (t *terminal) raw() error {
+	return errNoTerminal
+}
+
+func (t *terminal) restore() error {
+	return errNoTerminal
+}
+
+func (t *terminal) size() (int, int, error) {
+	return 0, 0, errNoTerminal
+}
+
+func (t *terminal) readPassword() (string, error) {
+	return "", errNoTerminal
+}
+
+func (t *terminal) waitInput(timeout time.Duration) (bool, error) {
+	return false, errNoTerminal
+}
+
+func (t *terminal) read(b []byte) (int, error) {
+	return 0, errNoTerminal
+}
+
+func notifyResize(c chan<- os.Signal) {
+}
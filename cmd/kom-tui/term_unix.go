@@ -0,0 +1,101 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// A terminal, which can be switched between raw mode and the mode it
+// was in when opened.
+type terminal struct {
+	fd  int
+	old unix.Termios
+}
+
+// Open the terminal on f, which must be a tty.
+func openTerminal(f *os.File) (*terminal, error) {
+	fd := int(f.Fd())
+	t, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	return &terminal{fd: fd, old: *t}, nil
+}
+
+// Switch to raw mode, where keys are read one at a time without echo
+// and without the terminal acting on ^C and ^Z.
+func (t *terminal) raw() error {
+	raw := t.old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(t.fd, ioctlSetTermios, &raw)
+}
+
+// Go back to the mode the terminal was in when opened.
+func (t *terminal) restore() error {
+	return unix.IoctlSetTermios(t.fd, ioctlSetTermios, &t.old)
+}
+
+// Return the width and height of the terminal.
+func (t *terminal) size() (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(t.fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// Read a line without echoing it, for passwords.
+func (t *terminal) readPassword() (string, error) {
+	noEcho := t.old
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	noEcho.Iflag |= unix.ICRNL
+	if err := unix.IoctlSetTermios(t.fd, ioctlSetTermios, &noEcho); err != nil {
+		return "", err
+	}
+	defer t.restore()
+
+	var rv []byte
+	b := make([]byte, 1)
+	for {
+		n, err := unix.Read(t.fd, b)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 || b[0] == '\n' {
+			return string(rv), nil
+		}
+		rv = append(rv, b[0])
+	}
+}
+
+// Wait at most timeout for the terminal to have input, returning true
+// if it has.
+func (t *terminal) waitInput(timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(t.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+	if err == unix.EINTR {
+		return false, nil
+	}
+	return n > 0, err
+}
+
+// Read whatever input the terminal has.
+func (t *terminal) read(b []byte) (int, error) {
+	return unix.Read(t.fd, b)
+}
+
+// Send a signal on c when the terminal changes size.
+func notifyResize(c chan<- os.Signal) {
+	signal.Notify(c, unix.SIGWINCH)
+}
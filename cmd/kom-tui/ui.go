@@ -0,0 +1,518 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The number of events kept, for the event log.
+const maxEvents = 200
+
+// How often to tell the server we are active (user-active, #82).
+const activeInterval = 30 * time.Second
+
+const helpText = `Keys:
+
+  SPACE      Scroll down, or do what the bottom line says
+  n          Read the next unread text, comments first
+  N          Go to the next conference with unread texts
+  g          Go to a conference, by name
+  t          Show a text, by number
+  r          Show the current text again
+  c          Write a comment to the current text
+  p          Post a new text in the current conference
+  m          Send a message to a person, a conference or everyone
+  w          List who is logged in
+  l          Show the event log
+  Up, Down, PgUp, PgDn, Home, End, j, k, b   Scroll
+  ^L         Redraw the screen
+  q, ^C      Quit
+
+Texts are written in $VISUAL or $EDITOR, vi if neither is set.`
+
+// The full-screen user interface.
+type ui struct {
+	k      *protocol.KomClient
+	term   *terminal
+	keys   *keyReader
+	scr    *screen
+	rd     *reader
+	async  <-chan protocol.AsyncMessage
+	resize chan os.Signal
+
+	// The contents of the pane, before and after wrapping, and the
+	// first line shown.
+	content []string
+	lines   []string
+	top     int
+
+	events     []string
+	prompt     string
+	input      bool
+	quit       bool
+	lastActive time.Time
+	// Why the connection to the server was lost, if it was
+	lost error
+}
+
+func newUI(k *protocol.KomClient, t *terminal) *ui {
+	return &ui{
+		k:      k,
+		term:   t,
+		scr:    newScreen(os.Stdout),
+		rd:     newReader(k),
+		resize: make(chan os.Signal, 1),
+	}
+}
+
+// Run the user interface until the user quits.
+func (u *ui) run() error {
+	if err := u.k.AcceptAsync(protocol.AsyncLoginNo, protocol.AsyncSendMessageNo, protocol.AsyncLogoutNo); err != nil {
+		return err
+	}
+	async, cancel := u.k.Subscribe()
+	defer cancel()
+	u.async = async
+
+	if err := u.term.raw(); err != nil {
+		return err
+	}
+	defer u.term.restore()
+	os.Stdout.WriteString(escAltScreen)
+	defer os.Stdout.WriteString(escShowCursor + escMainScreen)
+
+	notifyResize(u.resize)
+	u.updateSize()
+	u.keys = newKeyReader(u.term)
+
+	u.cmdNextConf()
+	for !u.quit {
+		k, ok := u.nextKey()
+		if !ok {
+			break
+		}
+		u.active()
+		u.handleKey(k)
+	}
+	if u.lost != nil {
+		return u.lost
+	}
+
+	return u.rd.engine.Flush()
+}
+
+// Wait for a key, handling asynchronous messages and resizes
+// meanwhile. Returns false when there are no more keys, or the
+// connection to the server is lost.
+func (u *ui) nextKey() (key, bool) {
+	for u.lost == nil {
+		u.draw()
+		select {
+		case k, ok := <-u.keys.keys:
+			return k, ok
+		case msg, ok := <-u.async:
+			if !ok {
+				// Only closed when the connection is lost
+				u.lost = u.k.Err()
+				continue
+			}
+			u.handleAsync(msg)
+		case <-u.resize:
+			u.updateSize()
+		}
+	}
+	return 0, false
+}
+
+// Tell the server we are active, at most every activeInterval.
+func (u *ui) active() {
+	if time.Since(u.lastActive) < activeInterval {
+		return
+	}
+	u.lastActive = time.Now()
+	u.k.UserActive()
+}
+
+func (u *ui) updateSize() {
+	if w, h, err := u.term.size(); err == nil && w > 0 && h > 0 {
+		u.scr.width, u.scr.height = w, h
+	}
+	os.Stdout.WriteString(escClear)
+	u.wrap()
+}
+
+func (u *ui) draw() {
+	conf := "No conference"
+	if c := u.rd.conf(); c != 0 {
+		conf = fmt.Sprintf("%s, %d unread", u.rd.name(c), u.rd.unreadCount())
+	}
+	status := fmt.Sprintf(" %s | %s | ? for help", u.rd.name(u.k.Person()), conf)
+
+	bottom := u.prompt
+	if !u.input {
+		bottom = "-- " + u.defaultAction() + " --"
+	}
+
+	end := u.top + u.scr.paneHeight()
+	if end > len(u.lines) {
+		end = len(u.lines)
+	}
+	u.scr.draw(view{
+		status: status,
+		pane:   u.lines[u.top:end],
+		events: u.events,
+		prompt: bottom,
+		input:  u.input,
+	})
+}
+
+// Show lines in the pane.
+func (u *ui) setPane(lines []string) {
+	u.content = lines
+	u.top = 0
+	u.wrap()
+}
+
+func (u *ui) wrap() {
+	u.lines = wrap(strings.Join(u.content, "\n"), u.scr.width)
+	u.scroll(0)
+}
+
+// Scroll the pane by n lines, keeping it within the text.
+func (u *ui) scroll(n int) {
+	u.top += n
+	if max := len(u.lines) - u.scr.paneHeight(); u.top > max {
+		u.top = max
+	}
+	if u.top < 0 {
+		u.top = 0
+	}
+}
+
+// Return true if there is more of the pane to see.
+func (u *ui) more() bool {
+	return u.top+u.scr.paneHeight() < len(u.lines)
+}
+
+// Add a line to the events shown at the bottom.
+func (u *ui) event(format string, args ...interface{}) {
+	msg := time.Now().Format("15:04") + " " + strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", " ")
+	u.events = append(u.events, msg)
+	if len(u.events) > maxEvents {
+		u.events = u.events[len(u.events)-maxEvents:]
+	}
+}
+
+func (u *ui) handleAsync(msg protocol.AsyncMessage) {
+	switch m := msg.(type) {
+	case protocol.AsyncSendMessage:
+		switch m.Recipient {
+		case 0:
+			u.event("Alarm from %s: %s", u.rd.name(m.Sender), m.Message)
+		case u.k.Person():
+			u.event("Message from %s: %s", u.rd.name(m.Sender), m.Message)
+			os.Stdout.WriteString("\a")
+		default:
+			u.event("Message from %s to %s: %s", u.rd.name(m.Sender), u.rd.name(m.Recipient), m.Message)
+		}
+	case protocol.AsyncLogin:
+		u.event("%s logged in", u.rd.name(m.Person))
+	case protocol.AsyncLogout:
+		u.event("%s logged out", u.rd.name(m.Person))
+	}
+}
+
+// Describe what SPACE does.
+func (u *ui) defaultAction() string {
+	switch {
+	case u.more():
+		return "Scroll down"
+	case u.rd.conf() == 0:
+		return "Look for unread texts"
+	case len(u.rd.comments) > 0:
+		return "Read the next comment"
+	case u.rd.unreadCount() > 0:
+		return "Read the next text"
+	}
+	return "Go to the next conference"
+}
+
+func (u *ui) handleKey(k key) {
+	page := u.scr.paneHeight() - 1
+	switch k {
+	case ' ':
+		if u.more() {
+			u.scroll(page)
+		} else {
+			u.cmdNext()
+		}
+	case 'n':
+		u.cmdNext()
+	case 'N':
+		u.cmdNextConf()
+	case 'g':
+		u.cmdGoto()
+	case 't':
+		u.cmdText()
+	case 'r':
+		if u.rd.current != nil {
+			u.setPane(u.rd.format(u.rd.current))
+		}
+	case 'c':
+		u.cmdComment()
+	case 'p':
+		u.cmdPost()
+	case 'm':
+		u.cmdMessage()
+	case 'w':
+		u.cmdWho()
+	case 'l':
+		u.setPane(append([]string{"Events:", ""}, u.events...))
+		u.scroll(len(u.lines))
+	case '?', 'h':
+		u.setPane(strings.Split(helpText, "\n"))
+	case keyDown, 'j', keyEnter:
+		u.scroll(1)
+	case keyUp, 'k':
+		u.scroll(-1)
+	case keyPageDown:
+		u.scroll(page)
+	case keyPageUp, 'b':
+		u.scroll(-page)
+	case keyHome:
+		u.scroll(-len(u.lines))
+	case keyEnd:
+		u.scroll(len(u.lines))
+	case keyCtrlL:
+		u.updateSize()
+	case 'q', keyCtrlC:
+		u.quit = true
+	}
+}
+
+// Read the next unread text, going to the next conference when there
+// are none left.
+func (u *ui) cmdNext() {
+	next, ok := u.rd.next()
+	if !ok {
+		u.cmdNextConf()
+		return
+	}
+	tv, err := u.rd.read(next)
+	if tv != nil {
+		u.setPane(u.rd.format(tv))
+	}
+	if err != nil {
+		u.event("Reading text %d: %v", next.GlobalNo, err)
+	}
+}
+
+func (u *ui) cmdNextConf() {
+	ok, err := u.rd.nextConf()
+	switch {
+	case err != nil:
+		u.event("Looking for unread texts: %v", err)
+	case !ok:
+		u.setPane([]string{"You have no unread texts."})
+	default:
+		u.setPane([]string{fmt.Sprintf("%s, %d unread texts.", u.rd.name(u.rd.conf()), u.rd.unreadCount())})
+	}
+}
+
+func (u *ui) cmdGoto() {
+	name, ok := u.readLine("Go to conference: ")
+	if !ok || name == "" {
+		return
+	}
+	conf, err := u.resolve(name)
+	if err != nil {
+		u.event("%s: %v", name, err)
+		return
+	}
+	if err := u.rd.gotoConf(conf); err != nil {
+		u.event("Going to %s: %v", u.rd.name(conf), err)
+		return
+	}
+	u.setPane([]string{fmt.Sprintf("%s, %d unread texts.", u.rd.name(conf), u.rd.unreadCount())})
+}
+
+func (u *ui) cmdText() {
+	s, ok := u.readLine("Show text: ")
+	if !ok || s == "" {
+		return
+	}
+	no, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || no == 0 {
+		u.event("Bad text number %q", s)
+		return
+	}
+	tv, err := u.rd.show(types.TextNo(no))
+	if err != nil {
+		u.event("Text %d: %v", no, err)
+		return
+	}
+	u.setPane(u.rd.format(tv))
+}
+
+func (u *ui) cmdComment() {
+	tv := u.rd.current
+	if tv == nil {
+		u.event("There is no text to comment")
+		return
+	}
+	misc := append(tv.commentRecipients(), types.CommentToMisc(tv.no))
+	u.write(tv.text.Subject, misc)
+}
+
+func (u *ui) cmdPost() {
+	def := u.rd.conf()
+	label := "Post to: "
+	if def != 0 {
+		label = fmt.Sprintf("Post to (%s): ", u.rd.name(def))
+	}
+	name, ok := u.readLine(label)
+	if !ok {
+		return
+	}
+	conf := def
+	if name != "" {
+		var err error
+		if conf, err = u.resolve(name); err != nil {
+			u.event("%s: %v", name, err)
+			return
+		}
+	}
+	if conf == 0 {
+		return
+	}
+	u.write("", []types.MiscInfo{types.RecipientMisc(conf)})
+}
+
+// Edit and create a text.
+func (u *ui) write(subject string, misc []types.MiscInfo) {
+	draft, err := u.suspend(func() (string, error) {
+		return editDraft(subject)
+	})
+	if err != nil {
+		u.event("%v", err)
+		return
+	}
+	subject, body, err := parseDraft(draft)
+	if err != nil {
+		u.event("%v", err)
+		return
+	}
+
+	no, err := u.k.CreateTextContent(types.NewText(subject, body, "", ""), misc, nil)
+	if err != nil {
+		u.event("Creating the text: %v", err)
+		return
+	}
+	u.event("Text %d created", no)
+}
+
+// Give the terminal to another program while f runs.
+func (u *ui) suspend(f func() (string, error)) (string, error) {
+	u.keys.pause()
+	defer u.keys.resume()
+
+	os.Stdout.WriteString(escShowCursor + escMainScreen)
+	u.term.restore()
+	rv, err := f()
+	u.term.raw()
+	os.Stdout.WriteString(escAltScreen)
+	u.updateSize()
+	return rv, err
+}
+
+func (u *ui) cmdMessage() {
+	name, ok := u.readLine("Send message to (empty for everyone): ")
+	if !ok {
+		return
+	}
+	var to types.ConfNo
+	if name != "" {
+		var err error
+		if to, err = u.resolve(name); err != nil {
+			u.event("%s: %v", name, err)
+			return
+		}
+	}
+
+	msg, ok := u.readLine(fmt.Sprintf("Message to %s: ", u.rd.name(to)))
+	if !ok || msg == "" {
+		return
+	}
+	if err := u.k.SendMessageTo(to, msg); err != nil {
+		u.event("Sending the message: %v", err)
+		return
+	}
+	u.event("Message sent to %s", u.rd.name(to))
+}
+
+func (u *ui) cmdWho() {
+	sessions, err := u.k.WhoIsOnDynamic(true, false, 0)
+	if err != nil {
+		u.event("Listing sessions: %v", err)
+		return
+	}
+
+	lines := []string{fmt.Sprintf("%-8s %-30s %-10s %s", "Session", "Person", "Idle", "Doing"), ""}
+	for _, s := range sessions {
+		name := "(not logged in)"
+		if s.Peron != 0 {
+			name = u.rd.name(s.Peron)
+		}
+		idle := time.Duration(s.IdleTime) * time.Second
+		lines = append(lines, fmt.Sprintf("%-8d %-30s %-10s %s", s.Session, name, idle, s.WhatAmIDoing))
+	}
+	lines = append(lines, "", fmt.Sprintf("%d sessions.", len(sessions)))
+	u.setPane(lines)
+}
+
+// Resolve a person or conference given by number or name.
+func (u *ui) resolve(name string) (types.ConfNo, error) {
+	if n, err := strconv.ParseUint(name, 10, 16); err == nil {
+		return types.ConfNo(n), nil
+	}
+	return u.k.ResolveName(name, true, true)
+}
+
+// Read a line on the bottom line, returning false if cancelled with
+// escape or ^C.
+func (u *ui) readLine(label string) (string, bool) {
+	u.input = true
+	defer func() {
+		u.input = false
+		u.prompt = ""
+	}()
+
+	var line []rune
+	for {
+		u.prompt = label + string(line)
+		k, ok := u.nextKey()
+		if !ok {
+			return "", false
+		}
+		switch {
+		case k == keyEnter:
+			return strings.TrimSpace(string(line)), true
+		case k == keyEscape || k == keyCtrlC:
+			return "", false
+		case k == keyBackspace:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case k == keyCtrlU:
+			line = line[:0]
+		case k >= ' ':
+			line = append(line, rune(k))
+		}
+	}
+}
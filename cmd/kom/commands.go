@@ -0,0 +1,741 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/export"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The time format used in text output.
+const timeFormat = "2006-01-02 15:04"
+
+func runLogin(c *cli, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageOf("login")
+	}
+
+	k, err := c.connect(true)
+	if err != nil {
+		return err
+	}
+	person := k.Person()
+	rv := struct {
+		Person types.ConfNo `json:"person"`
+		Name   string       `json:"name"`
+	}{person, c.name(person)}
+
+	return c.output(rv, func(w io.Writer) {
+		fmt.Fprintf(w, "Logged in as %s (person %d)\n", rv.Name, rv.Person)
+	})
+}
+
+// A session, as printed by who.
+type sessionOutput struct {
+	Session        types.SessionNo `json:"session"`
+	Person         types.ConfNo    `json:"person"`
+	Name           string          `json:"name"`
+	Conference     types.ConfNo    `json:"conference,omitempty"`
+	ConferenceName string          `json:"conference-name,omitempty"`
+	Idle           uint32          `json:"idle"`
+	Invisible      bool            `json:"invisible,omitempty"`
+	WhatAmIDoing   string          `json:"what-am-i-doing,omitempty"`
+}
+
+func runWho(c *cli, args []string) error {
+	fs := flag.NewFlagSet("who", flag.ContinueOnError)
+	all := fs.Bool("all", false, "Include invisible sessions")
+	active := fs.Uint("active", 0, "Only list sessions active in the last this many seconds")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return usageOf("who")
+	}
+
+	k, err := c.connect(false)
+	if err != nil {
+		return err
+	}
+	sessions, err := k.WhoIsOnDynamic(true, *all, uint32(*active))
+	if err != nil {
+		return err
+	}
+
+	rv := make([]sessionOutput, 0, len(sessions))
+	for _, s := range sessions {
+		out := sessionOutput{
+			Session:      s.Session,
+			Person:       s.Peron,
+			Idle:         s.IdleTime,
+			Invisible:    s.Flags.Invisible,
+			WhatAmIDoing: s.WhatAmIDoing,
+		}
+		if s.Peron != 0 {
+			out.Name = c.name(s.Peron)
+		}
+		if s.WorkingConference != 0 {
+			out.Conference = s.WorkingConference
+			out.ConferenceName = c.name(s.WorkingConference)
+		}
+		rv = append(rv, out)
+	}
+
+	return c.output(rv, func(w io.Writer) {
+		fmt.Fprintf(w, "SESSION\tPERSON\tIDLE\tCONFERENCE\tDOING\n")
+		for _, s := range rv {
+			name := s.Name
+			if s.Person == 0 {
+				name = "(not logged in)"
+			}
+			if s.Invisible {
+				name += " (invisible)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", s.Session, name, time.Duration(s.Idle)*time.Second, s.ConferenceName, s.WhatAmIDoing)
+		}
+	})
+}
+
+// A recipient of a text.
+type recipientOutput struct {
+	Type       string       `json:"type"`
+	Conference types.ConfNo `json:"conference"`
+	Name       string       `json:"name"`
+	LocalNo    types.TextNo `json:"local-no,omitempty"`
+}
+
+// A text, as printed by read and stat.
+type textOutput struct {
+	TextNo      types.TextNo      `json:"text"`
+	Created     time.Time         `json:"created"`
+	Author      types.ConfNo      `json:"author"`
+	AuthorName  string            `json:"author-name"`
+	Lines       uint32            `json:"lines"`
+	Chars       uint32            `json:"chars"`
+	Marks       uint16            `json:"marks"`
+	Recipients  []recipientOutput `json:"recipients"`
+	CommentTo   []types.TextNo    `json:"comment-to,omitempty"`
+	CommentedIn []types.TextNo    `json:"commented-in,omitempty"`
+	FootnoteTo  []types.TextNo    `json:"footnote-to,omitempty"`
+	FootnotedIn []types.TextNo    `json:"footnoted-in,omitempty"`
+	ContentType string            `json:"content-type,omitempty"`
+	Subject     string            `json:"subject"`
+	Body        string            `json:"body,omitempty"`
+}
+
+// Collect what is printed about a text, fetching the contents if
+// withBody is true.
+func (c *cli) text(k *protocol.KomClient, no types.TextNo, withBody bool) (textOutput, error) {
+	stat, err := k.GetTextStat(no)
+	if err != nil {
+		return textOutput{}, err
+	}
+	// Only fetch the subject, unless the body is wanted.
+	var content types.Text
+	if withBody {
+		content, err = k.GetTextContent(no)
+	} else {
+		if item, ok := types.FindAuxItem(stat.AuxItems, types.AuxContentType); ok {
+			content.ContentType = item.Data()
+		}
+		content.Subject, err = k.GetSubject(no, stat)
+	}
+	if err != nil {
+		return textOutput{}, err
+	}
+
+	rv := textOutput{
+		TextNo:      no,
+		Created:     stat.CreationTime,
+		Author:      stat.Author,
+		AuthorName:  c.name(stat.Author),
+		Lines:       stat.Lines,
+		Chars:       stat.Chars,
+		Marks:       stat.Marks,
+		Recipients:  []recipientOutput{},
+		ContentType: content.MediaType(),
+		Subject:     content.Subject,
+	}
+	if withBody {
+		rv.Body = content.PlainBody()
+	}
+
+	for _, mi := range stat.MiscInfo {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient:
+			rv.Recipients = append(rv.Recipients, recipientOutput{Type: "recipient", Conference: mi.Recipient, Name: c.name(mi.Recipient)})
+		case types.CCRecipient:
+			rv.Recipients = append(rv.Recipients, recipientOutput{Type: "cc-recipient", Conference: mi.CCRecipient, Name: c.name(mi.CCRecipient)})
+		case types.BCCRecipient:
+			rv.Recipients = append(rv.Recipients, recipientOutput{Type: "bcc-recipient", Conference: mi.BCCRecipient, Name: c.name(mi.BCCRecipient)})
+		case types.LocalNo:
+			if n := len(rv.Recipients); n > 0 {
+				rv.Recipients[n-1].LocalNo = mi.LocalNo
+			}
+		case types.CommentTo:
+			rv.CommentTo = append(rv.CommentTo, mi.CommentTo)
+		case types.CommentIn:
+			rv.CommentedIn = append(rv.CommentedIn, mi.CommentedIn)
+		case types.FootnoteTo:
+			rv.FootnoteTo = append(rv.FootnoteTo, mi.FootnoteTo)
+		case types.FootnoteIn:
+			rv.FootnotedIn = append(rv.FootnotedIn, mi.FootnotedIn)
+		}
+	}
+
+	return rv, nil
+}
+
+// Print a text the way LysKOM clients traditionally do.
+func printText(w io.Writer, t textOutput) {
+	fmt.Fprintf(w, "%d %s /%d lines/ %s\n", t.TextNo, t.Created.Format(timeFormat), t.Lines, t.AuthorName)
+	for _, no := range t.CommentTo {
+		fmt.Fprintf(w, "Comment to text %d\n", no)
+	}
+	for _, no := range t.FootnoteTo {
+		fmt.Fprintf(w, "Footnote to text %d\n", no)
+	}
+	for _, r := range t.Recipients {
+		label := "Recipient"
+		switch r.Type {
+		case "cc-recipient":
+			label = "CC recipient"
+		case "bcc-recipient":
+			label = "BCC recipient"
+		}
+		fmt.Fprintf(w, "%s: %s <%d>\n", label, r.Name, r.LocalNo)
+	}
+	fmt.Fprintf(w, "Subject: %s\n", t.Subject)
+	fmt.Fprintf(w, "%s\n", strings.Repeat("-", 60))
+	if t.Body != "" {
+		fmt.Fprintf(w, "%s\n", strings.TrimRight(t.Body, "\n"))
+	}
+	fmt.Fprintf(w, "(%d) %s\n", t.TextNo, strings.Repeat("-", 50))
+	for _, no := range t.CommentedIn {
+		fmt.Fprintf(w, "Comment in text %d\n", no)
+	}
+	for _, no := range t.FootnotedIn {
+		fmt.Fprintf(w, "Footnote in text %d\n", no)
+	}
+}
+
+func runRead(c *cli, args []string) error {
+	fs := flag.NewFlagSet("read", flag.ContinueOnError)
+	max := fs.Int("n", 0, "Read at most this many texts, zero for all")
+	keep := fs.Bool("keep", false, "Do not mark the texts as read")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageOf("read")
+	}
+
+	k, err := c.connect(true)
+	if err != nil {
+		return err
+	}
+	conf, err := c.resolve(fs.Arg(0), true, true)
+	if err != nil {
+		return err
+	}
+
+	engine := k.UnreadEngine()
+	unread, err := engine.UnreadIn(conf)
+	if err != nil {
+		return err
+	}
+	if *max > 0 && len(unread) > *max {
+		unread = unread[:*max]
+	}
+
+	rv := make([]textOutput, 0, len(unread))
+	for _, u := range unread {
+		t, err := c.text(k, u.GlobalNo, true)
+		if err != nil {
+			return fmt.Errorf("Reading text %d: %w", u.GlobalNo, err)
+		}
+		rv = append(rv, t)
+		if !*keep {
+			if err := engine.MarkRead(u); err != nil {
+				return err
+			}
+		}
+	}
+	if err := engine.Flush(); err != nil {
+		return err
+	}
+
+	return c.output(rv, func(w io.Writer) {
+		for ix, t := range rv {
+			if ix > 0 {
+				fmt.Fprintln(w)
+			}
+			printText(w, t)
+		}
+	})
+}
+
+func runPost(c *cli, args []string) error {
+	fs := flag.NewFlagSet("post", flag.ContinueOnError)
+	to := fs.String("to", "", "Recipients, comma-separated")
+	cc := fs.String("cc", "", "CC recipients, comma-separated")
+	subject := fs.String("subject", "", "The subject")
+	commentTo := fs.Uint("comment-to", 0, "The text this is a comment to")
+	body := fs.String("body", "", "The body, read from stdin if not given")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || (*to == "" && *commentTo == 0) {
+		return usageOf("post")
+	}
+
+	k, err := c.connect(true)
+	if err != nil {
+		return err
+	}
+
+	var misc []types.MiscInfo
+	for _, name := range splitList(*to) {
+		conf, err := c.resolve(name, true, true)
+		if err != nil {
+			return err
+		}
+		misc = append(misc, types.RecipientMisc(conf))
+	}
+	for _, name := range splitList(*cc) {
+		conf, err := c.resolve(name, true, true)
+		if err != nil {
+			return err
+		}
+		misc = append(misc, types.CCRecipientMisc(conf))
+	}
+	if *commentTo != 0 {
+		parent := types.TextNo(*commentTo)
+		misc = append(misc, types.CommentToMisc(parent))
+		if *to == "" {
+			// Send the comment to the recipients of the
+			// commented text.
+			stat, err := k.GetTextStat(parent)
+			if err != nil {
+				return err
+			}
+			for _, mi := range stat.MiscInfo {
+				if types.InfoType(mi.Selector) == types.Recipient {
+					misc = append(misc, types.RecipientMisc(mi.Recipient))
+				}
+			}
+		}
+	}
+
+	text := *body
+	if text == "" {
+		b, err := io.ReadAll(c.in)
+		if err != nil {
+			return err
+		}
+		text = string(b)
+	}
+
+	no, err := k.CreateTextContent(types.NewText(*subject, text, "", ""), misc, nil)
+	if err != nil {
+		return err
+	}
+
+	rv := struct {
+		TextNo types.TextNo `json:"text"`
+	}{no}
+	return c.output(rv, func(w io.Writer) {
+		fmt.Fprintf(w, "Text %d created\n", no)
+	})
+}
+
+func runSendMessage(c *cli, args []string) error {
+	fs := flag.NewFlagSet("send-message", flag.ContinueOnError)
+	to := fs.String("to", "", "The person or conference to send to, everyone if not given")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	msg := strings.Join(fs.Args(), " ")
+	if msg == "" {
+		b, err := io.ReadAll(c.in)
+		if err != nil {
+			return err
+		}
+		msg = strings.TrimRight(string(b), "\n")
+	}
+	if msg == "" {
+		return usageOf("send-message")
+	}
+
+	k, err := c.connect(true)
+	if err != nil {
+		return err
+	}
+	var recipient types.ConfNo
+	if *to != "" {
+		if recipient, err = c.resolve(*to, true, true); err != nil {
+			return err
+		}
+	}
+	if err := k.SendMessageTo(recipient, msg); err != nil {
+		return err
+	}
+
+	rv := struct {
+		Recipient types.ConfNo `json:"recipient"`
+		Message   string       `json:"message"`
+	}{recipient, msg}
+	return c.output(rv, func(w io.Writer) {
+		if recipient == 0 {
+			fmt.Fprintf(w, "Message sent to everyone\n")
+		} else {
+			fmt.Fprintf(w, "Message sent to %s\n", c.name(recipient))
+		}
+	})
+}
+
+func runMarks(c *cli, args []string) error {
+	fs := flag.NewFlagSet("marks", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	op := "list"
+	if fs.NArg() > 0 {
+		op = fs.Arg(0)
+	}
+
+	k, err := c.connect(true)
+	if err != nil {
+		return err
+	}
+	marks := k.Marks()
+
+	switch op {
+	case "list":
+		if fs.NArg() > 1 {
+			return usageOf("marks")
+		}
+	case "add":
+		add := flag.NewFlagSet("marks", flag.ContinueOnError)
+		typeName := add.String("type", "default", "The mark type, by name or number")
+		if err := parseFlags(add, fs.Args()[1:]); err != nil {
+			return err
+		}
+		markType, ok := protocol.MarkTypeFromName(*typeName)
+		if !ok {
+			return usagef("Unknown mark type %q", *typeName)
+		}
+		texts, err := parseTexts(add.Args())
+		if err != nil {
+			return err
+		}
+		if len(texts) == 0 {
+			return usageOf("marks")
+		}
+		if err := marks.Mark(markType, texts...); err != nil {
+			return err
+		}
+	case "remove":
+		texts, err := parseTexts(fs.Args()[1:])
+		if err != nil {
+			return err
+		}
+		if len(texts) == 0 {
+			return usageOf("marks")
+		}
+		if err := marks.Unmark(texts...); err != nil {
+			return err
+		}
+	default:
+		return usageOf("marks")
+	}
+
+	rv, err := marks.List()
+	if err != nil {
+		return err
+	}
+	return c.output(rv, func(w io.Writer) {
+		fmt.Fprintf(w, "TEXT\tTYPE\tAUTHOR\tSUBJECT\n")
+		for _, m := range rv {
+			if m.Missing {
+				fmt.Fprintf(w, "%d\t%s\t\t(not available)\n", m.TextNo, m.Category)
+				continue
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", m.TextNo, m.Category, m.AuthorName, m.Subject)
+		}
+	})
+}
+
+// A person or conference found by lookup.
+type confOutput struct {
+	Conference types.ConfNo `json:"conference"`
+	Name       string       `json:"name"`
+	Person     bool         `json:"person"`
+}
+
+func runLookup(c *cli, args []string) error {
+	fs := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	persons := fs.Bool("persons", false, "Find persons")
+	confs := fs.Bool("confs", false, "Find conferences")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageOf("lookup")
+	}
+	if !*persons && !*confs {
+		*persons, *confs = true, true
+	}
+
+	k, err := c.connect(false)
+	if err != nil {
+		return err
+	}
+	found, err := k.ReZLookup(fs.Arg(0), *persons, *confs)
+	if err != nil {
+		return err
+	}
+
+	rv := make([]confOutput, 0, len(found))
+	for _, f := range found {
+		rv = append(rv, confOutput{Conference: f.No, Name: f.Name, Person: f.Type.LetterBox})
+	}
+	return c.output(rv, func(w io.Writer) {
+		for _, f := range rv {
+			kind := "conference"
+			if f.Person {
+				kind = "person"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", f.Conference, kind, f.Name)
+		}
+	})
+}
+
+// The status of a conference, as printed by stat.
+type confStatOutput struct {
+	Conference     types.ConfNo `json:"conference"`
+	Name           string       `json:"name"`
+	Person         bool         `json:"person"`
+	Secret         bool         `json:"secret,omitempty"`
+	ReadProtected  bool         `json:"read-protected,omitempty"`
+	Original       bool         `json:"original,omitempty"`
+	HighestLocalNo types.TextNo `json:"highest-local-no"`
+	Nice           uint32       `json:"nice"`
+}
+
+// The status of a person, as printed by stat.
+type personStatOutput struct {
+	Person           types.ConfNo `json:"person"`
+	Name             string       `json:"name"`
+	Username         string       `json:"username,omitempty"`
+	LastLogin        time.Time    `json:"last-login"`
+	UserArea         types.TextNo `json:"user-area,omitempty"`
+	TotalTimePresent uint32       `json:"total-time-present"`
+	Sessions         uint32       `json:"sessions"`
+	CreatedTexts     uint32       `json:"created-texts"`
+	ReadTexts        uint32       `json:"read-texts"`
+	Marks            uint16       `json:"marks"`
+	Conferences      uint16       `json:"conferences"`
+}
+
+func runStat(c *cli, args []string) error {
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageOf("stat")
+	}
+
+	k, err := c.connect(false)
+	if err != nil {
+		return err
+	}
+
+	switch what, arg := fs.Arg(0), fs.Arg(1); what {
+	case "text":
+		texts, err := parseTexts([]string{arg})
+		if err != nil {
+			return err
+		}
+		rv, err := c.text(k, texts[0], false)
+		if err != nil {
+			return err
+		}
+		return c.output(rv, func(w io.Writer) {
+			printText(w, rv)
+		})
+
+	case "conf":
+		conf, err := c.resolve(arg, true, true)
+		if err != nil {
+			return err
+		}
+		uconf, err := k.GetUConfStat(conf)
+		if err != nil {
+			return err
+		}
+		rv := confStatOutput{
+			Conference:     conf,
+			Name:           uconf.Name,
+			Person:         uconf.Type.LetterBox,
+			Secret:         uconf.Type.Secret,
+			ReadProtected:  uconf.Type.RdProt,
+			Original:       uconf.Type.Original,
+			HighestLocalNo: uconf.HighestLocalNo,
+			Nice:           uconf.Nice,
+		}
+		return c.output(rv, func(w io.Writer) {
+			fmt.Fprintf(w, "Conference:\t%d\n", rv.Conference)
+			fmt.Fprintf(w, "Name:\t%s\n", rv.Name)
+			fmt.Fprintf(w, "Type:\t%s\n", confTypeString(rv))
+			fmt.Fprintf(w, "Highest local number:\t%d\n", rv.HighestLocalNo)
+			fmt.Fprintf(w, "Garbage nice:\t%d\n", rv.Nice)
+		})
+
+	case "person":
+		person, err := c.resolve(arg, true, false)
+		if err != nil {
+			return err
+		}
+		p, err := k.GetPersonStat(person)
+		if err != nil {
+			return err
+		}
+		rv := personStatOutput{
+			Person:           person,
+			Name:             c.name(person),
+			Username:         p.Username,
+			LastLogin:        p.LastLogin,
+			UserArea:         p.UserArea,
+			TotalTimePresent: p.TotalTimePresent,
+			Sessions:         p.Sessions,
+			CreatedTexts:     p.CreatedTexts,
+			ReadTexts:        p.ReadTexts,
+			Marks:            p.Marks,
+			Conferences:      p.Conferences,
+		}
+		return c.output(rv, func(w io.Writer) {
+			fmt.Fprintf(w, "Person:\t%d\n", rv.Person)
+			fmt.Fprintf(w, "Name:\t%s\n", rv.Name)
+			fmt.Fprintf(w, "Last login:\t%s\n", rv.LastLogin.Format(timeFormat))
+			if rv.Username != "" {
+				fmt.Fprintf(w, "From:\t%s\n", rv.Username)
+			}
+			fmt.Fprintf(w, "Time present:\t%s\n", time.Duration(rv.TotalTimePresent)*time.Second)
+			fmt.Fprintf(w, "Sessions:\t%d\n", rv.Sessions)
+			fmt.Fprintf(w, "Texts written:\t%d\n", rv.CreatedTexts)
+			fmt.Fprintf(w, "Texts read:\t%d\n", rv.ReadTexts)
+			fmt.Fprintf(w, "Marks:\t%d\n", rv.Marks)
+			fmt.Fprintf(w, "Memberships:\t%d\n", rv.Conferences)
+		})
+	}
+
+	return usageOf("stat")
+}
+
+// Describe the type of a conference, such as "person, secret".
+func confTypeString(conf confStatOutput) string {
+	var rv []string
+	if conf.Person {
+		rv = append(rv, "person")
+	} else {
+		rv = append(rv, "conference")
+	}
+	if conf.Secret {
+		rv = append(rv, "secret")
+	}
+	if conf.ReadProtected {
+		rv = append(rv, "read-protected")
+	}
+	if conf.Original {
+		rv = append(rv, "original")
+	}
+	return strings.Join(rv, ", ")
+}
+
+// The result of export.
+type exportOutput struct {
+	Conference types.ConfNo `json:"conference"`
+	Exported   int          `json:"exported"`
+	LastLocal  types.TextNo `json:"last-local-no"`
+}
+
+func runExport(c *cli, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	maildir := fs.Bool("maildir", false, "Export to a Maildir, rather than an mbox file")
+	statePath := fs.String("state", "", "The file remembering what has been exported, default next to the mailbox")
+	domain := fs.String("domain", "", "The domain of the addresses and Message-IDs, default the server's host name")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageOf("export")
+	}
+	path := fs.Arg(1)
+
+	k, err := c.connect(true)
+	if err != nil {
+		return err
+	}
+	conf, err := c.resolve(fs.Arg(0), true, true)
+	if err != nil {
+		return err
+	}
+
+	if *statePath == "" {
+		*statePath = path + ".kom-export"
+		if *maildir {
+			*statePath = filepath.Join(path, ".kom-export")
+		}
+	}
+	if *domain == "" {
+		*domain = export.DefaultDomain
+		if host, _, err := net.SplitHostPort(serverAddress(c.cfg.Server)); err == nil && host != "" {
+			*domain = host
+		}
+	}
+
+	var mailbox export.Mailbox
+	if *maildir {
+		mailbox, err = export.OpenMaildir(path)
+	} else {
+		mailbox, err = export.OpenMbox(path)
+	}
+	if err != nil {
+		return err
+	}
+	state, err := export.LoadState(*statePath)
+	if err != nil {
+		mailbox.Close()
+		return err
+	}
+
+	e := export.New(k, mailbox)
+	e.Domain = *domain
+	e.State = state
+	n, err := e.Export(conf)
+	if cerr := mailbox.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Exporting %s after %d texts: %w", c.name(conf), n, err)
+	}
+
+	rv := exportOutput{Conference: conf, Exported: n, LastLocal: state.Last(conf)}
+	return c.output(rv, func(w io.Writer) {
+		fmt.Fprintf(w, "Exported %d texts from %s, up to local text %d\n", rv.Exported, c.name(conf), rv.LastLocal)
+	})
+}
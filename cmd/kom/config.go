@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The default LysKOM port.
+const defaultPort = "4894"
+
+// Where to connect, and who to log in as.
+type config struct {
+	Server   string
+	User     string
+	Password string
+}
+
+// Return the default config file, $KOM_CONFIG or kom/config in the
+// user's config directory.
+func defaultConfigFile() string {
+	if path := os.Getenv("KOM_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "kom", "config")
+}
+
+// Read a config file, with one "key = value" setting per line. Blank
+// lines and lines starting with "#" are ignored. The keys are server,
+// user and password.
+func readConfig(r io.Reader, cfg *config) error {
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Line %d: expected key = value", line)
+		}
+		value := strings.TrimSpace(parts[1])
+		switch key := strings.ToLower(strings.TrimSpace(parts[0])); key {
+		case "server":
+			cfg.Server = value
+		case "user":
+			cfg.User = value
+		case "password":
+			cfg.Password = value
+		default:
+			return fmt.Errorf("Line %d: unknown setting %q", line, key)
+		}
+	}
+	return s.Err()
+}
+
+// Load the configuration from a file, if it exists, and then the
+// environment. KOM_SERVER, KOM_USER and KOM_PASSWORD override the
+// file.
+func loadConfig(path string) (config, error) {
+	var rv config
+
+	if path != "" {
+		f, err := os.Open(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return rv, err
+		default:
+			defer f.Close()
+			if info, err := f.Stat(); err == nil && info.Mode().Perm()&0077 != 0 {
+				log.WithFields(log.Fields{
+					"file": path,
+				}).Warning("The config file is readable by others, consider chmod 600")
+			}
+			if err := readConfig(f, &rv); err != nil {
+				return rv, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+
+	if s := os.Getenv("KOM_SERVER"); s != "" {
+		rv.Server = s
+	}
+	if s := os.Getenv("KOM_USER"); s != "" {
+		rv.User = s
+	}
+	if s := os.Getenv("KOM_PASSWORD"); s != "" {
+		rv.Password = s
+	}
+
+	return rv, nil
+}
+
+// Return a server address with the default port added, if it has
+// none.
+func serverAddress(server string) string {
+	if server == "" {
+		server = "localhost"
+	}
+	if !strings.Contains(server, ":") {
+		server += ":" + defaultPort
+	}
+	return server
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	cases := []struct {
+		in   string
+		want config
+		err  bool
+	}{
+		{"", config{}, false},
+		{"server = kom.example\nuser=Ingrid Bergman\npassword = sec=ret\n", config{"kom.example", "Ingrid Bergman", "sec=ret"}, false},
+		{"# A comment\n\n  SERVER = kom.example:4711  \n", config{Server: "kom.example:4711"}, false},
+		{"server kom.example\n", config{}, true},
+		{"colour = red\n", config{}, true},
+	}
+
+	for ix, c := range cases {
+		var got config
+		err := readConfig(strings.NewReader(c.in), &got)
+		if (err != nil) != c.err {
+			t.Errorf("Case #%d, unexpected error state %v", ix, err)
+			continue
+		}
+		if !c.err && got != c.want {
+			t.Errorf("Case #%d, saw %+v, want %+v", ix, got, c.want)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("server = kom.example\nuser = ingrid\npassword = secret\n"), 0600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Setenv("KOM_SERVER", "")
+	t.Setenv("KOM_USER", "")
+	t.Setenv("KOM_PASSWORD", "")
+
+	cfg, err := loadConfig(path)
+	if want := (config{"kom.example", "ingrid", "secret"}); err != nil || cfg != want {
+		t.Errorf("saw %+v/%v, want %+v", cfg, err, want)
+	}
+
+	// The environment overrides the file.
+	t.Setenv("KOM_USER", "greta")
+	cfg, err = loadConfig(path)
+	if want := (config{"kom.example", "greta", "secret"}); err != nil || cfg != want {
+		t.Errorf("saw %+v/%v, want %+v", cfg, err, want)
+	}
+
+	// A missing file is no error.
+	cfg, err = loadConfig(filepath.Join(dir, "missing"))
+	if want := (config{User: "greta"}); err != nil || cfg != want {
+		t.Errorf("saw %+v/%v, want %+v", cfg, err, want)
+	}
+
+	if err := os.WriteFile(path, []byte("server\n"), 0600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("saw %v, want an error naming %s", err, path)
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"", "localhost:4894"},
+		{"kom.example", "kom.example:4894"},
+		{"kom.example:4711", "kom.example:4711"},
+	}
+
+	for ix, c := range cases {
+		if got := serverAddress(c.in); got != c.want {
+			t.Errorf("Case #%d, saw %q, want %q", ix, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// kom is a command-line client for LysKOM, meant for scripting.
+//
+// Usage:
+//
+//	kom [-server host:port] [-user name] [-config file] [-format text|json] command [args]
+//
+// The commands are:
+//
+//	login                          check the credentials, print the person
+//	who [-all] [-active s]         list the sessions on the server
+//	read [-n max] [-keep] conf     print, and mark as read, the unread texts in conf
+//	post -to conf -subject s       create a text, with the body from -body or stdin
+//	send-message [-to name] msg    send a message, or broadcast one without -to
+//	marks [list|add|remove]        list, add or remove marks
+//	lookup [-persons] [-confs] re  find persons and conferences matching a regexp
+//	stat text|conf|person arg      print the status of a text, conference or person
+//	export [-maildir] conf path    append the texts of conf to an mbox file or Maildir
+//
+// Persons and conferences may be given by number or by name, names
+// being matched as an unambiguous abbreviation. With -format json,
+// each command prints one JSON document.
+//
+// Exports resume where the previous export of the conference stopped,
+// as recorded in path.kom-export, or .kom-export in the Maildir.
+//
+// The server, user and password are read from a config file, by
+// default $KOM_CONFIG or kom/config in the user's config directory,
+// with lines of the form "key = value". The environment variables
+// KOM_SERVER, KOM_USER and KOM_PASSWORD override the file, and the
+// flags override both.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// A subcommand.
+type command struct {
+	usage string
+	run   func(c *cli, args []string) error
+}
+
+// The subcommands, by name. Set in init, as the commands refer to it
+// for their usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"login":        {"login", runLogin},
+		"who":          {"who [-all] [-active seconds]", runWho},
+		"read":         {"read [-n max] [-keep] conf", runRead},
+		"post":         {"post -to conf[,conf] [-cc conf] -subject subject [-comment-to text] [-body body]", runPost},
+		"send-message": {"send-message [-to name] message...", runSendMessage},
+		"marks":        {"marks [list | add [-type type] text... | remove text...]", runMarks},
+		"lookup":       {"lookup [-persons] [-confs] regexp", runLookup},
+		"stat":         {"stat text|conf|person arg", runStat},
+		"export":       {"export [-maildir] [-state file] [-domain domain] conf path", runExport},
+	}
+}
+
+// A usage error, making kom exit with status 2.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// Return a usage error showing the usage of a command.
+func usageOf(name string) error {
+	return usageError{"usage: kom " + commands[name].usage}
+}
+
+// The state shared by the commands.
+type cli struct {
+	cfg       config
+	json      bool
+	invisible bool
+	out       io.Writer
+	in        io.Reader
+
+	client *protocol.KomClient
+}
+
+// Connect to the server, logging in if a user is configured, or if
+// login is true. Connecting again returns the same client.
+func (c *cli) connect(login bool) (*protocol.KomClient, error) {
+	if c.client != nil {
+		return c.client, nil
+	}
+	if login && c.cfg.User == "" {
+		return nil, errors.New("No user given, use -user, KOM_USER or the config file")
+	}
+
+	k, err := protocol.NewKomClient(serverAddress(c.cfg.Server))
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.User != "" {
+		if err := k.Login(c.cfg.User, c.cfg.Password, c.invisible); err != nil {
+			k.Close()
+			return nil, fmt.Errorf("Logging in as %s: %w", c.cfg.User, err)
+		}
+	}
+	c.client = k
+	return k, nil
+}
+
+// Log out, if logged in, and close the connection.
+func (c *cli) close() {
+	if c.client == nil {
+		return
+	}
+	if c.client.Person() != 0 {
+		c.client.Logout()
+	}
+	c.client.Close()
+}
+
+// Return the name of a person or conference, or a placeholder if it
+// cannot be found.
+func (c *cli) name(conf types.ConfNo) string {
+	if c.client != nil {
+		if name, err := c.client.ConfName(conf); err == nil {
+			return name
+		}
+	}
+	return fmt.Sprintf("Conference %d (unknown)", conf)
+}
+
+// Resolve a person or conference given by number or name.
+func (c *cli) resolve(arg string, wantPersons, wantConferences bool) (types.ConfNo, error) {
+	n, err := strconv.ParseUint(arg, 10, 16)
+	if err == nil {
+		return types.ConfNo(n), nil
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, usagef("Bad conference number %q", arg)
+	}
+	k, err := c.connect(false)
+	if err != nil {
+		return 0, err
+	}
+	return k.ResolveName(arg, wantPersons, wantConferences)
+}
+
+// Print a result, as JSON or by calling text.
+func (c *cli) output(v interface{}, text func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	text(tw)
+	return tw.Flush()
+}
+
+// Parse the flags of a subcommand, turning flag errors into usage
+// errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return usageOf(fs.Name())
+		}
+		return usagef("%s: %v", fs.Name(), err)
+	}
+	return nil
+}
+
+// Print the usage of kom and its commands.
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "usage: kom [flags] command [args]\n\nFlags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nCommands:\n")
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  kom %s\n", commands[name].usage)
+	}
+}
+
+// Run kom with the given arguments, returning the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("kom", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", "", "The LysKOM server, as host or host:port")
+	user := fs.String("user", "", "The person to log in as")
+	configFile := fs.String("config", defaultConfigFile(), "The config file")
+	format := fs.String("format", "text", "Output format, text or json")
+	invisible := fs.Bool("invisible", false, "Log in invisibly")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		usage(fs)
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "kom: unknown command %q\n", fs.Arg(0))
+		usage(fs)
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "kom: unknown format %q, want text or json\n", *format)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "kom: %v\n", err)
+		return 1
+	}
+	if *server != "" {
+		cfg.Server = *server
+	}
+	if *user != "" {
+		cfg.User = *user
+	}
+
+	c := &cli{
+		cfg:       cfg,
+		json:      *format == "json",
+		invisible: *invisible,
+		out:       stdout,
+		in:        stdin,
+	}
+	err = cmd.run(c, fs.Args()[1:])
+	c.close()
+
+	var uerr usageError
+	switch {
+	case errors.As(err, &uerr) && strings.HasPrefix(uerr.msg, "usage:"):
+		fmt.Fprintln(stderr, uerr.msg)
+		return 2
+	case errors.As(err, &uerr):
+		fmt.Fprintf(stderr, "kom: %v\n", err)
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "kom: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Split a comma-separated argument, dropping empty elements.
+func splitList(s string) []string {
+	var rv []string
+	for _, elem := range strings.Split(s, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			rv = append(rv, elem)
+		}
+	}
+	return rv
+}
+
+// Parse text numbers.
+func parseTexts(args []string) ([]types.TextNo, error) {
+	var rv []types.TextNo
+	for _, arg := range args {
+		n, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil || n == 0 {
+			return nil, usagef("Bad text number %q", arg)
+		}
+		rv = append(rv, types.TextNo(n))
+	}
+	return rv, nil
+}
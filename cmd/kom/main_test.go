@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/internal/komdtest"
+	"github.com/vatine/komandgo/pkg/komd"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Start a komd server with a text in its conference, returning the
+// address to connect to.
+func testServer(t *testing.T) string {
+	t.Helper()
+
+	store, _, conf := komdtest.NewStore()
+	srv := komd.NewServer(store)
+	t.Cleanup(func() { srv.Close() })
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	go srv.Serve(l)
+
+	k, err := protocol.NewKomClientConn(komdtest.Pipe(srv))
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	defer k.Close()
+	if err := k.Login("ingrid", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	if _, err := k.CreateText("Hej\nFörsta inlägget", []types.MiscInfo{types.RecipientMisc(conf)}, nil); err != nil {
+		t.Fatalf("unexpected error creating a text, %v", err)
+	}
+	return l.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("KOM_SERVER", "")
+	t.Setenv("KOM_USER", "")
+	t.Setenv("KOM_PASSWORD", "")
+	server := testServer(t)
+	flags := []string{"-config", filepath.Join(t.TempDir(), "config"), "-server", server}
+
+	cases := []struct {
+		args   []string
+		status int
+		stdout string
+		stderr string
+	}{
+		{nil, 2, "", "usage: kom [flags]"},
+		{[]string{"-h"}, 0, "", "usage: kom [flags]"},
+		{[]string{"-colour", "red", "who"}, 2, "", "flag provided but not defined"},
+		{[]string{"frobnicate"}, 2, "", `unknown command "frobnicate"`},
+		{[]string{"-format", "xml", "who"}, 2, "", `unknown format "xml"`},
+		{[]string{"stat"}, 2, "", "usage: kom stat"},
+		{[]string{"stat", "-x", "text", "1"}, 2, "", "stat: flag provided but not defined: -x"},
+		{[]string{"stat", "text", "0"}, 2, "", `Bad text number "0"`},
+		{[]string{"stat", "conf", "70000"}, 2, "", `Bad conference number "70000"`},
+		{[]string{"stat", "conf", "film"}, 0, "Filmklubben", ""},
+		{[]string{"stat", "text", "1"}, 0, "Subject: Hej", ""},
+		{[]string{"login"}, 1, "", "No user given"},
+		{[]string{"-user", "ingrid", "login"}, 1, "", "Logging in as ingrid"},
+	}
+
+	for ix, c := range cases {
+		var stdout, stderr bytes.Buffer
+		args := c.args
+		if len(args) > 0 && args[0] != "-h" {
+			args = append(append([]string{}, flags...), args...)
+		}
+		status := run(args, strings.NewReader(""), &stdout, &stderr)
+		if status != c.status {
+			t.Errorf("Case #%d, saw status %d, want %d, stderr %q", ix, status, c.status, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), c.stdout) {
+			t.Errorf("Case #%d, saw output %q, want it to contain %q", ix, stdout.String(), c.stdout)
+		}
+		if !strings.Contains(stderr.String(), c.stderr) {
+			t.Errorf("Case #%d, saw errors %q, want them to contain %q", ix, stderr.String(), c.stderr)
+		}
+	}
+}
+
+// stat text shows the subject, but not the body.
+func TestStatText(t *testing.T) {
+	t.Setenv("KOM_SERVER", "")
+	t.Setenv("KOM_USER", "")
+	args := []string{"-config", filepath.Join(t.TempDir(), "config"), "-server", testServer(t), "-format", "json", "stat", "text", "1"}
+
+	var stdout, stderr bytes.Buffer
+	if status := run(args, strings.NewReader(""), &stdout, &stderr); status != 0 {
+		t.Fatalf("saw status %d, %q", status, stderr.String())
+	}
+	var got textOutput
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got.Subject != "Hej" || got.Body != "" || got.ContentType != types.ContentKomBasic {
+		t.Errorf("unexpected text %+v", got)
+	}
+}
+
+func TestResolveNumbers(t *testing.T) {
+	c := &cli{}
+	cases := []struct {
+		arg  string
+		want types.ConfNo
+		err  bool
+	}{
+		{"17", 17, false},
+		{"65535", 65535, false},
+		{"65536", 0, true},
+		{"70000", 0, true},
+	}
+
+	for ix, cs := range cases {
+		got, err := c.resolve(cs.arg, true, true)
+		var uerr usageError
+		if cs.err != errors.As(err, &uerr) || got != cs.want {
+			t.Errorf("Case #%d, saw %d/%v, want %d", ix, got, err, cs.want)
+		}
+	}
+}
+
+func TestParseTexts(t *testing.T) {
+	cases := []struct {
+		args []string
+		want []types.TextNo
+		err  bool
+	}{
+		{[]string{"1", "4711"}, []types.TextNo{1, 4711}, false},
+		{[]string{"4294967295"}, []types.TextNo{4294967295}, false},
+		{[]string{"4294967296"}, nil, true},
+		{[]string{"0"}, nil, true},
+		{[]string{"Hej"}, nil, true},
+	}
+
+	for ix, c := range cases {
+		got, err := parseTexts(c.args)
+		if (err != nil) != c.err {
+			t.Errorf("Case #%d, unexpected error state %v", ix, err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("Case #%d, saw %v, want %v", ix, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("Case #%d, saw %v, want %v", ix, got, c.want)
+			}
+		}
+	}
+}
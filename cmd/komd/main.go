@@ -0,0 +1,104 @@
+// komd is a minimal LysKOM server, for local development and tests.
+//
+// Usage:
+//
+//	komd [-listen :4894] [-data komd.json] [-person name -password pw]
+//
+// Without -data, everything is lost when the server stops. With
+// -data, the database is loaded from the file on start and saved to
+// it every -save interval and on shutdown.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/komd"
+)
+
+func main() {
+	listen := flag.String("listen", ":4894", "Address to listen on")
+	data := flag.String("data", "", "File to keep the database in")
+	interval := flag.Duration("save", time.Minute, "How often to save the database")
+	name := flag.String("person", "", "Create a person with this name, unless it exists")
+	password := flag.String("password", "", "Password for the person created with -person")
+	admin := flag.Bool("admin", false, "Give the person created with -person all privileges")
+	debug := flag.Bool("debug", false, "Log bad requests")
+	flag.Parse()
+
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	store := komd.NewStore()
+	if *data != "" {
+		var err error
+		store, err = komd.OpenStore(*data)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"file":  *data,
+			}).Fatal("Failed to load the database")
+		}
+	}
+
+	if *name != "" {
+		if _, ok := store.LookupPerson(*name); !ok {
+			pers, err := store.CreatePerson(*name, *password)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error":  err,
+					"person": *name,
+				}).Fatal("Failed to create person")
+			}
+			if *admin {
+				store.SetAdmin(pers)
+			}
+			log.WithFields(log.Fields{
+				"person": *name,
+				"number": pers,
+			}).Info("Created person")
+		}
+	}
+
+	save := func() {
+		if err := store.Save(); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"file":  *data,
+			}).Error("Failed to save the database")
+		}
+	}
+
+	srv := komd.NewServer(store)
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		ticker := time.NewTicker(*interval)
+		for {
+			select {
+			case <-ticker.C:
+				save()
+			case <-signals:
+				srv.Close()
+				return
+			}
+		}
+	}()
+
+	log.WithFields(log.Fields{
+		"address": *listen,
+	}).Info("komd listening")
+	if err := srv.ListenAndServe(*listen); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Listening")
+	}
+	save()
+}
@@ -0,0 +1,62 @@
+// komgateway serves an HTTP/JSON gateway to a LysKOM server, for web
+// frontends. See the gateway package for the endpoints.
+//
+// Usage:
+//
+//	komgateway [-listen :8080] [-server localhost:4894] [-idle 30m]
+//
+// Each web session logs in to the server with a session of its own,
+// which is logged out after being idle for the -idle duration. The
+// gateway does not do TLS, put it behind a proxy that does.
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/gateway"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "Address to listen on")
+	server := flag.String("server", "localhost:4894", "The LysKOM server")
+	idle := flag.Duration("idle", gateway.DefaultIdleTimeout, "How long a session may be idle before it is logged out")
+	flag.Parse()
+
+	g := gateway.New(*server)
+	g.IdleTimeout = *idle
+	hs := &http.Server{
+		Addr:              *listen,
+		Handler:           g,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Info("komgateway shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		hs.Shutdown(ctx)
+	}()
+
+	log.WithFields(log.Fields{
+		"address": *listen,
+		"server":  *server,
+	}).Info("komgateway listening")
+	err := hs.ListenAndServe()
+	g.Close()
+	if err != nil && err != http.ErrServerClosed {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Listening")
+	}
+}
@@ -0,0 +1,118 @@
+// komproxy is a Protocol A debugging proxy. It sits between a LysKOM
+// client and server, forwarding everything and printing each request,
+// reply and asynchronous message in human-readable form.
+//
+// Usage:
+//
+//	komproxy [-listen :4895] [-server localhost:4894] [-trace dir]
+//	         [-deny calls] [-rewrite from=to,...] [-hide calls]
+//
+// Replies to the common calls, such as get-text-stat, get-conf-stat
+// and get-membership, are decoded and shown field by field, others
+// token by token.
+//
+// Calls are given by number or name, such as "82" or "user-active".
+// Denied calls are answered with not-implemented without reaching the
+// server, which is handy for testing client fallbacks. Rewritten calls
+// are sent to the server as another call, with the same arguments.
+// Hidden calls are forwarded, but not printed. With -trace, each
+// connection is recorded to a trace file in dir, see the trace
+// package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+// Parse a comma-separated list of calls.
+func parseCalls(s string) (map[uint32]bool, error) {
+	rv := make(map[uint32]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		no, ok := wire.CallNumber(name)
+		if !ok {
+			return nil, fmt.Errorf("Unknown call %q", name)
+		}
+		rv[no] = true
+	}
+	return rv, nil
+}
+
+// Parse a comma-separated list of from=to call rewrites.
+func parseRewrites(s string) (map[uint32]uint32, error) {
+	rv := make(map[uint32]uint32)
+	for _, rewrite := range strings.Split(s, ",") {
+		rewrite = strings.TrimSpace(rewrite)
+		if rewrite == "" {
+			continue
+		}
+		parts := strings.SplitN(rewrite, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Malformed rewrite %q, want from=to", rewrite)
+		}
+		parts[0], parts[1] = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		from, ok := wire.CallNumber(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("Unknown call %q", parts[0])
+		}
+		to, ok := wire.CallNumber(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("Unknown call %q", parts[1])
+		}
+		rv[from] = to
+	}
+	return rv, nil
+}
+
+func main() {
+	listen := flag.String("listen", ":4895", "Address to listen on")
+	server := flag.String("server", "localhost:4894", "The LysKOM server to forward to")
+	traces := flag.String("trace", "", "Directory to record a trace of each connection in")
+	deny := flag.String("deny", "", "Calls to answer with not-implemented, comma-separated")
+	rewrite := flag.String("rewrite", "", "Calls to send as other calls, as from=to, comma-separated")
+	hide := flag.String("hide", "", "Calls not to print, comma-separated")
+	flag.Parse()
+
+	p := &proxy{
+		server: *server,
+		traces: *traces,
+		out:    os.Stdout,
+	}
+
+	var err error
+	if p.deny, err = parseCalls(*deny); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Parsing -deny")
+	}
+	if p.hide, err = parseCalls(*hide); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Parsing -hide")
+	}
+	if p.rewrite, err = parseRewrites(*rewrite); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Parsing -rewrite")
+	}
+
+	log.WithFields(log.Fields{
+		"address": *listen,
+		"server":  *server,
+	}).Info("komproxy listening")
+	if err := p.listenAndServe(*listen); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("Listening")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCalls(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    map[uint32]bool
+		wantErr bool
+	}{
+		{"", map[uint32]bool{}, false},
+		{"82", map[uint32]bool{82: true}, false},
+		{"user-active,who-is-on-dynamic", map[uint32]bool{82: true, 83: true}, false},
+		{" 82 , ,get-time", map[uint32]bool{82: true, 35: true}, false},
+		{"no-such-call", nil, true},
+		{"82,4711", map[uint32]bool{82: true, 4711: true}, false},
+	}
+
+	for _, c := range cases {
+		got, err := parseCalls(c.s)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseCalls(%q), saw error %v, want error %v", c.s, err, c.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseCalls(%q), saw %v, want %v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestParseRewrites(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    map[uint32]uint32
+		wantErr bool
+	}{
+		{"", map[uint32]uint32{}, false},
+		{"91=50", map[uint32]uint32{91: 50}, false},
+		{"get-conf-stat=get-conf-stat-old,90=26", map[uint32]uint32{91: 50, 90: 26}, false},
+		{" 91 = 50 ", map[uint32]uint32{91: 50}, false},
+		{"91", nil, true},
+		{"91=no-such-call", nil, true},
+		{"no-such-call=91", nil, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseRewrites(c.s)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseRewrites(%q), saw error %v, want error %v", c.s, err, c.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseRewrites(%q), saw %v, want %v", c.s, got, c.want)
+		}
+	}
+}
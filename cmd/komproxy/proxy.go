@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/trace"
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+// The proxy configuration, shared by all connections.
+type proxy struct {
+	server  string
+	traces  string
+	deny    map[uint32]bool
+	rewrite map[uint32]uint32
+	hide    map[uint32]bool
+
+	outLock sync.Mutex
+	out     io.Writer
+	nextNo  int
+}
+
+func (p *proxy) listenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		p.outLock.Lock()
+		p.nextNo++
+		no := p.nextNo
+		p.outLock.Unlock()
+		go p.serve(c, no)
+	}
+}
+
+// Print a line about a connection.
+func (p *proxy) show(no int, dir string, format string, args ...interface{}) {
+	p.outLock.Lock()
+	defer p.outLock.Unlock()
+	fmt.Fprintf(p.out, "%s [%d] %s %s\n", time.Now().Format("15:04:05.000"), no, dir, fmt.Sprintf(format, args...))
+}
+
+// A proxied connection.
+type connection struct {
+	proxy  *proxy
+	no     int
+	client net.Conn
+	server io.ReadWriter
+
+	clientLock sync.Mutex
+
+	// The call of each request waiting for a reply, by request id.
+	pendingLock sync.Mutex
+	pending     map[uint32]uint32
+}
+
+// Forward a client connection to the server, until either side
+// closes.
+func (p *proxy) serve(client net.Conn, no int) {
+	defer client.Close()
+
+	upstream, err := net.Dial("tcp", p.server)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"server": p.server,
+		}).Error("Connecting to the server")
+		return
+	}
+	defer upstream.Close()
+
+	c := &connection{
+		proxy:   p,
+		no:      no,
+		client:  client,
+		server:  upstream,
+		pending: make(map[uint32]uint32),
+	}
+
+	if p.traces != "" {
+		f, err := trace.CreateFile(p.traces)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"connection": no,
+			}).Error("Creating trace file, not tracing")
+		} else {
+			defer f.Close()
+			c.server = trace.NewTap(upstream, trace.NewWriter(f))
+			p.show(no, "-", "tracing to %s", f.Name())
+		}
+	}
+
+	p.show(no, "-", "connected from %s", client.RemoteAddr())
+	done := make(chan struct{}, 2)
+	go func() {
+		c.fromClient()
+		done <- struct{}{}
+	}()
+	go func() {
+		c.fromServer()
+		done <- struct{}{}
+	}()
+
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+	p.show(no, "-", "disconnected")
+}
+
+func (c *connection) writeClient(s string) error {
+	c.clientLock.Lock()
+	defer c.clientLock.Unlock()
+	_, err := io.WriteString(c.client, s)
+	return err
+}
+
+// Read requests from the client, and forward them to the server.
+func (c *connection) fromClient() {
+	p := c.proxy
+	r := bufio.NewReader(c.client)
+
+	header, err := wire.ReadMessage(r)
+	if err != nil {
+		return
+	}
+	p.show(c.no, ">", "%s", wire.Format(header))
+	if _, err := io.WriteString(c.server, header+"\n"); err != nil {
+		return
+	}
+
+	for {
+		msg, err := wire.ReadMessage(r)
+		if err != nil {
+			return
+		}
+
+		req, err := wire.ParseRequest(msg)
+		switch {
+		case strings.TrimSpace(msg) == "":
+		case err != nil:
+			p.show(c.no, ">", "%s (%v)", wire.Format(msg), err)
+		case p.deny[req.Call]:
+			if !p.hide[req.Call] {
+				p.show(c.no, ">", "%d %s: denied", req.ID, wire.CallName(req.Call))
+			}
+			if c.writeClient(fmt.Sprintf("%%%d 2 0\n", req.ID)) != nil {
+				return
+			}
+			continue
+		default:
+			if to, ok := p.rewrite[req.Call]; ok {
+				if !p.hide[req.Call] {
+					p.show(c.no, ">", "%d %s: rewritten to %s", req.ID, wire.CallName(req.Call), wire.CallName(to))
+				}
+				msg = rewriteCall(msg, to)
+				req.Call = to
+			}
+			c.pendingLock.Lock()
+			c.pending[req.ID] = req.Call
+			c.pendingLock.Unlock()
+			if !p.hide[req.Call] {
+				p.show(c.no, ">", "%d %s%s", req.ID, wire.CallName(req.Call), formatArgs(msg, 2))
+			}
+		}
+
+		if _, err := io.WriteString(c.server, msg+"\n"); err != nil {
+			return
+		}
+	}
+}
+
+// Read replies and asynchronous messages from the server, and forward
+// them to the client.
+func (c *connection) fromServer() {
+	r := bufio.NewReader(c.server)
+
+	for {
+		msg, err := wire.ReadMessage(r)
+		if err != nil {
+			return
+		}
+		c.describe(msg)
+		if c.writeClient(msg+"\n") != nil {
+			return
+		}
+	}
+}
+
+// Print a message from the server.
+func (c *connection) describe(msg string) {
+	p := c.proxy
+
+	if strings.TrimSpace(msg) == "LysKOM" {
+		p.show(c.no, "<", "%s", msg)
+		return
+	}
+
+	reply, err := wire.ParseReply(msg)
+	if err != nil {
+		p.show(c.no, "<", "%s (%v)", wire.Format(msg), err)
+		return
+	}
+
+	switch reply.Kind {
+	case wire.ReplyOK, wire.ReplyError:
+		c.pendingLock.Lock()
+		call, ok := c.pending[reply.ID]
+		delete(c.pending, reply.ID)
+		c.pendingLock.Unlock()
+
+		name := "unknown request"
+		if ok {
+			if p.hide[call] {
+				return
+			}
+			name = wire.CallName(call)
+		}
+		if reply.Kind == wire.ReplyOK {
+			p.show(c.no, "<", "%d %s: ok%s", reply.ID, name, formatReply(call, msg))
+		} else {
+			p.show(c.no, "<", "%d %s: %s (%d), status %d", reply.ID, name, wire.ErrorName(reply.Code), reply.Code, reply.Status)
+		}
+	case wire.ReplyAsync:
+		p.show(c.no, "<", "%s%s", wire.AsyncName(reply.Code), formatAsync(reply))
+	case wire.ReplyProtocolError:
+		p.show(c.no, "<", "protocol error: %s", reply.Args[0])
+	}
+}
+
+// Return the end of the n first whitespace-separated fields of a
+// message, which must not be Hollerith strings.
+func skipFields(msg string, n int) int {
+	ix := 0
+	for ; n > 0; n-- {
+		for ix < len(msg) && msg[ix] == ' ' {
+			ix++
+		}
+		for ix < len(msg) && msg[ix] != ' ' {
+			ix++
+		}
+	}
+	return ix
+}
+
+// Format the arguments of a message, after its n first fields, with a
+// leading space unless there are none.
+func formatArgs(msg string, n int) string {
+	rest := strings.TrimSpace(msg[skipFields(msg, n):])
+	if rest == "" {
+		return ""
+	}
+	return " " + wire.Format(rest)
+}
+
+// Replace the call number of a request.
+func rewriteCall(msg string, call uint32) string {
+	start := skipFields(msg, 1)
+	for start < len(msg) && msg[start] == ' ' {
+		start++
+	}
+	end := skipFields(msg, 2)
+	return msg[:start] + strconv.FormatUint(uint64(call), 10) + msg[end:]
+}
+
+// Argument names of the asynchronous messages we know, a trailing
+// "$" marking string arguments.
+var asyncArgs = map[uint32][]string{
+	5:  {"conf", "old-name$", "new-name$"},
+	8:  {"conf"},
+	9:  {"person", "session"},
+	12: {"recipient", "sender", "message$"},
+	13: {"person", "session"},
+	14: {"text"},
+	15: {"text"},
+	16: {"text", "conf", "type"},
+	17: {"text", "conf", "type"},
+	18: {"person", "conf"},
+	19: {"person", "old-text", "new-text"},
+	20: {"conf", "old-text", "new-text"},
+	21: {"conf", "text"},
+	22: {"text"},
+}
+
+// Format the arguments of an asynchronous message, naming the ones we
+// know.
+func formatAsync(reply wire.Reply) string {
+	var b strings.Builder
+	names := asyncArgs[reply.Code]
+	for ix, arg := range reply.Args {
+		b.WriteByte(' ')
+		if ix >= len(names) {
+			b.WriteString(arg)
+			continue
+		}
+		name := strings.TrimSuffix(names[ix], "$")
+		if name != names[ix] {
+			arg = strconv.Quote(arg)
+		}
+		fmt.Fprintf(&b, "%s=%s", name, arg)
+	}
+	return b.String()
+}
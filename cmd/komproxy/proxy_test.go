@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSkipFields(t *testing.T) {
+	cases := []struct {
+		msg  string
+		n    int
+		want int
+	}{
+		{"5 82", 0, 0},
+		{"5 82", 1, 1},
+		{"5 82", 2, 4},
+		{"5 82", 3, 4},
+		{"  5  82 17", 2, 7},
+		{"=5 0 1", 1, 2},
+		{"", 2, 0},
+	}
+
+	for _, c := range cases {
+		if got := skipFields(c.msg, c.n); got != c.want {
+			t.Errorf("skipFields(%q, %d), saw %d, want %d", c.msg, c.n, got, c.want)
+		}
+	}
+}
+
+func TestRewriteCall(t *testing.T) {
+	cases := []struct {
+		msg  string
+		call uint32
+		want string
+	}{
+		{"5 82", 80, "5 80"},
+		{"17 91 6", 78, "17 78 6"},
+		{"17  91 6", 78, "17  78 6"},
+		{"3 62 6 6Hsecret 0", 0, "3 0 6 6Hsecret 0"},
+		{"4711 1 5Hhello", 105, "4711 105 5Hhello"},
+	}
+
+	for _, c := range cases {
+		if got := rewriteCall(c.msg, c.call); got != c.want {
+			t.Errorf("rewriteCall(%q, %d), saw %q, want %q", c.msg, c.call, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+// Typed decoding of the replies to the common calls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vatine/komandgo/pkg/types"
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+// Decodes the arguments of an OK reply, returning a human-readable
+// description of them. Decoding errors are left in the Args.
+type replyFormatter func(a *wire.Args) string
+
+// Reply formatters, by call number. Replies to other calls are shown
+// token by token.
+var replyFormatters = map[uint32]replyFormatter{
+	23:  formatMarks,       // get-marks
+	35:  formatTime,        // get-time
+	49:  formatPerson,      // get-person-stat
+	52:  formatConfNos,     // get-unread-confs
+	56:  formatSessionNo,   // who-am-i
+	78:  formatUConference, // get-uconf-stat
+	90:  formatTextStat,    // get-text-stat
+	91:  formatConference,  // get-conf-stat
+	107: formatMembership,  // query-read-texts
+	108: formatMemberships, // get-membership
+}
+
+// Times are shown as the server sends them, which is in its own time
+// zone unless the client has asked for UTC, so they are decoded with
+// the default codec and shown without a zone.
+const timeLayout = "2006-01-02T15:04:05"
+
+var times = types.TimeCodec{}
+
+// Format the arguments of the OK reply to a call, decoding them if we
+// know the call, and falling back on showing the tokens if we do not,
+// or if they cannot be decoded. Returns an empty string for replies
+// without arguments, and a leading space otherwise.
+func formatReply(call uint32, msg string) string {
+	if f, ok := replyFormatters[call]; ok {
+		if reply, err := wire.ParseReply(msg); err == nil {
+			a := wire.NewArgs(reply.Args)
+			s := f(a)
+			if a.Err() == nil && a.Done() {
+				return " " + s
+			}
+		}
+	}
+	return formatArgs(msg[1:], 1)
+}
+
+// Return the names of the flags that are set, comma-separated, or
+// "none".
+func flagNames(names []string, flags ...bool) string {
+	var set []string
+	for ix, flag := range flags {
+		if flag {
+			set = append(set, names[ix])
+		}
+	}
+	if len(set) == 0 {
+		return "none"
+	}
+	return strings.Join(set, ",")
+}
+
+func describeAuxItems(items []types.AuxItem) string {
+	var rv []string
+	for _, item := range items {
+		rv = append(rv, fmt.Sprintf("%d:%s=%s", item.AuxNo, types.AuxTagName(item.Tag), strconv.Quote(item.Data())))
+	}
+	return "{" + strings.Join(rv, " ") + "}"
+}
+
+// Names of the misc-info selectors, by selector.
+var miscInfoNames = map[types.InfoType]string{
+	types.Recipient:    "recipient",
+	types.CCRecipient:  "cc-recipient",
+	types.CommentTo:    "comment-to",
+	types.CommentIn:    "commented-in",
+	types.FootnoteTo:   "footnote-to",
+	types.FootnoteIn:   "footnoted-in",
+	types.LocalNo:      "local-no",
+	types.ReceiveTime:  "received-at",
+	types.SentBy:       "sent-by",
+	types.SentAt:       "sent-at",
+	types.BCCRecipient: "bcc-recipient",
+}
+
+func describeMiscInfos(items []types.MiscInfo) string {
+	var rv []string
+	for _, mi := range items {
+		var value interface{}
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient:
+			value = mi.Recipient
+		case types.CCRecipient:
+			value = mi.CCRecipient
+		case types.CommentTo:
+			value = mi.CommentTo
+		case types.CommentIn:
+			value = mi.CommentedIn
+		case types.FootnoteTo:
+			value = mi.FootnoteTo
+		case types.FootnoteIn:
+			value = mi.FootnotedIn
+		case types.LocalNo:
+			value = mi.LocalNo
+		case types.ReceiveTime:
+			value = mi.ReceivedAt.Format(timeLayout)
+		case types.SentBy:
+			value = mi.Sender
+		case types.SentAt:
+			value = mi.SentAt.Format(timeLayout)
+		case types.BCCRecipient:
+			value = mi.BCCRecipient
+		}
+		rv = append(rv, fmt.Sprintf("%s=%v", miscInfoNames[types.InfoType(mi.Selector)], value))
+	}
+	return "{" + strings.Join(rv, " ") + "}"
+}
+
+func describeConfType(t types.ExtendedConfType) string {
+	return flagNames([]string{"rd-prot", "original", "secret", "letterbox", "allow-anonymous", "forbid-secret"},
+		t.RdProt, t.Original, t.Secret, t.LetterBox, t.AllowAnonymous, t.ForbidSecret)
+}
+
+func describeMembership(m types.Membership) string {
+	var ranges []string
+	for _, r := range m.ReadRanges {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", r.FirstRead, r.LastRead))
+	}
+	return fmt.Sprintf("conf=%d position=%d priority=%d last-read=%s read={%s} added-by=%d added-at=%s type=%s",
+		m.Conference, m.Position, m.Priority, m.LastTimeRead.Format(timeLayout),
+		strings.Join(ranges, " "), m.AddedBy, m.AddedAt.Format(timeLayout),
+		flagNames([]string{"invitation", "passive", "secret", "passive-message-invert"},
+			m.Type.Invitation, m.Type.Passive, m.Type.Secret, m.Type.PassiveMessageInver))
+}
+
+func formatMarks(a *wire.Args) string {
+	var rv []string
+
+	n, present := a.ArrayStart()
+	if present {
+		for ix := uint32(0); ix < n && a.Err() == nil; ix++ {
+			mark := types.Mark{TextNo: types.TextNo(a.UInt32()), Type: a.UInt8()}
+			rv = append(rv, fmt.Sprintf("%d:%d", mark.TextNo, mark.Type))
+		}
+		a.ArrayEnd()
+	}
+
+	return "marks={" + strings.Join(rv, " ") + "}"
+}
+
+func formatTime(a *wire.Args) string {
+	return "time=" + a.Time(times).Format(timeLayout)
+}
+
+func formatPerson(a *wire.Args) string {
+	var p types.Person
+
+	p.Username = a.String()
+	p.Privileges = a.PrivBits()
+	p.Flags = a.PersonalFlags()
+	p.LastLogin = a.Time(times)
+	p.UserArea = types.TextNo(a.UInt32())
+	p.TotalTimePresent = a.UInt32()
+	p.Sessions = a.UInt32()
+	p.CreatedLines = a.UInt32()
+	p.CreatedBytes = a.UInt32()
+	p.ReadTexts = a.UInt32()
+	p.Testfetches = a.UInt32()
+	p.CreatedPersons = a.UInt16()
+	p.CreatedConferences = a.UInt16()
+	p.FirstCreatedLocalNo = a.UInt32()
+	p.CreatedTexts = a.UInt32()
+	p.Marks = a.UInt16()
+	p.Conferences = a.UInt16()
+
+	privs := flagNames([]string{"wheel", "admin", "statistic", "create-pers", "create-conf", "change-name"},
+		p.Privileges.Wheel, p.Privileges.Admin, p.Privileges.Statistic,
+		p.Privileges.CreatePersons, p.Privileges.CreateConferences, p.Privileges.ChangeName)
+	return fmt.Sprintf("username=%s privileges=%s last-login=%s user-area=%d sessions=%d created-texts=%d marks=%d confs=%d",
+		strconv.Quote(p.Username), privs, p.LastLogin.Format(timeLayout), p.UserArea,
+		p.Sessions, p.CreatedTexts, p.Marks, p.Conferences)
+}
+
+func formatConfNos(a *wire.Args) string {
+	var rv []string
+	for _, conf := range a.UInt32Array() {
+		rv = append(rv, fmt.Sprint(types.ConfNo(conf)))
+	}
+	return "confs={" + strings.Join(rv, " ") + "}"
+}
+
+func formatSessionNo(a *wire.Args) string {
+	return fmt.Sprintf("session=%d", types.SessionNo(a.UInt32()))
+}
+
+func formatUConference(a *wire.Args) string {
+	var c types.UConference
+
+	c.Name = a.String()
+	c.Type = a.ConfType()
+	c.HighestLocalNo = types.TextNo(a.UInt32())
+	c.Nice = a.UInt32()
+
+	return fmt.Sprintf("name=%s type=%s highest-local-no=%d nice=%d",
+		strconv.Quote(c.Name), describeConfType(c.Type), c.HighestLocalNo, c.Nice)
+}
+
+func formatTextStat(a *wire.Args) string {
+	ts := a.TextStat(times)
+
+	return fmt.Sprintf("created=%s author=%d lines=%d chars=%d marks=%d misc=%s aux=%s",
+		ts.CreationTime.Format(timeLayout), ts.Author, ts.Lines, ts.Chars, ts.Marks,
+		describeMiscInfos(ts.MiscInfo), describeAuxItems(ts.AuxItems))
+}
+
+func formatConference(a *wire.Args) string {
+	var c types.Conference
+
+	c.Name = a.String()
+	c.Type = a.ConfType()
+	c.CreationTime = a.Time(times)
+	c.LastWritten = a.Time(times)
+	c.Creator = types.ConfNo(a.UInt32())
+	c.Presentation = types.TextNo(a.UInt32())
+	c.Supervisor = types.ConfNo(a.UInt32())
+	c.PermittedSubmitters = types.ConfNo(a.UInt32())
+	c.SuperConf = types.ConfNo(a.UInt32())
+	c.MsgOfDay = types.TextNo(a.UInt32())
+	c.Nice = a.UInt32()
+	c.KeepCommented = a.UInt32()
+	c.NoOfMembers = a.UInt32()
+	c.FirstLocalNo = types.TextNo(a.UInt32())
+	c.NoOfTexts = a.UInt32()
+	c.Expire = a.UInt32()
+	c.AuxItems = a.AuxItems(times)
+
+	return fmt.Sprintf("name=%s type=%s created=%s last-written=%s creator=%d supervisor=%d "+
+		"presentation=%d members=%d first-local-no=%d texts=%d nice=%d keep-commented=%d expire=%d aux=%s",
+		strconv.Quote(c.Name), describeConfType(c.Type), c.CreationTime.Format(timeLayout),
+		c.LastWritten.Format(timeLayout), c.Creator, c.Supervisor, c.Presentation,
+		c.NoOfMembers, c.FirstLocalNo, c.NoOfTexts,
+		c.Nice, c.KeepCommented, c.Expire, describeAuxItems(c.AuxItems))
+}
+
+func formatMembership(a *wire.Args) string {
+	return describeMembership(a.Membership(times))
+}
+
+func formatMemberships(a *wire.Args) string {
+	var rv []string
+
+	n, present := a.ArrayStart()
+	if present {
+		for ix := uint32(0); ix < n && a.Err() == nil; ix++ {
+			rv = append(rv, "{"+describeMembership(a.Membership(times))+"}")
+		}
+		a.ArrayEnd()
+	}
+
+	return "memberships={" + strings.Join(rv, " ") + "}"
+}
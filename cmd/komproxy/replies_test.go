@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+func TestFormatReply(t *testing.T) {
+	when := types.TimeCodec{}.Encode(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC))
+
+	cases := []struct {
+		call uint32
+		msg  string
+		want string
+	}{
+		{82, "=5", ""},
+		{35, "=5 " + when, " time=2024-05-01T12:30:00"},
+		{56, "=5 17", " session=17"},
+		{52, "=5 2 { 6 17 }", " confs={6 17}"},
+		{52, "=5 0 *", " confs={}"},
+		{23, "=5 1 { 4711 100 }", " marks={4711:100}"},
+		{78, "=5 11HFilmklubben 00000000 47 77", ` name="Filmklubben" type=none highest-local-no=47 nice=77`},
+		{
+			90,
+			"=5 " + when + " 6 1 3 0 2 { 0 17 6 8 } 1 { 1 1 6 " + when + " 00000000 0 10Htext/plain }",
+			" created=2024-05-01T12:30:00 author=6 lines=1 chars=3 marks=0 misc={recipient=17 local-no=8} aux={1:content-type=\"text/plain\"}",
+		},
+		{
+			91,
+			"=5 11HFilmklubben 10010000 " + when + " " + when + " 6 0 6 0 0 0 77 77 3 1 8 0 0 *",
+			` name="Filmklubben" type=rd-prot,letterbox created=2024-05-01T12:30:00 last-written=2024-05-01T12:30:00 creator=6 supervisor=6 presentation=0 members=3 first-local-no=1 texts=8 nice=77 keep-commented=77 expire=0 aux={}`,
+		},
+		{
+			108,
+			"=5 1 { 0 " + when + " 17 255 1 { 1 8 } 6 " + when + " 01000000 }",
+			" memberships={{conf=17 position=0 priority=255 last-read=2024-05-01T12:30:00 read={1-8} added-by=6 added-at=2024-05-01T12:30:00 type=passive}}",
+		},
+		// Unknown calls, and replies that cannot be decoded, are
+		// shown token by token.
+		{4711, "=5 3Hfoo 17", ` "foo" 17`},
+		{90, "=5 17", " 17"},
+		{52, "=5 2 { 6 17 } 4711", " 2 { 6 17 } 4711"},
+	}
+
+	for _, c := range cases {
+		if got := formatReply(c.call, c.msg); got != c.want {
+			t.Errorf("formatReply(%d, %q)\nsaw  %q\nwant %q", c.call, c.msg, got, c.want)
+		}
+	}
+}
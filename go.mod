@@ -2,6 +2,7 @@ module github.com/vatine/komandgo
 
 go 1.19
 
-require github.com/sirupsen/logrus v1.8.3
-
-require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+require (
+	github.com/sirupsen/logrus v1.8.3
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8
+)
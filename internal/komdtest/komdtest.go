@@ -0,0 +1,36 @@
+// Package komdtest holds helpers for tests that talk to an in-memory
+// komd server.
+package komdtest
+
+import (
+	"net"
+
+	"github.com/vatine/komandgo/pkg/komd"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Return a store with a person, "Ingrid Bergman" with the password
+// "secret", who is a member of a conference, "Filmklubben".
+func NewStore() (store *komd.Store, pers, conf types.ConfNo) {
+	store = komd.NewStore()
+	pers, err := store.CreatePerson("Ingrid Bergman", "secret")
+	if err == nil {
+		conf, err = store.CreateConf("Filmklubben", types.ExtendedConfType{}, pers)
+	}
+	if err == nil {
+		err = store.AddMember(conf, pers, 100)
+	}
+	if err != nil {
+		// Cannot happen in an empty store
+		panic(err)
+	}
+	return store, pers, conf
+}
+
+// Return the client end of an in-memory connection to a server, the
+// other end served like an accepted connection.
+func Pipe(srv *komd.Server) net.Conn {
+	client, server := net.Pipe()
+	go srv.ServeConn(server)
+	return client
+}
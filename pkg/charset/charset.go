@@ -0,0 +1,160 @@
+// A package for converting between the character sets used by LysKOM
+// servers and UTF-8 Go strings
+package charset
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Canonical names of the supported character sets
+const (
+	UTF8     = "utf-8"
+	Latin1   = "iso-8859-1"
+	Latin9   = "iso-8859-15"
+	USASCII  = "us-ascii"
+	Fallback = Latin1
+)
+
+type CharsetError string
+
+func (e CharsetError) Error() string {
+	return string(e)
+}
+
+var aliases = map[string]string{
+	"utf-8":       UTF8,
+	"utf8":        UTF8,
+	"iso-8859-1":  Latin1,
+	"iso8859-1":   Latin1,
+	"iso_8859-1":  Latin1,
+	"latin1":      Latin1,
+	"latin-1":     Latin1,
+	"l1":          Latin1,
+	"iso-8859-15": Latin9,
+	"iso8859-15":  Latin9,
+	"iso_8859-15": Latin9,
+	"latin9":      Latin9,
+	"latin-9":     Latin9,
+	"us-ascii":    USASCII,
+	"ascii":       USASCII,
+}
+
+// The code points where ISO-8859-15 differs from ISO-8859-1
+var latin9Runes = map[byte]rune{
+	0xa4: '€',
+	0xa6: 'Š',
+	0xa8: 'š',
+	0xb4: 'Ž',
+	0xb8: 'ž',
+	0xbc: 'Œ',
+	0xbd: 'œ',
+	0xbe: 'Ÿ',
+}
+
+var latin9Bytes = map[rune]byte{}
+
+func init() {
+	for b, r := range latin9Runes {
+		latin9Bytes[r] = b
+	}
+}
+
+// Return the canonical name of a character set, and whether it is
+// supported.
+func Canonical(name string) (string, bool) {
+	cs, ok := aliases[strings.ToLower(strings.TrimSpace(name))]
+	return cs, ok
+}
+
+// Return the character set named in a MIME content type, such as
+// "text/plain; charset=utf-8". If the content type names no character
+// set, or cannot be parsed, the empty string is returned.
+func FromContentType(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return params["charset"]
+}
+
+// Convert a string of bytes in the named character set to a UTF-8
+// string. Bytes that cannot be converted are replaced with the
+// Unicode replacement character and an error is returned alongside
+// the converted string.
+func Decode(data, name string) (string, error) {
+	cs, ok := Canonical(name)
+	if !ok {
+		return data, CharsetError("Unsupported character set " + name)
+	}
+
+	var b strings.Builder
+	var err error
+
+	switch cs {
+	case UTF8:
+		if utf8.ValidString(data) {
+			return data, nil
+		}
+		return strings.ToValidUTF8(data, "�"), CharsetError("Invalid UTF-8")
+	case USASCII:
+		for ix := 0; ix < len(data); ix++ {
+			c := data[ix]
+			if c >= 0x80 {
+				b.WriteRune(utf8.RuneError)
+				err = CharsetError("Non-ASCII byte in US-ASCII text")
+				continue
+			}
+			b.WriteByte(c)
+		}
+	case Latin1, Latin9:
+		for ix := 0; ix < len(data); ix++ {
+			c := data[ix]
+			if r, ok := latin9Runes[c]; ok && cs == Latin9 {
+				b.WriteRune(r)
+				continue
+			}
+			b.WriteRune(rune(c))
+		}
+	}
+
+	return b.String(), err
+}
+
+// Convert a UTF-8 string to a string of bytes in the named character
+// set. Characters that cannot be represented are replaced with '?'
+// and an error is returned alongside the converted string. The length
+// of the result, in bytes, is what goes into a Hollerith string.
+func Encode(s, name string) (string, error) {
+	cs, ok := Canonical(name)
+	if !ok {
+		return s, CharsetError("Unsupported character set " + name)
+	}
+	if cs == UTF8 {
+		return s, nil
+	}
+
+	var b strings.Builder
+	var err error
+
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b.WriteByte(byte(r))
+		case cs == Latin9 && latin9Bytes[r] != 0:
+			b.WriteByte(latin9Bytes[r])
+		case cs == Latin9 && r <= 0xff && latin9Runes[byte(r)] != 0:
+			// Replaced by another character in ISO-8859-15
+			b.WriteByte('?')
+			err = CharsetError("Character not representable in " + cs)
+		case cs != USASCII && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+			err = CharsetError("Character not representable in " + cs)
+		}
+	}
+
+	return b.String(), err
+}
@@ -0,0 +1,98 @@
+package charset
+
+import (
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"UTF-8", UTF8, true},
+		{" latin1 ", Latin1, true},
+		{"ISO-8859-15", Latin9, true},
+		{"ascii", USASCII, true},
+		{"koi8-r", "", false},
+	}
+
+	for ix, c := range cases {
+		saw, ok := Canonical(c.name)
+		if saw != c.want || ok != c.ok {
+			t.Errorf("Case #%d, saw %q/%v, want %q/%v", ix, saw, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestFromContentType(t *testing.T) {
+	cases := []struct {
+		contentType string
+		want        string
+	}{
+		{"text/plain", ""},
+		{"text/plain;charset=utf-8", "utf-8"},
+		{"text/plain; charset=\"ISO-8859-1\"", "ISO-8859-1"},
+		{"x-kom/basic", ""},
+		{";;;", ""},
+	}
+
+	for ix, c := range cases {
+		if saw := FromContentType(c.contentType); saw != c.want {
+			t.Errorf("Case #%d, saw %q, want %q", ix, saw, c.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		data    string
+		charset string
+		want    string
+		err     bool
+	}{
+		{"r\xe4ksm\xf6rg\xe5s", Latin1, "räksmörgås", false},
+		{"\xa4", Latin1, "¤", false},
+		{"\xa4", Latin9, "€", false},
+		{"räksmörgås", UTF8, "räksmörgås", false},
+		{"r\xe4k", UTF8, "r�k", true},
+		{"r\xe4k", USASCII, "r�k", true},
+		{"abc", "ebcdic", "abc", true},
+	}
+
+	for ix, c := range cases {
+		saw, err := Decode(c.data, c.charset)
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %q, want %q", ix, saw, c.want)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("Case #%d, unexpected error state %v", ix, err)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	cases := []struct {
+		s       string
+		charset string
+		want    string
+		err     bool
+	}{
+		{"räksmörgås", Latin1, "r\xe4ksm\xf6rg\xe5s", false},
+		{"räksmörgås", UTF8, "räksmörgås", false},
+		{"€", Latin9, "\xa4", false},
+		{"¤", Latin9, "?", true},
+		{"€", Latin1, "?", true},
+		{"å", USASCII, "?", true},
+	}
+
+	for ix, c := range cases {
+		saw, err := Encode(c.s, c.charset)
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %q, want %q", ix, saw, c.want)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("Case #%d, unexpected error state %v", ix, err)
+		}
+	}
+}
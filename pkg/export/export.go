@@ -0,0 +1,405 @@
+// Package export writes the texts of LysKOM conferences as RFC 5322
+// mail messages, to an mbox file or a Maildir, for archiving
+// conferences outside LysKOM.
+//
+// The texts of a conference are walked in local number order with
+// local-to-global. Each text becomes a message with the author in
+// From, the recipients in To and Cc, the creation time in Date, and
+// the texts it comments in In-Reply-To and References, so that mail
+// readers can thread them. Persons, conferences and texts get made-up
+// addresses and Message-IDs in the exporter's domain. Texts are
+// converted to UTF-8, while binary texts are base64-encoded with
+// their own content type.
+//
+// With a State, an export resumes after the last text exported from
+// the conference the previous time.
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/charset"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The calls the exporter needs. A *protocol.KomClient is one.
+type Source interface {
+	LocalToGlobal(conf types.ConfNo, firstLocal types.TextNo, count uint32) (types.TextMapping, error)
+	GetTextStat(text types.TextNo) (types.TextStat, error)
+	GetRawText(text types.TextNo) (types.TextStat, string, error)
+	ConfName(conf types.ConfNo) (string, error)
+	TextCharset(auxItems []types.AuxItem) string
+}
+
+// The domain used for addresses and Message-IDs, if none is given.
+const DefaultDomain = "lyskom.invalid"
+
+// The largest number of texts local-to-global returns in one call.
+const localToGlobalMax = 255
+
+// The most Message-IDs put in References. Longer chains keep the root
+// and the closest ancestors.
+const maxReferences = 20
+
+// The longest line sent as is, longer lines are quoted-printable
+// encoded (RFC 5322 allows 998 characters).
+const maxLineLength = 998
+
+// A Message is an exported text, as an RFC 5322 message with LF line
+// endings.
+type Message struct {
+	// The envelope sender, the author's address
+	Sender string
+	Date   time.Time
+	Data   []byte
+}
+
+// An Exporter exports conferences from a Source to a Mailbox.
+type Exporter struct {
+	Source  Source
+	Mailbox Mailbox
+	// The domain for addresses and Message-IDs, DefaultDomain if
+	// empty
+	Domain string
+	// Where the export resumes from, if not nil
+	State *State
+
+	parents map[types.TextNo]types.TextNo
+}
+
+// Return an exporter writing the texts read from source to mailbox.
+func New(source Source, mailbox Mailbox) *Exporter {
+	return &Exporter{
+		Source:  source,
+		Mailbox: mailbox,
+		Domain:  DefaultDomain,
+	}
+}
+
+func (e *Exporter) domain() string {
+	if e.Domain == "" {
+		return DefaultDomain
+	}
+	return e.Domain
+}
+
+// Export the texts of a conference not exported before, returning the
+// number of texts exported. Texts that cannot be read are skipped.
+// With a State, it is saved after each batch of texts, and when
+// returning.
+func (e *Exporter) Export(conf types.ConfNo) (int, error) {
+	var n int
+	start := types.TextNo(1)
+	if e.State != nil {
+		start = e.State.Last(conf) + 1
+	}
+
+	for {
+		mapping, err := e.Source.LocalToGlobal(conf, start, localToGlobalMax)
+		if err != nil {
+			var noLocal protocol.NoSuchLocalTextError
+			if errors.As(err, &noLocal) {
+				// Nothing more to export
+				return n, e.save()
+			}
+			return n, e.saveAfter(err)
+		}
+
+		for _, pair := range mapping.Texts {
+			msg, err := e.Message(pair.GlobalNo)
+			var noText protocol.NoSuchTextError
+			switch {
+			case errors.As(err, &noText):
+				// Deleted since the mapping was fetched
+			case err != nil:
+				return n, e.saveAfter(fmt.Errorf("Exporting text %d: %w", pair.GlobalNo, err))
+			default:
+				if err := e.Mailbox.Deliver(msg); err != nil {
+					return n, e.saveAfter(err)
+				}
+				n++
+			}
+			if e.State != nil {
+				e.State.Set(conf, pair.LocalNo)
+			}
+		}
+		if err := e.save(); err != nil {
+			return n, err
+		}
+
+		if !mapping.LaterTextsExist || mapping.RangeEnd <= start {
+			return n, nil
+		}
+		start = mapping.RangeEnd
+	}
+}
+
+func (e *Exporter) save() error {
+	if e.State == nil {
+		return nil
+	}
+	return e.State.Save()
+}
+
+// Save the state after an error, returning the error.
+func (e *Exporter) saveAfter(err error) error {
+	e.save()
+	return err
+}
+
+// Return the name of a person or conference, or a placeholder if it
+// cannot be found.
+func (e *Exporter) name(conf types.ConfNo, placeholder string) string {
+	name, err := e.Source.ConfName(conf)
+	if err != nil {
+		return fmt.Sprintf(placeholder, conf)
+	}
+	return name
+}
+
+func (e *Exporter) personAddress(pers types.ConfNo) string {
+	if pers == 0 {
+		return fmt.Sprintf("anonymous@%s", e.domain())
+	}
+	return fmt.Sprintf("person-%d@%s", pers, e.domain())
+}
+
+func (e *Exporter) author(pers types.ConfNo) string {
+	name := "Anonymous"
+	if pers != 0 {
+		name = e.name(pers, "Person %d")
+	}
+	return (&mail.Address{Name: name, Address: e.personAddress(pers)}).String()
+}
+
+func (e *Exporter) recipients(confs []types.ConfNo) string {
+	var rv []string
+	for _, conf := range confs {
+		addr := mail.Address{
+			Name:    e.name(conf, "Conference %d"),
+			Address: fmt.Sprintf("conf-%d@%s", conf, e.domain()),
+		}
+		rv = append(rv, addr.String())
+	}
+	return strings.Join(rv, ",\n\t")
+}
+
+func (e *Exporter) messageID(text types.TextNo) string {
+	return fmt.Sprintf("<text-%d@%s>", text, e.domain())
+}
+
+// The texts a text comments, or is a footnote to.
+func commented(stat types.TextStat) []types.TextNo {
+	var rv []types.TextNo
+	for _, mi := range stat.MiscInfo {
+		switch types.InfoType(mi.Selector) {
+		case types.FootnoteTo:
+			rv = append(rv, mi.FootnoteTo)
+		case types.CommentTo:
+			rv = append(rv, mi.CommentTo)
+		}
+	}
+	return rv
+}
+
+// Return the first text a text comments, zero for none, remembering
+// it for later.
+func (e *Exporter) parent(text types.TextNo) types.TextNo {
+	if parent, ok := e.parents[text]; ok {
+		return parent
+	}
+	var parent types.TextNo
+	if stat, err := e.Source.GetTextStat(text); err == nil {
+		if p := commented(stat); len(p) > 0 {
+			parent = p[0]
+		}
+	}
+	if e.parents == nil {
+		e.parents = make(map[types.TextNo]types.TextNo)
+	}
+	e.parents[text] = parent
+	return parent
+}
+
+// Return the texts to put in References for a text commenting parent,
+// root first.
+func (e *Exporter) references(parent types.TextNo) []types.TextNo {
+	var chain []types.TextNo
+	seen := make(map[types.TextNo]bool)
+	for text := parent; text != 0 && !seen[text]; text = e.parent(text) {
+		seen[text] = true
+		chain = append(chain, text)
+	}
+
+	if len(chain) > maxReferences {
+		chain = append(chain[:maxReferences-1], chain[len(chain)-1])
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// Convert a string in a character set to UTF-8. Bytes not valid in
+// the character set become replacement characters, as do bytes not
+// valid in UTF-8 if the character set is unknown, so a mislabelled
+// text is still exported, and labelled as UTF-8.
+func decodeText(s, cs string) string {
+	if _, ok := charset.Canonical(cs); !ok {
+		return strings.ToValidUTF8(s, "\ufffd")
+	}
+	rv, _ := charset.Decode(s, cs)
+	return rv
+}
+
+// Convert a text into a message.
+func (e *Exporter) Message(no types.TextNo) (Message, error) {
+	stat, raw, err := e.Source.GetRawText(no)
+	if err != nil {
+		return Message{}, err
+	}
+	var contentType string
+	if item, ok := types.FindAuxItem(stat.AuxItems, types.AuxContentType); ok {
+		contentType = item.Data()
+	}
+	text := types.ParseText(raw, contentType)
+	cs := e.Source.TextCharset(stat.AuxItems)
+	text.Subject = decodeText(text.Subject, cs)
+	if text.IsText() && !text.IsMultipart() {
+		text.Body = decodeText(text.Body, cs)
+	}
+
+	var to, cc []types.ConfNo
+	for _, mi := range stat.MiscInfo {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient:
+			to = append(to, mi.Recipient)
+		case types.CCRecipient:
+			cc = append(cc, mi.CCRecipient)
+		}
+	}
+	parents := commented(stat)
+	if e.parents == nil {
+		e.parents = make(map[types.TextNo]types.TextNo)
+	}
+	if len(parents) > 0 {
+		e.parents[no] = parents[0]
+	} else {
+		e.parents[no] = 0
+	}
+
+	var b bytes.Buffer
+	header := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+
+	header("From", e.author(stat.Author))
+	header("To", e.recipients(to))
+	header("Cc", e.recipients(cc))
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(text.Subject)))
+	header("Date", stat.CreationTime.Format(time.RFC1123Z))
+	header("Message-ID", e.messageID(no))
+	if len(parents) > 0 {
+		var ids []string
+		for _, parent := range parents {
+			ids = append(ids, e.messageID(parent))
+		}
+		header("In-Reply-To", strings.Join(ids, " "))
+
+		ids = nil
+		for _, ref := range e.references(parents[0]) {
+			ids = append(ids, e.messageID(ref))
+		}
+		header("References", strings.Join(ids, "\n\t"))
+	}
+	header("X-LysKOM-Text", fmt.Sprint(no))
+	header("MIME-Version", "1.0")
+	writeBody(&b, text)
+
+	return Message{
+		Sender: e.personAddress(stat.Author),
+		Date:   stat.CreationTime,
+		Data:   b.Bytes(),
+	}, nil
+}
+
+// Return true if a string has non-ASCII bytes, and if it has lines
+// too long to send as is.
+func scanBody(s string) (nonASCII, longLines bool) {
+	lineLength := 0
+	for ix := 0; ix < len(s); ix++ {
+		switch c := s[ix]; {
+		case c == '\n':
+			lineLength = 0
+			continue
+		case c >= 0x80:
+			nonASCII = true
+		}
+		lineLength++
+		if lineLength > maxLineLength {
+			longLines = true
+		}
+	}
+	return nonASCII, longLines
+}
+
+// Write the Content-Type and Content-Transfer-Encoding headers, and
+// the body, of a text.
+func writeBody(b *bytes.Buffer, text types.Text) {
+	if !text.IsText() {
+		fmt.Fprintf(b, "Content-Type: %s\n", text.FullContentType())
+		b.WriteString("Content-Transfer-Encoding: base64\n\n")
+		encoded := base64.StdEncoding.EncodeToString([]byte(text.Body))
+		for len(encoded) > 76 {
+			b.WriteString(encoded[:76] + "\n")
+			encoded = encoded[76:]
+		}
+		if encoded != "" {
+			b.WriteString(encoded + "\n")
+		}
+		return
+	}
+
+	if !text.IsMultipart() {
+		// The text has been converted to UTF-8, and
+		// text/x-kom-basic is plain text to mail readers.
+		if text.MediaType() == types.ContentKomBasic {
+			text.ContentType = types.ContentPlain
+		}
+		text.Charset = "utf-8"
+	}
+	fmt.Fprintf(b, "Content-Type: %s\n", text.FullContentType())
+
+	body := text.Body
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	nonASCII, longLines := scanBody(body)
+	switch {
+	case longLines && !text.IsMultipart():
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\n\n")
+		var qp bytes.Buffer
+		w := quotedprintable.NewWriter(&qp)
+		w.Write([]byte(body))
+		w.Close()
+		// The writer ends lines with CRLF, as sent over SMTP.
+		b.Write(bytes.ReplaceAll(qp.Bytes(), []byte("\r\n"), []byte("\n")))
+		return
+	case nonASCII || longLines:
+		b.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+	default:
+		b.WriteString("Content-Transfer-Encoding: 7bit\n\n")
+	}
+	b.WriteString(body)
+}
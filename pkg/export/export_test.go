@@ -0,0 +1,342 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/internal/komdtest"
+	"github.com/vatine/komandgo/pkg/komd"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Return a client logged in to a komd server, with a conference to
+// export.
+func testClient(t *testing.T) (*protocol.KomClient, types.ConfNo) {
+	t.Helper()
+
+	store, _, conf := komdtest.NewStore()
+	srv := komd.NewServer(store)
+	t.Cleanup(func() { srv.Close() })
+
+	k, err := protocol.NewKomClientConn(komdtest.Pipe(srv))
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	if err := k.Login("ingrid", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	return k, conf
+}
+
+func createText(t *testing.T, k *protocol.KomClient, text types.Text, misc ...types.MiscInfo) types.TextNo {
+	t.Helper()
+
+	no, err := k.CreateTextContent(text, misc, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating a text, %v", err)
+	}
+	return no
+}
+
+// Read the messages of a Maildir, by text number.
+func readMaildir(t *testing.T, dir string) map[string]*mail.Message {
+	t.Helper()
+
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rv := make(map[string]*mail.Message)
+	for _, entry := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, "new", entry.Name()))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s, %v", entry.Name(), err)
+		}
+		rv[msg.Header.Get("X-LysKOM-Text")] = msg
+	}
+	return rv
+}
+
+func TestExport(t *testing.T) {
+	k, conf := testClient(t)
+	to := types.RecipientMisc(conf)
+
+	root := createText(t, k, types.NewText("Hej", "Första inlägget", "", ""), to)
+	comment := createText(t, k, types.NewText("Re: Hej", "Svar", "", ""), to, types.CommentToMisc(root))
+	createText(t, k, types.NewText("Re: Hej", "Svar på svar", "", ""), to, types.CommentToMisc(comment))
+	createText(t, k, types.NewText("Räksmörgås", "Smörgåsbord", "text/plain", "iso-8859-1"), to)
+
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	maildir, err := OpenMaildir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	statePath := filepath.Join(t.TempDir(), "state")
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	e := New(k, maildir)
+	e.Domain = "kom.example"
+	e.State = state
+	n, err := e.Export(conf)
+	if err != nil {
+		t.Fatalf("unexpected error exporting, %v", err)
+	}
+	if n != 4 {
+		t.Errorf("exported %d texts, want 4", n)
+	}
+
+	msgs := readMaildir(t, dir)
+	if len(msgs) != 4 {
+		t.Fatalf("saw %d messages, want 4", len(msgs))
+	}
+
+	first := msgs["1"]
+	from, err := mail.ParseAddress(first.Header.Get("From"))
+	if err != nil || from.Name != "Ingrid Bergman" || from.Address != "person-1@kom.example" {
+		t.Errorf("unexpected From %q, %v", first.Header.Get("From"), err)
+	}
+	if got := first.Header.Get("To"); !strings.Contains(got, "Filmklubben") {
+		t.Errorf("unexpected To %q", got)
+	}
+	if got := first.Header.Get("Message-ID"); got != "<text-1@kom.example>" {
+		t.Errorf("unexpected Message-ID %q", got)
+	}
+	if _, err := first.Header.Date(); err != nil {
+		t.Errorf("unexpected error parsing Date, %v", err)
+	}
+	if got := first.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", got)
+	}
+	if body, _ := io.ReadAll(first.Body); string(body) != "Första inlägget\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	third := msgs["3"]
+	if got := third.Header.Get("In-Reply-To"); got != "<text-2@kom.example>" {
+		t.Errorf("unexpected In-Reply-To %q", got)
+	}
+	if got := strings.Fields(third.Header.Get("References")); len(got) != 2 || got[0] != "<text-1@kom.example>" || got[1] != "<text-2@kom.example>" {
+		t.Errorf("unexpected References %q", got)
+	}
+
+	latin1 := msgs["4"]
+	subject, err := new(mime.WordDecoder).DecodeHeader(latin1.Header.Get("Subject"))
+	if err != nil || subject != "Räksmörgås" {
+		t.Errorf("unexpected Subject %q, %v", subject, err)
+	}
+	if body, _ := io.ReadAll(latin1.Body); string(body) != "Smörgåsbord\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	// Exporting again picks up where the last export stopped.
+	createText(t, k, types.NewText("Nytt", "Ett nytt inlägg", "", ""), to)
+	state, err = LoadState(statePath)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if state.Last(conf) != 4 {
+		t.Errorf("saw last exported text %d, want 4", state.Last(conf))
+	}
+	e.State = state
+	if n, err := e.Export(conf); err != nil || n != 1 {
+		t.Errorf("exported %d texts, %v, want 1", n, err)
+	}
+	if n, err := e.Export(conf); err != nil || n != 0 {
+		t.Errorf("exported %d texts, %v, want 0", n, err)
+	}
+	if msgs := readMaildir(t, dir); len(msgs) != 5 || msgs["5"] == nil {
+		t.Errorf("saw %d messages, want 5", len(msgs))
+	}
+}
+
+// A Source with a single text, in a conference of its own.
+type fakeSource struct {
+	raw         string
+	contentType string
+	charset     string
+}
+
+func (f fakeSource) LocalToGlobal(conf types.ConfNo, firstLocal types.TextNo, count uint32) (types.TextMapping, error) {
+	if firstLocal > 1 {
+		return types.TextMapping{}, protocol.NoSuchLocalTextError{LocalNo: firstLocal}
+	}
+	return types.TextMapping{
+		RangeBegin: 1,
+		RangeEnd:   2,
+		Texts:      []types.TextNumberPair{{LocalNo: 1, GlobalNo: 4711}},
+	}, nil
+}
+
+func (f fakeSource) GetTextStat(text types.TextNo) (types.TextStat, error) {
+	return types.TextStat{Author: 6}, nil
+}
+
+func (f fakeSource) GetRawText(text types.TextNo) (types.TextStat, string, error) {
+	stat := types.TextStat{Author: 6}
+	if f.contentType != "" {
+		item := types.AuxItem{Tag: types.AuxContentType}
+		item.SetData(f.contentType)
+		stat.AuxItems = []types.AuxItem{item}
+	}
+	return stat, f.raw, nil
+}
+
+func (f fakeSource) ConfName(conf types.ConfNo) (string, error) {
+	return "", protocol.UndefinedConferenceError{Conf: conf}
+}
+
+func (f fakeSource) TextCharset(auxItems []types.AuxItem) string {
+	return f.charset
+}
+
+func TestExportMislabelled(t *testing.T) {
+	cases := []struct {
+		source      fakeSource
+		subject     string
+		contentType string
+		body        string
+	}{
+		// UTF-8 in a text said to be US-ASCII
+		{
+			fakeSource{raw: "Räksmörgås\nSmörgåsbord", charset: "us-ascii"},
+			"R\ufffd\ufffdksm\ufffd\ufffdrg\ufffd\ufffds",
+			"text/plain; charset=utf-8",
+			"Sm\ufffd\ufffdrg\ufffd\ufffdsbord\n",
+		},
+		// A multipart text from a Latin-1 server, where the
+		// parts keep their own character sets.
+		{
+			fakeSource{
+				raw:         "R\xe4ksm\xf6rg\xe5s\n--b\nContent-Type: text/plain; charset=iso-8859-1\n\nSm\xf6rg\xe5s\n--b--\n",
+				contentType: "multipart/mixed; boundary=b",
+				charset:     "iso-8859-1",
+			},
+			"Räksmörgås",
+			"multipart/mixed; boundary=b",
+			"--b\nContent-Type: text/plain; charset=iso-8859-1\n\nSm\xf6rg\xe5s\n--b--\n",
+		},
+		// A character set we cannot decode
+		{
+			fakeSource{raw: "Sm\xf6rg\xe5s\nHej d\xe5", contentType: "text/plain; charset=koi8-r", charset: "koi8-r"},
+			"Sm\ufffdrg\ufffds",
+			"text/plain; charset=utf-8",
+			"Hej d\ufffd\n",
+		},
+	}
+
+	for ix, c := range cases {
+		dir := filepath.Join(t.TempDir(), "Maildir")
+		maildir, err := OpenMaildir(dir)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		state, err := LoadState(filepath.Join(t.TempDir(), "state"))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		e := New(c.source, maildir)
+		e.State = state
+		if n, err := e.Export(17); err != nil || n != 1 {
+			t.Fatalf("Case #%d, exported %d texts, %v, want 1", ix, n, err)
+		}
+		if state.Last(17) != 1 {
+			t.Errorf("Case #%d, saw last exported text %d, want 1", ix, state.Last(17))
+		}
+
+		msg := readMaildir(t, dir)["4711"]
+		if msg == nil {
+			t.Fatalf("Case #%d, text 4711 was not exported", ix)
+		}
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if err != nil || subject != c.subject {
+			t.Errorf("Case #%d, saw subject %q/%v, want %q", ix, subject, err, c.subject)
+		}
+		if contentType := msg.Header.Get("Content-Type"); contentType != c.contentType {
+			t.Errorf("Case #%d, saw content type %q, want %q", ix, contentType, c.contentType)
+		}
+		if body, _ := io.ReadAll(msg.Body); string(body) != c.body {
+			t.Errorf("Case #%d, saw body %q, want %q", ix, body, c.body)
+		}
+	}
+}
+
+func TestWriteBody(t *testing.T) {
+	cases := []struct {
+		text types.Text
+		want string
+	}{
+		{
+			types.NewText("", "hello", "", ""),
+			"Content-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: 7bit\n\nhello\n",
+		},
+		{
+			types.NewText("", "<bold>hej</bold>\n", "text/enriched", "iso-8859-1"),
+			"Content-Type: text/enriched; charset=utf-8\nContent-Transfer-Encoding: 7bit\n\n<bold>hej</bold>\n",
+		},
+		{
+			types.NewText("", "\x89PNG\r\n", "image/png", ""),
+			"Content-Type: image/png\nContent-Transfer-Encoding: base64\n\niVBORw0K\n",
+		},
+		{
+			types.NewText("", strings.Repeat("å", 500), "", ""),
+			"Content-Type: text/plain; charset=utf-8\nContent-Transfer-Encoding: quoted-printable\n\n",
+		},
+	}
+
+	for ix, c := range cases {
+		var b bytes.Buffer
+		writeBody(&b, c.text)
+		if !strings.HasPrefix(b.String(), c.want) {
+			t.Errorf("Case #%d, saw %q, want %q", ix, b.String(), c.want)
+		}
+		if strings.Contains(b.String(), "\r") {
+			t.Errorf("Case #%d, saw CR in %q", ix, b.String())
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	s, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	s.Set(17, 4711)
+	s.Set(6, 1)
+	if err := s.Save(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if want := "6 1\n17 4711\n"; !strings.HasSuffix(string(b), want) {
+		t.Errorf("saw %q, want it to end with %q", b, want)
+	}
+	s, err = LoadState(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s.Last(17) != 4711 || s.Last(6) != 1 || s.Last(7) != 0 {
+		t.Errorf("unexpected state %+v", s.last)
+	}
+
+	os.WriteFile(path, []byte("17\n"), 0600)
+	if _, err := LoadState(path); err == nil {
+		t.Errorf("expected an error loading a malformed state")
+	}
+}
@@ -0,0 +1,141 @@
+package export
+
+// Writing messages to mbox files and Maildirs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A Mailbox is where exported messages are delivered.
+type Mailbox interface {
+	Deliver(msg Message) error
+	Close() error
+}
+
+// An Mbox appends messages to an mbox file, in the mboxrd format:
+// lines in the body starting with "From ", after any number of ">",
+// get another ">".
+type Mbox struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// Open an mbox file for appending, creating it if needed.
+func OpenMbox(path string) (*Mbox, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Mbox{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Return true if a line needs quoting in an mbox file.
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// Append a message to the mbox file. The message is written to disk
+// before Deliver returns.
+func (m *Mbox) Deliver(msg Message) error {
+	fmt.Fprintf(m.w, "From %s %s\n", msg.Sender, msg.Date.UTC().Format(time.ANSIC))
+
+	data := msg.Data
+	for len(data) > 0 {
+		line := data
+		if ix := bytes.IndexByte(data, '\n'); ix >= 0 {
+			line = data[:ix+1]
+		}
+		data = data[len(line):]
+		if isFromLine(line) {
+			m.w.WriteByte('>')
+		}
+		m.w.Write(line)
+	}
+	if !bytes.HasSuffix(msg.Data, []byte("\n")) {
+		m.w.WriteByte('\n')
+	}
+	m.w.WriteByte('\n')
+
+	if err := m.w.Flush(); err != nil {
+		return err
+	}
+	return m.f.Sync()
+}
+
+func (m *Mbox) Close() error {
+	err := m.w.Flush()
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// A Maildir delivers each message as a file of its own in the new
+// subdirectory of a Maildir.
+type Maildir struct {
+	dir      string
+	hostname string
+}
+
+// Counts deliveries, for unique file names.
+var deliveries uint64
+
+// Open a Maildir, creating it and its tmp, new and cur subdirectories
+// if needed.
+func OpenMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	// "/" and ":" have special meanings in Maildir file names.
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+
+	return &Maildir{dir: dir, hostname: hostname}, nil
+}
+
+// Deliver a message, by writing it to tmp and moving it to new. The
+// file's modification time is set to the message's date.
+func (m *Maildir) Deliver(msg Message) error {
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&deliveries, 1), m.hostname)
+	tmp := filepath.Join(m.dir, "tmp", name)
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(msg.Data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !msg.Date.IsZero() {
+		err = os.Chtimes(tmp, msg.Date, msg.Date)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(m.dir, "new", name))
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (m *Maildir) Close() error {
+	return nil
+}
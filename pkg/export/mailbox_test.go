@@ -0,0 +1,69 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	date := time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC)
+
+	for _, data := range []string{
+		"Subject: Hej\n\nFrom here\n>From there\nFromage\n",
+		"Subject: Utan radslut\n\nsista raden",
+	} {
+		m, err := OpenMbox(path)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := m.Deliver(Message{Sender: "person-6@kom.example", Date: date, Data: []byte(data)}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := m.Close(); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	want := "From person-6@kom.example Thu Jul 17 19:47:23 1997\n" +
+		"Subject: Hej\n\n>From here\n>>From there\nFromage\n\n" +
+		"From person-6@kom.example Thu Jul 17 19:47:23 1997\n" +
+		"Subject: Utan radslut\n\nsista raden\n\n"
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if string(b) != want {
+		t.Errorf("saw %q, want %q", b, want)
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Maildir")
+	m, err := OpenMaildir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	date := time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC)
+	for ix := 0; ix < 2; ix++ {
+		if err := m.Deliver(Message{Date: date, Data: []byte("Subject: Hej\n\nHej\n")}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	for _, sub := range []string{"tmp", "cur"} {
+		if entries, err := os.ReadDir(filepath.Join(dir, sub)); err != nil || len(entries) != 0 {
+			t.Errorf("saw %d files in %s, %v, want none", len(entries), sub, err)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("saw %d files in new, %v, want 2", len(entries), err)
+	}
+	info, err := entries[0].Info()
+	if err != nil || !info.ModTime().Equal(date) {
+		t.Errorf("unexpected modification time %v, %v", info.ModTime(), err)
+	}
+}
@@ -0,0 +1,103 @@
+package export
+
+// Remembering how far each conference has been exported
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// A State records the last local text number exported from each
+// conference, so that an export can be resumed. It is kept in a file
+// with one "conference local-number" line per conference.
+type State struct {
+	path string
+	last map[types.ConfNo]types.TextNo
+}
+
+// Read the state from a file. A file that does not exist is an empty
+// state, which is created when saved.
+func LoadState(path string) (*State, error) {
+	rv := &State{path: path, last: make(map[types.ConfNo]types.TextNo)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rv, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: malformed line %q", path, lineNo, line)
+		}
+		conf, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad conference %q", path, lineNo, fields[0])
+		}
+		local, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: bad local text number %q", path, lineNo, fields[1])
+		}
+		rv.last[types.ConfNo(conf)] = types.TextNo(local)
+	}
+	return rv, s.Err()
+}
+
+// Return the last local text number exported from a conference, zero
+// if nothing has been.
+func (s *State) Last(conf types.ConfNo) types.TextNo {
+	return s.last[conf]
+}
+
+// Record that a conference has been exported up to a local number.
+func (s *State) Set(conf types.ConfNo, local types.TextNo) {
+	s.last[conf] = local
+}
+
+// Write the state to its file. The file is replaced atomically, so an
+// interrupted save leaves the old state.
+func (s *State) Save() error {
+	var confs []types.ConfNo
+	for conf := range s.last {
+		confs = append(confs, conf)
+	}
+	sort.Slice(confs, func(i, j int) bool { return confs[i] < confs[j] })
+
+	var b strings.Builder
+	b.WriteString("# The last local text number exported from each conference\n")
+	for _, conf := range confs {
+		fmt.Fprintf(&b, "%d %d\n", conf, s.last[conf])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".kom-export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,271 @@
+package gateway
+
+// Pushing asynchronous messages to browsers as Server-Sent Events
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Event names, as sent in the event field and given in the events
+// parameter of GET /events.
+const (
+	EventNewText = "new-text"
+	EventMessage = "message"
+	EventLogin   = "login"
+	EventLogout  = "logout"
+)
+
+// The number of events queued for a stream. A stream falling further
+// behind than this is closed, and the browser has to reconnect.
+const eventBuffer = 64
+
+// The most event streams a session may have open at once.
+const maxStreams = 8
+
+// How often a comment is sent on an idle stream, to keep proxies from
+// closing it. An open stream also keeps its session from expiring.
+const keepAliveInterval = 30 * time.Second
+
+// The data of a new-text event, a text has been created in one of the
+// conferences subscribed to.
+type NewTextEvent struct {
+	TextNo types.TextNo
+	Stat   types.TextStat
+}
+
+// The data of a message event, a message sent by another session.
+// Kind is "personal", "group" or "alarm".
+type MessageEvent struct {
+	Sender    types.ConfNo
+	Recipient types.ConfNo
+	Kind      string
+	Text      string
+}
+
+// The data of login and logout events.
+type SessionEvent struct {
+	Person  types.ConfNo
+	Session types.SessionNo
+}
+
+// An encoded event, ready to be written to a stream.
+type event struct {
+	name string
+	data []byte
+}
+
+// What an event stream is interested in.
+type eventFilter struct {
+	events map[string]bool
+	// The conferences to send new texts in
+	confs map[types.ConfNo]bool
+}
+
+// Build the filter of an event stream from its request. Without a conf
+// parameter, new texts are sent for the conferences the person is an
+// active member of.
+func newEventFilter(s *session, r *http.Request) (*eventFilter, error) {
+	rv := &eventFilter{
+		events: make(map[string]bool),
+		confs:  make(map[types.ConfNo]bool),
+	}
+
+	q := r.URL.Query()
+	names := q.Get("events")
+	if names == "" {
+		names = strings.Join([]string{EventNewText, EventMessage, EventLogin, EventLogout}, ",")
+	}
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case EventNewText, EventMessage, EventLogin, EventLogout:
+			rv.events[name] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("Unknown event %q", name)
+		}
+	}
+
+	if confs := q.Get("conf"); confs != "" {
+		for _, conf := range strings.Split(confs, ",") {
+			n, err := strconv.ParseUint(strings.TrimSpace(conf), 10, 16)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("Bad conference number %q", conf)
+			}
+			rv.confs[types.ConfNo(n)] = true
+		}
+		return rv, nil
+	}
+
+	if rv.events[EventNewText] {
+		memberships, err := s.client.GetMembership(s.client.Person(), 0, math.MaxUint16)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range memberships {
+			if !m.Type.Passive {
+				rv.confs[m.Conference] = true
+			}
+		}
+	}
+	return rv, nil
+}
+
+// Return the event for an asynchronous message, and if the stream
+// wants it.
+func (f *eventFilter) event(k *protocol.KomClient, msg protocol.AsyncMessage) (string, interface{}, bool) {
+	switch m := msg.(type) {
+	case protocol.AsyncNewText:
+		for _, mi := range m.Stat.MiscInfo {
+			var conf types.ConfNo
+			switch types.InfoType(mi.Selector) {
+			case types.Recipient:
+				conf = mi.Recipient
+			case types.CCRecipient:
+				conf = mi.CCRecipient
+			case types.BCCRecipient:
+				conf = mi.BCCRecipient
+			default:
+				continue
+			}
+			if f.confs[conf] {
+				return EventNewText, NewTextEvent{TextNo: m.Text, Stat: m.Stat}, f.events[EventNewText]
+			}
+		}
+		return EventNewText, nil, false
+	case protocol.AsyncSendMessage:
+		mess := k.MessageFrom(m)
+		return EventMessage, MessageEvent{
+			Sender:    mess.Sender,
+			Recipient: mess.Recipient,
+			Kind:      mess.Kind.String(),
+			Text:      mess.Text,
+		}, f.events[EventMessage]
+	case protocol.AsyncLogin:
+		return EventLogin, SessionEvent{Person: m.Person, Session: m.Session}, f.events[EventLogin]
+	case protocol.AsyncLogout:
+		return EventLogout, SessionEvent{Person: m.Person, Session: m.Session}, f.events[EventLogout]
+	}
+	return "", nil, false
+}
+
+// Filter and encode the asynchronous messages of a session onto a
+// stream's queue, until the session is gone or the stream is done. If
+// the queue fills up, overflow is closed and no more events are
+// queued.
+func pumpEvents(s *session, f *eventFilter, msgs <-chan protocol.AsyncMessage, queue chan<- event, overflow, done chan struct{}) {
+	for {
+		var msg protocol.AsyncMessage
+		var ok bool
+		select {
+		case msg, ok = <-msgs:
+			if !ok {
+				// The connection to the server is lost
+				return
+			}
+		case <-done:
+			return
+		case <-s.done:
+			return
+		}
+
+		name, data, ok := f.event(s.client, msg)
+		if !ok {
+			continue
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+				"event": name,
+			}).Error("gateway - encoding event")
+			continue
+		}
+
+		select {
+		case queue <- event{name: name, data: b}:
+		default:
+			log.WithFields(log.Fields{
+				"person": s.client.Person(),
+			}).Warning("gateway - event stream not keeping up, closing it")
+			close(overflow)
+			return
+		}
+	}
+}
+
+// GET /events, a stream of Server-Sent Events. The events parameter
+// picks the events to send, as a comma-separated list, default all of
+// them. The conf parameter picks the conferences to send new texts in.
+func (g *Gateway) events(s *session, w http.ResponseWriter, r *http.Request, arg string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	f, err := newEventFilter(s, r)
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+	err = s.client.AcceptAsync(protocol.AsyncLoginNo, protocol.AsyncSendMessageNo, protocol.AsyncLogoutNo, protocol.AsyncNewTextNo)
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+
+	if !g.openStream(s) {
+		writeError(w, http.StatusTooManyRequests, "Too many event streams open")
+		return
+	}
+	defer g.closeStream(s)
+
+	msgs, cancel := s.client.Subscribe()
+	defer cancel()
+	queue := make(chan event, eventBuffer)
+	overflow := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go pumpEvents(s, f, msgs, queue, overflow, done)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e := <-queue:
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+		case <-keepAlive.C:
+			g.touch(s)
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case <-overflow:
+			return
+		case <-s.done:
+			return
+		case <-s.client.Done():
+			g.logout(s)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Log in through the gateway, returning the session token.
+func login(t *testing.T, hs *httptest.Server, user, password string) string {
+	t.Helper()
+
+	var rv LoginResponse
+	if status := do(t, "POST", hs.URL+"/session", "", Login{User: user, Password: password}, &rv); status != http.StatusCreated {
+		t.Fatalf("got status %d logging in as %s, want %d", status, user, http.StatusCreated)
+	}
+	return rv.Token
+}
+
+// An open event stream.
+type stream struct {
+	resp  *http.Response
+	lines *bufio.Scanner
+}
+
+func openStream(t *testing.T, url, token string) *stream {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d opening %s, want %d", resp.StatusCode, url, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q, want text/event-stream", ct)
+	}
+	return &stream{resp: resp, lines: bufio.NewScanner(resp.Body)}
+}
+
+// Read the next event from a stream, decoding its data into v.
+func (s *stream) next(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	timer := time.AfterFunc(5*time.Second, func() { s.resp.Body.Close() })
+	defer timer.Stop()
+
+	var name string
+	for s.lines.Scan() {
+		line := s.lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), v); err != nil {
+				t.Fatalf("unexpected error decoding %q, %v", line, err)
+			}
+		case line == "" && name != "":
+			return name
+		}
+	}
+	t.Fatalf("stream ended waiting for an event, %v", s.lines.Err())
+	return ""
+}
+
+func TestEvents(t *testing.T) {
+	hs, g, pers, conf := testGateway(t)
+
+	ingrid := login(t, hs, "Ingrid Bergman", "secret")
+	events := openStream(t, hs.URL+"/events", ingrid)
+
+	var created NewTextResponse
+	post := NewText{Text: types.Text{Subject: "Hej", Body: "Nytt inlägg"}, Recipients: []types.ConfNo{conf}}
+	if status := do(t, "POST", hs.URL+"/texts", ingrid, post, &created); status != http.StatusCreated {
+		t.Fatalf("got status %d creating a text, want %d", status, http.StatusCreated)
+	}
+
+	var text NewTextEvent
+	if name := events.next(t, &text); name != EventNewText {
+		t.Fatalf("got event %q, want %q", name, EventNewText)
+	}
+	if text.TextNo != created.TextNo || text.Stat.Author != pers {
+		t.Errorf("unexpected new-text event %+v", text)
+	}
+
+	// A second stream of the same session, for messages only
+	messages := openStream(t, hs.URL+"/events?events=message", ingrid)
+	g.lock.Lock()
+	s := g.sessions[ingrid]
+	g.lock.Unlock()
+	if err := s.client.Broadcast("Hallå"); err != nil {
+		t.Fatalf("unexpected error sending a message, %v", err)
+	}
+	if status := do(t, "POST", hs.URL+"/texts", ingrid, post, &created); status != http.StatusCreated {
+		t.Fatalf("got status %d creating a text, want %d", status, http.StatusCreated)
+	}
+
+	var msg MessageEvent
+	if name := messages.next(t, &msg); name != EventMessage {
+		t.Fatalf("got event %q, want %q", name, EventMessage)
+	}
+	if msg.Text != "Hallå" || msg.Kind != "alarm" || msg.Sender != pers {
+		t.Errorf("unexpected message event %+v", msg)
+	}
+
+	// The first stream sees both, in order
+	if name := events.next(t, &msg); name != EventMessage {
+		t.Errorf("got event %q, want %q", name, EventMessage)
+	}
+	if name := events.next(t, &text); name != EventNewText || text.TextNo != created.TextNo {
+		t.Errorf("got event %q %+v, want %q for text %d", name, text, EventNewText, created.TextNo)
+	}
+
+	logins := openStream(t, hs.URL+"/events?events=login,logout", ingrid)
+	login(t, hs, "ingrid", "secret")
+	var sess SessionEvent
+	if name := logins.next(t, &sess); name != EventLogin || sess.Person != pers {
+		t.Errorf("got event %q %+v, want %q for person %d", name, sess, EventLogin, pers)
+	}
+
+	// Logging out ends the streams
+	if status := do(t, "DELETE", hs.URL+"/session", ingrid, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("got status %d logging out, want %d", status, http.StatusNoContent)
+	}
+	for events.lines.Scan() {
+	}
+}
+
+func TestEventsBadRequest(t *testing.T) {
+	hs, _, _, _ := testGateway(t)
+	token := login(t, hs, "ingrid", "secret")
+
+	for _, q := range []string{"?events=bogus", "?conf=x"} {
+		if status := do(t, "GET", hs.URL+"/events"+q, token, nil, nil); status != http.StatusBadRequest {
+			t.Errorf("got status %d for %s, want %d", status, q, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	f := &eventFilter{
+		events: map[string]bool{EventNewText: true, EventLogin: true},
+		confs:  map[types.ConfNo]bool{17: true},
+	}
+
+	newText := func(misc ...types.MiscInfo) protocol.AsyncMessage {
+		return protocol.AsyncNewText{Text: 4711, Stat: types.TextStat{MiscInfo: misc}}
+	}
+	cases := []struct {
+		msg  protocol.AsyncMessage
+		want bool
+	}{
+		{newText(types.RecipientMisc(17)), true},
+		{newText(types.RecipientMisc(18), types.CCRecipientMisc(17)), true},
+		{newText(types.RecipientMisc(18)), false},
+		{protocol.AsyncLogin{Person: 6, Session: 10}, true},
+		{protocol.AsyncLogout{Person: 6, Session: 10}, false},
+		{protocol.AsyncSendMessage{Sender: 6, Message: "hi"}, false},
+	}
+
+	k := &protocol.KomClient{}
+	for ix, c := range cases {
+		if _, _, ok := f.event(k, c.msg); ok != c.want {
+			t.Errorf("Case #%d, saw %v, want %v", ix, ok, c.want)
+		}
+	}
+}
+
+func TestEventsConnectionLost(t *testing.T) {
+	srv, _, _ := testServer(t)
+	hs, g := newTestGateway(t, srv, nil)
+	token := login(t, hs, "ingrid", "secret")
+	s := openStream(t, hs.URL+"/events", token)
+
+	// Closes the server end of all connections
+	srv.Close()
+	timer := time.AfterFunc(5*time.Second, func() { s.resp.Body.Close() })
+	defer timer.Stop()
+	for s.lines.Scan() {
+	}
+	if err := s.lines.Err(); err != nil {
+		t.Errorf("unexpected error reading the stream, %v", err)
+	}
+
+	g.lock.Lock()
+	n := len(g.sessions)
+	g.lock.Unlock()
+	if n != 0 {
+		t.Errorf("saw %d sessions after the stream ended, want 0", n)
+	}
+	if status := do(t, "GET", hs.URL+"/events", token, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d after losing the connection, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	s := &session{client: &protocol.KomClient{}, done: make(chan struct{})}
+	f := &eventFilter{events: map[string]bool{EventLogin: true}}
+	msgs := make(chan protocol.AsyncMessage)
+	queue := make(chan event, 2)
+	overflow := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go pumpEvents(s, f, msgs, queue, overflow, done)
+
+	for ix := 0; ix < 3; ix++ {
+		msgs <- protocol.AsyncLogin{Person: 6, Session: types.SessionNo(ix)}
+	}
+	select {
+	case <-overflow:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the stream to overflow")
+	}
+	if len(queue) != 2 {
+		t.Errorf("saw %d queued events, want 2", len(queue))
+	}
+}
@@ -0,0 +1,399 @@
+// Package gateway is an HTTP/JSON gateway in front of KomClient, for
+// web frontends that cannot speak Protocol A. Each web session logs
+// in to LysKOM with a session of its own:
+//
+//	POST   /session           log in, with {"User", "Password", "Invisible"}
+//	DELETE /session           log out
+//	GET    /texts/{no}        a text, as {"TextNo", "Stat", "Text"}
+//	POST   /texts             create a text, see NewText
+//	GET    /conferences/{no}  the status of a conference
+//	GET    /conferences?name= conferences matching a name
+//	GET    /persons/{no}      the status of a person
+//	GET    /persons?name=     persons matching a name
+//	GET    /events            a stream of Server-Sent Events, see below
+//
+// Logging in returns a token, which is sent with the other requests
+// as "Authorization: Bearer <token>". It is also set as a cookie, for
+// browsers. Sessions idle for longer than IdleTimeout are logged out.
+//
+// Responses are the types of pkg/types, encoded as JSON. Errors are
+// returned as {"Error": "message"}, with a matching status code.
+//
+// The event stream pushes the asynchronous messages of the session:
+// new texts (new-text), messages from other sessions (message), and
+// persons logging in and out (login, logout). The events parameter
+// picks which, as in "?events=new-text,message", and the conf
+// parameter picks the conferences to send new texts in, by default
+// the ones the person is an active member of. Each event's data is a
+// JSON object, see NewTextEvent, MessageEvent and SessionEvent. A
+// stream that does not keep up is closed, and the browser's
+// EventSource reconnects.
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The default time a session may be idle before it is logged out.
+const DefaultIdleTimeout = 30 * time.Minute
+
+// The largest request body accepted.
+const maxBody = 1 << 20
+
+// A Gateway is an http.Handler serving the REST API.
+type Gateway struct {
+	// Connect to the LysKOM server, once for each web session.
+	Dial func() (*protocol.KomClient, error)
+
+	// How long a session may be idle before it is logged out.
+	IdleTimeout time.Duration
+
+	lock     sync.Mutex
+	sessions map[string]*session
+	done     chan struct{}
+}
+
+// The body of POST /session.
+type Login struct {
+	User      string
+	Password  string
+	Invisible bool
+}
+
+// The response to POST /session.
+type LoginResponse struct {
+	Token  string
+	Person types.ConfNo
+}
+
+// A text, as returned by GET /texts/{no}.
+type Text struct {
+	TextNo types.TextNo
+	Stat   types.TextStat
+	Text   types.Text
+}
+
+// The body of POST /texts. A text needs at least one recipient, or to
+// be a comment or footnote. The content type defaults to
+// text/x-kom-basic.
+type NewText struct {
+	types.Text
+	Recipients   []types.ConfNo
+	CCRecipients []types.ConfNo
+	CommentTo    []types.TextNo
+	FootnoteTo   []types.TextNo
+}
+
+// The response to POST /texts.
+type NewTextResponse struct {
+	TextNo types.TextNo
+}
+
+// The body of error responses.
+type Error struct {
+	Error string
+}
+
+// Return a gateway to a LysKOM server, given as "host:port".
+func New(server string) *Gateway {
+	g := &Gateway{
+		Dial:        func() (*protocol.KomClient, error) { return protocol.NewKomClient(server) },
+		IdleTimeout: DefaultIdleTimeout,
+		sessions:    make(map[string]*session),
+		done:        make(chan struct{}),
+	}
+	go g.expireLoop()
+	return g
+}
+
+// Log out all sessions, and stop expiring idle ones.
+func (g *Gateway) Close() error {
+	close(g.done)
+
+	g.lock.Lock()
+	var all []*session
+	for _, s := range g.sessions {
+		all = append(all, s)
+	}
+	g.lock.Unlock()
+
+	for _, s := range all {
+		g.logout(s)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Debug("gateway - writing response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, Error{Error: fmt.Sprintf(format, args...)})
+}
+
+// Write an error from KomClient, with a status code matching the
+// protocol error.
+func writeKomError(w http.ResponseWriter, err error) {
+	var noText protocol.NoSuchTextError
+	var noConf protocol.UndefinedConferenceError
+	var notImplemented protocol.NotImplementedError
+	var lost protocol.ConnectionLostError
+
+	switch {
+	case errors.As(err, &noText), errors.As(err, &noConf):
+		writeError(w, http.StatusNotFound, "%v", err)
+	case errors.As(err, &notImplemented):
+		writeError(w, http.StatusNotImplemented, "%v", err)
+	case errors.As(err, &lost):
+		writeError(w, http.StatusBadGateway, "%v", err)
+	default:
+		writeError(w, http.StatusBadRequest, "%v", err)
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if len(path) == 1 && path[0] == "session" {
+		switch r.Method {
+		case http.MethodPost:
+			g.postSession(w, r)
+		case http.MethodDelete:
+			g.deleteSession(w, r)
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", r.Method)
+		}
+		return
+	}
+
+	var handler func(*session, http.ResponseWriter, *http.Request, string)
+	method := http.MethodGet
+	switch {
+	case len(path) == 1 && path[0] == "texts":
+		handler, method = g.postText, http.MethodPost
+	case len(path) == 2 && path[0] == "texts":
+		handler = g.getText
+	case len(path) == 1 && path[0] == "conferences":
+		handler = g.findConferences
+	case len(path) == 2 && path[0] == "conferences":
+		handler = g.getConference
+	case len(path) == 1 && path[0] == "persons":
+		handler = g.findPersons
+	case len(path) == 2 && path[0] == "persons":
+		handler = g.getPerson
+	case len(path) == 1 && path[0] == "events":
+		handler = g.events
+	default:
+		writeError(w, http.StatusNotFound, "No such resource %s", r.URL.Path)
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "Method %s not allowed", r.Method)
+		return
+	}
+
+	s := g.session(r)
+	if s == nil {
+		writeError(w, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+	arg := ""
+	if len(path) == 2 {
+		arg = path[1]
+	}
+	handler(s, w, r, arg)
+}
+
+// Decode a JSON request body.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "Malformed request body, %v", err)
+		return false
+	}
+	return true
+}
+
+func (g *Gateway) postSession(w http.ResponseWriter, r *http.Request) {
+	var req Login
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.User == "" {
+		writeError(w, http.StatusBadRequest, "No user given")
+		return
+	}
+
+	s, err := g.login(req.User, req.Password, req.Invisible)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"user":  req.User,
+		}).Info("gateway - login failed")
+		var dialErr dialError
+		if errors.As(err, &dialErr) {
+			writeError(w, http.StatusBadGateway, "%v", err)
+			return
+		}
+		writeError(w, http.StatusUnauthorized, "Login failed, %v", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    s.token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	writeJSON(w, http.StatusCreated, LoginResponse{Token: s.token, Person: s.client.Person()})
+}
+
+func (g *Gateway) deleteSession(w http.ResponseWriter, r *http.Request) {
+	s := g.session(r)
+	if s == nil {
+		writeError(w, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+	g.logout(s)
+	http.SetCookie(w, &http.Cookie{Name: cookieName, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Parse a text or conference number from a path.
+func parseNumber(w http.ResponseWriter, arg string, bits int) (uint64, bool) {
+	n, err := strconv.ParseUint(arg, 10, bits)
+	if err != nil || n == 0 {
+		writeError(w, http.StatusBadRequest, "Bad number %q", arg)
+		return 0, false
+	}
+	return n, true
+}
+
+func (g *Gateway) getText(s *session, w http.ResponseWriter, r *http.Request, arg string) {
+	n, ok := parseNumber(w, arg, 32)
+	if !ok {
+		return
+	}
+	no := types.TextNo(n)
+
+	stat, err := s.client.GetTextStat(no)
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+	text, err := s.client.GetTextContent(no)
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Text{TextNo: no, Stat: stat, Text: text})
+}
+
+func (g *Gateway) postText(s *session, w http.ResponseWriter, r *http.Request, arg string) {
+	var req NewText
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if len(req.Recipients)+len(req.CCRecipients)+len(req.CommentTo)+len(req.FootnoteTo) == 0 {
+		writeError(w, http.StatusBadRequest, "The text has no recipients")
+		return
+	}
+
+	var misc []types.MiscInfo
+	for _, conf := range req.Recipients {
+		misc = append(misc, types.RecipientMisc(conf))
+	}
+	for _, conf := range req.CCRecipients {
+		misc = append(misc, types.CCRecipientMisc(conf))
+	}
+	for _, text := range req.CommentTo {
+		misc = append(misc, types.CommentToMisc(text))
+	}
+	for _, text := range req.FootnoteTo {
+		misc = append(misc, types.FootnoteToMisc(text))
+	}
+
+	text := types.NewText(req.Subject, req.Body, req.ContentType, req.Charset)
+	no, err := s.client.CreateTextContent(text, misc, nil)
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/texts/%d", no))
+	writeJSON(w, http.StatusCreated, NewTextResponse{TextNo: no})
+}
+
+func (g *Gateway) getConference(s *session, w http.ResponseWriter, r *http.Request, arg string) {
+	n, ok := parseNumber(w, arg, 16)
+	if !ok {
+		return
+	}
+	conf, err := s.client.GetConfStat(types.ConfNo(n))
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, conf)
+}
+
+func (g *Gateway) getPerson(s *session, w http.ResponseWriter, r *http.Request, arg string) {
+	n, ok := parseNumber(w, arg, 16)
+	if !ok {
+		return
+	}
+	person, err := s.client.GetPersonStat(types.ConfNo(n))
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, person)
+}
+
+// Look up names (lookup-z-name, #76), for GET /persons and GET
+// /conferences.
+func (g *Gateway) find(s *session, w http.ResponseWriter, r *http.Request, wantPersons, wantConferences bool) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "No name given")
+		return
+	}
+	found, err := s.client.LookupZName(name, wantPersons, wantConferences)
+	if err != nil {
+		writeKomError(w, err)
+		return
+	}
+	if found == nil {
+		found = []types.ConfZInfo{}
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+func (g *Gateway) findPersons(s *session, w http.ResponseWriter, r *http.Request, arg string) {
+	g.find(s, w, r, true, false)
+}
+
+func (g *Gateway) findConferences(s *session, w http.ResponseWriter, r *http.Request, arg string) {
+	g.find(s, w, r, false, true)
+}
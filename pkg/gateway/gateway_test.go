@@ -0,0 +1,240 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/internal/komdtest"
+	"github.com/vatine/komandgo/pkg/komd"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Return a komd server with a person and a conference.
+func testServer(t *testing.T) (*komd.Server, types.ConfNo, types.ConfNo) {
+	store, pers, conf := komdtest.NewStore()
+	srv := komd.NewServer(store)
+	t.Cleanup(func() { srv.Close() })
+	return srv, pers, conf
+}
+
+// Return a gateway dialling a server, with the client end of each
+// connection passed through wrap, unless it is nil.
+func newTestGateway(t *testing.T, srv *komd.Server, wrap func(net.Conn) net.Conn) (*httptest.Server, *Gateway) {
+	g := New("")
+	g.Dial = func() (*protocol.KomClient, error) {
+		conn := komdtest.Pipe(srv)
+		if wrap != nil {
+			conn = wrap(conn)
+		}
+		return protocol.NewKomClientConn(conn)
+	}
+	t.Cleanup(func() { g.Close() })
+
+	hs := httptest.NewServer(g)
+	t.Cleanup(hs.Close)
+	return hs, g
+}
+
+// Return a gateway in front of a komd server with a person and a
+// conference.
+func testGateway(t *testing.T) (*httptest.Server, *Gateway, types.ConfNo, types.ConfNo) {
+	srv, pers, conf := testServer(t)
+	hs, g := newTestGateway(t, srv, nil)
+	return hs, g, pers, conf
+}
+
+// Make a request, decoding the response into rv unless it is nil.
+func do(t *testing.T, method, url, token string, body, rv interface{}) int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer resp.Body.Close()
+	if rv != nil {
+		if err := json.NewDecoder(resp.Body).Decode(rv); err != nil {
+			t.Fatalf("unexpected error decoding %s %s, %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestGateway(t *testing.T) {
+	hs, _, pers, conf := testGateway(t)
+
+	if status := do(t, "GET", hs.URL+"/texts/1", "", nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d without a session, want %d", status, http.StatusUnauthorized)
+	}
+	if status := do(t, "POST", hs.URL+"/session", "", Login{User: "Ingrid Bergman", Password: "wrong"}, nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d logging in with the wrong password, want %d", status, http.StatusUnauthorized)
+	}
+
+	var login LoginResponse
+	if status := do(t, "POST", hs.URL+"/session", "", Login{User: "Ingrid Bergman", Password: "secret"}, &login); status != http.StatusCreated {
+		t.Fatalf("got status %d logging in, want %d", status, http.StatusCreated)
+	}
+	if login.Person != pers || login.Token == "" {
+		t.Errorf("unexpected login response %+v", login)
+	}
+	token := login.Token
+
+	var created NewTextResponse
+	post := NewText{Text: types.Text{Subject: "Hej", Body: "Första inlägget"}, Recipients: []types.ConfNo{conf}}
+	if status := do(t, "POST", hs.URL+"/texts", token, post, &created); status != http.StatusCreated {
+		t.Fatalf("got status %d creating a text, want %d", status, http.StatusCreated)
+	}
+
+	var text Text
+	if status := do(t, "GET", fmt.Sprintf("%s/texts/%d", hs.URL, created.TextNo), token, nil, &text); status != http.StatusOK {
+		t.Fatalf("got status %d getting a text, want %d", status, http.StatusOK)
+	}
+	if text.Text.Subject != "Hej" || text.Text.Body != "Första inlägget" || text.Stat.Author != pers {
+		t.Errorf("unexpected text %+v", text)
+	}
+
+	var e Error
+	if status := do(t, "GET", hs.URL+"/texts/4711", token, nil, &e); status != http.StatusNotFound || e.Error == "" {
+		t.Errorf("got status %d and %+v for a missing text, want %d", status, e, http.StatusNotFound)
+	}
+	if status := do(t, "GET", hs.URL+"/texts/x", token, nil, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for a bad text number, want %d", status, http.StatusBadRequest)
+	}
+	if status := do(t, "POST", hs.URL+"/texts", token, NewText{Text: types.Text{Subject: "Hej"}}, nil); status != http.StatusBadRequest {
+		t.Errorf("got status %d for a text without recipients, want %d", status, http.StatusBadRequest)
+	}
+	if status := do(t, "DELETE", hs.URL+"/texts/1", token, nil, nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for DELETE, want %d", status, http.StatusMethodNotAllowed)
+	}
+
+	var c types.Conference
+	if status := do(t, "GET", fmt.Sprintf("%s/conferences/%d", hs.URL, conf), token, nil, &c); status != http.StatusOK {
+		t.Fatalf("got status %d getting a conference, want %d", status, http.StatusOK)
+	}
+	if c.Name != "Filmklubben" || c.NoOfTexts != 1 {
+		t.Errorf("unexpected conference %+v", c)
+	}
+	if status := do(t, "GET", hs.URL+"/conferences/999", token, nil, nil); status != http.StatusNotFound {
+		t.Errorf("got status %d for a missing conference, want %d", status, http.StatusNotFound)
+	}
+
+	var found []types.ConfZInfo
+	if status := do(t, "GET", hs.URL+"/persons?name=ingrid", token, nil, &found); status != http.StatusOK {
+		t.Fatalf("got status %d looking up persons, want %d", status, http.StatusOK)
+	}
+	if len(found) != 1 || found[0].No != pers {
+		t.Errorf("unexpected persons %+v", found)
+	}
+	found = nil
+	if status := do(t, "GET", hs.URL+"/conferences?name=ingrid", token, nil, &found); status != http.StatusOK {
+		t.Fatalf("got status %d looking up conferences, want %d", status, http.StatusOK)
+	}
+	if len(found) != 0 {
+		t.Errorf("unexpected conferences %+v", found)
+	}
+
+	var p types.Person
+	if status := do(t, "GET", fmt.Sprintf("%s/persons/%d", hs.URL, pers), token, nil, &p); status != http.StatusOK {
+		t.Fatalf("got status %d getting a person, want %d", status, http.StatusOK)
+	}
+
+	if status := do(t, "DELETE", hs.URL+"/session", token, nil, nil); status != http.StatusNoContent {
+		t.Errorf("got status %d logging out, want %d", status, http.StatusNoContent)
+	}
+	if status := do(t, "GET", hs.URL+"/texts/1", token, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d after logging out, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	hs, g, _, _ := testGateway(t)
+
+	var login LoginResponse
+	if status := do(t, "POST", hs.URL+"/session", "", Login{User: "ingrid", Password: "secret"}, &login); status != http.StatusCreated {
+		t.Fatalf("got status %d logging in, want %d", status, http.StatusCreated)
+	}
+
+	g.IdleTimeout = time.Hour
+	g.expire()
+	if status := do(t, "GET", hs.URL+"/persons?name=ingrid", login.Token, nil, nil); status != http.StatusOK {
+		t.Errorf("got status %d before expiry, want %d", status, http.StatusOK)
+	}
+
+	g.IdleTimeout = 0
+	g.expire()
+	if status := do(t, "GET", hs.URL+"/persons?name=ingrid", login.Token, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d after expiry, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+// A connection that is closed as soon as a request has been sent on
+// it, once hang is set.
+type hangUpConn struct {
+	net.Conn
+	hang *atomic.Bool
+}
+
+func (c hangUpConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if c.hang.Load() {
+		c.Conn.Close()
+	}
+	return n, err
+}
+
+func TestConnectionLost(t *testing.T) {
+	srv, _, _ := testServer(t)
+	var hang atomic.Bool
+	hs, g := newTestGateway(t, srv, func(c net.Conn) net.Conn {
+		return hangUpConn{Conn: c, hang: &hang}
+	})
+
+	var login LoginResponse
+	if status := do(t, "POST", hs.URL+"/session", "", Login{User: "ingrid", Password: "secret"}, &login); status != http.StatusCreated {
+		t.Fatalf("got status %d logging in, want %d", status, http.StatusCreated)
+	}
+
+	hang.Store(true)
+	if status := do(t, "GET", hs.URL+"/persons?name=ingrid", login.Token, nil, nil); status != http.StatusBadGateway {
+		t.Errorf("got status %d losing the connection, want %d", status, http.StatusBadGateway)
+	}
+	if status := do(t, "GET", hs.URL+"/persons?name=ingrid", login.Token, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("got status %d after losing the connection, want %d", status, http.StatusUnauthorized)
+	}
+
+	// The session is dropped, without waiting for it to expire.
+	deadline := time.Now().Add(time.Second)
+	for {
+		g.lock.Lock()
+		n := len(g.sessions)
+		g.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("saw %d sessions after losing the connection, want 0", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,212 @@
+package gateway
+
+// Web sessions, each with a LysKOM session of its own
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+)
+
+// The cookie carrying the session token, for browsers.
+const cookieName = "kom-session"
+
+// A web session.
+type session struct {
+	token    string
+	client   *protocol.KomClient
+	lastUsed time.Time
+	// The number of open event streams
+	streams int
+	// Closed when the session is logged out
+	done chan struct{}
+}
+
+// Return a new random session token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Return the session token of a request, from the Authorization
+// header or the session cookie.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if c, err := r.Cookie(cookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// An error connecting to the LysKOM server.
+type dialError struct {
+	err error
+}
+
+func (e dialError) Error() string {
+	return fmt.Sprintf("Connecting to the server, %v", e.err)
+}
+
+func (e dialError) Unwrap() error {
+	return e.err
+}
+
+// Connect and log in, returning a new session.
+func (g *Gateway) login(user, password string, invisible bool) (*session, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	client, err := g.Dial()
+	if err != nil {
+		return nil, dialError{err}
+	}
+	if err := client.Login(user, password, invisible); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	s := &session{token: token, client: client, lastUsed: time.Now(), done: make(chan struct{})}
+	g.lock.Lock()
+	g.sessions[token] = s
+	g.lock.Unlock()
+	go g.watch(s)
+	return s, nil
+}
+
+// Drop a session when its connection to the server is lost.
+func (g *Gateway) watch(s *session) {
+	select {
+	case <-s.client.Done():
+		select {
+		case <-s.done:
+			// Logged out, which closed the connection
+			return
+		default:
+		}
+		log.WithFields(log.Fields{
+			"person": s.client.Person(),
+			"error":  s.client.Err(),
+		}).Info("gateway - dropping session")
+		g.logout(s)
+	case <-s.done:
+	}
+}
+
+// Return the session of a request, or nil if it has none.
+func (g *Gateway) session(r *http.Request) *session {
+	token := requestToken(r)
+	if token == "" {
+		return nil
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	s, ok := g.sessions[token]
+	if !ok {
+		return nil
+	}
+	select {
+	case <-s.client.Done():
+		// Dropped by watch as soon as it notices
+		return nil
+	default:
+	}
+	s.lastUsed = time.Now()
+	return s
+}
+
+// Keep a session from expiring, unless its connection to the server
+// is lost.
+func (g *Gateway) touch(s *session) {
+	if s.client.Err() != nil {
+		return
+	}
+	g.lock.Lock()
+	s.lastUsed = time.Now()
+	g.lock.Unlock()
+}
+
+// Count an event stream opened by a session, returning false if it
+// has too many open already.
+func (g *Gateway) openStream(s *session) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if s.streams >= maxStreams {
+		return false
+	}
+	s.streams++
+	return true
+}
+
+func (g *Gateway) closeStream(s *session) {
+	g.lock.Lock()
+	s.streams--
+	g.lock.Unlock()
+}
+
+// Log out and forget a session.
+func (g *Gateway) logout(s *session) {
+	g.lock.Lock()
+	if _, ok := g.sessions[s.token]; !ok {
+		// Already logged out
+		g.lock.Unlock()
+		return
+	}
+	delete(g.sessions, s.token)
+	close(s.done)
+	g.lock.Unlock()
+
+	if err := s.client.Logout(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Debug("gateway - logging out")
+	}
+	s.client.Close()
+}
+
+// Log out the sessions that have been idle for longer than the idle
+// timeout.
+func (g *Gateway) expire() {
+	var idle []*session
+	g.lock.Lock()
+	for _, s := range g.sessions {
+		if time.Since(s.lastUsed) > g.IdleTimeout {
+			idle = append(idle, s)
+		}
+	}
+	g.lock.Unlock()
+
+	for _, s := range idle {
+		log.WithFields(log.Fields{
+			"person": s.client.Person(),
+		}).Info("gateway - logging out idle session")
+		g.logout(s)
+	}
+}
+
+// Expire idle sessions until the gateway is closed.
+func (g *Gateway) expireLoop() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			g.expire()
+		case <-g.done:
+			return
+		}
+	}
+}
@@ -11,6 +11,10 @@ import (
 	"github.com/vatine/komandgo/pkg/utils"
 )
 
+// The longest Hollerith string accepted, Protocol A lengths are
+// 32-bit numbers.
+const MaxLength = 1<<31 - 1
+
 type HollerithError string
 
 func (e HollerithError) Error() string {
@@ -18,7 +22,9 @@ func (e HollerithError) Error() string {
 }
 
 // Writes a string to an io.Writer, simply returns any underlying error as
-// and when they occur.
+// and when they occur. The length is counted in bytes, so the string
+// should already be in the character set the server expects, see the
+// charset package.
 func Fprint(sink io.Writer, s interface{}) (int, error) {
 	i := fmt.Sprint(s)
 	return fmt.Fprintf(sink, "%dH%s", len(i), i)
@@ -56,6 +62,9 @@ func Scan(source io.Reader) (string, error) {
 			}).Debug("reading length")
 			if p >= 0 {
 				l = l*10 + p
+				if l > MaxLength {
+					return "", HollerithError("Hollerith string too long")
+				}
 			} else {
 				log.WithFields(log.Fields{
 					"p": p,
@@ -80,29 +89,34 @@ func Scan(source io.Reader) (string, error) {
 
 // Parse a Hollerith string from a specified offset in a passed-in
 // source string, return the parsed string and the offset at which it
-// ends.
-func FromString(source string, offset int) (string, int) {
-	var len int
+// ends. Malformed or truncated strings are returned as an error.
+func FromString(source string, offset int) (string, int, error) {
+	var n int
 
-	done := false
 	ix := offset
-
-	for !done {
-		if source[ix] == 'H' {
-			done = true
-			continue
+	for {
+		if ix < 0 || ix >= len(source) {
+			return "", ix, HollerithError("Truncated Hollerith length")
 		}
-
 		c := source[ix]
+		if c == 'H' {
+			break
+		}
 		p := strings.IndexByte("0123456789", c)
 		if p >= 0 {
-			len = len*10 + p
+			n = n*10 + p
+			if n > MaxLength {
+				return "", ix, HollerithError("Hollerith string too long")
+			}
 		}
 		ix++
 	}
 
 	ix++
-	rv := source[ix : ix+len]
+	if n > len(source)-ix {
+		return "", ix, HollerithError("Truncated Hollerith string")
+	}
+	rv := source[ix : ix+n]
 
-	return rv, ix + len
+	return rv, ix + n, nil
 }
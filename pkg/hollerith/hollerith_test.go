@@ -106,7 +106,10 @@ func TestStringScanning(t *testing.T) {
 		ws := c.wantString
 		wOff := c.wantOffset
 
-		gs, gOff := FromString(c.source, c.offset)
+		gs, gOff, err := FromString(c.source, c.offset)
+		if err != nil {
+			t.Errorf("Case #%d, unexpected error %v", ix, err)
+		}
 		if gs != ws {
 			t.Errorf("Case #%d, got «%s» want «%s»", ix, gs, ws)
 		}
@@ -115,3 +118,38 @@ func TestStringScanning(t *testing.T) {
 		}
 	}
 }
+
+func FuzzScan(f *testing.F) {
+	for _, seed := range []string{"3HHej", " 10Hhej\nhopp!!", "0H", "H", "9", "4Hab", "99999999999999999999Hx", "1x"} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := Scan(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		again, err := Scan(bytes.NewReader([]byte(Sprint(s))))
+		if err != nil || again != s {
+			t.Errorf("round trip of %q gave %q/%v", s, again, err)
+		}
+	})
+}
+
+func FuzzFromString(f *testing.F) {
+	f.Add("3HHej", 0)
+	f.Add("15HStoppa in text!", 1)
+	f.Add("4Hab", 0)
+	f.Add("12", 0)
+	f.Add("3HHej", 7)
+
+	f.Fuzz(func(t *testing.T, source string, offset int) {
+		s, next, err := FromString(source, offset)
+		if err != nil {
+			return
+		}
+		if next < offset || next > len(source) || source[next-len(s):next] != s {
+			t.Errorf("FromString(%q, %d) gave %q ending at %d", source, offset, s, next)
+		}
+	})
+}
@@ -0,0 +1,853 @@
+package komd
+
+// The calls the server implements, in call number order. Each call
+// reads its arguments, and returns the encoded reply (without the
+// request ID) or an error. Calls run with the store locked.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/types"
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+type callFunc func(sess *session, a *wire.Args) (string, error)
+
+var calls = map[uint32]callFunc{
+	1:   (*session).logout,
+	23:  (*session).getMarks,
+	25:  (*session).getText,
+	27:  (*session).markAsRead,
+	35:  (*session).getTime,
+	49:  (*session).getPersonStat,
+	52:  (*session).getUnreadConfs,
+	53:  (*session).sendMessage,
+	56:  (*session).whoAmI,
+	62:  (*session).login,
+	69:  (*session).setClientVersion,
+	72:  (*session).markText,
+	73:  (*session).unmarkText,
+	74:  (*session).reZLookup,
+	76:  (*session).lookupZName,
+	78:  (*session).getUConfStat,
+	80:  (*session).acceptAsync,
+	81:  (*session).queryAsync,
+	82:  (*session).userActive,
+	83:  (*session).whoIsOnDynamic,
+	86:  (*session).createText,
+	87:  (*session).createAnonymousText,
+	88:  (*session).createConf,
+	89:  (*session).createPerson,
+	90:  (*session).getTextStat,
+	91:  (*session).getConfStat,
+	100: (*session).addMember,
+	103: (*session).localToGlobal,
+	107: (*session).queryReadTexts,
+	108: (*session).getMembership,
+	110: (*session).setReadRanges,
+	120: (*session).setConnectionTimeFormat,
+}
+
+// The largest number of texts local-to-global returns.
+const maxLocalToGlobal = 255
+
+func boolArg(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// Return a function for notify, sending the same arguments to every
+// session.
+func everyone(args ...string) func(*session) []string {
+	return func(*session) []string { return args }
+}
+
+func (sess *session) store() *Store {
+	return sess.server.store
+}
+
+// Return the conference, if the session can see it.
+func (sess *session) conf(no types.ConfNo) (*conference, error) {
+	if no == 0 {
+		return nil, callError{code: errConferenceZero}
+	}
+	c, ok := sess.store().db.Confs[no]
+	if !ok || !sess.store().visible(sess.person, c) {
+		return nil, undefinedConference(no)
+	}
+	return c, nil
+}
+
+func (sess *session) pers(no types.ConfNo) (*person, error) {
+	p, ok := sess.store().db.Persons[no]
+	if !ok {
+		return nil, undefinedPerson(no)
+	}
+	return p, nil
+}
+
+// Return the text, if the session can read it.
+func (sess *session) text(no types.TextNo) (*text, error) {
+	if no == 0 {
+		return nil, callError{code: errTextZero}
+	}
+	t, ok := sess.store().db.Texts[no]
+	if !ok || !sess.store().canRead(sess.person, t) {
+		return nil, noSuchText(no)
+	}
+	return t, nil
+}
+
+// Return the logged-in person's membership in a conference.
+func (sess *session) membershipIn(conf types.ConfNo) (*types.Membership, error) {
+	if err := sess.loginFirst(); err != nil {
+		return nil, err
+	}
+	if _, err := sess.conf(conf); err != nil {
+		return nil, err
+	}
+	p := sess.store().db.Persons[sess.person]
+	ix := p.membershipIndex(conf)
+	if ix < 0 {
+		return nil, callError{code: errNotMember, status: uint32(conf)}
+	}
+	return &p.Memberships[ix], nil
+}
+
+// logout [1]
+func (sess *session) logout(a *wire.Args) (string, error) {
+	if sess.person != 0 {
+		sess.notifyLogout()
+	}
+	return "", nil
+}
+
+// get-marks [23]
+func (sess *session) getMarks(a *wire.Args) (string, error) {
+	if err := sess.loginFirst(); err != nil {
+		return "", err
+	}
+
+	var marks []string
+	for _, m := range sess.store().db.Persons[sess.person].Marks {
+		marks = append(marks, fmt.Sprintf("%d %d", m.TextNo, m.Type))
+	}
+	return array(marks), nil
+}
+
+// get-text [25]
+func (sess *session) getText(a *wire.Args) (string, error) {
+	no := types.TextNo(a.UInt32())
+	start := int64(a.UInt32())
+	end := int64(a.UInt32())
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	t, err := sess.text(no)
+	if err != nil {
+		return "", err
+	}
+
+	size := int64(len(t.Contents))
+	if start > size {
+		return "", callError{code: errIndexOutOfRange}
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if end < start {
+		return hollerith.Sprint(""), nil
+	}
+	return hollerith.Sprint(t.Contents[start : end+1]), nil
+}
+
+// mark-as-read [27]
+func (sess *session) markAsRead(a *wire.Args) (string, error) {
+	conf := types.ConfNo(a.UInt32())
+	locals := a.UInt32Array()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	m, err := sess.membershipIn(conf)
+	if err != nil {
+		return "", err
+	}
+	for _, local := range locals {
+		if local == 0 {
+			return "", callError{code: errLocalTextZero}
+		}
+	}
+	for _, local := range locals {
+		m.ReadRanges = types.AddToReadRanges(m.ReadRanges, types.TextNo(local))
+	}
+	m.LastTimeRead = time.Now()
+	sess.store().dirty = true
+
+	return "", nil
+}
+
+// get-time [35]
+func (sess *session) getTime(a *wire.Args) (string, error) {
+	return sess.time(time.Now()), nil
+}
+
+// get-person-stat [49]
+func (sess *session) getPersonStat(a *wire.Args) (string, error) {
+	no := types.ConfNo(a.UInt32())
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	p, err := sess.pers(no)
+	if err != nil {
+		return "", err
+	}
+	return sess.personStat(p, sess.store().db.Confs[no].Name), nil
+}
+
+// get-unread-confs [52]
+func (sess *session) getUnreadConfs(a *wire.Args) (string, error) {
+	no := types.ConfNo(a.UInt32())
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	p, err := sess.pers(no)
+	if err != nil {
+		return "", err
+	}
+
+	var confs []string
+	for _, m := range p.Memberships {
+		c, ok := sess.store().db.Confs[m.Conference]
+		if !ok || !sess.store().visible(sess.person, c) {
+			continue
+		}
+		for local := types.TextNo(1); local <= c.highestLocal(); local++ {
+			if c.global(local) != 0 && !types.IsRead(m.ReadRanges, local) {
+				confs = append(confs, fmt.Sprint(c.No))
+				break
+			}
+		}
+	}
+	return array(confs), nil
+}
+
+// send-message [53]
+func (sess *session) sendMessage(a *wire.Args) (string, error) {
+	recipient := types.ConfNo(a.UInt32())
+	message := a.String()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+	if err := sess.loginFirst(); err != nil {
+		return "", err
+	}
+
+	var c *conference
+	if recipient != 0 {
+		var err error
+		if c, err = sess.conf(recipient); err != nil {
+			return "", err
+		}
+	}
+
+	before := len(sess.pending)
+	sess.notify(asyncMessage, func(other *session) []string {
+		if c != nil && (other.person == 0 || !c.isMember(other.person)) {
+			return nil
+		}
+		return []string{fmt.Sprint(recipient), fmt.Sprint(sess.person), hollerith.Sprint(message)}
+	})
+	if len(sess.pending) == before {
+		return "", callError{code: errMessageNotSent}
+	}
+
+	return "", nil
+}
+
+// who-am-i [56]
+func (sess *session) whoAmI(a *wire.Args) (string, error) {
+	return fmt.Sprint(sess.no), nil
+}
+
+// login [62]
+func (sess *session) login(a *wire.Args) (string, error) {
+	no := types.ConfNo(a.UInt32())
+	password := a.String()
+	invisible := a.Bool()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	p, err := sess.pers(no)
+	if err != nil {
+		return "", err
+	}
+	if p.Password != hashPassword(password) {
+		return "", callError{code: errInvalidPassword}
+	}
+
+	if sess.person != 0 {
+		sess.notifyLogout()
+	}
+	sess.person = no
+	sess.invisible = invisible
+	p.LastLogin = time.Now()
+	p.Sessions++
+	sess.store().dirty = true
+
+	if !invisible {
+		sess.notify(asyncLogin, everyone(fmt.Sprint(no), fmt.Sprint(sess.no)))
+	}
+
+	return "", nil
+}
+
+// set-client-version [69]
+func (sess *session) setClientVersion(a *wire.Args) (string, error) {
+	sess.clientName = a.String()
+	sess.clientVersion = a.String()
+	return "", a.Err()
+}
+
+// mark-text [72]
+func (sess *session) markText(a *wire.Args) (string, error) {
+	no := types.TextNo(a.UInt32())
+	markType := a.UInt8()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+	if err := sess.loginFirst(); err != nil {
+		return "", err
+	}
+
+	t, err := sess.text(no)
+	if err != nil {
+		return "", err
+	}
+
+	p := sess.store().db.Persons[sess.person]
+	sess.store().dirty = true
+	for ix, m := range p.Marks {
+		if m.TextNo == no {
+			p.Marks[ix].Type = markType
+			return "", nil
+		}
+	}
+	p.Marks = append(p.Marks, types.Mark{TextNo: no, Type: markType})
+	t.Marks++
+
+	return "", nil
+}
+
+// unmark-text [73]
+func (sess *session) unmarkText(a *wire.Args) (string, error) {
+	no := types.TextNo(a.UInt32())
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+	if err := sess.loginFirst(); err != nil {
+		return "", err
+	}
+
+	p := sess.store().db.Persons[sess.person]
+	for ix, m := range p.Marks {
+		if m.TextNo != no {
+			continue
+		}
+		p.Marks = append(p.Marks[:ix], p.Marks[ix+1:]...)
+		if t, ok := sess.store().db.Texts[no]; ok && t.Marks > 0 {
+			t.Marks--
+		}
+		sess.store().dirty = true
+		return "", nil
+	}
+
+	return "", callError{code: errNotMarked, status: uint32(no)}
+}
+
+// Return true if every word in the pattern is a prefix of the
+// corresponding word in the name, ignoring case. This is how lyskomd
+// matches names, so "ing" matches "Ingrid Bergman".
+func matchName(pattern, name string) bool {
+	pw := strings.Fields(strings.ToLower(pattern))
+	nw := strings.Fields(strings.ToLower(name))
+	if len(pw) > len(nw) {
+		return false
+	}
+	for ix := range pw {
+		if !strings.HasPrefix(nw[ix], pw[ix]) {
+			return false
+		}
+	}
+	return true
+}
+
+// re-z-lookup [74]
+func (sess *session) reZLookup(a *wire.Args) (string, error) {
+	pattern := a.String()
+	wantPersons := a.Bool()
+	wantConfs := a.Bool()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return "", callError{code: errRegexpError}
+	}
+	return sess.zLookup(wantPersons, wantConfs, re.MatchString), nil
+}
+
+// lookup-z-name [76]
+func (sess *session) lookupZName(a *wire.Args) (string, error) {
+	pattern := a.String()
+	wantPersons := a.Bool()
+	wantConfs := a.Bool()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	return sess.zLookup(wantPersons, wantConfs, func(name string) bool {
+		return matchName(pattern, name)
+	}), nil
+}
+
+// Return the conf-z-info array of the visible persons and
+// conferences with names matching.
+func (sess *session) zLookup(wantPersons, wantConfs bool, match func(name string) bool) string {
+	var nos []types.ConfNo
+	for no := range sess.store().db.Confs {
+		nos = append(nos, no)
+	}
+	sort.Slice(nos, func(i, j int) bool { return nos[i] < nos[j] })
+
+	var found []string
+	for _, no := range nos {
+		c := sess.store().db.Confs[no]
+		_, isPerson := sess.store().db.Persons[no]
+		if (isPerson && !wantPersons) || (!isPerson && !wantConfs) {
+			continue
+		}
+		if !sess.store().visible(sess.person, c) || !match(c.Name) {
+			continue
+		}
+		found = append(found, fmt.Sprintf("%s %s %d", hollerith.Sprint(c.Name), oldConfType(c.Type), c.No))
+	}
+
+	return array(found)
+}
+
+// get-uconf-stat [78]
+func (sess *session) getUConfStat(a *wire.Args) (string, error) {
+	no := types.ConfNo(a.UInt32())
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	c, err := sess.conf(no)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %d %d", hollerith.Sprint(c.Name), c.Type.Repr(), c.highestLocal(), c.Nice), nil
+}
+
+// accept-async [80]
+func (sess *session) acceptAsync(a *wire.Args) (string, error) {
+	msgs := a.UInt32Array()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	sess.accepted = make(map[uint32]bool)
+	for _, m := range msgs {
+		if supportedAsync[m] {
+			sess.accepted[m] = true
+		}
+	}
+	return "", nil
+}
+
+// query-async [81]
+func (sess *session) queryAsync(a *wire.Args) (string, error) {
+	var msgs []uint32
+	for m := range sess.accepted {
+		msgs = append(msgs, m)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i] < msgs[j] })
+	return types.UInt32Array(msgs), nil
+}
+
+// user-active [82]
+func (sess *session) userActive(a *wire.Args) (string, error) {
+	sess.userActiveUsed = true
+	return "", nil
+}
+
+// who-is-on-dynamic [83]
+func (sess *session) whoIsOnDynamic(a *wire.Args) (string, error) {
+	wantVisible := a.Bool()
+	wantInvisible := a.Bool()
+	activeLast := a.UInt32()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	s := sess.server
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var nos []types.SessionNo
+	for no := range s.sessions {
+		nos = append(nos, no)
+	}
+	sort.Slice(nos, func(i, j int) bool { return nos[i] < nos[j] })
+
+	var found []string
+	for _, no := range nos {
+		other := s.sessions[no]
+		if (other.invisible && !wantInvisible) || (!other.invisible && !wantVisible) {
+			continue
+		}
+		idle := uint32(time.Since(other.active) / time.Second)
+		if activeLast != 0 && idle > activeLast {
+			continue
+		}
+		flags := fmt.Sprintf("%s%s000000", boolArg(other.invisible), boolArg(other.userActiveUsed))
+		found = append(found, fmt.Sprintf("%d %d 0 %d %s 0H", other.no, other.person, idle, flags))
+	}
+
+	return array(found), nil
+}
+
+// create-text [86]
+func (sess *session) createText(a *wire.Args) (string, error) {
+	if err := sess.loginFirst(); err != nil {
+		return "", err
+	}
+	return sess.newText(a, sess.person)
+}
+
+// create-anonymous-text [87]
+func (sess *session) createAnonymousText(a *wire.Args) (string, error) {
+	return sess.newText(a, 0)
+}
+
+func (sess *session) newText(a *wire.Args, author types.ConfNo) (string, error) {
+	contents := a.String()
+	misc, err := readMiscInfos(a)
+	if err != nil {
+		return "", err
+	}
+	aux := a.AuxItemInputs()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	store := sess.store()
+	for _, mi := range misc {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient, types.CCRecipient, types.BCCRecipient:
+			c, err := sess.conf(recipientOf(mi))
+			if err != nil {
+				return "", err
+			}
+			if author == 0 && !c.Type.AllowAnonymous {
+				return "", callError{code: errAnonymousReject}
+			}
+		}
+	}
+
+	t, err := store.createText(author, contents, misc, aux)
+	if err != nil {
+		return "", err
+	}
+
+	sess.notify(asyncNewText, func(other *session) []string {
+		if !store.canRead(other.person, t) {
+			return nil
+		}
+		return []string{fmt.Sprint(t.No), other.textStat(t)}
+	})
+
+	return fmt.Sprint(t.No), nil
+}
+
+// create-conf [88]
+func (sess *session) createConf(a *wire.Args) (string, error) {
+	name := a.String()
+	confType := a.ConfType()
+	aux := a.AuxItemInputs()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+	if err := sess.loginFirst(); err != nil {
+		return "", err
+	}
+
+	no, err := sess.store().createConf(name, confType, sess.person)
+	if err != nil {
+		return "", err
+	}
+	c := sess.store().db.Confs[no]
+	c.AuxItems = newAuxItems(aux, sess.person, c.CreationTime)
+
+	return fmt.Sprint(no), nil
+}
+
+// create-person [89]
+func (sess *session) createPerson(a *wire.Args) (string, error) {
+	name := a.String()
+	password := a.String()
+	flags := a.Bits(8)
+	aux := a.AuxItemInputs()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	no, err := sess.store().createPerson(name, password, sess.person)
+	if err != nil {
+		return "", err
+	}
+	c := sess.store().db.Confs[no]
+	c.AuxItems = newAuxItems(aux, no, c.CreationTime)
+	sess.store().db.Persons[no].Flags.UnreadIsSecret = flags[0] == '1'
+
+	return fmt.Sprint(no), nil
+}
+
+// get-text-stat [90]
+func (sess *session) getTextStat(a *wire.Args) (string, error) {
+	no := types.TextNo(a.UInt32())
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	t, err := sess.text(no)
+	if err != nil {
+		return "", err
+	}
+	return sess.textStat(t), nil
+}
+
+// get-conf-stat [91]
+func (sess *session) getConfStat(a *wire.Args) (string, error) {
+	no := types.ConfNo(a.UInt32())
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	c, err := sess.conf(no)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s %s %d 0 %d 0 0 0 %d 77 %d 1 %d 0 %s",
+		hollerith.Sprint(c.Name), c.Type.Repr(), sess.time(c.CreationTime), sess.time(c.LastWritten),
+		c.Creator, c.Supervisor, c.Nice, len(c.Members), len(c.Texts), sess.auxItems(c.AuxItems)), nil
+}
+
+// add-member [100]
+func (sess *session) addMember(a *wire.Args) (string, error) {
+	conf := types.ConfNo(a.UInt32())
+	pers := types.ConfNo(a.UInt32())
+	priority := a.UInt8()
+	where := a.UInt16()
+	mt := a.MembershipType()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+	if err := sess.loginFirst(); err != nil {
+		return "", err
+	}
+
+	c, err := sess.conf(conf)
+	if err != nil {
+		return "", err
+	}
+	if _, err := sess.pers(pers); err != nil {
+		return "", err
+	}
+
+	admin := sess.store().db.Persons[sess.person].Privileges.Admin
+	supervisor := c.Supervisor == sess.person || admin
+	switch {
+	case pers != sess.person && !supervisor:
+		return "", callError{code: errPermissionDenied, status: uint32(conf)}
+	case c.Type.RdProt && !supervisor && !c.isMember(pers):
+		return "", callError{code: errAccessDenied, status: uint32(conf)}
+	}
+
+	return "", sess.store().addMember(conf, pers, sess.person, priority, where, mt)
+}
+
+// local-to-global [103]
+func (sess *session) localToGlobal(a *wire.Args) (string, error) {
+	conf := types.ConfNo(a.UInt32())
+	first := types.TextNo(a.UInt32())
+	n := a.UInt32()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	c, err := sess.conf(conf)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !sess.store().readable(sess.person, c):
+		return "", callError{code: errAccessDenied, status: uint32(conf)}
+	case first == 0:
+		return "", callError{code: errLocalTextZero}
+	case n > maxLocalToGlobal:
+		return "", callError{code: errLongArray}
+	case first > c.highestLocal():
+		return "", callError{code: errNoSuchLocalText, status: uint32(first)}
+	}
+
+	// Texts the session cannot read are left out, as if deleted.
+	global := func(local types.TextNo) types.TextNo {
+		g := c.global(local)
+		if t, ok := sess.store().db.Texts[g]; !ok || !sess.store().canRead(sess.person, t) {
+			return 0
+		}
+		return g
+	}
+
+	var pairs []string
+	var dense []string
+	local := first
+	for ; local <= c.highestLocal() && uint32(len(pairs)) < n; local++ {
+		g := global(local)
+		dense = append(dense, fmt.Sprint(g))
+		if g != 0 {
+			pairs = append(pairs, fmt.Sprintf("%d %d", local, g))
+		}
+	}
+
+	later := false
+	for l := local; l <= c.highestLocal() && !later; l++ {
+		later = global(l) != 0
+	}
+
+	// Send whichever block is shorter, like lyskomd does.
+	block := fmt.Sprintf("0 %s", array(pairs))
+	if len(dense) <= 2*len(pairs) {
+		block = fmt.Sprintf("1 %d %s", first, array(dense))
+	}
+
+	return fmt.Sprintf("%d %d %s %s", first, local, boolArg(later), block), nil
+}
+
+// query-read-texts [107]
+func (sess *session) queryReadTexts(a *wire.Args) (string, error) {
+	pers := types.ConfNo(a.UInt32())
+	conf := types.ConfNo(a.UInt32())
+	wantRanges := a.Bool()
+	maxRanges := a.UInt32()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	p, err := sess.pers(pers)
+	if err != nil {
+		return "", err
+	}
+	if _, err := sess.conf(conf); err != nil {
+		return "", err
+	}
+	ix := p.membershipIndex(conf)
+	if ix < 0 {
+		return "", callError{code: errNotMember, status: uint32(conf)}
+	}
+
+	return sess.membership(p.Memberships[ix], wantRanges, maxRanges), nil
+}
+
+// get-membership [108]
+func (sess *session) getMembership(a *wire.Args) (string, error) {
+	pers := types.ConfNo(a.UInt32())
+	first := int(a.UInt16())
+	count := int(a.UInt16())
+	wantRanges := a.Bool()
+	maxRanges := a.UInt32()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	p, err := sess.pers(pers)
+	if err != nil {
+		return "", err
+	}
+	if first > len(p.Memberships) {
+		return "", callError{code: errIndexOutOfRange}
+	}
+
+	last := first + count
+	if last > len(p.Memberships) {
+		last = len(p.Memberships)
+	}
+	var rv []string
+	for _, m := range p.Memberships[first:last] {
+		rv = append(rv, sess.membership(m, wantRanges, maxRanges))
+	}
+	return array(rv), nil
+}
+
+// set-read-ranges [110]
+func (sess *session) setReadRanges(a *wire.Args) (string, error) {
+	conf := types.ConfNo(a.UInt32())
+	var ranges []types.ReadRange
+	n, present := a.ArrayStart()
+	if present {
+		for ix := uint32(0); ix < n && a.Err() == nil; ix++ {
+			first := types.TextNo(a.UInt32())
+			last := types.TextNo(a.UInt32())
+			ranges = append(ranges, types.ReadRange{FirstRead: first, LastRead: last})
+		}
+		a.ArrayEnd()
+	}
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+
+	for ix, r := range ranges {
+		switch {
+		case r.FirstRead == 0:
+			return "", callError{code: errLocalTextZero}
+		case r.FirstRead > r.LastRead:
+			return "", callError{code: errInvalidRange}
+		case ix > 0 && r.FirstRead <= ranges[ix-1].LastRead:
+			return "", callError{code: errInvalidRangeList}
+		}
+	}
+
+	m, err := sess.membershipIn(conf)
+	if err != nil {
+		return "", err
+	}
+	m.ReadRanges = ranges
+	m.LastTimeRead = time.Now()
+	sess.store().dirty = true
+
+	return "", nil
+}
+
+// set-connection-time-format [120]
+func (sess *session) setConnectionTimeFormat(a *wire.Args) (string, error) {
+	utc := a.Bool()
+	if err := a.Err(); err != nil {
+		return "", err
+	}
+	sess.utc = utc
+	return "", nil
+}
@@ -0,0 +1,155 @@
+package komd
+
+// Encoding replies, and decoding the more complicated arguments
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/types"
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+// Return an array of already encoded elements.
+func array(elements []string) string {
+	if len(elements) == 0 {
+		return "0 { }"
+	}
+	return fmt.Sprintf("%d { %s }", len(elements), strings.Join(elements, " "))
+}
+
+func (sess *session) time(t time.Time) string {
+	return sess.times().Encode(t)
+}
+
+func (sess *session) miscInfo(mi types.MiscInfo) string {
+	var value string
+	switch types.InfoType(mi.Selector) {
+	case types.Recipient:
+		value = fmt.Sprint(mi.Recipient)
+	case types.CCRecipient:
+		value = fmt.Sprint(mi.CCRecipient)
+	case types.CommentTo:
+		value = fmt.Sprint(mi.CommentTo)
+	case types.CommentIn:
+		value = fmt.Sprint(mi.CommentedIn)
+	case types.FootnoteTo:
+		value = fmt.Sprint(mi.FootnoteTo)
+	case types.FootnoteIn:
+		value = fmt.Sprint(mi.FootnotedIn)
+	case types.LocalNo:
+		value = fmt.Sprint(mi.LocalNo)
+	case types.ReceiveTime:
+		value = sess.time(mi.ReceivedAt)
+	case types.SentBy:
+		value = fmt.Sprint(mi.Sender)
+	case types.SentAt:
+		value = sess.time(mi.SentAt)
+	case types.BCCRecipient:
+		value = fmt.Sprint(mi.BCCRecipient)
+	}
+	return fmt.Sprintf("%d %s", mi.Selector, value)
+}
+
+func (sess *session) auxItems(items []auxItem) string {
+	var rv []string
+	for _, item := range items {
+		rv = append(rv, fmt.Sprintf("%d %d %d %s %s %d %s",
+			item.AuxNo, item.Tag, item.Creator, sess.time(item.CreatedAt),
+			item.Flags.Repr(), item.InheritLimit, hollerith.Sprint(item.Data)))
+	}
+	return array(rv)
+}
+
+// Return a Text-Stat. Misc-info items referring to texts or
+// conferences the session cannot see are left out. The store lock
+// must be held.
+func (sess *session) textStat(t *text) string {
+	store := sess.server.store
+
+	var misc []string
+	hidden := false
+	for _, mi := range t.MiscInfo {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient, types.CCRecipient, types.BCCRecipient:
+			c, ok := store.db.Confs[recipientOf(mi)]
+			hidden = !ok || !store.visible(sess.person, c)
+		case types.CommentTo, types.CommentIn, types.FootnoteTo, types.FootnoteIn:
+			hidden = false
+			other, ok := store.db.Texts[mi.CommentTo+mi.CommentedIn+mi.FootnoteTo+mi.FootnotedIn]
+			if !ok || !store.canRead(sess.person, other) {
+				continue
+			}
+		case types.LocalNo:
+			// Belongs to the recipient before it.
+		}
+		if hidden {
+			continue
+		}
+		misc = append(misc, sess.miscInfo(mi))
+	}
+
+	lines := uint32(0)
+	if t.Contents != "" {
+		lines = uint32(strings.Count(t.Contents, "\n") + 1)
+	}
+
+	return fmt.Sprintf("%s %d %d %d %d %s %s",
+		sess.time(t.CreationTime), t.Author, lines, len(t.Contents), t.Marks,
+		array(misc), sess.auxItems(t.AuxItems))
+}
+
+// Return a Membership. If wantRanges is false, the read ranges are
+// left out, and at most maxRanges are sent otherwise (zero meaning
+// all of them).
+func (sess *session) membership(m types.Membership, wantRanges bool, maxRanges uint32) string {
+	ranges := "0 *"
+	if wantRanges {
+		rs := m.ReadRanges
+		if maxRanges > 0 && uint32(len(rs)) > maxRanges {
+			rs = rs[:maxRanges]
+		}
+		var elements []string
+		for _, r := range rs {
+			elements = append(elements, fmt.Sprintf("%d %d", r.FirstRead, r.LastRead))
+		}
+		ranges = array(elements)
+	}
+
+	return fmt.Sprintf("%d %s %d %d %s %d %s %s",
+		m.Position, sess.time(m.LastTimeRead), m.Conference, m.Priority,
+		ranges, m.AddedBy, sess.time(m.AddedAt), m.Type.Repr())
+}
+
+// Return a Person.
+func (sess *session) personStat(p *person, name string) string {
+	return fmt.Sprintf("%s %s %s %s %d 0 %d 0 0 0 0 0 0 1 %d %d %d",
+		hollerith.Sprint(name), p.Privileges.Repr(), p.Flags.Repr(),
+		sess.time(p.LastLogin), p.UserArea, p.Sessions, p.Texts,
+		len(p.Marks), len(p.Memberships))
+}
+
+// Return a 4-bit Conf-Type.
+func oldConfType(t types.ExtendedConfType) string {
+	return types.ConfType{RdProt: t.RdProt, Original: t.Original, Secret: t.Secret, LetterBox: t.LetterBox}.BitField()
+}
+
+// Read an ARRAY Misc-Info, as sent when creating a text, rejecting
+// the items a client may not give.
+func readMiscInfos(a *wire.Args) ([]types.MiscInfo, error) {
+	misc := a.MiscInfos(types.TimeCodec{})
+	if a.Err() != nil {
+		return nil, callError{code: errIllegalMisc, status: uint32(len(misc))}
+	}
+	for ix, mi := range misc {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient, types.CCRecipient, types.BCCRecipient, types.CommentTo, types.FootnoteTo:
+		default:
+			return nil, callError{code: errIllegalMisc, status: uint32(ix)}
+		}
+	}
+
+	return misc, nil
+}
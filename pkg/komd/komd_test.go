@@ -0,0 +1,414 @@
+package komd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+// Return a store with a person, "Ingrid Bergman" with the password
+// "secret", who is a member of a conference, "Filmklubben". Tests
+// outside this package use internal/komdtest, which cannot be
+// imported here.
+func newTestStore() (store *Store, pers, conf types.ConfNo) {
+	store = NewStore()
+	pers, err := store.CreatePerson("Ingrid Bergman", "secret")
+	if err == nil {
+		conf, err = store.CreateConf("Filmklubben", types.ExtendedConfType{}, pers)
+	}
+	if err == nil {
+		err = store.AddMember(conf, pers, 100)
+	}
+	if err != nil {
+		// Cannot happen in an empty store
+		panic(err)
+	}
+	return store, pers, conf
+}
+
+// Return the client end of a net.Pipe, the other end served by srv.
+func pipe(srv *Server) net.Conn {
+	client, server := net.Pipe()
+	go srv.ServeConn(server)
+	return client
+}
+
+// Connect a client to the server over a net.Pipe.
+func connect(t *testing.T, srv *Server) *protocol.KomClient {
+	t.Helper()
+
+	k, err := protocol.NewKomClientConn(pipe(srv))
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+func TestTexts(t *testing.T) {
+	store, pers, conf := newTestStore()
+	srv := NewServer(store)
+	defer srv.Close()
+	client := connect(t, srv)
+
+	if err := client.Login("Ingrid Bergman", "wrong", false); err == nil {
+		t.Errorf("expected an error logging in with the wrong password")
+	}
+	if err := client.Login("ingrid", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	if client.Person() != pers {
+		t.Errorf("logged in as %d, want %d", client.Person(), pers)
+	}
+
+	root, err := client.CreateText("Hej\nFörsta inlägget", []types.MiscInfo{types.RecipientMisc(conf)}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating text, %v", err)
+	}
+	comment, err := client.CreateText("Re: Hej\nSvar", []types.MiscInfo{types.RecipientMisc(conf), types.CommentToMisc(root)}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating comment, %v", err)
+	}
+
+	stat, err := client.GetTextStat(root)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if stat.Author != pers || stat.Lines != 2 {
+		t.Errorf("unexpected text stat %+v", stat)
+	}
+	wantMisc := []types.MiscInfo{
+		types.RecipientMisc(conf),
+		{Selector: uint32(types.LocalNo), LocalNo: 1},
+		{Selector: uint32(types.CommentIn), CommentedIn: comment},
+	}
+	if !reflect.DeepEqual(stat.MiscInfo, wantMisc) {
+		t.Errorf("saw misc-info %+v, want %+v", stat.MiscInfo, wantMisc)
+	}
+
+	body, err := client.GetText(root)
+	if err != nil || body != "Hej\nFörsta inlägget" {
+		t.Errorf("saw %q/%v, want the text back", body, err)
+	}
+
+	var thread []types.TextNo
+	it := client.Thread(root)
+	for it.Next() {
+		thread = append(thread, it.Entry().TextNo)
+	}
+	if want := []types.TextNo{root, comment}; it.Err() != nil || !reflect.DeepEqual(thread, want) {
+		t.Errorf("saw thread %v/%v, want %v", thread, it.Err(), want)
+	}
+
+	_, err = client.GetTextStat(4711)
+	var noText protocol.NoSuchTextError
+	if !errors.As(err, &noText) || noText.Text != 4711 {
+		t.Errorf("saw %v, want no-such-text", err)
+	}
+}
+
+func TestUnreadAndMarks(t *testing.T) {
+	store, _, conf := newTestStore()
+	srv := NewServer(store)
+	defer srv.Close()
+	client := connect(t, srv)
+
+	if err := client.Login("Ingrid Bergman", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+
+	var texts []types.TextNo
+	for _, s := range []string{"Ett", "Två", "Tre"} {
+		no, err := client.CreateText(s+"\n", []types.MiscInfo{types.RecipientMisc(conf)}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating text, %v", err)
+		}
+		texts = append(texts, no)
+	}
+
+	engine := client.UnreadEngine()
+	it := engine.Texts()
+	var unread []types.TextNo
+	for it.Next() {
+		unread = append(unread, it.Text().GlobalNo)
+		if len(unread) == 1 {
+			if err := engine.MarkRead(it.Text()); err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+	}
+	if it.Err() != nil || !reflect.DeepEqual(unread, texts) {
+		t.Errorf("saw unread %v/%v, want %v", unread, it.Err(), texts)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	unread = nil
+	it = client.UnreadEngine().Texts()
+	for it.Next() {
+		unread = append(unread, it.Text().GlobalNo)
+	}
+	if want := texts[1:]; it.Err() != nil || !reflect.DeepEqual(unread, want) {
+		t.Errorf("after reading, saw unread %v/%v, want %v", unread, it.Err(), want)
+	}
+
+	marks := client.Marks()
+	if err := marks.Mark(protocol.MarkTodo, texts[1]); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	entries, err := marks.List()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("saw %v/%v, want one mark", entries, err)
+	}
+	if entries[0].Subject != "Två" || entries[0].AuthorName != "Ingrid Bergman" || entries[0].Type != protocol.MarkTodo {
+		t.Errorf("unexpected mark %+v", entries[0])
+	}
+	if err := marks.Unmark(texts[1]); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := marks.Unmark(texts[1]); err == nil {
+		t.Errorf("expected an error unmarking a text that is not marked")
+	}
+}
+
+func TestAsyncMessages(t *testing.T) {
+	store, ingrid, _ := newTestStore()
+	greta, err := store.CreatePerson("Greta Garbo", "alone")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	srv := NewServer(store)
+	defer srv.Close()
+
+	a := connect(t, srv)
+	if err := a.Login("Ingrid Bergman", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	msgs, cancel := a.Subscribe()
+	defer cancel()
+	if err := a.AcceptAsync(protocol.AsyncLoginNo, protocol.AsyncSendMessageNo); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	b := connect(t, srv)
+	if err := b.Login("Greta Garbo", "alone", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	if err := b.SendMessageTo(ingrid, "Fika?"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := b.SendMessageTo(greta, "Nobody listens"); err == nil {
+		t.Errorf("expected message-not-sent for a person not accepting messages")
+	}
+
+	var seen []protocol.AsyncMessage
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 2 {
+		select {
+		case msg := <-msgs:
+			seen = append(seen, msg)
+		case <-timeout:
+			t.Fatalf("timed out, saw %v", seen)
+		}
+	}
+
+	if login, ok := seen[0].(protocol.AsyncLogin); !ok || login.Person != greta {
+		t.Errorf("saw %+v, want a login from %d", seen[0], greta)
+	}
+	want := protocol.AsyncSendMessage{Recipient: ingrid, Sender: greta, Message: "Fika?"}
+	if seen[1] != want {
+		t.Errorf("saw %+v, want %+v", seen[1], want)
+	}
+}
+
+// The header of an asynchronous message counts the tokens of its
+// arguments, not the arguments.
+func TestAsyncTokenCount(t *testing.T) {
+	store, _, conf := newTestStore()
+	srv := NewServer(store)
+	defer srv.Close()
+
+	raw := pipe(srv)
+	defer raw.Close()
+	r := bufio.NewReader(raw)
+	if _, err := io.WriteString(raw, "A4Htest\n1 80 1 { 15 }\n"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, want := range []string{"LysKOM", "=1"} {
+		if saw, err := wire.ReadMessage(r); err != nil || saw != want {
+			t.Fatalf("saw %q/%v, want %q", saw, err, want)
+		}
+	}
+
+	client := connect(t, srv)
+	if err := client.Login("Ingrid Bergman", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	text, err := client.CreateText("Hej\nHopp", []types.MiscInfo{types.RecipientMisc(conf)}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	msg, err := wire.ReadMessage(r)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	reply, err := wire.ParseReply(msg)
+	if err != nil || reply.Kind != wire.ReplyAsync || reply.Code != protocol.AsyncNewTextNo {
+		t.Fatalf("saw %q/%v, want async-new-text", msg, err)
+	}
+	if reply.Args[0] != fmt.Sprint(text) {
+		t.Errorf("saw %q, want text %d", msg, text)
+	}
+	if want := fmt.Sprintf(":%d 15 ", len(reply.Args)); !strings.HasPrefix(msg, want) || len(reply.Args) < 10 {
+		t.Errorf("saw %q, want it to start with %q", msg, want)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "komd.json")
+
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	pers, err := store.CreatePerson("Ingrid Bergman", "secret")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := store.CreatePerson("ingrid bergman", "again"); err == nil {
+		t.Errorf("expected an error creating a person with a name in use")
+	}
+	if err := store.Save(); err != nil {
+		t.Fatalf("unexpected error saving, %v", err)
+	}
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading, %v", err)
+	}
+	if no, ok := store.LookupPerson("Ingrid Bergman"); !ok || no != pers {
+		t.Errorf("saw %d/%v, want person %d", no, ok, pers)
+	}
+
+	srv := NewServer(store)
+	defer srv.Close()
+	client := connect(t, srv)
+	if err := client.Login("Ingrid Bergman", "secret", false); err != nil {
+		t.Errorf("unexpected error logging in after loading, %v", err)
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"ing", "Ingrid Bergman", true},
+		{"ing berg", "Ingrid Bergman", true},
+		{"berg", "Ingrid Bergman", false},
+		{"ingrid bergman x", "Ingrid Bergman", false},
+		{"", "Ingrid Bergman", true},
+	}
+
+	for _, c := range cases {
+		if got := matchName(c.pattern, c.name); got != c.want {
+			t.Errorf("matchName(%q, %q) is %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestLookupAndWho(t *testing.T) {
+	store, ingrid, conf := newTestStore()
+	srv := NewServer(store)
+	defer srv.Close()
+	client := connect(t, srv)
+
+	if err := client.Login("Ingrid Bergman", "secret", true); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+
+	found, err := client.ReZLookup("^film", true, true)
+	if err != nil || len(found) != 1 || found[0].No != conf {
+		t.Errorf("saw %+v/%v, want conference %d", found, err, conf)
+	}
+	if _, err := client.ReZLookup("(", true, true); err == nil {
+		t.Errorf("expected an error for a malformed regexp")
+	}
+
+	if err := client.UserActive(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	sessions, err := client.WhoIsOnDynamic(true, true, 0)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("saw %+v/%v, want one session", sessions, err)
+	}
+	if s := sessions[0]; s.Peron != ingrid || !s.Flags.Invisible || !s.Flags.UserActiveUsed {
+		t.Errorf("unexpected session %+v", s)
+	}
+	if sessions, err := client.WhoIsOnDynamic(true, false, 0); err != nil || len(sessions) != 0 {
+		t.Errorf("saw %+v/%v, want no visible sessions", sessions, err)
+	}
+}
+
+func TestConfStat(t *testing.T) {
+	store, ingrid, conf := newTestStore()
+	srv := NewServer(store)
+	defer srv.Close()
+	client := connect(t, srv)
+
+	if err := client.Login("Ingrid Bergman", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	if _, err := client.CreateText("Hello\nWorld", []types.MiscInfo{types.RecipientMisc(conf)}, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	c, err := client.GetConfStat(conf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if c.Name != "Filmklubben" || c.Creator != ingrid || c.NoOfMembers != 1 || c.FirstLocalNo != 1 || c.NoOfTexts != 1 {
+		t.Errorf("unexpected conference %+v", c)
+	}
+
+	var undefined protocol.UndefinedConferenceError
+	if _, err := client.GetConfStat(4711); !errors.As(err, &undefined) {
+		t.Errorf("saw %v, want undefined-conference", err)
+	}
+}
+
+func TestReadMiscInfos(t *testing.T) {
+	cases := []struct {
+		msg  string
+		want []types.MiscInfo
+		err  error
+	}{
+		{"2 { 0 17 2 4711 }", []types.MiscInfo{types.RecipientMisc(17), types.CommentToMisc(4711)}, nil},
+		{"0 *", nil, nil},
+		{"2 { 0 17 6 8 }", nil, callError{code: errIllegalMisc, status: 1}},
+		{"2 { 0 17 99 4711 }", nil, callError{code: errIllegalMisc, status: 1}},
+		{"1 { 9 17 }", nil, callError{code: errIllegalMisc, status: 0}},
+	}
+
+	for _, c := range cases {
+		tokens, _ := wire.Tokens(c.msg)
+		misc, err := readMiscInfos(wire.NewArgs(tokens))
+		if err != c.err || !reflect.DeepEqual(misc, c.want) {
+			t.Errorf("readMiscInfos(%q), saw %+v/%v, want %+v/%v", c.msg, misc, err, c.want, c.err)
+		}
+	}
+}
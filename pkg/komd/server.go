@@ -0,0 +1,377 @@
+// A minimal Protocol A server, keeping everything in a Store. It is
+// meant for local development and tests, so that KomClient can be
+// exercised end to end without a real lyskomd:
+//
+//	store := komd.NewStore()
+//	store.CreatePerson("Ingrid", "secret")
+//	srv := komd.NewServer(store)
+//	go srv.ListenAndServe("localhost:4894")
+//
+// Only the calls needed for logging in, looking up names, listing
+// sessions, reading and writing texts, keeping track of memberships and
+// marks, and sending messages are implemented. Everything else is
+// answered with not-implemented.
+package komd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/types"
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+// Protocol A error codes used by the server
+const (
+	errNotImplemented   = 2
+	errInvalidPassword  = 4
+	errLoginFirst       = 6
+	errConferenceZero   = 8
+	errUndefinedConf    = 9
+	errUndefinedPerson  = 10
+	errAccessDenied     = 11
+	errPermissionDenied = 12
+	errNotMember        = 13
+	errNoSuchText       = 14
+	errTextZero         = 15
+	errNoSuchLocalText  = 16
+	errLocalTextZero    = 17
+	errBadName          = 18
+	errIndexOutOfRange  = 19
+	errConferenceExists = 20
+	errSecretPublic     = 22
+	errIllegalMisc      = 25
+	errRegexpError      = 43
+	errNotMarked        = 44
+	errLongArray        = 46
+	errAnonymousReject  = 47
+	errMessageNotSent   = 53
+	errInvalidRange     = 55
+	errInvalidRangeList = 56
+)
+
+// A callError is sent to the client as an error reply.
+type callError struct {
+	code   uint32
+	status uint32
+}
+
+func (e callError) Error() string {
+	return fmt.Sprintf("Protocol A error %d, status %d", e.code, e.status)
+}
+
+func undefinedConference(conf types.ConfNo) error {
+	return callError{code: errUndefinedConf, status: uint32(conf)}
+}
+
+func undefinedPerson(pers types.ConfNo) error {
+	return callError{code: errUndefinedPerson, status: uint32(pers)}
+}
+
+func noSuchText(text types.TextNo) error {
+	return callError{code: errNoSuchText, status: uint32(text)}
+}
+
+// The asynchronous messages the server can send.
+var supportedAsync = map[uint32]bool{
+	asyncLogin:   true,
+	asyncMessage: true,
+	asyncLogout:  true,
+	asyncNewText: true,
+}
+
+const (
+	asyncLogin   = 9
+	asyncMessage = 12
+	asyncLogout  = 13
+	asyncNewText = 15
+)
+
+// A Server serves Protocol A connections from a Store.
+type Server struct {
+	// The time zone times are sent in, for sessions that have not
+	// asked for UTC. Defaults to the local time zone.
+	Location *time.Location
+
+	store *Store
+
+	lock        sync.Mutex
+	sessions    map[types.SessionNo]*session
+	nextSession types.SessionNo
+	listeners   []net.Listener
+}
+
+// Return a new server, serving the contents of store.
+func NewServer(store *Store) *Server {
+	return &Server{
+		Location:    time.Local,
+		store:       store,
+		sessions:    make(map[types.SessionNo]*session),
+		nextSession: 1,
+	}
+}
+
+// Listen on a TCP address and serve connections until the listener
+// fails or the server is closed.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve connections from a listener, until it fails or the server is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	s.listeners = append(s.listeners, l)
+	s.lock.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// Stop listening, and close all connections.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for _, sess := range s.sessions {
+		sess.conn.Close()
+	}
+	return nil
+}
+
+// A connected client.
+type session struct {
+	server    *Server
+	conn      io.ReadWriteCloser
+	writeLock sync.Mutex
+
+	no             types.SessionNo
+	person         types.ConfNo
+	invisible      bool
+	utc            bool
+	accepted       map[uint32]bool
+	connected      time.Time
+	active         time.Time
+	userActiveUsed bool
+	user           string
+	clientName     string
+	clientVersion  string
+
+	// Asynchronous messages to send once the current reply has
+	// been sent.
+	pending []pendingAsync
+}
+
+type pendingAsync struct {
+	to  *session
+	msg string
+}
+
+func (sess *session) write(s string) error {
+	sess.writeLock.Lock()
+	defer sess.writeLock.Unlock()
+	_, err := io.WriteString(sess.conn, s)
+	return err
+}
+
+// Serve a single connection until it is closed.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	sess := &session{
+		server:    s,
+		conn:      conn,
+		accepted:  make(map[uint32]bool),
+		connected: time.Now(),
+		active:    time.Now(),
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	header, err := wire.ReadMessage(r)
+	if err != nil || !strings.HasPrefix(header, "A") {
+		return
+	}
+	if tokens, err := wire.Tokens(header[1:]); err == nil && len(tokens) > 0 {
+		sess.user = tokens[0]
+	}
+	if err := sess.write("LysKOM\n"); err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	sess.no = s.nextSession
+	s.nextSession++
+	s.sessions[sess.no] = sess
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.sessions, sess.no)
+		s.lock.Unlock()
+		if sess.person != 0 {
+			s.store.lock.Lock()
+			sess.notifyLogout()
+			s.store.lock.Unlock()
+			sess.flushAsync()
+		}
+	}()
+
+	for {
+		raw, err := wire.ReadMessage(r)
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		req, err := wire.ParseRequest(raw)
+		if err != nil {
+			if sess.write("%% "+err.Error()+"\n") != nil {
+				return
+			}
+			continue
+		}
+
+		if err := sess.write(sess.handle(req)); err != nil {
+			return
+		}
+		sess.flushAsync()
+	}
+}
+
+// Handle a request, returning the reply.
+func (sess *session) handle(req wire.Request) string {
+	call, ok := calls[req.Call]
+	if !ok {
+		return fmt.Sprintf("%%%d %d 0\n", req.ID, errNotImplemented)
+	}
+
+	store := sess.server.store
+	store.lock.Lock()
+	sess.active = time.Now()
+	args := wire.NewArgs(req.Args)
+	reply, err := call(sess, args)
+	if err == nil && args.Err() != nil {
+		err = args.Err()
+	}
+	store.lock.Unlock()
+
+	var ce callError
+	switch {
+	case errors.As(err, &ce):
+		return fmt.Sprintf("%%%d %d %d\n", req.ID, ce.code, ce.status)
+	case err != nil:
+		log.WithFields(log.Fields{
+			"error":   err,
+			"request": req.Raw,
+		}).Debug("komd - bad request")
+		return fmt.Sprintf("%%%% %s\n", err)
+	case reply == "":
+		return fmt.Sprintf("=%d\n", req.ID)
+	}
+	return fmt.Sprintf("=%d %s\n", req.ID, reply)
+}
+
+// Send the asynchronous messages queued while handling a request.
+func (sess *session) flushAsync() {
+	pending := sess.pending
+	sess.pending = nil
+	for _, p := range pending {
+		if err := p.to.write(p.msg); err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"session": p.to.no,
+			}).Debug("komd - sending async message")
+		}
+	}
+}
+
+// Queue an asynchronous message for every session that has accepted
+// it. The arguments for each session are returned by args, which
+// returns nil for sessions that should not get the message. The store
+// lock must be held.
+func (sess *session) notify(msgNo uint32, args func(other *session) []string) {
+	s := sess.server
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var nos []types.SessionNo
+	for no := range s.sessions {
+		nos = append(nos, no)
+	}
+	sort.Slice(nos, func(i, j int) bool { return nos[i] < nos[j] })
+
+	for _, no := range nos {
+		other := s.sessions[no]
+		if !other.accepted[msgNo] {
+			continue
+		}
+		a := args(other)
+		if a == nil {
+			continue
+		}
+		// The arguments may be several tokens each, such as a
+		// whole Text-Stat, and the header counts tokens.
+		body := strings.Join(a, " ")
+		tokens, err := wire.Tokens(body)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"message": msgNo,
+			}).Error("komd - malformed asynchronous message")
+			return
+		}
+		msg := fmt.Sprintf(":%d %d %s\n", len(tokens), msgNo, body)
+		sess.pending = append(sess.pending, pendingAsync{to: other, msg: msg})
+	}
+}
+
+// Tell everyone the session's person has logged out. The store lock
+// must be held.
+func (sess *session) notifyLogout() {
+	if !sess.invisible {
+		sess.notify(asyncLogout, everyone(fmt.Sprint(sess.person), fmt.Sprint(sess.no)))
+	}
+	sess.person = 0
+	sess.invisible = false
+}
+
+// The time codec for the session.
+func (sess *session) times() types.TimeCodec {
+	if sess.utc {
+		return types.UTCTimes
+	}
+	return types.TimeCodec{Location: sess.server.Location}
+}
+
+// Return an error unless the session is logged in.
+func (sess *session) loginFirst() error {
+	if sess.person == 0 {
+		return callError{code: errLoginFirst}
+	}
+	return nil
+}
@@ -0,0 +1,472 @@
+package komd
+
+// The in-memory database, and saving it to and loading it from a file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// An aux item, as stored. The data of a types.AuxItem is not exported,
+// so it does not survive a round-trip through JSON.
+type auxItem struct {
+	AuxNo        types.AuxNo        `json:"aux-no"`
+	Tag          uint32             `json:"tag"`
+	Creator      types.ConfNo       `json:"creator"`
+	CreatedAt    time.Time          `json:"created-at"`
+	Flags        types.AuxItemFlags `json:"flags"`
+	InheritLimit uint32             `json:"inherit-limit"`
+	Data         string             `json:"data"`
+}
+
+type conference struct {
+	No           types.ConfNo           `json:"no"`
+	Name         string                 `json:"name"`
+	Type         types.ExtendedConfType `json:"type"`
+	CreationTime time.Time              `json:"creation-time"`
+	LastWritten  time.Time              `json:"last-written"`
+	Creator      types.ConfNo           `json:"creator"`
+	Supervisor   types.ConfNo           `json:"supervisor"`
+	Nice         uint32                 `json:"nice"`
+	Members      []types.Member         `json:"members"`
+	AuxItems     []auxItem              `json:"aux-items"`
+
+	// The global number of each text, Texts[0] is local number 1.
+	Texts []types.TextNo `json:"texts"`
+}
+
+type person struct {
+	No          types.ConfNo        `json:"no"`
+	Password    string              `json:"password"`
+	Privileges  types.PrivBits      `json:"privileges"`
+	Flags       types.PersonalFlags `json:"flags"`
+	LastLogin   time.Time           `json:"last-login"`
+	UserArea    types.TextNo        `json:"user-area"`
+	Sessions    uint32              `json:"sessions"`
+	Texts       uint32              `json:"texts"`
+	Marks       []types.Mark        `json:"marks"`
+	Memberships []types.Membership  `json:"memberships"`
+}
+
+type text struct {
+	No           types.TextNo     `json:"no"`
+	CreationTime time.Time        `json:"creation-time"`
+	Author       types.ConfNo     `json:"author"`
+	Contents     string           `json:"contents"`
+	Marks        uint16           `json:"marks"`
+	MiscInfo     []types.MiscInfo `json:"misc-info"`
+	AuxItems     []auxItem        `json:"aux-items"`
+}
+
+// The database, as saved to disk.
+type database struct {
+	NextConf types.ConfNo                 `json:"next-conf"`
+	NextText types.TextNo                 `json:"next-text"`
+	Confs    map[types.ConfNo]*conference `json:"conferences"`
+	Persons  map[types.ConfNo]*person     `json:"persons"`
+	Texts    map[types.TextNo]*text       `json:"texts"`
+}
+
+// A Store holds all persons, conferences, texts, memberships and
+// marks. Persons and conferences share the same number space, as each
+// person has a letterbox conference with the same number.
+type Store struct {
+	lock  sync.Mutex
+	path  string
+	dirty bool
+	db    database
+}
+
+// Return a new, empty, store that is only kept in memory.
+func NewStore() *Store {
+	return &Store{
+		db: database{
+			NextConf: 1,
+			NextText: 1,
+			Confs:    make(map[types.ConfNo]*conference),
+			Persons:  make(map[types.ConfNo]*person),
+			Texts:    make(map[types.TextNo]*text),
+		},
+	}
+}
+
+// Return a store that is saved to a file. If the file exists, the
+// store is loaded from it.
+func OpenStore(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&s.db); err != nil {
+		return nil, fmt.Errorf("Reading %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save the store to its file, if it has one and anything has changed
+// since it was last saved. The file is replaced atomically.
+func (s *Store) Save() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.db); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create a person, with a letterbox conference of the same name.
+func (s *Store) CreatePerson(name, password string) (types.ConfNo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.createPerson(name, password, 0)
+}
+
+// Create a conference, with creator as its creator and supervisor.
+func (s *Store) CreateConf(name string, confType types.ExtendedConfType, creator types.ConfNo) (types.ConfNo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.createConf(name, confType, creator)
+}
+
+// Make a person a member of a conference, with a given priority.
+func (s *Store) AddMember(conf, pers types.ConfNo, priority uint8) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.addMember(conf, pers, pers, priority, ^uint16(0), types.MembershipType{})
+}
+
+// Give a person all privileges.
+func (s *Store) SetAdmin(pers types.ConfNo) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p, ok := s.db.Persons[pers]
+	if !ok {
+		return undefinedPerson(pers)
+	}
+	p.Privileges = types.PrivBits{Wheel: true, Admin: true, Statistic: true, CreatePersons: true, CreateConferences: true, ChangeName: true}
+	s.dirty = true
+	return nil
+}
+
+// Return the number of a person, given the exact name (ignoring case).
+func (s *Store) LookupPerson(name string) (types.ConfNo, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for no, c := range s.db.Confs {
+		if _, ok := s.db.Persons[no]; ok && strings.EqualFold(c.Name, name) {
+			return no, true
+		}
+	}
+	return 0, false
+}
+
+// Return true if the name is used by a person or conference. The lock
+// must be held.
+func (s *Store) nameTaken(name string) bool {
+	for _, c := range s.db.Confs {
+		if strings.EqualFold(c.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) createConf(name string, confType types.ExtendedConfType, creator types.ConfNo) (types.ConfNo, error) {
+	if strings.TrimSpace(name) == "" {
+		return 0, callError{code: errBadName}
+	}
+	if s.nameTaken(name) {
+		return 0, callError{code: errConferenceExists}
+	}
+	if confType.Secret && !confType.RdProt {
+		return 0, callError{code: errSecretPublic}
+	}
+
+	no := s.db.NextConf
+	s.db.NextConf++
+	now := time.Now()
+	s.db.Confs[no] = &conference{
+		No:           no,
+		Name:         name,
+		Type:         confType,
+		CreationTime: now,
+		LastWritten:  now,
+		Creator:      creator,
+		Supervisor:   creator,
+	}
+	s.dirty = true
+
+	return no, nil
+}
+
+func (s *Store) createPerson(name, password string, creator types.ConfNo) (types.ConfNo, error) {
+	no, err := s.createConf(name, types.ExtendedConfType{RdProt: true, LetterBox: true}, creator)
+	if err != nil {
+		return 0, err
+	}
+	if creator == 0 {
+		s.db.Confs[no].Creator = no
+		s.db.Confs[no].Supervisor = no
+	}
+
+	s.db.Persons[no] = &person{No: no, Password: hashPassword(password), LastLogin: time.Now()}
+	if err := s.addMember(no, no, no, 255, 0, types.MembershipType{}); err != nil {
+		return 0, err
+	}
+
+	return no, nil
+}
+
+// Add a member to a conference, or update the priority and position
+// of an existing membership.
+func (s *Store) addMember(conf, pers, addedBy types.ConfNo, priority uint8, where uint16, mt types.MembershipType) error {
+	c, ok := s.db.Confs[conf]
+	if !ok {
+		return undefinedConference(conf)
+	}
+	p, ok := s.db.Persons[pers]
+	if !ok {
+		return undefinedPerson(pers)
+	}
+
+	now := time.Now()
+	m := types.Membership{
+		LastTimeRead: now,
+		Conference:   conf,
+		Priority:     priority,
+		AddedBy:      addedBy,
+		AddedAt:      now,
+		Type:         mt,
+	}
+
+	if ix := p.membershipIndex(conf); ix >= 0 {
+		m.LastTimeRead = p.Memberships[ix].LastTimeRead
+		m.ReadRanges = p.Memberships[ix].ReadRanges
+		m.AddedBy = p.Memberships[ix].AddedBy
+		m.AddedAt = p.Memberships[ix].AddedAt
+		p.Memberships = append(p.Memberships[:ix], p.Memberships[ix+1:]...)
+	} else {
+		c.Members = append(c.Members, types.Member{Member: pers, AddedBy: addedBy, AddedAt: now, Type: mt})
+	}
+
+	pos := int(where)
+	if pos > len(p.Memberships) {
+		pos = len(p.Memberships)
+	}
+	p.Memberships = append(p.Memberships, types.Membership{})
+	copy(p.Memberships[pos+1:], p.Memberships[pos:])
+	p.Memberships[pos] = m
+	p.renumber()
+	s.dirty = true
+
+	return nil
+}
+
+// Return the index of the membership in a conference, or -1.
+func (p *person) membershipIndex(conf types.ConfNo) int {
+	for ix, m := range p.Memberships {
+		if m.Conference == conf {
+			return ix
+		}
+	}
+	return -1
+}
+
+func (p *person) renumber() {
+	for ix := range p.Memberships {
+		p.Memberships[ix].Position = uint32(ix)
+	}
+}
+
+// Return true if a person is a member of a conference.
+func (c *conference) isMember(pers types.ConfNo) bool {
+	for _, m := range c.Members {
+		if m.Member == pers {
+			return true
+		}
+	}
+	return false
+}
+
+// The highest local text number in a conference.
+func (c *conference) highestLocal() types.TextNo {
+	return types.TextNo(len(c.Texts))
+}
+
+// Return the global number of a local text number, or zero.
+func (c *conference) global(local types.TextNo) types.TextNo {
+	if local == 0 || local > c.highestLocal() {
+		return 0
+	}
+	return c.Texts[local-1]
+}
+
+// Return true if a person can see that a conference exists.
+func (s *Store) visible(pers types.ConfNo, c *conference) bool {
+	return !c.Type.Secret || c.Supervisor == pers || c.isMember(pers)
+}
+
+// Return true if a person can read the texts in a conference.
+func (s *Store) readable(pers types.ConfNo, c *conference) bool {
+	return !c.Type.RdProt || c.Supervisor == pers || c.isMember(pers)
+}
+
+// Return true if a person can read a text, because they wrote it or
+// can read one of its recipients.
+func (s *Store) canRead(pers types.ConfNo, t *text) bool {
+	if pers != 0 && t.Author == pers {
+		return true
+	}
+	for _, mi := range t.MiscInfo {
+		var conf types.ConfNo
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient:
+			conf = mi.Recipient
+		case types.CCRecipient:
+			conf = mi.CCRecipient
+		case types.BCCRecipient:
+			conf = mi.BCCRecipient
+		default:
+			continue
+		}
+		if c, ok := s.db.Confs[conf]; ok && s.readable(pers, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add the aux items of a new object, numbering them from one.
+func newAuxItems(items []types.AuxItemInput, creator types.ConfNo, now time.Time) []auxItem {
+	var rv []auxItem
+	for ix, item := range items {
+		rv = append(rv, auxItem{
+			AuxNo:        types.AuxNo(ix + 1),
+			Tag:          item.Tag,
+			Creator:      creator,
+			CreatedAt:    now,
+			Flags:        item.Flags,
+			InheritLimit: item.InheritLimit,
+			Data:         item.Data(),
+		})
+	}
+	return rv
+}
+
+// Create a text. The misc-info items have been checked by the caller,
+// and only contain recipients, comment-to and footnote-to items.
+func (s *Store) createText(author types.ConfNo, contents string, misc []types.MiscInfo, aux []types.AuxItemInput) (*text, error) {
+	for ix, mi := range misc {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient, types.CCRecipient, types.BCCRecipient:
+			conf := recipientOf(mi)
+			if _, ok := s.db.Confs[conf]; !ok {
+				return nil, undefinedConference(conf)
+			}
+		case types.CommentTo, types.FootnoteTo:
+			parent := mi.CommentTo + mi.FootnoteTo
+			if t, ok := s.db.Texts[parent]; !ok || !s.canRead(author, t) {
+				return nil, noSuchText(parent)
+			}
+		default:
+			return nil, callError{code: errIllegalMisc, status: uint32(ix)}
+		}
+	}
+
+	now := time.Now()
+	t := &text{
+		No:           s.db.NextText,
+		CreationTime: now,
+		Author:       author,
+		Contents:     contents,
+		AuxItems:     newAuxItems(aux, author, now),
+	}
+	s.db.NextText++
+
+	for _, mi := range misc {
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient, types.CCRecipient, types.BCCRecipient:
+			c := s.db.Confs[recipientOf(mi)]
+			c.Texts = append(c.Texts, t.No)
+			c.LastWritten = now
+			t.MiscInfo = append(t.MiscInfo, mi, types.MiscInfo{Selector: uint32(types.LocalNo), LocalNo: c.highestLocal()})
+		case types.CommentTo:
+			parent := s.db.Texts[mi.CommentTo]
+			parent.MiscInfo = append(parent.MiscInfo, types.MiscInfo{Selector: uint32(types.CommentIn), CommentedIn: t.No})
+			t.MiscInfo = append(t.MiscInfo, mi)
+		case types.FootnoteTo:
+			parent := s.db.Texts[mi.FootnoteTo]
+			parent.MiscInfo = append(parent.MiscInfo, types.MiscInfo{Selector: uint32(types.FootnoteIn), FootnotedIn: t.No})
+			t.MiscInfo = append(t.MiscInfo, mi)
+		}
+	}
+
+	s.db.Texts[t.No] = t
+	if p, ok := s.db.Persons[author]; ok {
+		p.Texts++
+	}
+	s.dirty = true
+
+	return t, nil
+}
+
+// Return the conference a recipient misc-info item refers to.
+func recipientOf(mi types.MiscInfo) types.ConfNo {
+	switch types.InfoType(mi.Selector) {
+	case types.CCRecipient:
+		return mi.CCRecipient
+	case types.BCCRecipient:
+		return mi.BCCRecipient
+	}
+	return mi.Recipient
+}
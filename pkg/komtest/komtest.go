@@ -0,0 +1,276 @@
+// A scriptable in-memory Protocol A server, for testing code that
+// uses protocol.KomClient against something that answers.
+//
+// A test registers a handler for each call it expects, connects a
+// client and then checks the requests the server saw:
+//
+//	srv := komtest.NewServer()
+//	defer srv.Close()
+//	srv.Handle(82, func(req komtest.Request) komtest.Reply {
+//		return komtest.OK()
+//	})
+//	client, err := srv.Client()
+//	...
+//	err = client.UserActive()
+//	reqs := srv.Requests()
+//
+// Calls without a handler are answered with not-implemented (error
+// code 2).
+package komtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/wire"
+)
+
+// The error code sent for calls without a handler.
+const notImplemented = 2
+
+// A request received by the server. Args holds the arguments after
+// the call number, split as described for wire.Tokens.
+type Request = wire.Request
+
+// The reply to a request, see OK and Error.
+type Reply struct {
+	err    bool
+	code   uint32
+	status uint32
+	body   string
+}
+
+// A successful reply, the arguments are formatted with fmt.Sprint
+// and separated by spaces. Strings are sent as is, so use
+// hollerith.Sprint for strings that should be Hollerith strings.
+func OK(args ...interface{}) Reply {
+	parts := make([]string, len(args))
+	for ix, arg := range args {
+		parts[ix] = fmt.Sprint(arg)
+	}
+	return Reply{body: strings.Join(parts, " ")}
+}
+
+// An error reply, with a Protocol A error code and status.
+func Error(code, status uint32) Reply {
+	return Reply{err: true, code: code, status: status}
+}
+
+// A Handler answers a request.
+type Handler func(req Request) Reply
+
+// A connection to the server, writes are serialised so that async
+// messages do not end up in the middle of a reply.
+type conn struct {
+	net.Conn
+	writeLock sync.Mutex
+}
+
+func (c *conn) write(s string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := io.WriteString(c, s)
+	return err
+}
+
+// A Server is an in-memory Protocol A server.
+type Server struct {
+	lock     sync.Mutex
+	handlers map[uint32]Handler
+	requests []Request
+	conns    map[*conn]bool
+	listener net.Listener
+	received chan Request
+}
+
+// Create a new server without any handlers.
+func NewServer() *Server {
+	return &Server{
+		handlers: make(map[uint32]Handler),
+		conns:    make(map[*conn]bool),
+		received: make(chan Request, 1024),
+	}
+}
+
+// Register the handler for a call number, replacing any previous
+// handler for the call.
+func (s *Server) Handle(call uint32, h Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[call] = h
+}
+
+// Register a handler that always replies with the same arguments, see
+// OK.
+func (s *Server) HandleOK(call uint32, args ...interface{}) {
+	reply := OK(args...)
+	s.Handle(call, func(Request) Reply { return reply })
+}
+
+// Return the requests received so far, in the order they arrived.
+func (s *Server) Requests() []Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rv := make([]Request, len(s.requests))
+	copy(rv, s.requests)
+	return rv
+}
+
+// Return a channel on which every request is delivered as it
+// arrives, for tests that do not wait for a reply. Requests are
+// dropped if nobody reads them.
+func (s *Server) Received() <-chan Request {
+	return s.received
+}
+
+// Send an asynchronous message to every connected client. The
+// arguments are formatted as for OK, and the message is sent with
+// the number of tokens they make up, so strings and arrays may be
+// passed preformatted.
+func (s *Server) Async(msgNo uint32, args ...interface{}) error {
+	body := OK(args...).body
+	tokens, err := wire.Tokens(body)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf(":%d %d", len(tokens), msgNo)
+	if body != "" {
+		msg += " " + body
+	}
+	msg += "\n"
+
+	s.lock.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.lock.Unlock()
+
+	for _, c := range conns {
+		if err := c.write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return a client connected to the server over a net.Pipe.
+func (s *Server) Client() (*protocol.KomClient, error) {
+	client, server := net.Pipe()
+	go s.Serve(server)
+	return protocol.NewKomClientConn(client)
+}
+
+// Start listening on a loopback port, returning the address to
+// connect to.
+func (s *Server) Listen() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	s.lock.Lock()
+	s.listener = l
+	s.lock.Unlock()
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.Serve(c)
+		}
+	}()
+
+	return l.Addr().String(), nil
+}
+
+// Stop listening and close all connections.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+// Serve a single connection until it is closed.
+func (s *Server) Serve(nc net.Conn) {
+	c := &conn{Conn: nc}
+	s.lock.Lock()
+	s.conns[c] = true
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, c)
+		s.lock.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	header, err := wire.ReadMessage(r)
+	if err != nil || !strings.HasPrefix(header, "A") {
+		return
+	}
+	if err := c.write("LysKOM\n"); err != nil {
+		return
+	}
+
+	for {
+		raw, err := wire.ReadMessage(r)
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		req, err := wire.ParseRequest(raw)
+		if err != nil {
+			if c.write("%% "+err.Error()+"\n") != nil {
+				return
+			}
+			continue
+		}
+
+		s.lock.Lock()
+		s.requests = append(s.requests, req)
+		h, ok := s.handlers[req.Call]
+		s.lock.Unlock()
+
+		select {
+		case s.received <- req:
+		default:
+		}
+
+		reply := Error(notImplemented, 0)
+		if ok {
+			reply = h(req)
+		}
+
+		var out string
+		if reply.err {
+			out = fmt.Sprintf("%%%d %d %d\n", req.ID, reply.code, reply.status)
+		} else if reply.body == "" {
+			out = fmt.Sprintf("=%d\n", req.ID)
+		} else {
+			out = fmt.Sprintf("=%d %s\n", req.ID, reply.body)
+		}
+		if err := c.write(out); err != nil {
+			return
+		}
+	}
+}
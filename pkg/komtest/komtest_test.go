@@ -0,0 +1,141 @@
+package komtest
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/protocol"
+)
+
+func TestClientCalls(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.HandleOK(82)
+	srv.Handle(78, func(req Request) Reply {
+		if req.Args[0] != "17" {
+			return Error(9, 4711)
+		}
+		return OK(hollerith.Sprint("Test"), "00001000", 6, 77)
+	})
+
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	if err := client.UserActive(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	uConf, err := client.GetUConfStat(17)
+	if err != nil || uConf.Name != "Test" || uConf.Nice != 77 {
+		t.Errorf("saw %+v/%v, want conference Test", uConf, err)
+	}
+
+	_, err = client.GetUConfStat(4711)
+	var undef protocol.UndefinedConferenceError
+	if !errors.As(err, &undef) {
+		t.Errorf("saw %v, want an undefined conference error", err)
+	}
+
+	if _, err := client.FirstUnusedConfNo(); err == nil {
+		t.Errorf("expected not-implemented for a call without handler")
+	}
+
+	var calls []uint32
+	for _, req := range srv.Requests() {
+		calls = append(calls, req.Call)
+	}
+	if want := []uint32{82, 78, 78, 114}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("saw calls %v, want %v", calls, want)
+	}
+}
+
+func TestAsync(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	msgs, cancel := client.Subscribe()
+	defer cancel()
+
+	if err := srv.Async(protocol.AsyncSendMessageNo, 0, 6, hollerith.Sprint("Fika!")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	select {
+	case msg := <-msgs:
+		want := protocol.AsyncSendMessage{Recipient: 0, Sender: 6, Message: "Fika!"}
+		if msg != want {
+			t.Errorf("saw %+v, want %+v", msg, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("timed out waiting for the message")
+	}
+}
+
+func TestAsyncCount(t *testing.T) {
+	cases := []struct {
+		msgNo uint32
+		args  []interface{}
+		want  string
+	}{
+		{protocol.AsyncLogoutNo, []interface{}{6, 10}, ":2 13 6 10\n"},
+		{protocol.AsyncSendMessageNo, []interface{}{0, 6, hollerith.Sprint("Fika i koket!")}, ":3 12 0 6 13HFika i koket!\n"},
+		{protocol.AsyncSendMessageNo, []interface{}{"0 6", hollerith.Sprint("Fika!")}, ":3 12 0 6 5HFika!\n"},
+		{protocol.AsyncNewTextNo, []interface{}{4711, "2 { 0 17 6 8 }"}, ":8 15 4711 2 { 0 17 6 8 }\n"},
+	}
+
+	for _, c := range cases {
+		srv := NewServer()
+		client, server := net.Pipe()
+		go srv.Serve(server)
+
+		r := bufio.NewReader(client)
+		go client.Write([]byte("A4Htest\n"))
+		if line, err := r.ReadString('\n'); err != nil || line != "LysKOM\n" {
+			t.Fatalf("saw %q/%v, want LysKOM", line, err)
+		}
+
+		go srv.Async(c.msgNo, c.args...)
+		line, err := r.ReadString('\n')
+		if err != nil || line != c.want {
+			t.Errorf("Async(%d, %v), saw %q/%v, want %q", c.msgNo, c.args, line, err, c.want)
+		}
+
+		client.Close()
+		srv.Close()
+	}
+}
+
+func TestListen(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.HandleOK(82)
+
+	addr, err := srv.Listen()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	client, err := protocol.NewKomClient(addr)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	if err := client.UserActive(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,574 @@
+package protocol
+
+// Blocking wrappers around the asynchronous protocol calls, these
+// send a request and wait for the server to respond to it.
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Wait for a generic response, returning the error carried in it.
+func waitGeneric(c chan genericResponse, err error) error {
+	if err != nil {
+		return err
+	}
+	resp := <-c
+	return resp.err
+}
+
+// Add and delete aux items on a text (modify-text-info, #92).
+func (k *KomClient) ModifyTextInfo(text types.TextNo, deleteItems []types.AuxNo, addItems []types.AuxItemInput) error {
+	return waitGeneric(k.asyncModifyTextInfo(text, deleteItems, addItems))
+}
+
+// Add and delete aux items on a conference (modify-conf-info, #93).
+func (k *KomClient) ModifyConfInfo(conf types.ConfNo, deleteItems []types.AuxNo, addItems []types.AuxItemInput) error {
+	return waitGeneric(k.asyncModifyConfInfo(conf, deleteItems, addItems))
+}
+
+// Set the server information (set-info, #79). Only the fields that
+// are part of the old info structure are sent, use ModifySystemInfo
+// to change the aux items.
+func (k *KomClient) SetInfo(info types.Info) error {
+	return waitGeneric(k.asyncSetInfo(info.Old()))
+}
+
+// Fetch the server information, including the aux items (get-info, #94).
+func (k *KomClient) GetInfo() (types.Info, error) {
+	c, err := k.asyncGetInfo()
+	if err != nil {
+		return types.Info{}, err
+	}
+	resp := <-c
+	return resp.info, resp.err
+}
+
+// Add and delete aux items on the server (modify-system-info, #95).
+func (k *KomClient) ModifySystemInfo(deleteItems []types.AuxNo, addItems []types.AuxItemInput) error {
+	return waitGeneric(k.asyncModifySystemInfo(deleteItems, addItems))
+}
+
+// Fetch information about the server that only changes when the
+// server restarts (get-boottime-info, #113).
+func (k *KomClient) GetBoottimeInfo() (types.StaticServerInfo, error) {
+	c, err := k.asyncGetBoottimeInfo()
+	if err != nil {
+		return types.StaticServerInfo{}, err
+	}
+	resp := <-c
+	return resp.info, resp.err
+}
+
+// Fetch the names of the measurements the server makes, and the
+// intervals they are averaged over (get-stats-description, #111).
+func (k *KomClient) GetStatsDescription() (types.StatsDescription, error) {
+	c, err := k.asyncGetStatsDescription()
+	if err != nil {
+		return types.StatsDescription{}, err
+	}
+	resp := <-c
+	return resp.description, resp.err
+}
+
+// Fetch the statistics for a single measurement, one entry per
+// interval in the stats description (get-stats, #112).
+func (k *KomClient) GetStats(what string) ([]types.Stats, error) {
+	c, err := k.asyncGetStats(what)
+	if err != nil {
+		return nil, err
+	}
+	resp := <-c
+	return resp.stats, resp.err
+}
+
+// Fetch all measurements the server makes, over all intervals.
+func (k *KomClient) GetMeasurements() ([]types.Measurement, error) {
+	desc, err := k.GetStatsDescription()
+	if err != nil {
+		return nil, err
+	}
+	intervals := desc.Intervals()
+
+	var rv []types.Measurement
+	for _, what := range desc.What {
+		stats, err := k.GetStats(what)
+		if err != nil {
+			return rv, err
+		}
+		for ix, s := range stats {
+			if ix >= len(intervals) {
+				break
+			}
+			rv = append(rv, types.Measurement{Name: what, Interval: intervals[ix], Stats: s})
+		}
+	}
+
+	return rv, nil
+}
+
+// Fetch the scheduling priority and weight of a session
+// (get-scheduling, #118).
+func (k *KomClient) GetScheduling(session types.SessionNo) (types.SchedulingInfo, error) {
+	c, err := k.asyncGetScheduling(session)
+	if err != nil {
+		return types.SchedulingInfo{}, err
+	}
+	resp := <-c
+	return resp.info, resp.err
+}
+
+// Set the scheduling priority and weight of a session
+// (set-scheduling, #119). The server responds with a
+// PriorityDeniedError, WeightDeniedError or WeightZeroError if the
+// change is not allowed.
+func (k *KomClient) SetScheduling(session types.SessionNo, info types.SchedulingInfo) error {
+	return waitGeneric(k.asyncSetScheduling(session, info))
+}
+
+// Wait for a conference number response.
+func waitConf(c chan confResponse, err error) (types.ConfNo, error) {
+	if err != nil {
+		return 0, err
+	}
+	resp := <-c
+	return resp.conf, resp.err
+}
+
+// Fetch the status of a conference, in the short form
+// (get-uconf-stat, #78).
+func (k *KomClient) GetUConfStat(conf types.ConfNo) (types.UConference, error) {
+	c, err := k.asyncGetUConfStat(conf)
+	if err != nil {
+		return types.UConference{}, err
+	}
+	resp := <-c
+	return resp.uConf, resp.err
+}
+
+// Return the name of a person or conference. The server is asked
+// (get-uconf-stat, #78) the first time, and the name is remembered for
+// as long as the client lives. That a conference does not exist is
+// also remembered, other errors are not.
+func (k *KomClient) ConfName(conf types.ConfNo) (string, error) {
+	k.nameLock.Lock()
+	cached, ok := k.names[conf]
+	k.nameLock.Unlock()
+	if ok {
+		return cached.name, cached.err
+	}
+
+	uconf, err := k.GetUConfStat(conf)
+	var undef UndefinedConferenceError
+	if err != nil && !errors.As(err, &undef) {
+		return "", err
+	}
+
+	k.nameLock.Lock()
+	defer k.nameLock.Unlock()
+	if k.names == nil {
+		k.names = make(map[types.ConfNo]confName)
+	}
+	k.names[conf] = confName{name: uconf.Name, err: err}
+	return uconf.Name, err
+}
+
+// Fetch the full status of a conference (get-conf-stat, #91).
+func (k *KomClient) GetConfStat(conf types.ConfNo) (types.Conference, error) {
+	c, err := k.asyncGetConfStat(conf)
+	if err != nil {
+		return types.Conference{}, err
+	}
+	resp := <-c
+	return resp.conf, resp.err
+}
+
+// Return the lowest conference number that has never been used
+// (first-unused-conf-no, #114).
+func (k *KomClient) FirstUnusedConfNo() (types.ConfNo, error) {
+	return waitConf(k.asyncFirstUnusedConfNo())
+}
+
+// Return the first existing conference number after conf
+// (find-next-conf-no, #116). An UndefinedConferenceError is returned
+// when there are no more conferences.
+func (k *KomClient) FindNextConfNo(conf types.ConfNo) (types.ConfNo, error) {
+	return waitConf(k.asyncFindNextConfNo(conf))
+}
+
+// Return the last existing conference number before conf
+// (find-previous-conf-no, #117). An UndefinedConferenceError is
+// returned when there are no earlier conferences.
+func (k *KomClient) FindPreviousConfNo(conf types.ConfNo) (types.ConfNo, error) {
+	return waitConf(k.asyncFindPreviousConfNo(conf))
+}
+
+// Set the number of days texts are kept in a conference
+// (set-garb-nice, #22). The conference is looked up as by ResolveName,
+// an unknown or ambiguous name is an error.
+func (k *KomClient) SetGarbNice(conference string, nice uint32) error {
+	conf, err := k.resolveName(conference, false, true)
+	if err != nil {
+		return err
+	}
+	return waitGeneric(k.asyncSetGarbNice(conf, nice))
+}
+
+// Set the number of days an empty conference is kept before it is
+// removed, zero meaning forever (set-expire, #97). The conference is
+// looked up as for SetGarbNice.
+func (k *KomClient) SetExpire(conference string, expire uint32) error {
+	conf, err := k.resolveName(conference, false, true)
+	if err != nil {
+		return err
+	}
+	return waitGeneric(k.asyncSetExpire(conf, expire))
+}
+
+// Set the number of days a text is kept after it was last commented
+// (set-keep-commented, #105). The conference is looked up as for
+// SetGarbNice.
+func (k *KomClient) SetKeepCommented(conference string, keepCommented uint32) error {
+	conf, err := k.resolveName(conference, false, true)
+	if err != nil {
+		return err
+	}
+	return waitGeneric(k.asyncSetKeepCommented(conf, keepCommented))
+}
+
+// Fetch the status of a person (get-person-stat, #49).
+func (k *KomClient) GetPersonStat(person types.ConfNo) (types.Person, error) {
+	c, err := k.asyncGetPersonStat(person)
+	if err != nil {
+		return types.Person{}, err
+	}
+	resp := <-c
+	return resp.person, resp.err
+}
+
+// Tell the server that the user is actively doing something, which
+// resets the idle time of the session (user-active, #82).
+func (k *KomClient) UserActive() error {
+	return waitGeneric(k.asyncUserActive())
+}
+
+// Set the personal flags of a person (set-pers-flags, #106).
+func (k *KomClient) SetPersFlags(person types.ConfNo, flags types.PersonalFlags) error {
+	return waitGeneric(k.asyncSetPersFlags(person, flags))
+}
+
+// Look up persons and conferences by name (lookup-z-name, #76).
+func (k *KomClient) LookupZName(name string, wantPersons, wantConferences bool) ([]types.ConfZInfo, error) {
+	c, err := k.asyncLookupZName(name, wantPersons, wantConferences)
+	if err != nil {
+		return nil, err
+	}
+	resp := <-c
+	return resp.confs, resp.err
+}
+
+// Look up persons and conferences whose names match a regular
+// expression (re-z-lookup, #74).
+func (k *KomClient) ReZLookup(re string, wantPersons, wantConferences bool) ([]types.ConfZInfo, error) {
+	c, err := k.asyncReZLookup(re, wantPersons, wantConferences)
+	if err != nil {
+		return nil, err
+	}
+	resp := <-c
+	return resp.confs, resp.err
+}
+
+// Return the number of the person or conference with a name, which
+// must match exactly (ignoring case) or be an unambiguous
+// abbreviation. Names already seen are not looked up again.
+func (k *KomClient) ResolveName(name string, wantPersons, wantConferences bool) (types.ConfNo, error) {
+	return k.resolveName(name, wantPersons, wantConferences)
+}
+
+// List the sessions on the server (who-is-on-dynamic, #83). Sessions
+// idle for more than activeLast seconds are left out, unless
+// activeLast is zero.
+func (k *KomClient) WhoIsOnDynamic(wantVisible, wantInvisible bool, activeLast uint32) ([]types.DynamicSessionInfo, error) {
+	c, err := k.asyncWhoIsOnDynamic(wantVisible, wantInvisible, activeLast)
+	if err != nil {
+		return nil, err
+	}
+	resp := <-c
+	return resp.sessions, resp.err
+}
+
+// Log in as a person (login, #62). The person is looked up by name
+// if it is not already known.
+func (k *KomClient) Login(userName, password string, invisible bool) error {
+	person, err := k.resolveName(userName, true, false)
+	if err != nil {
+		return err
+	}
+	if err := waitGeneric(k.asyncLogin(userName, password, invisible)); err != nil {
+		return err
+	}
+
+	k.stateLock.Lock()
+	k.person = person
+	k.stateLock.Unlock()
+
+	return nil
+}
+
+// Log out, keeping the connection (logout, #1).
+func (k *KomClient) Logout() error {
+	if err := waitGeneric(k.asyncLogout()); err != nil {
+		return err
+	}
+
+	k.stateLock.Lock()
+	k.person = 0
+	k.stateLock.Unlock()
+
+	return nil
+}
+
+// Return the person the session is logged in as, or zero.
+func (k *KomClient) Person() types.ConfNo {
+	k.stateLock.Lock()
+	defer k.stateLock.Unlock()
+	return k.person
+}
+
+// Wait for a text number response.
+func waitText(c chan textResponse, err error) (types.TextNo, error) {
+	if err != nil {
+		return 0, err
+	}
+	resp := <-c
+	return resp.text, resp.err
+}
+
+// Create a text (create-text, #86), returning the new text number.
+func (k *KomClient) CreateText(text string, miscInfo []types.MiscInfo, auxItems []types.AuxItemInput) (types.TextNo, error) {
+	return waitText(k.asyncCreateText(text, miscInfo, auxItems))
+}
+
+// Create a text without an author (create-anonymous-text, #87),
+// returning the new text number. The server responds with an
+// AnonymousRejectedError if a recipient does not accept anonymous
+// texts.
+func (k *KomClient) CreateAnonymousText(text string, miscInfo []types.MiscInfo, auxItems []types.AuxItemInput) (types.TextNo, error) {
+	return waitText(k.asyncCreateAnonymousText(text, miscInfo, auxItems))
+}
+
+// Fetch the status of a text (get-text-stat, #90).
+func (k *KomClient) GetTextStat(text types.TextNo) (types.TextStat, error) {
+	c, err := k.asyncGetTextStat(text)
+	if err != nil {
+		return types.TextStat{}, err
+	}
+	resp := <-c
+	return resp.stat, resp.err
+}
+
+// Fetch the status and raw contents of a text.
+func (k *KomClient) getText(text types.TextNo) (types.TextStat, string, error) {
+	stat, err := k.GetTextStat(text)
+	if err != nil {
+		return stat, "", err
+	}
+
+	c, err := k.asyncGetText(text, 0, stat.Chars)
+	if err != nil {
+		return stat, "", err
+	}
+	resp := <-c
+	return stat, resp.text, resp.err
+}
+
+// Fetch the full contents of a text (get-text, #25), converted to
+// UTF-8 from the character set named in its content-type aux item, or
+// from the server's character set if it has none. Bytes that are not
+// valid in the character set become replacement characters.
+func (k *KomClient) GetText(text types.TextNo) (string, error) {
+	stat, raw, err := k.getText(text)
+	if err != nil {
+		return "", err
+	}
+
+	cs := k.textCharset(stat.AuxItems)
+	if cs == "" {
+		return raw, nil
+	}
+	return decodeText(text, raw, cs), nil
+}
+
+// Fetch the status and the contents of a text (get-text, #25), the
+// contents exactly as sent by the server. Binary texts, such as
+// images, must be fetched this way, as GetText would convert them
+// from the server's character set.
+func (k *KomClient) GetRawText(text types.TextNo) (types.TextStat, string, error) {
+	return k.getText(text)
+}
+
+// How much of a text GetSubject fetches at a time, looking for the
+// end of the subject.
+const subjectChunk = 256
+
+// Fetch the subject of a text, its first line, converted as by
+// GetText, without fetching the body. The stat is the status of the
+// text, as returned by GetTextStat.
+func (k *KomClient) GetSubject(text types.TextNo, stat types.TextStat) (string, error) {
+	var raw string
+	for start := uint32(0); start < stat.Chars; start += subjectChunk {
+		c, err := k.asyncGetText(text, start, start+subjectChunk-1)
+		if err != nil {
+			return "", err
+		}
+		resp := <-c
+		if resp.err != nil {
+			return "", resp.err
+		}
+		if ix := strings.IndexByte(resp.text, '\n'); ix >= 0 {
+			raw += resp.text[:ix]
+			break
+		}
+		raw += resp.text
+	}
+
+	cs := k.textCharset(stat.AuxItems)
+	if cs == "" {
+		return raw, nil
+	}
+	return decodeText(text, raw, cs), nil
+}
+
+// Fetch a text, split into subject and body, converted as by GetText.
+// The body of a multipart text is left as sent by the server, as each
+// part carries its own character set, see types.Text.Parts.
+func (k *KomClient) GetTextContent(text types.TextNo) (types.Text, error) {
+	stat, raw, err := k.getText(text)
+	if err != nil {
+		return types.Text{}, err
+	}
+
+	var contentType string
+	if item, ok := types.FindAuxItem(stat.AuxItems, types.AuxContentType); ok {
+		contentType = item.Data()
+	}
+
+	rv := types.ParseText(raw, contentType)
+	if rv.IsMultipart() {
+		return rv, nil
+	}
+
+	cs := k.textCharset(stat.AuxItems)
+	if cs == "" {
+		return rv, nil
+	}
+	rv.Subject = decodeText(text, rv.Subject, cs)
+	rv.Body = decodeText(text, rv.Body, cs)
+	if rv.Charset == "" {
+		rv.Charset = cs
+	}
+	return rv, nil
+}
+
+// Create a text from a types.Text (create-text, #86), adding a
+// content-type aux item unless the text is plain text/x-kom-basic.
+func (k *KomClient) CreateTextContent(text types.Text, miscInfo []types.MiscInfo, auxItems []types.AuxItemInput) (types.TextNo, error) {
+	contents, items := text.Payload()
+	return k.CreateText(contents, miscInfo, append(items, auxItems...))
+}
+
+// Return the conferences in which a person may have unread texts
+// (get-unread-confs, #52).
+func (k *KomClient) GetUnreadConfs(person types.ConfNo) ([]types.ConfNo, error) {
+	c, err := k.asyncGetUnreadConfs(person)
+	if err != nil {
+		return nil, err
+	}
+	resp := <-c
+	return resp.unread, resp.err
+}
+
+// Mark local texts in a conference as read (mark-as-read, #27).
+func (k *KomClient) MarkAsRead(conf types.ConfNo, localTexts []types.TextNo) error {
+	return waitGeneric(k.asyncMarkAsReadConf(conf, localTexts))
+}
+
+// Map local text numbers in a conference to global text numbers
+// (local-to-global, #103), starting at firstLocal and returning at
+// most count (at most 255) texts.
+func (k *KomClient) LocalToGlobal(conf types.ConfNo, firstLocal types.TextNo, count uint32) (types.TextMapping, error) {
+	c, err := k.asyncLocalToGlobal(conf, firstLocal, count)
+	if err != nil {
+		return types.TextMapping{}, err
+	}
+	resp := <-c
+	return resp.mapping, resp.err
+}
+
+// Return a person's membership in a conference, including all read
+// ranges (query-read-texts, #107).
+func (k *KomClient) QueryReadTexts(person, conf types.ConfNo) (types.Membership, error) {
+	c, err := k.asyncQueryReadTexts(person, conf, true, 0)
+	if err != nil {
+		return types.Membership{}, err
+	}
+	resp := <-c
+	if resp.err != nil {
+		return types.Membership{}, resp.err
+	}
+	return resp.memberships[0], nil
+}
+
+// Return count of a person's memberships, starting at position first,
+// without read ranges (get-membership, #108).
+func (k *KomClient) GetMembership(person types.ConfNo, first, count uint16) ([]types.Membership, error) {
+	c, err := k.asyncGetMembership(person, first, count, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp := <-c
+	return resp.memberships, resp.err
+}
+
+// Replace the read ranges of the logged-in person's membership in a
+// conference (set-read-ranges, #110).
+func (k *KomClient) SetReadRanges(conf types.ConfNo, ranges []types.ReadRange) error {
+	return waitGeneric(k.asyncSetReadRanges(conf, ranges))
+}
+
+// Return the texts the logged-in person has marked (get-marks, #23).
+func (k *KomClient) GetMarks() ([]types.Mark, error) {
+	c, err := k.asyncGetMarks()
+	if err != nil {
+		return nil, err
+	}
+	resp := <-c
+	return resp.marks, resp.err
+}
+
+// Mark a text (mark-text, #72). Marking a text that is already marked
+// changes the mark type.
+func (k *KomClient) MarkText(text types.TextNo, markType uint8) error {
+	return waitGeneric(k.asyncMarkText(text, markType))
+}
+
+// Remove the mark from a text (unmark-text, #73).
+func (k *KomClient) UnmarkText(text types.TextNo) error {
+	return waitGeneric(k.asyncUnmarkText(text))
+}
+
+// Ask the server to send all times in UTC, rather than in the
+// server's local time zone (set-connection-time-format, #120).
+func (k *KomClient) SetConnectionTimeFormat(useUTC bool) error {
+	if err := waitGeneric(k.asyncSetConnectionTimeFormat(useUTC)); err != nil {
+		return err
+	}
+
+	k.stateLock.Lock()
+	k.utcTimes = useUTC
+	k.stateLock.Unlock()
+
+	return nil
+}
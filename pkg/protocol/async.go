@@ -0,0 +1,196 @@
+package protocol
+
+// Asynchronous messages, sent by the server without a corresponding
+// request. The server only sends the messages the client has asked
+// for with accept-async (#80).
+
+import (
+	"io"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Asynchronous message numbers
+const (
+	AsyncLoginNo       = uint32(9)
+	AsyncSendMessageNo = uint32(12)
+	AsyncLogoutNo      = uint32(13)
+	AsyncNewTextNo     = uint32(15)
+)
+
+// The number of messages buffered for each subscriber, before we
+// start dropping messages for it.
+const subscriberBuffer = 64
+
+// An AsyncMessage is a decoded asynchronous message.
+type AsyncMessage interface {
+	MessageNo() uint32
+}
+
+// The async-login message (#9), a person has logged in.
+type AsyncLogin struct {
+	Person  types.ConfNo
+	Session types.SessionNo
+}
+
+func (a AsyncLogin) MessageNo() uint32 {
+	return AsyncLoginNo
+}
+
+// The async-send-message message (#12), a message sent by another
+// session. Recipient is zero for alarm messages sent to everyone.
+type AsyncSendMessage struct {
+	Recipient types.ConfNo
+	Sender    types.ConfNo
+	Message   string
+}
+
+func (a AsyncSendMessage) MessageNo() uint32 {
+	return AsyncSendMessageNo
+}
+
+// The async-logout message (#13), a person has logged out.
+type AsyncLogout struct {
+	Person  types.ConfNo
+	Session types.SessionNo
+}
+
+func (a AsyncLogout) MessageNo() uint32 {
+	return AsyncLogoutNo
+}
+
+// The async-new-text message (#15), a text has been created that the
+// logged-in person may read.
+type AsyncNewText struct {
+	Text types.TextNo
+	Stat types.TextStat
+}
+
+func (a AsyncNewText) MessageNo() uint32 {
+	return AsyncNewTextNo
+}
+
+// Read an asynchronous message, the leading colon has already been
+// consumed. Messages we do not know how to decode are skipped.
+func (k *KomClient) readAsyncMessage(r io.Reader) {
+	var msg AsyncMessage
+	var err error
+
+	_ = readUInt32(r) // number of parameters
+	msgNo := readUInt32(r)
+
+	switch msgNo {
+	case AsyncLoginNo:
+		var m AsyncLogin
+		m.Person = types.ConfNo(readUInt32(r))
+		m.Session = types.SessionNo(readUInt32(r))
+		msg = m
+	case AsyncSendMessageNo:
+		var m AsyncSendMessage
+		m.Recipient = types.ConfNo(readUInt32(r))
+		m.Sender = types.ConfNo(readUInt32(r))
+		m.Message, err = readString(r)
+		msg = m
+	case AsyncLogoutNo:
+		var m AsyncLogout
+		m.Person = types.ConfNo(readUInt32(r))
+		m.Session = types.SessionNo(readUInt32(r))
+		msg = m
+	case AsyncNewTextNo:
+		var m AsyncNewText
+		m.Text = types.TextNo(readUInt32(r))
+		m.Stat, err = readTextStat(r)
+		msg = m
+	default:
+		log.WithFields(log.Fields{
+			"message": msgNo,
+		}).Debug("readAsyncMessage - skipping unknown message")
+		skipMessage(r)
+		return
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"message": msgNo,
+		}).Error("readAsyncMessage - decoding message")
+		skipMessage(r)
+		return
+	}
+
+	k.deliver(msg)
+}
+
+// Pass an asynchronous message on to all subscribers, never blocking
+// the receive loop.
+func (k *KomClient) deliver(msg AsyncMessage) {
+	k.subLock.Lock()
+	defer k.subLock.Unlock()
+
+	for id, c := range k.subscribers {
+		select {
+		case c <- msg:
+		default:
+			log.WithFields(log.Fields{
+				"subscriber": id,
+				"message":    msg.MessageNo(),
+			}).Warning("deliver - subscriber not keeping up, dropping message")
+		}
+	}
+}
+
+// Subscribe to the asynchronous messages from the server. All
+// messages are delivered on the returned channel until the returned
+// function is called, or the connection is lost, after which the
+// channel is closed. A subscriber that does not keep up will miss
+// messages.
+func (k *KomClient) Subscribe() (<-chan AsyncMessage, func()) {
+	k.subLock.Lock()
+	defer k.subLock.Unlock()
+
+	c := make(chan AsyncMessage, subscriberBuffer)
+	if k.Err() != nil {
+		close(c)
+		return c, func() {}
+	}
+	if k.subscribers == nil {
+		k.subscribers = make(map[int]chan AsyncMessage)
+	}
+	id := k.nextSub
+	k.nextSub++
+	k.subscribers[id] = c
+
+	cancel := func() {
+		k.subLock.Lock()
+		defer k.subLock.Unlock()
+		if _, ok := k.subscribers[id]; ok {
+			delete(k.subscribers, id)
+			close(c)
+		}
+	}
+
+	return c, cancel
+}
+
+// Ask the server to send the given asynchronous messages, in addition
+// to the ones previously accepted (accept-async, #80).
+func (k *KomClient) AcceptAsync(msgs ...uint32) error {
+	k.stateLock.Lock()
+	if k.accepted == nil {
+		k.accepted = make(map[uint32]bool)
+	}
+	for _, m := range msgs {
+		k.accepted[m] = true
+	}
+	var all []uint32
+	for m := range k.accepted {
+		all = append(all, m)
+	}
+	k.stateLock.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return waitGeneric(k.asyncAcceptAsync(all))
+}
@@ -3,9 +3,12 @@ package protocol
 // Protocol implementation for the KomAndGo client
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os/user"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,7 +16,9 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/vatine/komandgo/pkg/charset"
 	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/trace"
 	"github.com/vatine/komandgo/pkg/types"
 	"github.com/vatine/komandgo/pkg/utils"
 )
@@ -30,35 +35,152 @@ type Callback interface {
 }
 
 // The mapLock serves a dual purpose, it locks the nextRequest counter
-// and it synchronises access to the asyncMap
+// and it synchronises access to the asyncMap, and to lost and
+// lostErr, which record that the connection is gone. The stateLock protects
+// the session state we track on the client side, and the subLock the
+// subscribers to asynchronous messages. The sendLock makes sure
+// requests sent from different goroutines are not interleaved.
 type KomClient struct {
 	mapLock     sync.Mutex
+	sendLock    sync.Mutex
 	socket      io.ReadWriter
 	asyncMap    map[uint32]Callback
 	nextRequest uint32
 	server      *KomServer
 	shutdown    chan struct{}
+	lost        chan struct{}
+	lostErr     error
+
+	stateLock sync.Mutex
+	person    types.ConfNo
+	accepted  map[uint32]bool
+	utcTimes  bool
+
+	subLock     sync.Mutex
+	subscribers map[int]chan AsyncMessage
+	nextSub     int
+
+	nameLock sync.Mutex
+	names    map[types.ConfNo]confName
+}
+
+// A name looked up by ConfName.
+type confName struct {
+	name string
+	err  error
 }
 
+// Connect to a LysKOM server, given as "host:port".
 func NewKomClient(name string) (*KomClient, error) {
 	server, err := GetServer(name)
 	if err != nil {
 		return nil, err
 	}
-	return internalNewClient(name, server)
+	return internalNewClient(name, server, nil)
 }
 
-func internalNewClient(name string, server *KomServer) (*KomClient, error) {
-	rv := KomClient{
-		asyncMap: make(map[uint32]Callback),
-		server:   server,
+// Connect to a LysKOM server, given as "host:port", recording all
+// bytes sent and received to w. See the trace package for the format.
+func NewKomClientTrace(name string, w io.Writer) (*KomClient, error) {
+	server, err := GetServer(name)
+	if err != nil {
+		return nil, err
 	}
+	return internalNewClient(name, server, w)
+}
+
+func internalNewClient(name string, server *KomServer, w io.Writer) (*KomClient, error) {
 	s, err := net.Dial("tcp", name)
 	if err != nil {
 		return nil, err
 	}
-	rv.socket = s
-	return &rv, nil
+	var conn io.ReadWriteCloser = s
+	if w != nil {
+		conn = trace.NewTap(s, trace.NewWriter(w))
+	}
+	rv, err := startClient(conn, server)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return rv, nil
+}
+
+// Create a client talking to a server over an already established
+// connection, such as one end of a net.Pipe. The handshake is done
+// before returning. Server-wide information is not shared with other
+// clients.
+func NewKomClientConn(conn io.ReadWriter) (*KomClient, error) {
+	return startClient(conn, newKomServer())
+}
+
+// Do the handshake on a connection and start the receive loop.
+func startClient(conn io.ReadWriter, server *KomServer) (*KomClient, error) {
+	rv := &KomClient{
+		socket:   conn,
+		asyncMap: make(map[uint32]Callback),
+		server:   server,
+		shutdown: make(chan struct{}),
+	}
+	if err := rv.handshake(clientIdentity()); err != nil {
+		return nil, err
+	}
+	go rv.receiveLoop()
+	return rv, nil
+}
+
+// Return the user name to send in the handshake.
+func clientIdentity() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "komandgo"
+}
+
+// Send the connection header, "A<user>" with the user as a Hollerith
+// string, and wait for the server to respond with "LysKOM". The
+// response is read a byte at a time, so that nothing after it is
+// consumed before the receive loop starts.
+func (k *KomClient) handshake(user string) error {
+	if err := k.send("A" + hollerith.Sprint(user)); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for {
+		c, err := utils.ReadByte(k.socket)
+		if err != nil {
+			return err
+		}
+		if c == '\n' {
+			break
+		}
+		b.WriteByte(c)
+	}
+
+	if reply := strings.TrimSpace(b.String()); reply != "LysKOM" {
+		return fmt.Errorf("Unexpected handshake response %q", reply)
+	}
+	return nil
+}
+
+// Close the connection to the server, stopping the receive loop and
+// failing any outstanding requests.
+func (k *KomClient) Close() error {
+	k.stateLock.Lock()
+	select {
+	case <-k.shutdown:
+	default:
+		close(k.shutdown)
+	}
+	k.stateLock.Unlock()
+
+	var err error
+	if c, ok := k.socket.(io.Closer); ok {
+		err = c.Close()
+	}
+	k.connectionLost(net.ErrClosed)
+	return err
 }
 
 // Skips to the next linefeed character in the stream
@@ -103,8 +225,6 @@ func readDelimitedList(start, end byte, r io.Reader) (string, error) {
 			return string(rv), nil
 		}
 	}
-
-	return "", nil
 }
 
 // The generic "success is empty, failure is complicated" response
@@ -120,42 +240,30 @@ func (c genericCallback) OK(r io.Reader) {
 	go func() { c <- genericResponse{0, 0, nil}; close(c) }()
 }
 
-// Read error code and status from a reader, return them in that order.
-// If an error occurs durung reating, terurn zeroes and the error.
+// Read error code and status from a reader, return them in that
+// order. The request ID has already been consumed by the receive
+// loop.
 func readError(r io.Reader) (uint32, uint32, error) {
-	var errorCode, errorStatus, reqID uint32
-
-	n, err := fmt.Fscanf(r, "%d %d", &reqID, &errorCode, &errorStatus)
-	skipToNewline(r)
-	if err != nil || n != 2 {
-		log.WithFields(log.Fields{
-			"n":     n,
-			"error": err,
-		}).Errorf("Generic Callback, fscanf error.")
-		return 0, 0, err
+	if lc, ok := r.(lostConnection); ok {
+		return 0, 0, lc.err
 	}
+	errorCode := readUInt32(r)
+	errorStatus := readUInt32(r)
 
 	return errorCode, errorStatus, nil
 }
 
 func (c genericCallback) Error(r io.Reader) {
-	var errorCode, errorStatus, reqID uint32
+	code, status, err := readError(r)
 
-	n, err := fmt.Fscanf(r, "%d %d", &reqID, &errorCode, &errorStatus)
-	skipToNewline(r)
-	if err != nil || n != 2 {
-		log.WithFields(log.Fields{
-			"n":     n,
-			"error": err,
-		}).Errorf("Generic Callback, fscanf error.")
-		go func() { c <- genericResponse{0, 0, err}; close(c) }()
-		return
+	if err == nil {
+		err = protocolError(code, status)
 	}
 
 	resp := genericResponse{
-		code:   errorCode,
-		status: errorStatus,
-		err:    protocolError(errorCode, errorStatus),
+		code:   code,
+		status: status,
+		err:    err,
 	}
 	go func() { c <- resp; close(c) }()
 }
@@ -169,87 +277,46 @@ type getMarksResponse struct {
 type getMarksCallback chan getMarksResponse
 
 func (g getMarksCallback) OK(r io.Reader) {
-	marks := readUInt32(r)
-	marksArr := make([]types.Mark, marks)
-
-	ar, err := readDelimitedList('{', '}', r)
-	skipToNewline(r)
+	var rv getMarksResponse
+
+	n, present, err := readArrayStart(r)
+	if err == nil && present {
+		for ix := uint32(0); ix < n && err == nil; ix++ {
+			var mark types.Mark
+			var text, markType uint32
+			text, err = scanUInt32(r)
+			if err == nil {
+				markType, err = scanUInt32(r)
+			}
+			if err == nil {
+				mark.TextNo = types.TextNo(text)
+				mark.Type = byte(markType)
+				rv.marks = append(rv.marks, mark)
+			}
+		}
+		if err == nil {
+			err = readArrayEnd(r)
+		}
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"marks": marks,
-		}).Error("Failed to read delimited list")
-		go func() { g <- getMarksResponse{err: err}; close(g) }()
-		return
-	}
-
-	tmp := strings.Split(ar, " ")
-	log.WithFields(log.Fields{
-		"marks": marks,
-		"array": ar,
-		"tmp":   tmp,
-	}).Debug("get marks")
-
-	rv := getMarksResponse{}
-
-	strPos := 0
-	if tmp[strPos] == "{" {
-		strPos++
+			"marks": n,
+		}).Error("getMarksCallback.OK() - reading marks")
+		rv.err = err
 	}
 
-	for ix := 0; ix < int(marks); ix++ {
-		n, err := strconv.Atoi(tmp[strPos])
-		if err != nil {
-			log.WithFields(log.Fields{
-				"ix":          ix,
-				"strPos":      strPos,
-				"tmp[strPos]": tmp[strPos],
-			}).Error("parsing textNo")
-			rv.marks = marksArr[0:ix]
-			rv.err = err
-			go func() { g <- rv; close(g) }()
-			return
-		}
-		strPos++
-		marksArr[ix].TextNo = types.TextNo(n)
-
-		n, err = strconv.Atoi(tmp[strPos])
-		if err != nil {
-			log.WithFields(log.Fields{
-				"ix":          ix,
-				"strPos":      strPos,
-				"tmp[strPos]": tmp[strPos],
-			}).Error("parsing textNo")
-			rv.marks = marksArr[0:ix]
-			rv.err = err
-			go func() { g <- rv; close(g) }()
-			return
-		}
-		strPos++
-		marksArr[ix].Type = byte(n)
-	}
-	rv.marks = marksArr
 	go func() { g <- rv; close(g) }()
 }
 
 func (g getMarksCallback) Error(r io.Reader) {
-	var errorCode, errorStatus, reqID uint32
+	code, status, err := readError(r)
 
-	n, err := fmt.Fscanf(r, "%d %d", &reqID, &errorCode, &errorStatus)
-	skipToNewline(r)
-	if err != nil || n != 2 {
-		log.WithFields(log.Fields{
-			"n":     n,
-			"error": err,
-		}).Errorf("Generic Callback, fscanf error.")
-		go func() { g <- getMarksResponse{err: err}; close(g) }()
-		return
+	if err == nil {
+		err = protocolError(code, status)
 	}
 
-	resp := getMarksResponse{
-		err: fmt.Errorf("Generic error, code %d, status %d", errorCode, errorStatus),
-	}
-	go func() { g <- resp; close(g) }()
+	go func() { g <- getMarksResponse{err: err}; close(g) }()
 }
 
 // The get-text reponse structure
@@ -288,46 +355,38 @@ type zConfArrayResponse struct {
 type zConfArrayResponseCallback chan zConfArrayResponse
 
 func (zca zConfArrayResponseCallback) OK(r io.Reader) {
-	items := readUInt32(r)
-
-	var rv []types.ConfZInfo
-	ar, err := readDelimitedList('{', '}', r)
-
-	// We should now have a string representation of the entire
-	// array contents in ar, so we can just start working from
-	// there.
-	offset := 0
-	for item := uint32(0); item < items; item++ {
-		log.WithFields(log.Fields{
-			"looking-at": ar[offset : offset+10],
-			"offset":     offset,
-		}).Debug("loop start")
-		name, next := hollerith.FromString(ar, offset)
-		log.WithFields(log.Fields{
-			"looking-at": ar[next : next+6],
-			"offset":     next,
-		}).Debug("parsing conf type")
-		confType := utils.ParseConfType(ar, next+1)
-		next += 5
+	var rv zConfArrayResponse
+
+	n, present, err := readArrayStart(r)
+	if err == nil && present {
+		for ix := uint32(0); ix < n && err == nil; ix++ {
+			var conf types.ConfZInfo
+			var confNo uint32
+			conf.Name, err = readString(r)
+			if err == nil {
+				conf.Type, err = readConfType(r)
+			}
+			if err == nil {
+				confNo, err = scanUInt32(r)
+				conf.No = types.ConfNo(confNo)
+			}
+			if err == nil {
+				rv.confs = append(rv.confs, conf)
+			}
+		}
+		if err == nil {
+			err = readArrayEnd(r)
+		}
+	}
+	if err != nil {
 		log.WithFields(log.Fields{
-			"looking-at": ar[next : next+2],
-			"offset":     next,
-		}).Debug("parsing conf no")
-		tmp, next := utils.ReadUInt32FromString(ar, next)
-		confNo := types.ConfNo(tmp)
-		conf := types.ConfZInfo{Name: name, Type: confType, No: confNo}
-		//log.WithFields(log.Fields{
-		//	"next":     next,
-		//	"confNo":   confNo,
-		//	"confType": confType,
-		//	"name":     name,
-		//	"item":     item,
-		//}).Debug("Reading zConfArray")
-		offset = next
-		rv = append(rv, conf)
-	}
-
-	go func() { zca <- zConfArrayResponse{confs: rv, err: err} }()
+			"error": err,
+			"confs": n,
+		}).Error("zConfArrayResponseCallback.OK() - reading conferences")
+		rv.err = err
+	}
+
+	go func() { zca <- rv; close(zca) }()
 }
 
 func (zca zConfArrayResponseCallback) Error(r io.Reader) {
@@ -391,21 +450,27 @@ func (vi versionInfoResponseCallback) Error(r io.Reader) {
 type timeResponseCallback chan time.Time
 
 func (t timeResponseCallback) Error(r io.Reader) {
-	// this should never fail
+	// This only fails if the connection is lost, which the caller
+	// sees as the channel being closed.
+	go func() { close(t) }()
 }
 
+// Read a time, in the format the connection currently uses.
 func readTime(r io.Reader) time.Time {
-	sec := int(readUInt32(r))
-	min := int(readUInt32(r))
-	hour := int(readUInt32(r))
-	mday := int(readUInt32(r))
-	mon := int(readUInt32(r))
-	year := int(readUInt32(r))
-	_ = int(readUInt32(r))
-	_ = int(readUInt32(r))
-	_ = int(readUInt32(r))
+	codec := types.TimeCodec{}
+	if kr, ok := r.(*komReader); ok {
+		codec = kr.times
+	}
+
+	rv, err := codec.Decode(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"time":  rv,
+		}).Error("readTime - decoding time")
+	}
 
-	return time.Date(1900+year, time.Month(mon), mday, hour, min, sec, 0, time.UTC)
+	return rv
 }
 
 func (t timeResponseCallback) OK(r io.Reader) {
@@ -424,11 +489,11 @@ func (ps personStatCallback) OK(r io.Reader) {
 	var person types.Person
 	var err error
 
-	_, err = hollerith.Scan(r)
+	person.Username, err = readString(r)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-		}).Warning("personStatCallback.OK() - scanning last login failed.")
+		}).Warning("personStatCallback.OK() - scanning username failed.")
 	}
 	person.Privileges = types.ReadPrivBits(r)
 	person.Flags = types.ReadPersonalFlags(r)
@@ -447,7 +512,7 @@ func (ps personStatCallback) OK(r io.Reader) {
 	person.Marks = readUInt16(r)
 	person.Conferences = readUInt16(r)
 
-	go func() { ps <- personStat{person: person}; close(ps) }()
+	go func() { ps <- personStat{person: person, err: err}; close(ps) }()
 }
 
 func (ps personStatCallback) Error(r io.Reader) {
@@ -468,52 +533,29 @@ type unreadConfs struct {
 type unreadConfsCallback chan unreadConfs
 
 func (uc unreadConfsCallback) OK(r io.Reader) {
-	confs := readUInt32(r)
-	confArr := make([]types.ConfNo, confs)
-
-	ar, err := readDelimitedList('{', '}', r)
-	skipToNewline(r)
+	var rv unreadConfs
+
+	n, present, err := readArrayStart(r)
+	if err == nil && present {
+		for ix := uint32(0); ix < n && err == nil; ix++ {
+			var conf uint32
+			conf, err = scanUInt32(r)
+			if err == nil {
+				rv.unread = append(rv.unread, types.ConfNo(conf))
+			}
+		}
+		if err == nil {
+			err = readArrayEnd(r)
+		}
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
-			"confs": confs,
-		}).Error("ureadConfsCallback.OK() - failed reading array")
-		go func() { uc <- unreadConfs{err: err}; close(uc) }()
-		return
-	}
-
-	tmp := strings.Split(ar, " ")
-	log.WithFields(log.Fields{
-		"confs": confs,
-		"array": ar,
-		"tmp":   tmp,
-	}).Debug("unreadConfsCallback.OK() - array read")
-
-	rv := unreadConfs{}
-
-	strPos := 0
-	for tmp[strPos] == "{" {
-		strPos++
-	}
-
-	for ix := 0; ix < int(confs); ix++ {
-		n, err := strconv.Atoi(tmp[strPos])
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error":       err,
-				"ix":          ix,
-				"strPos":      strPos,
-				"tmp[strPos]": tmp[strPos],
-			}).Error("unreadConfsCallback.OK() - parsing conf no")
-			rv.unread = confArr[0:ix]
-			rv.err = err
-			go func() { uc <- rv; close(uc) }()
-		}
-		strPos++
-		confArr[ix] = types.ConfNo(n)
+			"confs": n,
+		}).Error("unreadConfsCallback.OK() - reading conferences")
+		rv.err = err
 	}
 
-	rv.unread = confArr
 	go func() { uc <- rv; close(uc) }()
 }
 
@@ -571,6 +613,27 @@ func (lt textResponseCallback) Error(r io.Reader) {
 	go func() { lt <- textResponse{err: err}; close(lt) }()
 }
 
+type confResponse struct {
+	conf types.ConfNo
+	err  error
+}
+type confResponseCallback chan confResponse
+
+func (cr confResponseCallback) OK(r io.Reader) {
+	confNo := types.ConfNo(readUInt32(r))
+	go func() { cr <- confResponse{conf: confNo}; close(cr) }()
+}
+
+func (cr confResponseCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { cr <- confResponse{err: err}; close(cr) }()
+}
+
 type stringResponse struct {
 	str string
 	err error
@@ -578,7 +641,7 @@ type stringResponse struct {
 type stringResponseCallback chan stringResponse
 
 func (s stringResponseCallback) OK(r io.Reader) {
-	str, err := hollerith.Scan(r)
+	str, err := readString(r)
 	go func() { s <- stringResponse{str: str, err: err}; close(s) }()
 }
 
@@ -602,7 +665,7 @@ func (uc uConfResponseCallback) OK(r io.Reader) {
 	var ucon types.UConference
 	var resp uConfResponse
 
-	ucon.Name, resp.err = hollerith.Scan(r)
+	ucon.Name, resp.err = readString(r)
 	ucon.Type = types.ReadExtendedConfType(r)
 	utils.ReadByte(r)
 	ucon.HighestLocalNo = types.TextNo(readUInt32(r))
@@ -622,6 +685,177 @@ func (uc uConfResponseCallback) Error(r io.Reader) {
 	go func() { uc <- uConfResponse{err: err}; close(uc) }()
 }
 
+type confStatResponse struct {
+	conf types.Conference
+	err  error
+}
+type confStatCallback chan confStatResponse
+
+func (cc confStatCallback) OK(r io.Reader) {
+	var resp confStatResponse
+	c := &resp.conf
+
+	c.Name, resp.err = readString(r)
+	if resp.err == nil {
+		c.Type = types.ReadExtendedConfType(r)
+		utils.ReadByte(r)
+		c.CreationTime = readTime(r)
+		c.LastWritten = readTime(r)
+		c.Creator = types.ConfNo(readUInt32(r))
+		c.Presentation = types.TextNo(readUInt32(r))
+		c.Supervisor = types.ConfNo(readUInt32(r))
+		c.PermittedSubmitters = types.ConfNo(readUInt32(r))
+		c.SuperConf = types.ConfNo(readUInt32(r))
+		c.MsgOfDay = types.TextNo(readUInt32(r))
+		c.Nice = readUInt32(r)
+		c.KeepCommented = readUInt32(r)
+		c.NoOfMembers = readUInt32(r)
+		c.FirstLocalNo = types.TextNo(readUInt32(r))
+		c.NoOfTexts = readUInt32(r)
+		c.Expire = readUInt32(r)
+		c.AuxItems, resp.err = readAuxItemList(r)
+	}
+
+	go func() { cc <- resp; close(cc) }()
+}
+
+func (cc confStatCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { cc <- confStatResponse{err: err}; close(cc) }()
+}
+
+type textStatResponse struct {
+	stat types.TextStat
+	err  error
+}
+type textStatCallback chan textStatResponse
+
+// Read a Text-Stat, as sent by get-text-stat (#90) and in
+// async-new-text (#15).
+func readTextStat(r io.Reader) (types.TextStat, error) {
+	var rv types.TextStat
+	var err error
+
+	rv.CreationTime = readTime(r)
+	rv.Author = types.ConfNo(readUInt32(r))
+	rv.Lines = readUInt32(r)
+	rv.Chars = readUInt32(r)
+	rv.Marks = readUInt16(r)
+	rv.MiscInfo, err = readMiscInfoList(r)
+	if err == nil {
+		rv.AuxItems, err = readAuxItemList(r)
+	}
+
+	return rv, err
+}
+
+func (ts textStatCallback) OK(r io.Reader) {
+	var resp textStatResponse
+
+	resp.stat, resp.err = readTextStat(r)
+
+	go func() { ts <- resp; close(ts) }()
+}
+
+func (ts textStatCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { ts <- textStatResponse{err: err}; close(ts) }()
+}
+
+type membershipResponse struct {
+	memberships []types.Membership
+	err         error
+}
+
+// Callback for calls returning a single membership
+type membershipCallback chan membershipResponse
+
+func (mc membershipCallback) OK(r io.Reader) {
+	var resp membershipResponse
+
+	m, err := readMembership(r)
+	resp.memberships = []types.Membership{m}
+	resp.err = err
+
+	go func() { mc <- resp; close(mc) }()
+}
+
+func (mc membershipCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { mc <- membershipResponse{err: err}; close(mc) }()
+}
+
+// Callback for calls returning an array of memberships
+type membershipListCallback chan membershipResponse
+
+func (mc membershipListCallback) OK(r io.Reader) {
+	var resp membershipResponse
+
+	n, present, err := readArrayStart(r)
+	if err == nil && present {
+		for ix := uint32(0); ix < n && err == nil; ix++ {
+			var m types.Membership
+			m, err = readMembership(r)
+			resp.memberships = append(resp.memberships, m)
+		}
+		if err == nil {
+			err = readArrayEnd(r)
+		}
+	}
+	resp.err = err
+
+	go func() { mc <- resp; close(mc) }()
+}
+
+func (mc membershipListCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { mc <- membershipResponse{err: err}; close(mc) }()
+}
+
+type textMappingResponse struct {
+	mapping types.TextMapping
+	err     error
+}
+type textMappingCallback chan textMappingResponse
+
+func (tm textMappingCallback) OK(r io.Reader) {
+	var resp textMappingResponse
+
+	resp.mapping, resp.err = readTextMapping(r)
+
+	go func() { tm <- resp; close(tm) }()
+}
+
+func (tm textMappingCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { tm <- textMappingResponse{err: err}; close(tm) }()
+}
+
 type queryAsyncResponse struct {
 	messages []uint32
 	err      error
@@ -636,13 +870,13 @@ func (qac queryAsyncCallback) OK(r io.Reader) {
 			qac <- queryAsyncResponse{err: err}
 			close(qac)
 		}()
+		return
 	}
 
 	go func() {
 		qac <- queryAsyncResponse{messages: acceptedCalls}
 		close(qac)
 	}()
-
 }
 
 func (qac queryAsyncCallback) Error(r io.Reader) {
@@ -655,47 +889,301 @@ func (qac queryAsyncCallback) Error(r io.Reader) {
 	go func() { qac <- queryAsyncResponse{err: err}; close(qac) }()
 }
 
-// Read an uint32 from the client socket, also consume the first
-// whitespace after the number.
-func readUInt32(r io.Reader) uint32 {
-	var done bool
-	var rv uint32
+type statsDescriptionResponse struct {
+	description types.StatsDescription
+	err         error
+}
 
-	for !done {
-		b, err := utils.ReadByte(r)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"b":     b,
-				"rv":    rv,
-			}).Error("read id")
-			return rv
+type statsDescriptionCallback chan statsDescriptionResponse
+
+func (sc statsDescriptionCallback) OK(r io.Reader) {
+	var rv statsDescriptionResponse
+
+	n, present, err := readArrayStart(r)
+	if err == nil && present {
+		for ix := uint32(0); ix < n && err == nil; ix++ {
+			var what string
+			what, err = hollerith.Scan(r)
+			rv.description.What = append(rv.description.What, what)
 		}
+		if err == nil {
+			err = readArrayEnd(r)
+		}
+	}
+	if err == nil {
+		var when []uint32
+		when, err = types.ReadUInt32Array(r)
+		rv.description.When = when
+	}
+	if err != nil {
 		log.WithFields(log.Fields{
-			"0": '0',
-			"9": '9',
-			"b": b,
-		}).Debug("read id")
+			"error": err,
+		}).Error("statsDescriptionCallback.OK() - reading description")
+		rv.err = err
+	}
 
-		switch {
-		case (b >= '0') && (b <= '9'):
-			rv = (10 * rv) + uint32(b-'0')
-		default:
-			done = true
+	go func() { sc <- rv; close(sc) }()
+}
+
+func (sc statsDescriptionCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { sc <- statsDescriptionResponse{err: err}; close(sc) }()
+}
+
+type statsResponse struct {
+	stats []types.Stats
+	err   error
+}
+
+type statsCallback chan statsResponse
+
+func (sc statsCallback) OK(r io.Reader) {
+	var rv statsResponse
+
+	n, present, err := readArrayStart(r)
+	if err == nil && present {
+		for ix := uint32(0); ix < n && err == nil; ix++ {
+			var s types.Stats
+			s.Average, err = readFloat(r)
+			if err == nil {
+				s.AscentRate, err = readFloat(r)
+			}
+			if err == nil {
+				s.DescentRate, err = readFloat(r)
+			}
+			rv.stats = append(rv.stats, s)
 		}
+		if err == nil {
+			err = readArrayEnd(r)
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("statsCallback.OK() - reading stats")
+		rv.err = err
 	}
 
-	return rv
+	go func() { sc <- rv; close(sc) }()
+}
+
+func (sc statsCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { sc <- statsResponse{err: err}; close(sc) }()
+}
+
+type schedulingResponse struct {
+	info types.SchedulingInfo
+	err  error
+}
+
+type schedulingCallback chan schedulingResponse
+
+func (sc schedulingCallback) OK(r io.Reader) {
+	var rv schedulingResponse
+
+	rv.info.Priority = readUInt16(r)
+	rv.info.Weight = readUInt16(r)
+
+	go func() { sc <- rv; close(sc) }()
+}
+
+func (sc schedulingCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { sc <- schedulingResponse{err: err}; close(sc) }()
+}
+
+type dynamicSessionsResponse struct {
+	sessions []types.DynamicSessionInfo
+	err      error
+}
+
+type dynamicSessionsCallback chan dynamicSessionsResponse
+
+func (dc dynamicSessionsCallback) OK(r io.Reader) {
+	var rv dynamicSessionsResponse
+
+	n, present, err := readArrayStart(r)
+	if err == nil && present {
+		for ix := uint32(0); ix < n && err == nil; ix++ {
+			var s types.DynamicSessionInfo
+			var session uint32
+			session, err = scanUInt32(r)
+			if err != nil {
+				break
+			}
+			s.Session = types.SessionNo(session)
+			s.Peron = types.ConfNo(readUInt32(r))
+			s.WorkingConference = types.ConfNo(readUInt32(r))
+			s.IdleTime = readUInt32(r)
+			s.Flags = types.ReadSessionFlags(r)
+			s.WhatAmIDoing, err = readString(r)
+			rv.sessions = append(rv.sessions, s)
+		}
+		if err == nil {
+			err = readArrayEnd(r)
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("dynamicSessionsCallback.OK() - reading sessions")
+		rv.err = err
+	}
+
+	go func() { dc <- rv; close(dc) }()
+}
+
+func (dc dynamicSessionsCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { dc <- dynamicSessionsResponse{err: err}; close(dc) }()
+}
+
+// Skip any spaces and newlines in the stream, returning the first
+// byte that is neither.
+func skipWhitespace(r io.Reader) (byte, error) {
+	for {
+		b, err := utils.ReadByte(r)
+		if err != nil || (b != ' ' && b != '\n') {
+			return b, err
+		}
+	}
+}
+
+type infoResponse struct {
+	info types.Info
+	err  error
+}
+
+type infoResponseCallback chan infoResponse
+
+func (ic infoResponseCallback) OK(r io.Reader) {
+	var rv infoResponse
+
+	rv.info.Version = readUInt32(r)
+	rv.info.ConferencePresentationConference = types.ConfNo(readUInt32(r))
+	rv.info.PersonPresentationConference = types.ConfNo(readUInt32(r))
+	rv.info.MOTDConference = types.ConfNo(readUInt32(r))
+	rv.info.KomNewsConference = types.ConfNo(readUInt32(r))
+	rv.info.MOTDOfLyskom = types.TextNo(readUInt32(r))
+	rv.info.AuxItems, rv.err = readAuxItemList(r)
+	if rv.err != nil {
+		log.WithFields(log.Fields{
+			"error": rv.err,
+		}).Error("infoResponseCallback.OK() - reading aux items")
+	}
+
+	go func() { ic <- rv; close(ic) }()
+}
+
+func (ic infoResponseCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { ic <- infoResponse{err: err}; close(ic) }()
+}
+
+type staticServerInfoResponse struct {
+	info types.StaticServerInfo
+	err  error
+}
+
+type staticServerInfoCallback chan staticServerInfoResponse
+
+func (sc staticServerInfoCallback) OK(r io.Reader) {
+	var rv staticServerInfoResponse
+
+	rv.info.BootTime = readTime(r)
+	rv.info.SaveTime = readTime(r)
+	rv.info.DBStatus, rv.err = hollerith.Scan(r)
+	rv.info.ExistingTexts = readUInt32(r)
+	rv.info.HighestTextNo = types.TextNo(readUInt32(r))
+	rv.info.ExistingConfs = readUInt32(r)
+	rv.info.ExistingPersons = readUInt32(r)
+	rv.info.HighestConfNo = types.ConfNo(readUInt32(r))
+
+	go func() { sc <- rv; close(sc) }()
+}
+
+func (sc staticServerInfoCallback) Error(r io.Reader) {
+	code, status, err := readError(r)
+
+	if err == nil {
+		err = protocolError(code, status)
+	}
+
+	go func() { sc <- staticServerInfoResponse{err: err}; close(sc) }()
 }
 
 // Read an uint32 from the client socket, also consume the first
-// whitespace after the number.
+// whitespace after the number. Leading whitespace is skipped.
+func readUInt32(r io.Reader) uint32 {
+	rv, err := scanUInt32(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"rv":    rv,
+		}).Error("read id")
+	}
+
+	return rv
+}
+
+// Read an uint32 like readUInt32, returning an error if the stream
+// fails or there is no number. Array decoders use this, so that they
+// stop at the end of malformed input instead of looping on it.
+func scanUInt32(r io.Reader) (uint32, error) {
+	var rv uint32
+
+	b, err := skipWhitespace(r)
+	if err != nil {
+		return rv, err
+	}
+	if b < '0' || b > '9' {
+		return rv, fmt.Errorf("Expected a number, saw '%c'", b)
+	}
+	for b >= '0' && b <= '9' {
+		rv = (10 * rv) + uint32(b-'0')
+		b, err = utils.ReadByte(r)
+		if err != nil {
+			return rv, nil
+		}
+	}
+
+	return rv, nil
+}
+
+// Read an uint16 from the client socket, also consume the first
+// whitespace after the number. Leading whitespace is skipped.
 func readUInt16(r io.Reader) uint16 {
 	var done bool
 	var rv uint16
 
+	b, err := skipWhitespace(r)
 	for !done {
-		b, err := utils.ReadByte(r)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
@@ -713,6 +1201,7 @@ func readUInt16(r io.Reader) uint16 {
 		switch {
 		case (b >= '0') && (b <= '9'):
 			rv = (10 * rv) + uint16(b-'0')
+			b, err = utils.ReadByte(r)
 		default:
 			done = true
 		}
@@ -721,6 +1210,23 @@ func readUInt16(r io.Reader) uint16 {
 	return rv
 }
 
+// Read a FLOAT from the client socket, also consume the first
+// whitespace after the number. Leading whitespace is skipped.
+func readFloat(r io.Reader) (float64, error) {
+	var buf []byte
+
+	b, err := skipWhitespace(r)
+	for err == nil && strings.IndexByte("0123456789.eE+-", b) >= 0 {
+		buf = append(buf, b)
+		b, err = utils.ReadByte(r)
+	}
+	if len(buf) == 0 && err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(string(buf), 64)
+}
+
 // Register a callback and return the corresponding request ID
 func (k *KomClient) registerCallback(c Callback) uint32 {
 	k.mapLock.Lock()
@@ -730,74 +1236,341 @@ func (k *KomClient) registerCallback(c Callback) uint32 {
 	k.nextRequest++
 	k.asyncMap[reqID] = c
 
-	return reqID
+	return reqID
+}
+
+// Return the callback associated with a specific request and delete
+// the request from the mapping.
+func (k *KomClient) getCallback(id uint32) (Callback, error) {
+	k.mapLock.Lock()
+	defer k.mapLock.Unlock()
+
+	c, ok := k.asyncMap[id]
+	if !ok {
+		log.WithFields(log.Fields{
+			"reqID": id,
+		}).Error("non-existent request id")
+		return c, fmt.Errorf("Unknown request %d", id)
+	}
+	delete(k.asyncMap, id)
+	return c, nil
+
+}
+
+// Return the channel closed when the connection is lost, creating it
+// if needed. The mapLock must be held.
+func (k *KomClient) lostChan() chan struct{} {
+	if k.lost == nil {
+		k.lost = make(chan struct{})
+	}
+	return k.lost
+}
+
+// Return a channel that is closed when the connection to the server
+// is lost, or the client is closed.
+func (k *KomClient) Done() <-chan struct{} {
+	k.mapLock.Lock()
+	defer k.mapLock.Unlock()
+
+	return k.lostChan()
+}
+
+// Return why the connection to the server was lost, as a
+// ConnectionLostError, or nil if the client is still connected.
+func (k *KomClient) Err() error {
+	k.mapLock.Lock()
+	defer k.mapLock.Unlock()
+
+	return k.lostErr
+}
+
+// Mark the client as dead, failing all outstanding requests and
+// closing the channels of all subscribers.
+func (k *KomClient) connectionLost(cause error) {
+	k.mapLock.Lock()
+	if k.lostErr != nil {
+		k.mapLock.Unlock()
+		return
+	}
+	err := ConnectionLostError{Err: cause}
+	k.lostErr = err
+	pending := k.asyncMap
+	k.asyncMap = make(map[uint32]Callback)
+	lost := k.lostChan()
+	k.mapLock.Unlock()
+
+	for _, callback := range pending {
+		callback.Error(lostConnection{err: err})
+	}
+
+	k.subLock.Lock()
+	for id, c := range k.subscribers {
+		delete(k.subscribers, id)
+		close(c)
+	}
+	k.subLock.Unlock()
+
+	close(lost)
+}
+
+// The reader passed to the Error method of outstanding callbacks when
+// the connection is lost. Reading from it fails, and readError returns
+// the error.
+type lostConnection struct {
+	err error
+}
+
+func (l lostConnection) Read(b []byte) (int, error) {
+	return 0, l.err
+}
+
+// Send a protocol string to the server, handle any and all errors.
+func (k *KomClient) send(s string) error {
+	if err := k.Err(); err != nil {
+		return err
+	}
+
+	k.sendLock.Lock()
+	defer k.sendLock.Unlock()
+
+	b := []byte(s)
+	offset := 0
+	remains := len(b)
+	done := false
+
+	for !done {
+		sent, err := k.socket.Write(b[offset:])
+		if err != nil {
+			return err
+		}
+		if sent >= remains {
+			done = true
+		} else {
+			offset += sent
+			remains -= sent
+		}
+	}
+
+	k.socket.Write([]byte{10})
+
+	return nil
+}
+
+// Skip the rest of a message, up to and including the terminating
+// newline, without stopping at newlines inside Hollerith strings.
+func skipMessage(r io.Reader) error {
+	var n int
+	inNumber := false
+
+	for {
+		b, err := utils.ReadByte(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case b >= '0' && b <= '9':
+			n = n*10 + int(b-'0')
+			inNumber = true
+		case b == 'H' && inNumber:
+			for ; n > 0; n-- {
+				if _, err := utils.ReadByte(r); err != nil {
+					return err
+				}
+			}
+			inNumber = false
+		case b == '\n':
+			return nil
+		default:
+			n = 0
+			inNumber = false
+		}
+	}
+}
+
+// A komReader is what the receive loop hands to the callbacks, it
+// carries the settings of the connection that are needed to decode
+// the replies.
+type komReader struct {
+	*bufio.Reader
+	times   types.TimeCodec
+	charset string
+}
+
+// Return the time codec matching the current connection settings.
+// Times are in the server's time zone, unless we have asked the
+// server to use UTC.
+func (k *KomClient) timeCodec() types.TimeCodec {
+	k.stateLock.Lock()
+	utc := k.utcTimes
+	k.stateLock.Unlock()
+
+	if utc || k.server == nil {
+		return types.UTCTimes
+	}
+	return types.TimeCodec{Location: k.server.Location()}
+}
+
+// Return the character set the server uses for names and messages,
+// or the empty string if strings should be passed through unchanged.
+func (k *KomClient) serverCharset() string {
+	if k.server == nil {
+		return ""
+	}
+	return k.server.Charset()
+}
+
+// Convert a string from the connection's character set to UTF-8.
+// Strings read from anything but the receive loop are returned
+// unchanged.
+func decodeString(r io.Reader, s string) string {
+	kr, ok := r.(*komReader)
+	if !ok || kr.charset == "" {
+		return s
+	}
+
+	rv, err := charset.Decode(s, kr.charset)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"charset": kr.charset,
+		}).Warning("decodeString - converting string")
+	}
+	return rv
+}
+
+// Convert part of a text to UTF-8. Bytes that are not valid in the
+// character set become replacement characters, and are logged rather
+// than failing the text, as with strings in decodeString.
+func decodeText(text types.TextNo, s, cs string) string {
+	rv, err := charset.Decode(s, cs)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"text":    text,
+			"charset": cs,
+		}).Warning("decodeText - converting text")
+	}
+	return rv
+}
+
+// Read a Hollerith string, converting it to UTF-8.
+func readString(r io.Reader) (string, error) {
+	s, err := hollerith.Scan(r)
+	return decodeString(r, s), err
 }
 
-// Return the callback associated with a specific request and delete
-// the request from the mapping.
-func (k *KomClient) getCallback(id uint32) (Callback, error) {
-	k.mapLock.Lock()
-	defer k.mapLock.Unlock()
+// Convert a string to a given character set, logging (but otherwise
+// ignoring) characters that cannot be converted.
+func encodeString(s, cs string) string {
+	if cs == "" {
+		return s
+	}
 
-	c, ok := k.asyncMap[id]
-	if !ok {
+	rv, err := charset.Encode(s, cs)
+	if err != nil {
 		log.WithFields(log.Fields{
-			"reqID": id,
-		}).Error("non-existent request id")
-		return c, fmt.Errorf("Unknown request %d", id)
+			"error":   err,
+			"charset": cs,
+		}).Warning("encodeString - converting string")
 	}
-	delete(k.asyncMap, id)
-	return c, nil
+	return rv
+}
 
+// Return a string as a Hollerith string in the server's character
+// set. The length is the number of bytes after conversion.
+func (k *KomClient) hollerith(s string) string {
+	return hollerith.Sprint(encodeString(s, k.serverCharset()))
 }
 
-// Send a protocol string to the server, handle any and all errors.
-func (k *KomClient) send(s string) error {
-	b := []byte(s)
-	offset := 0
-	remains := len(b)
-	done := false
+// Return the character set of a text, given its aux items. Texts
+// without a supported character set in their content type are in the
+// server's character set. The empty string means the text should be
+// passed through unchanged.
+func (k *KomClient) TextCharset(auxItems []types.AuxItem) string {
+	return k.textCharset(auxItems)
+}
 
-	for !done {
-		sent, err := k.socket.Write(b[offset:])
-		if err != nil {
-			return err
-		}
-		if sent >= remains {
-			done = true
-		} else {
-			offset += sent
-			remains -= sent
+func (k *KomClient) textCharset(auxItems []types.AuxItem) string {
+	if item, ok := types.FindAuxItem(auxItems, types.AuxContentType); ok {
+		if cs, ok := charset.Canonical(charset.FromContentType(item.Data())); ok {
+			return cs
 		}
 	}
+	return k.serverCharset()
+}
 
-	k.socket.Write([]byte{10})
-
-	return nil
+// Return the text of a new text as a Hollerith string, in the
+// character set named by its content-type aux item.
+func (k *KomClient) textHollerith(text string, auxItems []types.AuxItemInput) string {
+	cs := k.serverCharset()
+	for _, item := range auxItems {
+		if item.Tag != types.AuxContentType {
+			continue
+		}
+		if ct, ok := charset.Canonical(charset.FromContentType(item.Data())); ok {
+			cs = ct
+		}
+	}
+	return hollerith.Sprint(encodeString(text, cs))
 }
 
-// Run a continuous read loop on the
+// Run a continuous read loop on the client socket, dispatching
+// replies to their callbacks and asynchronous messages to the
+// subscribers. The loop terminates when the shutdown channel is
+// closed or the connection fails, after which the client is dead.
 func (k *KomClient) receiveLoop() {
-	done := false
+	r := &komReader{Reader: bufio.NewReader(k.socket)}
 
-	for !done {
+	for {
 		select {
-		case _, ok := <-k.shutdown:
-			_ = ok
-			done = true
-			continue
+		case <-k.shutdown:
+			k.connectionLost(net.ErrClosed)
+			return
 		default:
-			status, _ := utils.ReadByte(k.socket)
-			id := readUInt32(k.socket)
-			callback, _ := k.getCallback(id)
-			switch {
-			case status == '=':
-				callback.OK(k.socket)
-			case status == '%':
-				callback.Error(k.socket)
+		}
+		r.times = k.timeCodec()
+		r.charset = k.serverCharset()
+
+		status, err := skipWhitespace(r)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.ErrClosedPipe) {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Error("receiveLoop - reading from server")
 			}
+			k.connectionLost(err)
+			return
 		}
 
+		switch status {
+		case '=', '%':
+			if next, err := r.Peek(1); status == '%' && err == nil && next[0] == '%' {
+				// "%%" means the server could not parse a request
+				line, _ := r.ReadString('\n')
+				log.WithFields(log.Fields{
+					"message": line,
+				}).Error("receiveLoop - protocol error reported by server")
+				continue
+			}
+			id := readUInt32(r)
+			callback, err := k.getCallback(id)
+			if err != nil {
+				skipMessage(r)
+				continue
+			}
+			if status == '=' {
+				callback.OK(r)
+			} else {
+				callback.Error(r)
+			}
+		case ':':
+			k.readAsyncMessage(r)
+		default:
+			log.WithFields(log.Fields{
+				"status": status,
+			}).Error("receiveLoop - unexpected message start")
+			skipMessage(r)
+		}
 	}
 }
 
@@ -836,7 +1609,7 @@ func (k *KomClient) asyncChangeName(conference, newName string) (chan genericRes
 
 	reqID := k.registerCallback(genericCallback(rv))
 
-	req := fmt.Sprintf("%d 3 %d %s", reqID, confNo, hollerith.Sprint(newName))
+	req := fmt.Sprintf("%d 3 %d %s", reqID, confNo, k.hollerith(newName))
 	return rv, k.send(req)
 }
 
@@ -846,7 +1619,7 @@ func (k *KomClient) asyncChangeWhatIAmDoing(msg string) (chan genericResponse, e
 
 	reqID := k.registerCallback(genericCallback(rv))
 
-	req := fmt.Sprintf("%d 4 %s", reqID, hollerith.Sprint(msg))
+	req := fmt.Sprintf("%d 4 %s", reqID, k.hollerith(msg))
 
 	return rv, k.send(req)
 }
@@ -868,7 +1641,7 @@ func (k *KomClient) asyncChangePassword(person types.ConfNo, oldPasswd, newPassw
 
 	reqID := k.registerCallback(genericCallback(rv))
 
-	req := fmt.Sprintf("%d 8 %d %s %s", reqID, person, hollerith.Sprint(oldPasswd), hollerith.Sprint(newPasswd))
+	req := fmt.Sprintf("%d 8 %d %s %s", reqID, person, k.hollerith(oldPasswd), k.hollerith(newPasswd))
 
 	return rv, k.send(req)
 }
@@ -977,9 +1750,8 @@ func (k *KomClient) asyncSetConfTypef(conference string, confType types.AnyConfT
 
 // This sends the set-garb-nice protocol message (#22) and returns a
 // channel suitable to see if there was an error or not.
-func (k *KomClient) asyncSetGarbNice(conference string, nice uint32) (chan genericResponse, error) {
+func (k *KomClient) asyncSetGarbNice(confID types.ConfNo, nice uint32) (chan genericResponse, error) {
 	rv := make(chan genericResponse)
-	confID := k.ConferenceFromName(conference)
 
 	reqID := k.registerCallback(genericCallback(rv))
 	req := fmt.Sprintf("%d 22 %d %d", reqID, confID, nice)
@@ -988,6 +1760,30 @@ func (k *KomClient) asyncSetGarbNice(conference string, nice uint32) (chan gener
 	return rv, err
 }
 
+// This sends the set-expire protocol message (#97) and returns a
+// channel suitable to see if there was an error or not.
+func (k *KomClient) asyncSetExpire(confID types.ConfNo, expire uint32) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 97 %d %d", reqID, confID, expire)
+
+	err := k.send(req)
+	return rv, err
+}
+
+// This sends the set-keep-commented protocol message (#105) and
+// returns a channel suitable to see if there was an error or not.
+func (k *KomClient) asyncSetKeepCommented(confID types.ConfNo, keepCommented uint32) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 105 %d %d", reqID, confID, keepCommented)
+
+	err := k.send(req)
+	return rv, err
+}
+
 // This sends the get-marks protocol message (#23) and returns a
 // channel suitable to return an array of marks or an error.
 func (k *KomClient) asyncGetMarks() (chan getMarksResponse, error) {
@@ -1015,11 +1811,16 @@ func (k *KomClient) asyncGetText(textNo types.TextNo, start, end uint32) (chan g
 // This sends the mark-as-read message (#27) and returns a channel
 // suitable to get success or error.
 func (k *KomClient) asyncMarkAsRead(conference string, texts []types.TextNo) (chan genericResponse, error) {
+	return k.asyncMarkAsReadConf(k.ConferenceFromName(conference), texts)
+}
+
+// As asyncMarkAsRead, but taking a conference number. The texts are
+// local text numbers in the conference.
+func (k *KomClient) asyncMarkAsReadConf(conf types.ConfNo, texts []types.TextNo) (chan genericResponse, error) {
 	rv := make(chan genericResponse)
 
-	confID := k.ConferenceFromName(conference)
 	reqID := k.registerCallback(genericCallback(rv))
-	req := fmt.Sprintf("%d 27 %d %s", reqID, confID, types.TextNoArray(texts))
+	req := fmt.Sprintf("%d 27 %d %s", reqID, conf, types.TextNoArray(texts))
 
 	err := k.send(req)
 	return rv, err
@@ -1195,7 +1996,7 @@ func (k *KomClient) asyncGetUnreadConfs(person types.ConfNo) (chan unreadConfs,
 func (k *KomClient) asyncSendMessage(recipient types.ConfNo, message string) (chan genericResponse, error) {
 	rv := make(chan genericResponse)
 	reqID := k.registerCallback(genericCallback(rv))
-	req := fmt.Sprintf("%d 53 %d %s", reqID, recipient, hollerith.Sprint(message))
+	req := fmt.Sprintf("%d 53 %d %s", reqID, recipient, k.hollerith(message))
 	err := k.send(req)
 	return rv, err
 }
@@ -1237,7 +2038,7 @@ func (k *KomClient) asyncSetUserArea(who types.ConfNo, text types.TextNo) (chan
 func (k *KomClient) asyncGetLastText(when time.Time) (chan textResponse, error) {
 	rv := make(chan textResponse)
 	reqID := k.registerCallback(textResponseCallback(rv))
-	req := fmt.Sprintf("%d 58 %s", reqID, types.StringTime(when))
+	req := fmt.Sprintf("%d 58 %s", reqID, k.timeCodec().Encode(when))
 	err := k.send(req)
 	return rv, err
 }
@@ -1274,7 +2075,7 @@ func (k *KomClient) asyncLogin(userName, password string, invisible bool) (chan
 
 	reqID := k.registerCallback(genericCallback(rv))
 
-	req := fmt.Sprintf("%d 62 %d %s %d", reqID, persNo, hollerith.Sprint(password), visibility)
+	req := fmt.Sprintf("%d 62 %d %s %d", reqID, persNo, k.hollerith(password), visibility)
 	err := k.send(req)
 
 	return rv, err
@@ -1337,7 +2138,7 @@ func (k *KomClient) asyncUnmarkText(text types.TextNo) (chan genericResponse, er
 
 // This sends the "re-z-lookup" protocol message (#74) and returns a
 // channel suitable for reading the response or an error from.
-func (k *KomClient) asyncReZLookup(re string, wantPersons bool, wantConferences bool) (chan zConfArrayResponse, error) {
+func (k *KomClient) asyncReZLookup(re string, wantPersons, wantConferences bool) (chan zConfArrayResponse, error) {
 	rv := make(chan zConfArrayResponse)
 	reqID := k.registerCallback(zConfArrayResponseCallback(rv))
 	persons := 0
@@ -1348,7 +2149,7 @@ func (k *KomClient) asyncReZLookup(re string, wantPersons bool, wantConferences
 	if wantConferences {
 		confs = 1
 	}
-	req := fmt.Sprintf("%d 74 %s %d %d", reqID, hollerith.Sprint(re), persons, confs)
+	req := fmt.Sprintf("%d 74 %s %d %d", reqID, k.hollerith(re), persons, confs)
 	err := k.send(req)
 
 	return rv, err
@@ -1366,7 +2167,7 @@ func (k *KomClient) asyncGetVersionInfo() (chan versionInfoResponse, error) {
 
 // This sends the "lookup-z-name" protocol message (#76) and returns a
 // channel suitabe to read the reponse or an error from.
-func (k *KomClient) asyncLookupZName(name string, wantConferences, wantPersons bool) (chan zConfArrayResponse, error) {
+func (k *KomClient) asyncLookupZName(name string, wantPersons, wantConferences bool) (chan zConfArrayResponse, error) {
 	rv := make(chan zConfArrayResponse)
 	reqID := k.registerCallback(zConfArrayResponseCallback(rv))
 	persons := 0
@@ -1377,7 +2178,7 @@ func (k *KomClient) asyncLookupZName(name string, wantConferences, wantPersons b
 	if wantConferences {
 		confs = 1
 	}
-	req := fmt.Sprintf("%d 76 %s %d %d", reqID, hollerith.Sprint(name), persons, confs)
+	req := fmt.Sprintf("%d 76 %s %d %d", reqID, k.hollerith(name), persons, confs)
 	err := k.send(req)
 
 	return rv, err
@@ -1406,7 +2207,8 @@ func (k *KomClient) asyncGetUConfStat(conf types.ConfNo) (chan uConfResponse, er
 }
 
 // This sends the "set-info" protocol message (#79) and returns a
-// channel suitable for reading an OK or an error from.
+// channel suitable for reading an OK or an error from. The aux items
+// of the server are changed with modify-system-info (#95).
 func (k *KomClient) asyncSetInfo(info types.InfoOld) (chan genericResponse, error) {
 	rv := make(chan genericResponse)
 	reqID := k.registerCallback(genericCallback(rv))
@@ -1438,6 +2240,307 @@ func (k *KomClient) asyncQueryAsync() (chan queryAsyncResponse, error) {
 	return rv, err
 }
 
+// This sends the "user-active" protocol message (#82) and returns a
+// channel suitable to see if there was an error or not.
+func (k *KomClient) asyncUserActive() (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 82", reqID)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "who-is-on-dynamic" protocol message (#83) and
+// returns a channel suitable for reading the sessions or an error
+// from. Sessions idle for more than activeLast seconds are left out,
+// unless activeLast is zero.
+func (k *KomClient) asyncWhoIsOnDynamic(wantVisible, wantInvisible bool, activeLast uint32) (chan dynamicSessionsResponse, error) {
+	visible := 0
+	invisible := 0
+	if wantVisible {
+		visible = 1
+	}
+	if wantInvisible {
+		invisible = 1
+	}
+	rv := make(chan dynamicSessionsResponse)
+	reqID := k.registerCallback(dynamicSessionsCallback(rv))
+	req := fmt.Sprintf("%d 83 %d %d %d", reqID, visible, invisible, activeLast)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "create-text" protocol message (#86) and returns a
+// channel suitable for reading the new text number or an error from.
+func (k *KomClient) asyncCreateText(text string, miscInfo []types.MiscInfo, auxItems []types.AuxItemInput) (chan textResponse, error) {
+	rv := make(chan textResponse)
+	reqID := k.registerCallback(textResponseCallback(rv))
+	req := fmt.Sprintf("%d 86 %s %s %s", reqID, k.textHollerith(text, auxItems), types.MiscInfoArray(miscInfo), auxItemInputArray(auxItems))
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "create-anonymous-text" protocol message (#87) and
+// returns a channel suitable for reading the new text number or an
+// error from.
+func (k *KomClient) asyncCreateAnonymousText(text string, miscInfo []types.MiscInfo, auxItems []types.AuxItemInput) (chan textResponse, error) {
+	rv := make(chan textResponse)
+	reqID := k.registerCallback(textResponseCallback(rv))
+	req := fmt.Sprintf("%d 87 %s %s %s", reqID, k.textHollerith(text, auxItems), types.MiscInfoArray(miscInfo), auxItemInputArray(auxItems))
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "get-text-stat" protocol message (#90) and returns
+// a channel suitable for reading the text status or an error from.
+func (k *KomClient) asyncGetTextStat(text types.TextNo) (chan textStatResponse, error) {
+	rv := make(chan textStatResponse)
+	reqID := k.registerCallback(textStatCallback(rv))
+	req := fmt.Sprintf("%d 90 %d", reqID, text)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "get-conf-stat" protocol message (#91) and returns
+// a channel suitable for reading the conference status or an error
+// from.
+func (k *KomClient) asyncGetConfStat(conf types.ConfNo) (chan confStatResponse, error) {
+	rv := make(chan confStatResponse)
+	reqID := k.registerCallback(confStatCallback(rv))
+	req := fmt.Sprintf("%d 91 %d", reqID, conf)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "modify-text-info" protocol message (#92) and
+// returns a channel suitable for reading an OK or an error from.
+func (k *KomClient) asyncModifyTextInfo(text types.TextNo, deleteItems []types.AuxNo, addItems []types.AuxItemInput) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 92 %d %s %s", reqID, text, types.AuxNoArray(deleteItems), auxItemInputArray(addItems))
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "modify-conf-info" protocol message (#93) and
+// returns a channel suitable for reading an OK or an error from.
+func (k *KomClient) asyncModifyConfInfo(conf types.ConfNo, deleteItems []types.AuxNo, addItems []types.AuxItemInput) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 93 %d %s %s", reqID, conf, types.AuxNoArray(deleteItems), auxItemInputArray(addItems))
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "get-info" protocol message (#94) and returns a
+// channel suitable for reading the server information or an error
+// from.
+func (k *KomClient) asyncGetInfo() (chan infoResponse, error) {
+	rv := make(chan infoResponse)
+	reqID := k.registerCallback(infoResponseCallback(rv))
+	req := fmt.Sprintf("%d 94", reqID)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "modify-system-info" protocol message (#95) and
+// returns a channel suitable for reading an OK or an error from.
+func (k *KomClient) asyncModifySystemInfo(deleteItems []types.AuxNo, addItems []types.AuxItemInput) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 95 %s %s", reqID, types.AuxNoArray(deleteItems), auxItemInputArray(addItems))
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "get-scheduling" protocol message (#118) and returns
+// a channel suitable for reading the scheduling information or an
+// error from.
+func (k *KomClient) asyncGetScheduling(session types.SessionNo) (chan schedulingResponse, error) {
+	rv := make(chan schedulingResponse)
+	reqID := k.registerCallback(schedulingCallback(rv))
+	req := fmt.Sprintf("%d 118 %d", reqID, session)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "set-scheduling" protocol message (#119) and returns
+// a channel suitable for reading an OK or an error from.
+func (k *KomClient) asyncSetScheduling(session types.SessionNo, info types.SchedulingInfo) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 119 %d %d %d", reqID, session, info.Priority, info.Weight)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the local-to-global protocol message (#103) and returns
+// a channel suitable for reading the text mapping or an error from.
+// The server returns at most 255 texts per call.
+func (k *KomClient) asyncLocalToGlobal(conf types.ConfNo, firstLocal types.TextNo, count uint32) (chan textMappingResponse, error) {
+	rv := make(chan textMappingResponse)
+
+	reqID := k.registerCallback(textMappingCallback(rv))
+	req := fmt.Sprintf("%d 103 %d %d %d", reqID, conf, firstLocal, count)
+
+	err := k.send(req)
+	return rv, err
+}
+
+// This sends the set-pers-flags protocol message (#106) and returns
+// a channel suitable to see if there was an error or not.
+func (k *KomClient) asyncSetPersFlags(person types.ConfNo, flags types.PersonalFlags) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 106 %d %s", reqID, person, flags.Repr())
+
+	err := k.send(req)
+	return rv, err
+}
+
+// This sends the query-read-texts protocol message (#107) and returns
+// a channel suitable for reading the membership or an error from.
+func (k *KomClient) asyncQueryReadTexts(person, conf types.ConfNo, wantReadRanges bool, maxRanges uint32) (chan membershipResponse, error) {
+	want := 0
+	if wantReadRanges {
+		want = 1
+	}
+	rv := make(chan membershipResponse)
+
+	reqID := k.registerCallback(membershipCallback(rv))
+	req := fmt.Sprintf("%d 107 %d %d %d %d", reqID, person, conf, want, maxRanges)
+
+	err := k.send(req)
+	return rv, err
+}
+
+// This sends the get-membership protocol message (#108) and returns a
+// channel suitable for reading the memberships or an error from.
+func (k *KomClient) asyncGetMembership(person types.ConfNo, first, count uint16, wantReadRanges bool, maxRanges uint32) (chan membershipResponse, error) {
+	want := 0
+	if wantReadRanges {
+		want = 1
+	}
+	rv := make(chan membershipResponse)
+
+	reqID := k.registerCallback(membershipListCallback(rv))
+	req := fmt.Sprintf("%d 108 %d %d %d %d %d", reqID, person, first, count, want, maxRanges)
+
+	err := k.send(req)
+	return rv, err
+}
+
+// This sends the set-read-ranges protocol message (#110) and returns
+// a channel suitable to see if there was an error or not.
+func (k *KomClient) asyncSetReadRanges(conf types.ConfNo, ranges []types.ReadRange) (chan genericResponse, error) {
+	rv := make(chan genericResponse)
+
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 110 %d %s", reqID, conf, types.ReadRangeArray(ranges))
+
+	err := k.send(req)
+	return rv, err
+}
+
+// This sends the "get-stats-description" protocol message (#111) and
+// returns a channel suitable for reading the description or an error
+// from.
+func (k *KomClient) asyncGetStatsDescription() (chan statsDescriptionResponse, error) {
+	rv := make(chan statsDescriptionResponse)
+	reqID := k.registerCallback(statsDescriptionCallback(rv))
+	req := fmt.Sprintf("%d 111", reqID)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "get-stats" protocol message (#112) and returns a
+// channel suitable for reading the statistics or an error from.
+func (k *KomClient) asyncGetStats(what string) (chan statsResponse, error) {
+	rv := make(chan statsResponse)
+	reqID := k.registerCallback(statsCallback(rv))
+	req := fmt.Sprintf("%d 112 %s", reqID, hollerith.Sprint(what))
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "get-boottime-info" protocol message (#113) and
+// returns a channel suitable for reading the static server
+// information or an error from.
+func (k *KomClient) asyncGetBoottimeInfo() (chan staticServerInfoResponse, error) {
+	rv := make(chan staticServerInfoResponse)
+	reqID := k.registerCallback(staticServerInfoCallback(rv))
+	req := fmt.Sprintf("%d 113", reqID)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "first-unused-conf-no" protocol message (#114) and
+// returns a channel suitable for reading the conference number or an
+// error from.
+func (k *KomClient) asyncFirstUnusedConfNo() (chan confResponse, error) {
+	rv := make(chan confResponse)
+	reqID := k.registerCallback(confResponseCallback(rv))
+	req := fmt.Sprintf("%d 114", reqID)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "find-next-conf-no" protocol message (#116) and
+// returns a channel suitable for reading the conference number or an
+// error from.
+func (k *KomClient) asyncFindNextConfNo(conf types.ConfNo) (chan confResponse, error) {
+	rv := make(chan confResponse)
+	reqID := k.registerCallback(confResponseCallback(rv))
+	req := fmt.Sprintf("%d 116 %d", reqID, conf)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "find-previous-conf-no" protocol message (#117) and
+// returns a channel suitable for reading the conference number or an
+// error from.
+func (k *KomClient) asyncFindPreviousConfNo(conf types.ConfNo) (chan confResponse, error) {
+	rv := make(chan confResponse)
+	reqID := k.registerCallback(confResponseCallback(rv))
+	req := fmt.Sprintf("%d 117 %d", reqID, conf)
+	err := k.send(req)
+
+	return rv, err
+}
+
+// This sends the "set-connection-time-format" protocol message (#120)
+// and returns a channel suitable for reading an OK or an error from.
+func (k *KomClient) asyncSetConnectionTimeFormat(useUTC bool) (chan genericResponse, error) {
+	utc := 0
+	if useUTC {
+		utc = 1
+	}
+	rv := make(chan genericResponse)
+	reqID := k.registerCallback(genericCallback(rv))
+	req := fmt.Sprintf("%d 120 %d", reqID, utc)
+	err := k.send(req)
+
+	return rv, err
+}
+
 // Various utility functions
 
 func (k *KomClient) PersonFromName(user string) types.ConfNo {
@@ -1464,3 +2567,53 @@ func (k *KomClient) ConferenceFromName(name string) types.ConfNo {
 
 	return 0
 }
+
+// Look up a person or conference by name, consulting the server-wide
+// cache first and asking the server (lookup-z-name, #76) if the name
+// is not known. The name must either match exactly (ignoring case),
+// or be an unambiguous abbreviation.
+func (k *KomClient) resolveName(name string, wantPersons, wantConferences bool) (types.ConfNo, error) {
+	if wantPersons {
+		if rv, ok := k.server.LookupUser(name); ok {
+			return rv, nil
+		}
+	}
+	if wantConferences {
+		if rv, ok := k.server.LookupConference(name); ok {
+			return rv, nil
+		}
+	}
+
+	confs, err := k.LookupZName(name, wantPersons, wantConferences)
+	if err != nil {
+		return 0, err
+	}
+
+	var found []types.ConfZInfo
+	for _, c := range confs {
+		if strings.EqualFold(c.Name, name) {
+			found = []types.ConfZInfo{c}
+			break
+		}
+		found = append(found, c)
+	}
+
+	switch len(found) {
+	case 0:
+		return 0, fmt.Errorf("No conference or person matches %q", name)
+	case 1:
+	default:
+		return 0, fmt.Errorf("The name %q is ambiguous, it matches %d names", name, len(found))
+	}
+
+	c := found[0]
+	if c.Type.LetterBox {
+		k.server.addUser(name, c.No)
+		k.server.addUser(c.Name, c.No)
+	} else {
+		k.server.addConference(name, c.No)
+		k.server.addConference(c.Name, c.No)
+	}
+
+	return c.No, nil
+}
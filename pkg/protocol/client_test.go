@@ -3,10 +3,14 @@ package protocol
 // Various client implementation tests
 
 import (
+	"bufio"
 	"testing"
 
 	"bytes"
+	"errors"
 	"fmt"
+	"net"
+	"reflect"
 	"strings"
 	"time"
 
@@ -23,7 +27,7 @@ func TestReadUInt(t *testing.T) {
 		expected uint32
 	}{
 		{"2 ", 2}, {"2 22", 2}, {"22 ", 22}, {"012", 12},
-		{"0 ", 0}, {"990099 ", 990099},
+		{"0 ", 0}, {"990099 ", 990099}, {" 17 ", 17},
 	}
 
 	for ix, d := range td {
@@ -106,7 +110,8 @@ func TestGetTime(t *testing.T) {
 		response string
 		expected time.Time
 	}{
-		{"=1 23 47 19 17 6 97 4 197 1", time.Date(1997, time.June, 17, 19, 47, 23, 0, time.UTC)},
+		{"=1 23 47 19 17 6 97 4 197 1", time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC)},
+		{"=1 0 0 0 1 0 100 6 0 0", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)},
 	}
 
 	for ix, c := range cases {
@@ -122,6 +127,58 @@ func TestGetTime(t *testing.T) {
 }
 
 func TestGetPersonStat(t *testing.T) {
+	cl := fakeClient("=1 15Hvatine@kom.test 0110000000000000 10000000 0 0 12 1 0 100 6 0 0 4711 3600 12 100 5000 300 400 1 2 17 99 5 8\n")
+	rv := make(chan personStat)
+	cl.asyncMap[1] = personStatCallback(rv)
+	go cl.receiveLoop()
+	got := <-rv
+	close(cl.shutdown)
+
+	if got.err != nil {
+		t.Fatalf("unexpected error %v", got.err)
+	}
+	p := got.person
+	if p.Username != "vatine@kom.test" {
+		t.Errorf("saw username %q, want vatine@kom.test", p.Username)
+	}
+	if p.Privileges != (types.PrivBits{Admin: true, Statistic: true}) {
+		t.Errorf("saw privileges %+v", p.Privileges)
+	}
+	if !p.Flags.UnreadIsSecret {
+		t.Errorf("saw flags %+v, want unread-is-secret", p.Flags)
+	}
+	if p.UserArea != 4711 || p.TotalTimePresent != 3600 || p.Sessions != 12 || p.ReadTexts != 300 {
+		t.Errorf("unexpected counters in %+v", p)
+	}
+	if p.CreatedPersons != 1 || p.CreatedConferences != 2 || p.FirstCreatedLocalNo != 17 || p.CreatedTexts != 99 || p.Marks != 5 || p.Conferences != 8 {
+		t.Errorf("unexpected counters in %+v", p)
+	}
+}
+
+func TestPersonRequests(t *testing.T) {
+	c := fakeClient("")
+	c.asyncSetPersFlags(6, types.PersonalFlags{UnreadIsSecret: true})
+	c.asyncUserActive()
+	want := "0 106 6 10000000\n1 82\n"
+	if saw := c.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+}
+
+func TestWhoIsOnDynamic(t *testing.T) {
+	c := fakeClient("=0 2 { 3 6 0 12 10000000 0H 4 9 1 0 01000000 7HReading }\n")
+	rv, _ := c.asyncWhoIsOnDynamic(true, false, 0)
+	go c.receiveLoop()
+	seen := <-rv
+	close(c.shutdown)
+
+	want := []types.DynamicSessionInfo{
+		{Session: 3, Peron: 6, IdleTime: 12, Flags: types.SessionFlags{Invisible: true}},
+		{Session: 4, Peron: 9, WorkingConference: 1, Flags: types.SessionFlags{UserActiveUsed: true}, WhatAmIDoing: "Reading"},
+	}
+	if seen.err != nil || !reflect.DeepEqual(seen.sessions, want) {
+		t.Errorf("saw %+v/%v, want %+v", seen.sessions, seen.err, want)
+	}
 }
 
 func cmpConfZInfo(saw, want types.ConfZInfo, t *testing.T) bool {
@@ -195,6 +252,38 @@ func TestReZLookup(t *testing.T) {
 				err: nil,
 			},
 		},
+		{
+			"=1 1 { 6H{Kaos} 0000 12 }",
+			zConfArrayResponse{
+				confs: []types.ConfZInfo{
+					types.ConfZInfo{
+						Name: "{Kaos}",
+						No:   12,
+						Type: types.ConfType{},
+					},
+				},
+			},
+		},
+		{
+			"=1 0 *",
+			zConfArrayResponse{},
+		},
+		{
+			"=1 2 { 4HJohn 1001 9 }",
+			zConfArrayResponse{
+				confs: []types.ConfZInfo{
+					types.ConfZInfo{
+						Name: "John",
+						No:   9,
+						Type: types.ConfType{
+							RdProt:    true,
+							LetterBox: true,
+						},
+					},
+				},
+				err: errors.New("truncated"),
+			},
+		},
 	}
 
 	for ix, c := range cases {
@@ -207,6 +296,9 @@ func TestReZLookup(t *testing.T) {
 		seen := <-rv
 		close(cl.shutdown)
 
+		if (seen.err != nil) != (c.expected.err != nil) {
+			t.Errorf("Case #%d, saw error %v, want %v", ix, seen.err, c.expected.err)
+		}
 		if len(seen.confs) != len(c.expected.confs) {
 			t.Errorf("Case #%d, conf array length mismatch", ix)
 			continue
@@ -287,6 +379,45 @@ func TestGetUConfStat(t *testing.T) {
 	}
 }
 
+func TestGetConfStat(t *testing.T) {
+	c := fakeClient("=0 8HTestconf 00001000 23 47 19 17 6 97 4 197 1 0 0 0 1 0 100 6 0 0 6 12 6 0 0 0 77 77 2 1 5 0 1 { 1 1 6 0 0 0 1 0 100 6 0 0 00000000 0 10Htext/plain }\n")
+	rv, _ := c.asyncGetConfStat(7)
+	go c.receiveLoop()
+	seen := <-rv
+	close(c.shutdown)
+
+	created := time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC)
+	written := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	want := types.Conference{
+		Name:          "Testconf",
+		Type:          types.ExtendedConfType{AllowAnonymous: true},
+		CreationTime:  created,
+		LastWritten:   written,
+		Creator:       6,
+		Presentation:  12,
+		Supervisor:    6,
+		Nice:          77,
+		KeepCommented: 77,
+		NoOfMembers:   2,
+		FirstLocalNo:  1,
+		NoOfTexts:     5,
+	}
+	item := types.AuxItem{AuxNo: 1, Tag: types.AuxContentType, Creator: 6, CreatedAt: written}
+	item.SetData("text/plain")
+	want.AuxItems = []types.AuxItem{item}
+
+	if seen.err != nil {
+		t.Fatalf("unexpected error %v", seen.err)
+	}
+	if !seen.conf.CreationTime.Equal(created) || !seen.conf.LastWritten.Equal(written) || !seen.conf.AuxItems[0].CreatedAt.Equal(written) {
+		t.Errorf("saw times %v, %v, want %v, %v", seen.conf.CreationTime, seen.conf.LastWritten, created, written)
+	}
+	seen.conf.CreationTime, seen.conf.LastWritten, seen.conf.AuxItems[0].CreatedAt = created, written, written
+	if !reflect.DeepEqual(seen.conf, want) {
+		t.Errorf("saw %+v, want %+v", seen.conf, want)
+	}
+}
+
 func cmpSlice(got []uint32, want []uint32, t *testing.T) bool {
 	if len(got) != len(want) {
 		t.Errorf("slice lengths differ")
@@ -328,3 +459,537 @@ func TestGetQueryAsync(t *testing.T) {
 	}
 
 }
+
+func TestReadAuxItemList(t *testing.T) {
+	cases := []struct {
+		data string
+		want []types.AuxItem
+	}{
+		{"0 { }", nil},
+		{"2 *", nil},
+		{
+			"2 { 1 1 6 12 30 17 3 9 122 1 275 0 00000000 0 10Htext/plain 2 2 6 12 30 17 3 9 122 1 275 0 01000000 3 6HIndeed }",
+			[]types.AuxItem{
+				{AuxNo: 1, Tag: types.AuxContentType, Creator: 6},
+				{AuxNo: 2, Tag: types.AuxFastReply, Creator: 6, Flags: types.AuxItemFlags{Inherit: true}, InheritLimit: 3},
+			},
+		},
+	}
+	datas := [][]string{nil, nil, {"text/plain", "Indeed"}}
+
+	for ix, c := range cases {
+		got, err := readAuxItemList(strings.NewReader(c.data))
+		if err != nil {
+			t.Errorf("Case #%d, unexpected error %v", ix, err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("Case #%d, saw %d items, want %d", ix, len(got), len(c.want))
+			continue
+		}
+		for iIx, item := range got {
+			want := c.want[iIx]
+			if item.AuxNo != want.AuxNo || item.Tag != want.Tag || item.Creator != want.Creator || item.Flags != want.Flags || item.InheritLimit != want.InheritLimit {
+				t.Errorf("Case #%d, item %d, saw %+v, want %+v", ix, iIx, item, want)
+			}
+			if item.Data() != datas[ix][iIx] {
+				t.Errorf("Case #%d, item %d, saw data %q, want %q", ix, iIx, item.Data(), datas[ix][iIx])
+			}
+		}
+	}
+}
+
+func TestModifyInfoRequests(t *testing.T) {
+	add := []types.AuxItemInput{types.ContentTypeAux("text/plain"), types.NoCommentsAux()}
+
+	c := fakeClient("")
+	c.asyncModifyTextInfo(4711, []types.AuxNo{3}, add)
+	want := "0 92 4711 1 { 3 } 2 { 1 00000000 0 10Htext/plain 4 00000000 0 0H }\n"
+	if saw := c.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+
+	c = fakeClient("")
+	c.asyncModifyConfInfo(17, nil, add[:1])
+	want = "0 93 17 0 { } 1 { 1 00000000 0 10Htext/plain }\n"
+	if saw := c.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+}
+
+func TestGetInfo(t *testing.T) {
+	cl := fakeClient("=1 10901 1 2 3 4 1234 1 { 7 14 5 0 0 12 1 0 100 6 0 0 00000000 0 4H4711 }\n")
+	rv := make(chan infoResponse)
+	cl.asyncMap[1] = infoResponseCallback(rv)
+	go cl.receiveLoop()
+	got := <-rv
+	close(cl.shutdown)
+
+	if got.err != nil {
+		t.Fatalf("unexpected error %v", got.err)
+	}
+	want := types.InfoOld{
+		Version:                          10901,
+		ConferencePresentationConference: 1,
+		PersonPresentationConference:     2,
+		MOTDConference:                   3,
+		KomNewsConference:                4,
+		MOTDOfLyskom:                     1234,
+	}
+	if got.info.Old() != want {
+		t.Errorf("saw %+v, want %+v", got.info.Old(), want)
+	}
+	faq, ok := types.FindAuxItem(got.info.AuxItems, types.AuxFAQText)
+	if !ok {
+		t.Fatalf("no faq-text aux item in %+v", got.info.AuxItems)
+	}
+	if no, err := types.AuxTextNo(faq); err != nil || no != 4711 {
+		t.Errorf("saw faq text %d (%v), want 4711", no, err)
+	}
+}
+
+func TestGetBoottimeInfo(t *testing.T) {
+	cl := fakeClient("=1 0 0 12 1 0 100 6 0 0 0 30 12 1 0 100 6 0 0 9Hclean-ish 4711 9999 17 6 63\n")
+	rv := make(chan staticServerInfoResponse)
+	cl.asyncMap[1] = staticServerInfoCallback(rv)
+	go cl.receiveLoop()
+	got := <-rv
+	close(cl.shutdown)
+
+	if got.err != nil {
+		t.Fatalf("unexpected error %v", got.err)
+	}
+	if !got.info.BootTime.Equal(time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("saw boot time %s, want 2000-01-01 12:00", got.info.BootTime)
+	}
+	if got.info.SaveTime.Sub(got.info.BootTime) != 30*time.Minute {
+		t.Errorf("saw boot time %s and save time %s, want 30 minutes apart", got.info.BootTime, got.info.SaveTime)
+	}
+	if got.info.DBStatus != "clean-ish" {
+		t.Errorf("saw db status %q, want clean-ish", got.info.DBStatus)
+	}
+	if got.info.ExistingTexts != 4711 || got.info.HighestTextNo != 9999 || got.info.ExistingConfs != 17 || got.info.ExistingPersons != 6 || got.info.HighestConfNo != 63 {
+		t.Errorf("unexpected counts in %+v", got.info)
+	}
+}
+
+func TestSetInfoRequests(t *testing.T) {
+	c := fakeClient("")
+	c.asyncSetInfo(types.Info{Version: 10901, ConferencePresentationConference: 1, MOTDOfLyskom: 17}.Old())
+	want := "0 79 10901 1 0 0 0 17\n"
+	if saw := c.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+}
+
+func TestGetStatsDescription(t *testing.T) {
+	cl := fakeClient("=1 2 { 3Hrun 4Hpend } 3 { 0 60 300 }\n")
+	rv := make(chan statsDescriptionResponse)
+	cl.asyncMap[1] = statsDescriptionCallback(rv)
+	go cl.receiveLoop()
+	got := <-rv
+	close(cl.shutdown)
+
+	if got.err != nil {
+		t.Fatalf("unexpected error %v", got.err)
+	}
+	if len(got.description.What) != 2 || got.description.What[0] != "run" || got.description.What[1] != "pend" {
+		t.Errorf("saw what %q, want [run pend]", got.description.What)
+	}
+	if !cmpSlice(got.description.When, []uint32{0, 60, 300}, t) {
+		t.Errorf("saw when %v", got.description.When)
+	}
+	if iv := got.description.Intervals(); len(iv) != 3 || iv[2] != 5*time.Minute {
+		t.Errorf("saw intervals %v", iv)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	cl := fakeClient("=1 2 { 3 0 0 1.5 0.25 1.5e-2 }\n")
+	rv := make(chan statsResponse)
+	cl.asyncMap[1] = statsCallback(rv)
+	go cl.receiveLoop()
+	got := <-rv
+	close(cl.shutdown)
+
+	if got.err != nil {
+		t.Fatalf("unexpected error %v", got.err)
+	}
+	want := []types.Stats{{Average: 3}, {Average: 1.5, AscentRate: 0.25, DescentRate: 0.015}}
+	if len(got.stats) != len(want) {
+		t.Fatalf("saw %d stats, want %d", len(got.stats), len(want))
+	}
+	for ix, s := range got.stats {
+		if s != want[ix] {
+			t.Errorf("Stats %d, saw %+v, want %+v", ix, s, want[ix])
+		}
+	}
+}
+
+func TestGetScheduling(t *testing.T) {
+	cl := fakeClient("=1 2 4\n")
+	rv := make(chan schedulingResponse)
+	cl.asyncMap[1] = schedulingCallback(rv)
+	go cl.receiveLoop()
+	got := <-rv
+	close(cl.shutdown)
+
+	want := types.SchedulingInfo{Priority: 2, Weight: 4}
+	if got.err != nil || got.info != want {
+		t.Errorf("saw %+v (%v), want %+v", got.info, got.err, want)
+	}
+}
+
+func TestSchedulingErrors(t *testing.T) {
+	cases := []struct {
+		data  string
+		check func(error) bool
+	}{
+		{"%1 58 3\n", func(err error) bool {
+			var pd PriorityDeniedError
+			return errors.As(err, &pd) && pd.Lowest == 3
+		}},
+		{"%1 59 0\n", func(err error) bool {
+			var wd WeightDeniedError
+			return errors.As(err, &wd)
+		}},
+		{"%1 60 0\n", func(err error) bool {
+			var wz WeightZeroError
+			return errors.As(err, &wz)
+		}},
+	}
+
+	for ix, c := range cases {
+		cl := fakeClient(c.data)
+		rv := make(chan genericResponse)
+		cl.asyncMap[1] = genericCallback(rv)
+		go cl.receiveLoop()
+		got := <-rv
+		close(cl.shutdown)
+
+		if !c.check(got.err) {
+			t.Errorf("Case #%d, unexpected error %v (%T)", ix, got.err, got.err)
+		}
+	}
+}
+
+func TestConfResponse(t *testing.T) {
+	cases := []struct {
+		data string
+		want types.ConfNo
+		end  bool
+	}{
+		{"=1 17\n", 17, false},
+		{"%1 9 63\n", 0, true},
+	}
+
+	for ix, c := range cases {
+		cl := fakeClient(c.data)
+		rv := make(chan confResponse)
+		cl.asyncMap[1] = confResponseCallback(rv)
+		go cl.receiveLoop()
+		got := <-rv
+		close(cl.shutdown)
+
+		var undef UndefinedConferenceError
+		if errors.As(got.err, &undef) != c.end {
+			t.Errorf("Case #%d, unexpected error %v", ix, got.err)
+		}
+		if got.conf != c.want {
+			t.Errorf("Case #%d, saw conf %d, want %d", ix, got.conf, c.want)
+		}
+	}
+}
+
+func TestCreateTextRequests(t *testing.T) {
+	c := fakeClient("")
+	misc := []types.MiscInfo{types.RecipientMisc(17), types.CommentToMisc(4711)}
+	c.asyncCreateAnonymousText("Subject\nBody", misc, []types.AuxItemInput{types.ContentTypeAux("text/plain")})
+	c.asyncCreateText("Re: Subject\n", misc[:1], nil)
+	c.asyncSetConnectionTimeFormat(true)
+	want := "0 87 12HSubject\nBody 2 { 0 17 2 4711 } 1 { 1 00000000 0 10Htext/plain }\n" +
+		"1 86 12HRe: Subject\n 1 { 0 17 } 0 { }\n" +
+		"2 120 1\n"
+	if saw := c.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+}
+
+func TestAnonymousRejected(t *testing.T) {
+	cl := fakeClient("%1 47 0\n")
+	rv := make(chan textResponse)
+	cl.asyncMap[1] = textResponseCallback(rv)
+	go cl.receiveLoop()
+	got := <-rv
+	close(cl.shutdown)
+
+	var ar AnonymousRejectedError
+	if !errors.As(got.err, &ar) {
+		t.Errorf("saw error %v (%T), want AnonymousRejectedError", got.err, got.err)
+	}
+}
+
+func TestTimeZones(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	cases := []struct {
+		utc  bool
+		want time.Time
+	}{
+		{false, time.Date(1997, time.July, 17, 19, 47, 23, 0, stockholm)},
+		{true, time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC)},
+	}
+
+	for ix, c := range cases {
+		cl := fakeClient("=1 23 47 19 17 6 97 4 197 1\n")
+		cl.server = newKomServer()
+		cl.server.SetLocation(stockholm)
+		cl.utcTimes = c.utc
+		rv := make(chan time.Time)
+		cl.asyncMap[1] = timeResponseCallback(rv)
+		go cl.receiveLoop()
+		seen := <-rv
+		if !seen.Equal(c.want) {
+			t.Errorf("Case #%d, saw %s, want %s", ix, seen, c.want)
+		}
+	}
+}
+
+func TestGetTextStat(t *testing.T) {
+	cl := fakeClient("=1 23 47 19 17 6 97 4 197 1 6 3 72 0 3 { 0 17 6 8 9 23 47 19 17 6 97 4 197 1 } 1 { 4 1 6 23 47 19 17 6 97 4 197 1 00000000 0 24Htext/plain;charset=utf-8 }\n")
+	rv := make(chan textStatResponse)
+	cl.asyncMap[1] = textStatCallback(rv)
+	go cl.receiveLoop()
+	seen := <-rv
+	if seen.err != nil {
+		t.Fatalf("unexpected error %v", seen.err)
+	}
+
+	stat := seen.stat
+	if stat.Author != 6 || stat.Lines != 3 || stat.Chars != 72 || stat.Marks != 0 {
+		t.Errorf("unexpected text stat %+v", stat)
+	}
+	if len(stat.MiscInfo) != 3 {
+		t.Fatalf("saw %d misc-info items, want 3", len(stat.MiscInfo))
+	}
+	if stat.MiscInfo[0].Recipient != 17 || stat.MiscInfo[1].LocalNo != 8 || stat.MiscInfo[2].SentAt.Year() != 1997 {
+		t.Errorf("unexpected misc-info %+v", stat.MiscInfo)
+	}
+	if len(stat.AuxItems) != 1 || stat.AuxItems[0].Data() != "text/plain;charset=utf-8" {
+		t.Errorf("unexpected aux items %+v", stat.AuxItems)
+	}
+}
+
+func TestServerCharset(t *testing.T) {
+	cl := fakeClient("=1 10Hr\xe4ksm\xf6rg\xe5s 00001000 6 77\n")
+	cl.server = newKomServer()
+	rv := make(chan uConfResponse)
+	cl.asyncMap[1] = uConfResponseCallback(rv)
+	go cl.receiveLoop()
+	seen := <-rv
+	if seen.uConf.Name != "räksmörgås" {
+		t.Errorf("saw %q, want %q", seen.uConf.Name, "räksmörgås")
+	}
+
+	cl = fakeClient("")
+	cl.server = newKomServer()
+	cl.asyncChangeWhatIAmDoing("Äter räksmörgås")
+	want := "0 4 15H\xc4ter r\xe4ksm\xf6rg\xe5s\n"
+	if saw := cl.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+}
+
+func TestTextCharset(t *testing.T) {
+	cl := fakeClient("")
+	cl.server = newKomServer()
+	cl.asyncCreateText("Hej\nRäksmörgås", nil, []types.AuxItemInput{types.ContentTypeAux("text/plain;charset=utf-8")})
+	cl.asyncCreateText("Hej\nRäksmörgås", nil, nil)
+	want := "0 86 17HHej\nRäksmörgås 0 { } 1 { 1 00000000 0 24Htext/plain;charset=utf-8 }\n" +
+		"1 86 14HHej\nR\xe4ksm\xf6rg\xe5s 0 { } 0 { }\n"
+	if saw := cl.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+
+	if err := cl.server.SetCharset("utf8"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cs := cl.textCharset(nil); cs != "utf-8" {
+		t.Errorf("saw %q, want utf-8", cs)
+	}
+	if err := cl.server.SetCharset("ebcdic"); err == nil {
+		t.Errorf("expected an error for an unsupported character set")
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		r.ReadString('\n')
+		server.Write([]byte("LysKOM\n"))
+		// Hang up on the first request.
+		r.ReadString('\n')
+		server.Close()
+	}()
+
+	k, err := NewKomClientConn(client)
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	defer k.Close()
+	msgs, cancel := k.Subscribe()
+	defer cancel()
+
+	var lost ConnectionLostError
+	if err := k.UserActive(); !errors.As(err, &lost) {
+		t.Errorf("saw %v, want a ConnectionLostError", err)
+	}
+	select {
+	case <-k.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("the client is not done after losing the connection")
+	}
+	if _, ok := <-msgs; ok {
+		t.Errorf("expected the subscription to be closed")
+	}
+	if err := k.UserActive(); !errors.As(err, &lost) {
+		t.Errorf("saw %v, want a ConnectionLostError", err)
+	}
+	msgs, _ = k.Subscribe()
+	if _, ok := <-msgs; ok {
+		t.Errorf("expected a closed channel subscribing to a dead client")
+	}
+}
+
+// Return a client connected to a server answering its requests, in
+// order, with the given replies.
+func scriptedClient(t *testing.T, replies ...string) *KomClient {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	go func() {
+		r := bufio.NewReader(server)
+		r.ReadString('\n')
+		server.Write([]byte("LysKOM\n"))
+		for _, reply := range replies {
+			req, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(server, "=%s %s\n", strings.Fields(req)[0], reply)
+		}
+	}()
+
+	k, err := NewKomClientConn(client)
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	t.Cleanup(func() { k.Close() })
+	return k
+}
+
+func TestGetTextContentInvalid(t *testing.T) {
+	stat := "44 5 16 18 9 126 0 290 0 6 2 18 0 0 { } " +
+		"1 { 1 1 6 44 5 16 18 9 126 0 290 0 00000000 0 24Htext/plain;charset=utf-8 }"
+	k := scriptedClient(t, stat, "18HR\xe4ksm\xf6rg\xe5s\nSm\xf6rg\xe5s")
+
+	text, err := k.GetTextContent(4711)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := "R\ufffdksm\ufffdrg\ufffds"; text.Subject != want {
+		t.Errorf("saw subject %q, want %q", text.Subject, want)
+	}
+	if want := "Sm\ufffdrg\ufffds"; text.Body != want {
+		t.Errorf("saw body %q, want %q", text.Body, want)
+	}
+}
+
+func TestGetSubject(t *testing.T) {
+	stat := types.TextStat{Chars: 300}
+	k := scriptedClient(t, "256H"+strings.Repeat("a", 256), "10Hbb\nbody\nmo")
+
+	subject, err := k.GetSubject(4711, stat)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := strings.Repeat("a", 256) + "bb"; subject != want {
+		t.Errorf("saw subject %q, want %q", subject, want)
+	}
+}
+
+// Return one of each response callback, with buffered channels so
+// that the responses can be left unread.
+func fuzzCallbacks() []Callback {
+	return []Callback{
+		genericCallback(make(chan genericResponse, 1)),
+		getMarksCallback(make(chan getMarksResponse, 1)),
+		getTextCallback(make(chan getTextResponse, 1)),
+		zConfArrayResponseCallback(make(chan zConfArrayResponse, 1)),
+		versionInfoResponseCallback(make(chan versionInfoResponse, 1)),
+		timeResponseCallback(make(chan time.Time, 1)),
+		personStatCallback(make(chan personStat, 1)),
+		unreadConfsCallback(make(chan unreadConfs, 1)),
+		whoAmICallback(make(chan whoAmIResponse, 1)),
+		textResponseCallback(make(chan textResponse, 1)),
+		confResponseCallback(make(chan confResponse, 1)),
+		stringResponseCallback(make(chan stringResponse, 1)),
+		uConfResponseCallback(make(chan uConfResponse, 1)),
+		textStatCallback(make(chan textStatResponse, 1)),
+		membershipCallback(make(chan membershipResponse, 1)),
+		membershipListCallback(make(chan membershipResponse, 1)),
+		textMappingCallback(make(chan textMappingResponse, 1)),
+		queryAsyncCallback(make(chan queryAsyncResponse, 1)),
+		statsDescriptionCallback(make(chan statsDescriptionResponse, 1)),
+		statsCallback(make(chan statsResponse, 1)),
+		schedulingCallback(make(chan schedulingResponse, 1)),
+		infoResponseCallback(make(chan infoResponse, 1)),
+		staticServerInfoCallback(make(chan staticServerInfoResponse, 1)),
+		dynamicSessionsCallback(make(chan dynamicSessionsResponse, 1)),
+		confStatCallback(make(chan confStatResponse, 1)),
+	}
+}
+
+func fuzzReader(data []byte) *komReader {
+	return &komReader{Reader: bufio.NewReader(bytes.NewReader(data)), times: types.UTCTimes}
+}
+
+// Feed arbitrary replies to every callback, and to the asynchronous
+// message decoder. None of them may panic.
+func FuzzCallbacks(f *testing.F) {
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.PanicLevel)
+	f.Cleanup(func() { logrus.SetLevel(level) })
+
+	for _, seed := range []string{
+		"1 { 14HIngrid Bergman 0101 6 }\n",
+		"2 { 1 100 2 200 }\n",
+		"0 *\n",
+		"4294967295 { 1\n",
+		"1 { 9HTruncated",
+		"14 4711\n",
+		"10HHej\nhopp!!\n",
+		"44 5 16 18 9 126 0 290 0 1 2 8 0 2 { 0 1 6 1 } 0 { }\n",
+		"1 0 1 1 { 4 }\n",
+		"3 12 0 6 5HFika?\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, c := range fuzzCallbacks() {
+			c.OK(fuzzReader(data))
+		}
+		for _, c := range fuzzCallbacks() {
+			c.Error(fuzzReader(data))
+		}
+		k := &KomClient{}
+		k.readAsyncMessage(fuzzReader(data))
+	})
+}
@@ -0,0 +1,66 @@
+package protocol_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/komtest"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Look up names, checking which lookups are remembered.
+func TestConfName(t *testing.T) {
+	srv := komtest.NewServer()
+	defer srv.Close()
+	srv.Handle(78, func(req komtest.Request) komtest.Reply {
+		switch req.Args[0] {
+		case "6":
+			return komtest.OK(hollerith.Sprint("Ingrid Bergman"), "10000000", 0, 77)
+		case "7":
+			return komtest.Error(9, 7)
+		}
+		return komtest.Error(6, 0)
+	})
+
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	for _, c := range []struct {
+		conf    types.ConfNo
+		want    string
+		wantErr bool
+	}{
+		{6, "Ingrid Bergman", false},
+		{6, "Ingrid Bergman", false},
+		{7, "", true},
+		{7, "", true},
+		{8, "", true},
+		{8, "", true},
+	} {
+		name, err := client.ConfName(c.conf)
+		if name != c.want || (err != nil) != c.wantErr {
+			t.Errorf("saw %q/%v for conference %d, want %q", name, err, c.conf, c.want)
+		}
+	}
+	var undef protocol.UndefinedConferenceError
+	if _, err := client.ConfName(7); !errors.As(err, &undef) {
+		t.Errorf("saw %v for a remembered undefined conference, want an undefined conference", err)
+	}
+
+	var saw []string
+	for _, req := range srv.Requests() {
+		saw = append(saw, fmt.Sprint(req.Call, " ", strings.Join(req.Args, " ")))
+	}
+	want := []string{"78 6", "78 7", "78 8", "78 8"}
+	if !reflect.DeepEqual(saw, want) {
+		t.Errorf("saw requests %q, want %q", saw, want)
+	}
+}
@@ -4,8 +4,95 @@ package protocol
 
 import (
 	"fmt"
+
+	"github.com/vatine/komandgo/pkg/types"
 )
 
+// Returned when the server does not implement a call.
+type NotImplementedError struct{}
+
+func (e NotImplementedError) Error() string {
+	return "Not implemented."
+}
+
+// Returned when attempting to access a conference that does not
+// exist, or is secret.
+type UndefinedConferenceError struct {
+	Conf types.ConfNo
+}
+
+func (e UndefinedConferenceError) Error() string {
+	return fmt.Sprintf("Non-existent conference, %d", e.Conf)
+}
+
+// Returned when attempting to access a text that does not exist, or
+// is secret.
+type NoSuchTextError struct {
+	Text types.TextNo
+}
+
+func (e NoSuchTextError) Error() string {
+	return fmt.Sprintf("Text %d is not available.", e.Text)
+}
+
+// Returned when attempting to access a local text number that does
+// not represent an existing text.
+type NoSuchLocalTextError struct {
+	LocalNo types.TextNo
+}
+
+func (e NoSuchLocalTextError) Error() string {
+	return fmt.Sprintf("No such local text %d", e.LocalNo)
+}
+
+// Returned for requests that cannot be answered, as the connection to
+// the server is gone. Err is what ended the connection.
+type ConnectionLostError struct {
+	Err error
+}
+
+func (e ConnectionLostError) Error() string {
+	return fmt.Sprintf("The connection to the server was lost, %v", e.Err)
+}
+
+func (e ConnectionLostError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when attempting to send an anonymous text to a conference
+// that does not accept anonymous texts.
+type AnonymousRejectedError struct{}
+
+func (e AnonymousRejectedError) Error() string {
+	return "Anonymous texts are not accepted."
+}
+
+// Returned when the caller does not have enough privileges to lower
+// its scheduling priority as far as requested. Lowest is the lowest
+// priority the caller has access to.
+type PriorityDeniedError struct {
+	Lowest uint32
+}
+
+func (e PriorityDeniedError) Error() string {
+	return fmt.Sprintf("Priority denied, lowest available priority is %d", e.Lowest)
+}
+
+// Returned when the caller does not have enough privileges to set the
+// requested scheduling weight.
+type WeightDeniedError struct{}
+
+func (e WeightDeniedError) Error() string {
+	return "Weight denied."
+}
+
+// Returned when attempting to set a scheduling weight of zero.
+type WeightZeroError struct{}
+
+func (e WeightZeroError) Error() string {
+	return "Scheduling weight must be non-zero."
+}
+
 // Return an error that formats to a sensible error message based on
 // the protocol A error code and status.
 func protocolError(code, status uint32) error {
@@ -17,7 +104,7 @@ func protocolError(code, status uint32) error {
 	case code == 2:
 		// not-implemented (2)
 		//   The call has not been implemented yet. error-status is undefined.
-		return fmt.Errorf("Not implemented.")
+		return NotImplementedError{}
 	case code == 3:
 		// obsolete-call (3)
 		//   The call is obsolete and no longer implemented. error-status is undefined.
@@ -45,7 +132,7 @@ func protocolError(code, status uint32) error {
 	case code == 9:
 		// undefined-conference (9)
 		//   Attempt to access a non-existent or secret conference. error-status contains the conference number in question.
-		return fmt.Errorf("Non-existent conference, %d", status)
+		return UndefinedConferenceError{Conf: types.ConfNo(status)}
 	case code == 10:
 		// undefined-person (10)
 		//   Attempt to access a non-existent or secret person. error-status contains the person number in question.
@@ -65,7 +152,7 @@ func protocolError(code, status uint32) error {
 	case code == 14:
 		// no-such-text (14)
 		//   Attempt to access a text that either does not exist or is secret in some way. error-status indicates the text number in question.
-		return fmt.Errorf("Text %d is not available.", status)
+		return NoSuchTextError{Text: types.TextNo(status)}
 	case code == 15:
 		// text-zero (15)
 		//   Attempt to use text number 0. error-status is undefined.
@@ -73,7 +160,7 @@ func protocolError(code, status uint32) error {
 	case code == 16:
 		// no-such-local-text (16)
 		//   Attempt to access a text using a local text number that does not represent an existing text. error-status indicates the offending number.
-		return fmt.Errorf("No such local text %d", status)
+		return NoSuchLocalTextError{LocalNo: types.TextNo(status)}
 	case code == 17:
 		// local-text-zero (17)
 		//   Attempt to use local text number zero. error-status is undefined.
@@ -140,8 +227,10 @@ func protocolError(code, status uint32) error {
 		//   Temporary failure. Try again later. error-status is undefined.
 		// long-array (46)
 		//   An array sent to the server was too long. error-status is undefined.
+	case code == 47:
 		// anonymous-rejected (47)
 		//   Attempt to send an anonymous text to a conference that does not accept anonymous texts. error-status is undefined.
+		return AnonymousRejectedError{}
 		// illegal-aux-item (48)
 		//   Attempt to create an invalid aux-item. Probably the tag or data are invalid. error-status contains the index in the aux-item list where the invalid item appears.
 		// aux-item-permission (49)
@@ -162,12 +251,18 @@ func protocolError(code, status uint32) error {
 		//   The lower limit of a supplied range is not greater than the upper limit of the previous range in the list. error-status is undefined.
 		// undefined-measurement (57)
 		//   A request for a measurement that the server doesn't make has been made. error-status is undefined.
+	case code == 58:
 		// priority-denied (58)
 		//   You don't have enough privileges to lower your priority. error-status indicates the lowest priority that you have access to.
+		return PriorityDeniedError{Lowest: status}
+	case code == 59:
 		// weight-denied (59)
 		//   You don't have enough privileges to set the specified weight.
+		return WeightDeniedError{}
+	case code == 60:
 		// weight-zero (60)
 		//   The scheduling weight must be non-zero. error-status is undefined.
+		return WeightZeroError{}
 		// bad-bool (61)
 		//   An argument of type BOOL was given a value that is neither 0 nor 1. error-status is undefined.
 	}
@@ -0,0 +1,92 @@
+package protocol
+
+// Iteration over all conferences and persons on a server
+
+import (
+	"errors"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// A ConfIterator walks over every conference (and person) visible to
+// the session, in increasing conference number order. It is used
+// like a bufio.Scanner:
+//
+//	it := client.Conferences()
+//	for it.Next() {
+//		fmt.Println(it.ConfNo(), it.UConference().Name)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ConfIterator struct {
+	client  *KomClient
+	current types.ConfNo
+	uConf   types.UConference
+	err     error
+	done    bool
+}
+
+// Return an iterator over all conferences visible to the session.
+func (k *KomClient) Conferences() *ConfIterator {
+	return &ConfIterator{client: k}
+}
+
+// Advance to the next visible conference, returning false when there
+// are no more conferences or an error occurred.
+func (it *ConfIterator) Next() bool {
+	for !it.done {
+		next, err := it.client.FindNextConfNo(it.current)
+		if err != nil {
+			it.finish(err)
+			return false
+		}
+		if next <= it.current {
+			// A well-behaved server never does this, but make
+			// sure we terminate if it does.
+			it.done = true
+			return false
+		}
+		it.current = next
+
+		uConf, err := it.client.GetUConfStat(next)
+		if err != nil {
+			var undef UndefinedConferenceError
+			if errors.As(err, &undef) {
+				// Deleted between the two calls, skip it.
+				continue
+			}
+			it.finish(err)
+			return false
+		}
+		it.uConf = uConf
+		return true
+	}
+
+	return false
+}
+
+// Stop iterating, recording err unless it just signals the end of
+// the conferences.
+func (it *ConfIterator) finish(err error) {
+	var undef UndefinedConferenceError
+	if !errors.As(err, &undef) {
+		it.err = err
+	}
+	it.done = true
+}
+
+// The conference number the iterator is at.
+func (it *ConfIterator) ConfNo() types.ConfNo {
+	return it.current
+}
+
+// The status of the conference the iterator is at.
+func (it *ConfIterator) UConference() types.UConference {
+	return it.uConf
+}
+
+// The error that stopped the iteration, if any.
+func (it *ConfIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,148 @@
+package protocol_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/komtest"
+	"github.com/vatine/komandgo/pkg/protocol"
+)
+
+// Walk the conferences of a server, with find-next-conf-no (#116) and
+// get-uconf-stat (#78) answered from per-case tables.
+func TestConferences(t *testing.T) {
+	uConf := func(name string) komtest.Reply {
+		return komtest.OK(hollerith.Sprint(name), "00000000", 0, 77)
+	}
+	undefined := func(conf uint32) komtest.Reply {
+		return komtest.Error(9, conf)
+	}
+
+	cases := []struct {
+		name     string
+		next     map[string]komtest.Reply
+		uConfs   map[string]komtest.Reply
+		want     []string
+		wantErr  bool
+		requests []string
+	}{
+		{
+			name:     "walk order",
+			next:     map[string]komtest.Reply{"0": komtest.OK(1), "1": komtest.OK(5), "5": komtest.OK(9), "9": undefined(9)},
+			uConfs:   map[string]komtest.Reply{"1": uConf("Presentation"), "5": uConf("Ingrid"), "9": uConf("Filmklubben")},
+			want:     []string{"1 Presentation", "5 Ingrid", "9 Filmklubben"},
+			requests: []string{"116 0", "78 1", "116 1", "78 5", "116 5", "78 9", "116 9"},
+		},
+		{
+			name:     "undefined conference skipped",
+			next:     map[string]komtest.Reply{"0": komtest.OK(1), "1": komtest.OK(5), "5": komtest.OK(9), "9": undefined(9)},
+			uConfs:   map[string]komtest.Reply{"1": uConf("Presentation"), "5": undefined(5), "9": uConf("Filmklubben")},
+			want:     []string{"1 Presentation", "9 Filmklubben"},
+			requests: []string{"116 0", "78 1", "116 1", "78 5", "116 5", "78 9", "116 9"},
+		},
+		{
+			name:     "non-increasing number",
+			next:     map[string]komtest.Reply{"0": komtest.OK(5), "5": komtest.OK(5)},
+			uConfs:   map[string]komtest.Reply{"5": uConf("Ingrid")},
+			want:     []string{"5 Ingrid"},
+			requests: []string{"116 0", "78 5", "116 5"},
+		},
+		{
+			name:     "find-next-conf-no error",
+			next:     map[string]komtest.Reply{"0": komtest.OK(1), "1": komtest.Error(6, 0)},
+			uConfs:   map[string]komtest.Reply{"1": uConf("Presentation")},
+			want:     []string{"1 Presentation"},
+			wantErr:  true,
+			requests: []string{"116 0", "78 1", "116 1"},
+		},
+		{
+			name:     "get-uconf-stat error",
+			next:     map[string]komtest.Reply{"0": komtest.OK(1), "1": komtest.OK(5)},
+			uConfs:   map[string]komtest.Reply{"1": uConf("Presentation"), "5": komtest.Error(6, 0)},
+			want:     []string{"1 Presentation"},
+			wantErr:  true,
+			requests: []string{"116 0", "78 1", "116 1", "78 5"},
+		},
+	}
+
+	for _, c := range cases {
+		srv := komtest.NewServer()
+		lookup := func(table map[string]komtest.Reply) komtest.Handler {
+			return func(req komtest.Request) komtest.Reply {
+				if reply, ok := table[req.Args[0]]; ok {
+					return reply
+				}
+				return komtest.Error(2, 0)
+			}
+		}
+		srv.Handle(116, lookup(c.next))
+		srv.Handle(78, lookup(c.uConfs))
+
+		client, err := srv.Client()
+		if err != nil {
+			srv.Close()
+			t.Fatalf("%s: unexpected error %v", c.name, err)
+		}
+
+		var saw []string
+		it := client.Conferences()
+		for it.Next() {
+			saw = append(saw, fmt.Sprint(it.ConfNo(), " ", it.UConference().Name))
+		}
+		if !reflect.DeepEqual(saw, c.want) {
+			t.Errorf("%s: saw conferences %q, want %q", c.name, saw, c.want)
+		}
+		if err := it.Err(); (err != nil) != c.wantErr {
+			t.Errorf("%s: saw error %v, want an error: %v", c.name, err, c.wantErr)
+		}
+		if it.Next() {
+			t.Errorf("%s: Next returned true after the end", c.name)
+		}
+
+		var requests []string
+		for _, req := range srv.Requests() {
+			requests = append(requests, fmt.Sprint(req.Call, " ", strings.Join(req.Args, " ")))
+		}
+		if !reflect.DeepEqual(requests, c.requests) {
+			t.Errorf("%s: saw requests %q, want %q", c.name, requests, c.requests)
+		}
+
+		client.Close()
+		srv.Close()
+	}
+}
+
+// The single conference-number calls, first-unused-conf-no (#114)
+// and find-previous-conf-no (#117).
+func TestConfNumbers(t *testing.T) {
+	srv := komtest.NewServer()
+	defer srv.Close()
+	srv.HandleOK(114, 10)
+	srv.Handle(117, func(req komtest.Request) komtest.Reply {
+		if req.Args[0] == "1" {
+			return komtest.Error(9, 1)
+		}
+		return komtest.OK(1)
+	})
+
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	if conf, err := client.FirstUnusedConfNo(); err != nil || conf != 10 {
+		t.Errorf("saw %d/%v from first-unused-conf-no, want 10", conf, err)
+	}
+	if conf, err := client.FindPreviousConfNo(5); err != nil || conf != 1 {
+		t.Errorf("saw %d/%v from find-previous-conf-no, want 1", conf, err)
+	}
+	var undef protocol.UndefinedConferenceError
+	if _, err := client.FindPreviousConfNo(1); !errors.As(err, &undef) {
+		t.Errorf("saw %v before the first conference, want an undefined conference", err)
+	}
+}
@@ -0,0 +1,47 @@
+package protocol_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/komtest"
+)
+
+// Both lookups take the persons flag before the conferences flag, as
+// they are sent.
+func TestLookupFlags(t *testing.T) {
+	srv := komtest.NewServer()
+	defer srv.Close()
+	srv.HandleOK(74, 0, "*")
+	srv.HandleOK(76, 0, "*")
+
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.LookupZName("ingrid", true, false); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := client.LookupZName("film", false, true); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := client.ReZLookup("^ingrid", true, false); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := client.ReZLookup("^film", false, true); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	var saw []string
+	for _, req := range srv.Requests() {
+		saw = append(saw, fmt.Sprint(req.Call, " ", strings.Join(req.Args, " ")))
+	}
+	want := []string{"76 ingrid 1 0", "76 film 0 1", "74 ^ingrid 1 0", "74 ^film 0 1"}
+	if !reflect.DeepEqual(saw, want) {
+		t.Errorf("saw requests %q, want %q", saw, want)
+	}
+}
@@ -0,0 +1,264 @@
+package protocol
+
+// Managing marked texts, the LysKOM equivalent of bookmarks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The type of a mark. The server attaches no meaning to mark types.
+// MarkDefault is the type clients use when no other is asked for,
+// the other named types are this library's own convention, and other
+// clients may use the same numbers for something else.
+type MarkType uint8
+
+const (
+	MarkImportant = MarkType(1)
+	MarkTodo      = MarkType(2)
+	MarkReference = MarkType(3)
+	MarkFunny     = MarkType(4)
+	MarkDefault   = MarkType(100)
+)
+
+var markTypeNames = map[MarkType]string{
+	MarkImportant: "important",
+	MarkTodo:      "todo",
+	MarkReference: "reference",
+	MarkFunny:     "funny",
+	MarkDefault:   "default",
+}
+
+// Return the name of a mark type, or a string of the form "mark-<n>"
+// for types without a name.
+func (m MarkType) String() string {
+	if name, ok := markTypeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("mark-%d", m)
+}
+
+// Return the mark type with a given name, accepting both the names
+// returned by String and plain numbers.
+func MarkTypeFromName(name string) (MarkType, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for m, n := range markTypeNames {
+		if n == name {
+			return m, true
+		}
+	}
+
+	n, err := strconv.ParseUint(strings.TrimPrefix(name, "mark-"), 10, 8)
+	if err != nil {
+		return 0, false
+	}
+	return MarkType(n), true
+}
+
+// The calls the marks service needs. A *KomClient is one.
+type MarkSource interface {
+	GetMarks() ([]types.Mark, error)
+	MarkText(text types.TextNo, markType uint8) error
+	UnmarkText(text types.TextNo) error
+	GetTextStat(text types.TextNo) (types.TextStat, error)
+	GetSubject(text types.TextNo, stat types.TextStat) (string, error)
+	GetUConfStat(conf types.ConfNo) (types.UConference, error)
+}
+
+// A marked text, together with what is needed to show it in a list.
+// Texts that have been deleted, or are no longer readable, are listed
+// with Missing set.
+type MarkEntry struct {
+	TextNo     types.TextNo `json:"text"`
+	Type       MarkType     `json:"type"`
+	Category   string       `json:"category,omitempty"`
+	Subject    string       `json:"subject,omitempty"`
+	Author     types.ConfNo `json:"author,omitempty"`
+	AuthorName string       `json:"author-name,omitempty"`
+	Missing    bool         `json:"missing,omitempty"`
+}
+
+// The document written by Marks.Export and read by Marks.Import.
+type markExport struct {
+	Marks []MarkEntry `json:"marks"`
+}
+
+// The Marks service manages the marks of the logged-in person.
+type Marks struct {
+	source MarkSource
+}
+
+// Return the marks service for the logged-in person.
+func (k *KomClient) Marks() *Marks {
+	return NewMarks(k)
+}
+
+// Return a marks service, using source to talk to the server.
+func NewMarks(source MarkSource) *Marks {
+	return &Marks{source: source}
+}
+
+// List all marked texts, in text number order, with their subjects
+// and authors. Texts that cannot be read are listed as missing, only
+// losing the connection to the server fails the listing.
+func (m *Marks) List() ([]MarkEntry, error) {
+	marks, err := m.source.GetMarks()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[types.ConfNo]string)
+	rv := make([]MarkEntry, 0, len(marks))
+	for _, mark := range marks {
+		entry := MarkEntry{
+			TextNo:   mark.TextNo,
+			Type:     MarkType(mark.Type),
+			Category: MarkType(mark.Type).String(),
+		}
+
+		stat, err := m.source.GetTextStat(mark.TextNo)
+		var subject string
+		if err == nil {
+			subject, err = m.source.GetSubject(mark.TextNo, stat)
+		}
+		if err != nil {
+			var lost ConnectionLostError
+			if errors.As(err, &lost) {
+				return rv, err
+			}
+			entry.Missing = true
+			rv = append(rv, entry)
+			continue
+		}
+		entry.Author = stat.Author
+		entry.Subject = subject
+
+		name, ok := names[stat.Author]
+		if !ok {
+			if uConf, err := m.source.GetUConfStat(stat.Author); err == nil {
+				name = uConf.Name
+			}
+			names[stat.Author] = name
+		}
+		entry.AuthorName = name
+
+		rv = append(rv, entry)
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].TextNo < rv[j].TextNo })
+	return rv, nil
+}
+
+// List the marked texts of a given type.
+func (m *Marks) ListType(markType MarkType) ([]MarkEntry, error) {
+	all, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []MarkEntry
+	for _, entry := range all {
+		if entry.Type == markType {
+			rv = append(rv, entry)
+		}
+	}
+	return rv, nil
+}
+
+// Mark a number of texts. All texts are attempted, and the first
+// error (if any) is returned.
+func (m *Marks) Mark(markType MarkType, texts ...types.TextNo) error {
+	var rv error
+	for _, text := range texts {
+		if err := m.source.MarkText(text, uint8(markType)); err != nil && rv == nil {
+			rv = fmt.Errorf("marking text %d: %w", text, err)
+		}
+	}
+	return rv
+}
+
+// Unmark a number of texts. All texts are attempted, and the first
+// error (if any) is returned.
+func (m *Marks) Unmark(texts ...types.TextNo) error {
+	var rv error
+	for _, text := range texts {
+		if err := m.source.UnmarkText(text); err != nil && rv == nil {
+			rv = fmt.Errorf("unmarking text %d: %w", text, err)
+		}
+	}
+	return rv
+}
+
+// Unmark all texts of a given type.
+func (m *Marks) UnmarkType(markType MarkType) error {
+	marks, err := m.source.GetMarks()
+	if err != nil {
+		return err
+	}
+
+	var texts []types.TextNo
+	for _, mark := range marks {
+		if MarkType(mark.Type) == markType {
+			texts = append(texts, mark.TextNo)
+		}
+	}
+	return m.Unmark(texts...)
+}
+
+// Write all marks as a JSON document, of the form
+//
+//	{"marks": [
+//	  {"text": 4711, "type": 100, "category": "default", "subject": "Hello", "author": 6, "author-name": "Ingrid"}
+//	]}
+//
+// Subjects, authors and categories are only there for the benefit of
+// humans, and are ignored by Import. Only the type numbers are kept,
+// and the category names are this library's, see MarkType.
+func (m *Marks) Export(w io.Writer) error {
+	entries, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(markExport{Marks: entries})
+}
+
+// Read a JSON document written by Export, and mark the texts in it.
+// Texts that are not available to the logged-in person are skipped.
+// Returns the number of texts marked.
+func (m *Marks) Import(r io.Reader) (int, error) {
+	var doc markExport
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for _, entry := range doc.Marks {
+		if entry.TextNo == 0 {
+			return marked, fmt.Errorf("Mark without a text number")
+		}
+		err := m.source.MarkText(entry.TextNo, uint8(entry.Type))
+		if err != nil {
+			var noText NoSuchTextError
+			if errors.As(err, &noText) {
+				continue
+			}
+			return marked, fmt.Errorf("marking text %d: %w", entry.TextNo, err)
+		}
+		marked++
+	}
+
+	return marked, nil
+}
@@ -0,0 +1,181 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// A MarkSource holding a few texts, 17 is the only existing person.
+type fakeMarks struct {
+	marks map[types.TextNo]uint8
+	texts map[types.TextNo]types.Text
+	// A text whose contents cannot be fetched
+	unreadable types.TextNo
+}
+
+func (f *fakeMarks) GetMarks() ([]types.Mark, error) {
+	var rv []types.Mark
+	for text, markType := range f.marks {
+		rv = append(rv, types.Mark{TextNo: text, Type: markType})
+	}
+	return rv, nil
+}
+
+func (f *fakeMarks) MarkText(text types.TextNo, markType uint8) error {
+	if _, ok := f.texts[text]; !ok {
+		return NoSuchTextError{Text: text}
+	}
+	f.marks[text] = markType
+	return nil
+}
+
+func (f *fakeMarks) UnmarkText(text types.TextNo) error {
+	if _, ok := f.marks[text]; !ok {
+		return protocolError(44, uint32(text))
+	}
+	delete(f.marks, text)
+	return nil
+}
+
+func (f *fakeMarks) GetTextStat(text types.TextNo) (types.TextStat, error) {
+	if _, ok := f.texts[text]; !ok {
+		return types.TextStat{}, NoSuchTextError{Text: text}
+	}
+	return types.TextStat{Author: 17}, nil
+}
+
+func (f *fakeMarks) GetSubject(text types.TextNo, stat types.TextStat) (string, error) {
+	if text == f.unreadable {
+		return "", protocolError(14, uint32(text))
+	}
+	return f.texts[text].Subject, nil
+}
+
+func (f *fakeMarks) GetUConfStat(conf types.ConfNo) (types.UConference, error) {
+	if conf != 17 {
+		return types.UConference{}, UndefinedConferenceError{Conf: conf}
+	}
+	return types.UConference{Name: "Ingrid"}, nil
+}
+
+func newFakeMarks() *fakeMarks {
+	return &fakeMarks{
+		marks: map[types.TextNo]uint8{100: 100, 102: 2, 4711: 100},
+		texts: map[types.TextNo]types.Text{
+			100: types.ParseText("First\nbody", ""),
+			101: types.ParseText("Second\nbody", ""),
+			102: types.ParseText("Third\nbody", ""),
+		},
+	}
+}
+
+func TestMarkTypeNames(t *testing.T) {
+	cases := []struct {
+		markType MarkType
+		name     string
+	}{
+		{MarkDefault, "default"},
+		{MarkTodo, "todo"},
+		{MarkType(17), "mark-17"},
+	}
+
+	for ix, c := range cases {
+		if saw := c.markType.String(); saw != c.name {
+			t.Errorf("Case #%d, saw %s, want %s", ix, saw, c.name)
+		}
+		if saw, ok := MarkTypeFromName(c.name); !ok || saw != c.markType {
+			t.Errorf("Case #%d, saw %d/%v, want %d", ix, saw, ok, c.markType)
+		}
+	}
+
+	if saw, ok := MarkTypeFromName("42"); !ok || saw != 42 {
+		t.Errorf("saw %d/%v, want 42", saw, ok)
+	}
+	if _, ok := MarkTypeFromName("bogus"); ok {
+		t.Errorf("expected bogus to be an unknown mark type")
+	}
+}
+
+func TestMarksList(t *testing.T) {
+	marks := NewMarks(newFakeMarks())
+
+	entries, err := marks.List()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []MarkEntry{
+		{TextNo: 100, Type: MarkDefault, Category: "default", Subject: "First", Author: 17, AuthorName: "Ingrid"},
+		{TextNo: 102, Type: MarkTodo, Category: "todo", Subject: "Third", Author: 17, AuthorName: "Ingrid"},
+		{TextNo: 4711, Type: MarkDefault, Category: "default", Missing: true},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("saw %+v, want %+v", entries, want)
+	}
+
+	source := newFakeMarks()
+	source.unreadable = 100
+	entries, err = NewMarks(source).List()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(entries) != 3 || !entries[0].Missing || entries[1].Missing {
+		t.Errorf("saw %+v, want text 100 missing", entries)
+	}
+
+	todo, err := marks.ListType(MarkTodo)
+	if err != nil || len(todo) != 1 || todo[0].TextNo != 102 {
+		t.Errorf("saw %+v/%v, want text 102", todo, err)
+	}
+}
+
+func TestMarksBulk(t *testing.T) {
+	source := newFakeMarks()
+	marks := NewMarks(source)
+
+	if err := marks.Mark(MarkReference, 101, 4712, 102); err == nil {
+		t.Errorf("expected an error marking text 4712")
+	}
+	if source.marks[101] != 3 || source.marks[102] != 3 {
+		t.Errorf("expected 101 and 102 to be marked, saw %v", source.marks)
+	}
+
+	if err := marks.UnmarkType(MarkReference); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := map[types.TextNo]uint8{100: 100, 4711: 100}
+	if !reflect.DeepEqual(source.marks, want) {
+		t.Errorf("saw %v, want %v", source.marks, want)
+	}
+}
+
+func TestMarksExportImport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewMarks(newFakeMarks()).Export(&buf); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !strings.Contains(buf.String(), `"subject": "Third"`) {
+		t.Errorf("expected subjects in the export, saw %s", buf.String())
+	}
+
+	target := newFakeMarks()
+	target.marks = make(map[types.TextNo]uint8)
+	n, err := NewMarks(target).Import(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n != 2 {
+		t.Errorf("saw %d texts imported, want 2", n)
+	}
+	want := map[types.TextNo]uint8{100: 100, 102: 2}
+	if !reflect.DeepEqual(target.marks, want) {
+		t.Errorf("saw %v, want %v", target.marks, want)
+	}
+
+	if _, err := NewMarks(target).Import(strings.NewReader(`{"marks": [{"text": 1, "colour": "red"}]}`)); err == nil {
+		t.Errorf("expected an error for unknown fields")
+	}
+}
@@ -2,9 +2,276 @@
 
 package protocol
 
-// import (
-// 	"io"
-//
-// 	"github.com/vatine/komandgo/pkg/hollerith"
-// 	"github.com/vatine/komandgo/pkg/types"
-// )
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/types"
+	"github.com/vatine/komandgo/pkg/utils"
+)
+
+// Return the on-the-wire representation of an aux-item-input array.
+func auxItemInputArray(items []types.AuxItemInput) string {
+	var b strings.Builder
+	w := &b
+
+	fmt.Fprintf(w, "%d { ", len(items))
+	for _, item := range items {
+		fmt.Fprintf(w, "%d %s %d %s ", item.Tag, item.Flags.Repr(), item.InheritLimit, hollerith.Sprint(item.Data()))
+	}
+	fmt.Fprintf(w, "}")
+
+	return w.String()
+}
+
+// Read the start of an array, returning the number of elements and
+// whether the elements are actually present (the server sends "*"
+// instead of the array contents when it has been asked not to send
+// them).
+func readArrayStart(r io.Reader) (uint32, bool, error) {
+	n := readUInt32(r)
+	for {
+		b, err := utils.ReadByte(r)
+		if err != nil {
+			return n, false, err
+		}
+		switch b {
+		case ' ', '\n':
+			continue
+		case '{':
+			return n, true, nil
+		case '*':
+			return n, false, nil
+		default:
+			return n, false, fmt.Errorf("Unexpected array start '%c'", b)
+		}
+	}
+}
+
+// Consume the end of an array, up to and including the closing brace.
+func readArrayEnd(r io.Reader) error {
+	for {
+		b, err := utils.ReadByte(r)
+		if err != nil {
+			return err
+		}
+		switch b {
+		case ' ', '\n':
+			continue
+		case '}':
+			return nil
+		default:
+			return fmt.Errorf("Unexpected array end '%c'", b)
+		}
+	}
+}
+
+// Read a 4-bit conference type (as sent in a conf-z-info) from a
+// reader.
+func readConfType(r io.Reader) (types.ConfType, error) {
+	var rv types.ConfType
+	var bits [4]bool
+
+	b, err := skipWhitespace(r)
+	for ix := range bits {
+		if ix > 0 && err == nil {
+			b, err = utils.ReadByte(r)
+		}
+		if err != nil {
+			return rv, err
+		}
+		if b != '0' && b != '1' {
+			return rv, fmt.Errorf("Unexpected conference type bit '%c'", b)
+		}
+		bits[ix] = b == '1'
+	}
+
+	rv.RdProt = bits[0]
+	rv.Original = bits[1]
+	rv.Secret = bits[2]
+	rv.LetterBox = bits[3]
+
+	return rv, nil
+}
+
+// Read a single aux item from a reader.
+func readAuxItem(r io.Reader) (types.AuxItem, error) {
+	var rv types.AuxItem
+
+	rv.AuxNo = types.AuxNo(readUInt32(r))
+	rv.Tag = readUInt32(r)
+	rv.Creator = types.ConfNo(readUInt32(r))
+	rv.CreatedAt = readTime(r)
+	rv.Flags = types.ReadAuxItemFlags(r)
+	rv.InheritLimit = readUInt32(r)
+	data, err := hollerith.Scan(r)
+	rv.SetData(data)
+
+	return rv, err
+}
+
+// Read an array of aux items from a reader.
+func readAuxItemList(r io.Reader) ([]types.AuxItem, error) {
+	n, present, err := readArrayStart(r)
+	if err != nil || !present {
+		return nil, err
+	}
+
+	var rv []types.AuxItem
+	for ix := uint32(0); ix < n; ix++ {
+		item, err := readAuxItem(r)
+		if err != nil {
+			return rv, err
+		}
+		rv = append(rv, item)
+	}
+
+	return rv, readArrayEnd(r)
+}
+
+// Read a single misc-info item from a reader.
+func readMiscInfo(r io.Reader) (types.MiscInfo, error) {
+	var rv types.MiscInfo
+	var err error
+
+	rv.Selector, err = scanUInt32(r)
+	if err != nil {
+		return rv, err
+	}
+	switch types.InfoType(rv.Selector) {
+	case types.Recipient:
+		rv.Recipient = types.ConfNo(readUInt32(r))
+	case types.CCRecipient:
+		rv.CCRecipient = types.ConfNo(readUInt32(r))
+	case types.CommentTo:
+		rv.CommentTo = types.TextNo(readUInt32(r))
+	case types.CommentIn:
+		rv.CommentedIn = types.TextNo(readUInt32(r))
+	case types.FootnoteTo:
+		rv.FootnoteTo = types.TextNo(readUInt32(r))
+	case types.FootnoteIn:
+		rv.FootnotedIn = types.TextNo(readUInt32(r))
+	case types.LocalNo:
+		rv.LocalNo = types.TextNo(readUInt32(r))
+	case types.ReceiveTime:
+		rv.ReceivedAt = readTime(r)
+	case types.SentBy:
+		rv.Sender = types.ConfNo(readUInt32(r))
+	case types.SentAt:
+		rv.SentAt = readTime(r)
+	case types.BCCRecipient:
+		rv.BCCRecipient = types.ConfNo(readUInt32(r))
+	default:
+		return rv, fmt.Errorf("Unknown misc-info selector %d", rv.Selector)
+	}
+
+	return rv, nil
+}
+
+// Read an array of misc-info items from a reader.
+func readMiscInfoList(r io.Reader) ([]types.MiscInfo, error) {
+	n, present, err := readArrayStart(r)
+	if err != nil || !present {
+		return nil, err
+	}
+
+	var rv []types.MiscInfo
+	for ix := uint32(0); ix < n; ix++ {
+		item, err := readMiscInfo(r)
+		if err != nil {
+			return rv, err
+		}
+		rv = append(rv, item)
+	}
+
+	return rv, readArrayEnd(r)
+}
+
+// Read a membership (the current format, as sent by get-membership
+// and query-read-texts) from a reader.
+func readMembership(r io.Reader) (types.Membership, error) {
+	var rv types.Membership
+
+	rv.Position = readUInt32(r)
+	rv.LastTimeRead = readTime(r)
+	rv.Conference = types.ConfNo(readUInt32(r))
+	rv.Priority = byte(readUInt32(r))
+
+	n, present, err := readArrayStart(r)
+	if err != nil {
+		return rv, err
+	}
+	if present {
+		for ix := uint32(0); ix < n; ix++ {
+			first, err := scanUInt32(r)
+			if err != nil {
+				return rv, err
+			}
+			last, err := scanUInt32(r)
+			if err != nil {
+				return rv, err
+			}
+			rv.ReadRanges = append(rv.ReadRanges, types.ReadRange{FirstRead: types.TextNo(first), LastRead: types.TextNo(last)})
+		}
+		if err := readArrayEnd(r); err != nil {
+			return rv, err
+		}
+	}
+
+	rv.AddedBy = types.ConfNo(readUInt32(r))
+	rv.AddedAt = readTime(r)
+	rv.Type = types.ReadMembershipType(r)
+
+	return rv, nil
+}
+
+// Read a local-to-global text mapping from a reader. Dense blocks are
+// converted to pairs, leaving out the local numbers that have no text.
+func readTextMapping(r io.Reader) (types.TextMapping, error) {
+	var rv types.TextMapping
+
+	rv.RangeBegin = types.TextNo(readUInt32(r))
+	rv.RangeEnd = types.TextNo(readUInt32(r))
+	rv.LaterTextsExist = readUInt32(r) != 0
+
+	switch kind := readUInt32(r); kind {
+	case 0:
+		n, present, err := readArrayStart(r)
+		if err != nil || !present {
+			return rv, err
+		}
+		for ix := uint32(0); ix < n; ix++ {
+			local, err := scanUInt32(r)
+			if err != nil {
+				return rv, err
+			}
+			global, err := scanUInt32(r)
+			if err != nil {
+				return rv, err
+			}
+			rv.Texts = append(rv.Texts, types.TextNumberPair{LocalNo: types.TextNo(local), GlobalNo: types.TextNo(global)})
+		}
+		return rv, readArrayEnd(r)
+	case 1:
+		local := types.TextNo(readUInt32(r))
+		n, present, err := readArrayStart(r)
+		if err != nil || !present {
+			return rv, err
+		}
+		for ix := uint32(0); ix < n; ix++ {
+			global, err := scanUInt32(r)
+			if err != nil {
+				return rv, err
+			}
+			if global != 0 {
+				rv.Texts = append(rv.Texts, types.TextNumberPair{LocalNo: local, GlobalNo: types.TextNo(global)})
+			}
+			local++
+		}
+		return rv, readArrayEnd(r)
+	default:
+		return rv, fmt.Errorf("Unknown local-to-global block type %d", kind)
+	}
+}
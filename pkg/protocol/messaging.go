@@ -0,0 +1,128 @@
+package protocol
+
+// Sending and receiving messages between sessions
+
+import (
+	"sync"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The kind of a message, decided by who it was sent to.
+type MessageKind int
+
+const (
+	// Sent to the logged-in person only
+	PersonalMessage MessageKind = iota
+	// Sent to a conference the logged-in person is a member of
+	GroupMessage
+	// Sent to everyone on the server
+	AlarmMessage
+)
+
+func (mk MessageKind) String() string {
+	switch mk {
+	case PersonalMessage:
+		return "personal"
+	case GroupMessage:
+		return "group"
+	case AlarmMessage:
+		return "alarm"
+	}
+	return "unknown"
+}
+
+// A message received from another session.
+type Message struct {
+	Sender    types.ConfNo
+	Recipient types.ConfNo
+	Kind      MessageKind
+	Text      string
+}
+
+// Send a message to a person or conference number (send-message,
+// #53). Recipient zero sends the message to everyone.
+func (k *KomClient) SendMessageTo(recipient types.ConfNo, text string) error {
+	return waitGeneric(k.asyncSendMessage(recipient, text))
+}
+
+// Send a message to a person or conference, looked up by name.
+func (k *KomClient) SendMessage(recipient, text string) error {
+	no, err := k.resolveName(recipient, true, true)
+	if err != nil {
+		return err
+	}
+	return k.SendMessageTo(no, text)
+}
+
+// Send a message to everyone logged in on the server.
+func (k *KomClient) Broadcast(text string) error {
+	return k.SendMessageTo(0, text)
+}
+
+// Classify a message received from another session.
+func (k *KomClient) MessageFrom(m AsyncSendMessage) Message {
+	rv := Message{
+		Sender:    m.Sender,
+		Recipient: m.Recipient,
+		Text:      m.Message,
+		Kind:      GroupMessage,
+	}
+
+	switch {
+	case m.Recipient == 0:
+		rv.Kind = AlarmMessage
+	case m.Recipient == k.Person():
+		rv.Kind = PersonalMessage
+	}
+
+	return rv
+}
+
+// Start receiving messages sent by other sessions. Messages are
+// delivered on the returned channel until the returned function is
+// called, or the connection is lost, after which it is closed.
+func (k *KomClient) Messages() (<-chan Message, func(), error) {
+	// Subscribe first, so that no message arriving once the server
+	// has accepted async-send-message is missed.
+	msgs, unsubscribe := k.Subscribe()
+	if err := k.AcceptAsync(AsyncSendMessageNo); err != nil {
+		unsubscribe()
+		return nil, nil, err
+	}
+
+	rv := make(chan Message, subscriberBuffer)
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			unsubscribe()
+		})
+	}
+
+	go func() {
+		defer close(rv)
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				m, ok := msg.(AsyncSendMessage)
+				if !ok {
+					continue
+				}
+				select {
+				case rv <- k.MessageFrom(m):
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return rv, cancel, nil
+}
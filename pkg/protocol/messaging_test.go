@@ -0,0 +1,169 @@
+package protocol
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+func nextMessage(c <-chan AsyncMessage, t *testing.T) AsyncMessage {
+	select {
+	case msg := <-c:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for an asynchronous message")
+	}
+	return nil
+}
+
+func TestAsyncMessages(t *testing.T) {
+	cl := fakeClient(":3 12 0 6 5Hhello\n:1 99 7Ha\nb c d 17\n%%Unparseable request\n:2 9 6 10\n:2 13 6 10\n:2 15 4711 23 47 19 17 6 97 4 197 1 6 1 10 0 2 { 0 17 6 8 } 0 *\n")
+	msgs, cancel := cl.Subscribe()
+	defer cancel()
+	go cl.receiveLoop()
+
+	want := []AsyncMessage{
+		AsyncSendMessage{Recipient: 0, Sender: 6, Message: "hello"},
+		AsyncLogin{Person: 6, Session: 10},
+		AsyncLogout{Person: 6, Session: 10},
+		AsyncNewText{
+			Text: 4711,
+			Stat: types.TextStat{
+				CreationTime: time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC),
+				Author:       6,
+				Lines:        1,
+				Chars:        10,
+				MiscInfo: []types.MiscInfo{
+					{Selector: uint32(types.Recipient), Recipient: 17},
+					{Selector: uint32(types.LocalNo), LocalNo: 8},
+				},
+			},
+		},
+	}
+	for ix, w := range want {
+		got := nextMessage(msgs, t)
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("Message #%d, saw %+v, want %+v", ix, got, w)
+		}
+	}
+}
+
+// A message that cannot be decoded is skipped to its end, so that
+// what is left of it is not read as a message of its own.
+func TestAsyncDecodeError(t *testing.T) {
+	cl := fakeClient(":7 12 0 6 x :2 13 6 10\n:2 9 6 10\n")
+	msgs, cancel := cl.Subscribe()
+	defer cancel()
+	go cl.receiveLoop()
+
+	want := AsyncLogin{Person: 6, Session: 10}
+	if got := nextMessage(msgs, t); !reflect.DeepEqual(got, want) {
+		t.Errorf("saw %+v, want %+v", got, want)
+	}
+}
+
+func TestMessageKind(t *testing.T) {
+	cl := fakeClient("")
+	cl.person = 6
+
+	cases := []struct {
+		recipient types.ConfNo
+		want      MessageKind
+	}{
+		{0, AlarmMessage},
+		{6, PersonalMessage},
+		{17, GroupMessage},
+	}
+
+	for ix, c := range cases {
+		m := cl.MessageFrom(AsyncSendMessage{Recipient: c.recipient, Sender: 9, Message: "hi"})
+		if m.Kind != c.want {
+			t.Errorf("Case #%d, saw kind %s, want %s", ix, m.Kind, c.want)
+		}
+		if m.Sender != 9 || m.Text != "hi" || m.Recipient != c.recipient {
+			t.Errorf("Case #%d, unexpected message %+v", ix, m)
+		}
+	}
+}
+
+func TestResolveNameFromCache(t *testing.T) {
+	cl := fakeClient("")
+	cl.server = newKomServer()
+	cl.server.addUser("David Byers", 6)
+	cl.server.addConference("Trains (-) Discussion", 11)
+
+	cases := []struct {
+		name           string
+		persons, confs bool
+		want           types.ConfNo
+	}{
+		{"David Byers", true, false, 6},
+		{"David Byers", true, true, 6},
+		{"Trains (-) Discussion", true, true, 11},
+		{"Trains (-) Discussion", false, true, 11},
+	}
+
+	for ix, c := range cases {
+		got, err := cl.resolveName(c.name, c.persons, c.confs)
+		if err != nil || got != c.want {
+			t.Errorf("Case #%d, saw %d (%v), want %d", ix, got, err, c.want)
+		}
+	}
+}
+
+func TestSkipMessage(t *testing.T) {
+	cases := []struct {
+		in   string
+		rest string
+	}{
+		{"1 2 3\nrest", "rest"},
+		{"1 5Ha\nb\nc 2\nrest", "rest"},
+		{"0000 1001 3H\n\n\n\nrest", "rest"},
+	}
+
+	for ix, c := range cases {
+		r := strings.NewReader(c.in)
+		if err := skipMessage(r); err != nil {
+			t.Errorf("Case #%d, unexpected error %v", ix, err)
+		}
+		rest, _ := io.ReadAll(r)
+		if string(rest) != c.rest {
+			t.Errorf("Case #%d, saw rest %q, want %q", ix, rest, c.rest)
+		}
+	}
+}
+
+func TestMessagesCancel(t *testing.T) {
+	k := scriptedClient(t, "")
+	msgs, cancel, err := k.Messages()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	k.deliver(AsyncSendMessage{Recipient: 0, Sender: 6, Message: "Fika?"})
+	if m := <-msgs; m.Kind != AlarmMessage || m.Text != "Fika?" {
+		t.Errorf("saw %+v, want an alarm message", m)
+	}
+
+	// Nobody reads these, the forwarding goroutine must still stop.
+	for ix := 0; ix < 2*subscriberBuffer; ix++ {
+		k.deliver(AsyncSendMessage{Recipient: 0, Sender: 6, Message: "Fika?"})
+	}
+	cancel()
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-msgs:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the message channel was not closed after cancel")
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package protocol
+
+// Retention policies, controlling how long texts and conferences are
+// kept by the server's garbage collector.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// A RetentionPolicy describes how long the texts in a conference are
+// kept. Fields that are nil are left unchanged when the policy is
+// applied.
+type RetentionPolicy struct {
+	Conference    string  `json:"conference"`
+	Nice          *uint32 `json:"garb-nice,omitempty"`
+	KeepCommented *uint32 `json:"keep-commented,omitempty"`
+	Expire        *uint32 `json:"expire,omitempty"`
+}
+
+// Read a list of retention policies from a JSON document, of the form
+//
+//	[
+//	  {"conference": "Archive", "garb-nice": 3650, "keep-commented": 365, "expire": 0},
+//	  {"conference": "Chatter", "garb-nice": 7}
+//	]
+func ReadRetentionPolicies(r io.Reader) ([]RetentionPolicy, error) {
+	var rv []RetentionPolicy
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rv); err != nil {
+		return nil, err
+	}
+
+	for ix, p := range rv {
+		if p.Conference == "" {
+			return nil, fmt.Errorf("Retention policy #%d has no conference", ix)
+		}
+	}
+
+	return rv, nil
+}
+
+// Apply a retention policy, setting garb-nice, keep-commented and
+// expire on the conference as specified. Nothing is changed if the
+// conference name is unknown or ambiguous.
+func (k *KomClient) ApplyRetentionPolicy(p RetentionPolicy) error {
+	conf, err := k.resolveName(p.Conference, false, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.Conference, err)
+	}
+
+	if p.Nice != nil {
+		if err := waitGeneric(k.asyncSetGarbNice(conf, *p.Nice)); err != nil {
+			return fmt.Errorf("%s: setting garb-nice: %w", p.Conference, err)
+		}
+	}
+	if p.KeepCommented != nil {
+		if err := waitGeneric(k.asyncSetKeepCommented(conf, *p.KeepCommented)); err != nil {
+			return fmt.Errorf("%s: setting keep-commented: %w", p.Conference, err)
+		}
+	}
+	if p.Expire != nil {
+		if err := waitGeneric(k.asyncSetExpire(conf, *p.Expire)); err != nil {
+			return fmt.Errorf("%s: setting expire: %w", p.Conference, err)
+		}
+	}
+
+	return nil
+}
+
+// Apply a list of retention policies, stopping at the first error.
+func (k *KomClient) ApplyRetentionPolicies(policies []RetentionPolicy) error {
+	for _, p := range policies {
+		if err := k.ApplyRetentionPolicy(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
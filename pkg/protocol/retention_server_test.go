@@ -0,0 +1,62 @@
+package protocol_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/hollerith"
+	"github.com/vatine/komandgo/pkg/komtest"
+	"github.com/vatine/komandgo/pkg/protocol"
+)
+
+// Apply retention policies against a server, with nothing in the
+// client's name cache.
+func TestApplyRetentionPolicy(t *testing.T) {
+	srv := komtest.NewServer()
+	defer srv.Close()
+
+	confs := map[string]int{"Archive": 17, "Archaeology": 18, "Chatter": 19}
+	srv.Handle(76, func(req komtest.Request) komtest.Reply {
+		var found []string
+		for name, no := range confs {
+			if strings.HasPrefix(strings.ToLower(name), strings.ToLower(req.Args[0])) {
+				found = append(found, hollerith.Sprint(name), "0000", fmt.Sprint(no))
+			}
+		}
+		return komtest.OK(len(found)/3, "{", strings.Join(found, " "), "}")
+	})
+	for _, call := range []uint32{22, 97, 105} {
+		srv.HandleOK(call)
+	}
+
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	nice, keep := uint32(3650), uint32(365)
+	if err := client.ApplyRetentionPolicy(protocol.RetentionPolicy{Conference: "archive", Nice: &nice, KeepCommented: &keep}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, c := range []struct{ name, want string }{
+		{"Arch", "ambiguous"},
+		{"Nowhere", "No conference"},
+	} {
+		err := client.ApplyRetentionPolicy(protocol.RetentionPolicy{Conference: c.name, Nice: &nice})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("saw %v applying a policy to %q, want an error saying %q", err, c.name, c.want)
+		}
+	}
+
+	var saw []string
+	for _, req := range srv.Requests() {
+		saw = append(saw, fmt.Sprint(req.Call, " ", strings.Join(req.Args, " ")))
+	}
+	want := []string{"76 archive 0 1", "22 17 3650", "105 17 365", "76 Arch 0 1", "76 Nowhere 0 1"}
+	if !reflect.DeepEqual(saw, want) {
+		t.Errorf("saw requests %q, want %q", saw, want)
+	}
+}
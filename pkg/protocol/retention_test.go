@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadRetentionPolicies(t *testing.T) {
+	cases := []struct {
+		in   string
+		want int
+		err  bool
+	}{
+		{`[{"conference": "Archive", "garb-nice": 3650, "keep-commented": 365, "expire": 0}, {"conference": "Chatter", "garb-nice": 7}]`, 2, false},
+		{`[]`, 0, false},
+		{`[{"garb-nice": 7}]`, 0, true},
+		{`[{"conference": "Archive", "nice": 7}]`, 0, true},
+		{`{"conference": "Archive"}`, 0, true},
+	}
+
+	for ix, c := range cases {
+		got, err := ReadRetentionPolicies(strings.NewReader(c.in))
+		if (err != nil) != c.err {
+			t.Errorf("Case #%d, unexpected error state %v", ix, err)
+			continue
+		}
+		if len(got) != c.want {
+			t.Errorf("Case #%d, saw %d policies, want %d", ix, len(got), c.want)
+		}
+	}
+
+	got, _ := ReadRetentionPolicies(strings.NewReader(cases[0].in))
+	if got[1].KeepCommented != nil || got[1].Expire != nil || *got[1].Nice != 7 {
+		t.Errorf("unexpected second policy %+v", got[1])
+	}
+	if *got[0].Expire != 0 || *got[0].KeepCommented != 365 {
+		t.Errorf("unexpected first policy %+v", got[0])
+	}
+}
+
+func TestRetentionRequests(t *testing.T) {
+	c := fakeClient("")
+
+	c.asyncSetExpire(17, 30)
+	c.asyncSetKeepCommented(17, 365)
+	want := "0 97 17 30\n1 105 17 365\n"
+	if saw := c.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+}
@@ -0,0 +1,42 @@
+package protocol_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/komtest"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Get and set the scheduling of a session, checking the calls sent.
+func TestScheduling(t *testing.T) {
+	srv := komtest.NewServer()
+	defer srv.Close()
+	srv.HandleOK(118, 2, 4)
+	srv.HandleOK(119)
+
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer client.Close()
+
+	info, err := client.GetScheduling(6)
+	if want := (types.SchedulingInfo{Priority: 2, Weight: 4}); err != nil || info != want {
+		t.Errorf("saw %+v/%v, want %+v", info, err, want)
+	}
+	if err := client.SetScheduling(6, types.SchedulingInfo{Priority: 1, Weight: 8}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	var saw []string
+	for _, req := range srv.Requests() {
+		saw = append(saw, fmt.Sprint(req.Call, " ", strings.Join(req.Args, " ")))
+	}
+	want := []string{"118 6", "119 6 1 8"}
+	if !reflect.DeepEqual(saw, want) {
+		t.Errorf("saw requests %q, want %q", saw, want)
+	}
+}
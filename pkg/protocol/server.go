@@ -6,7 +6,9 @@ package protocol
 
 import (
 	"sync"
+	"time"
 
+	"github.com/vatine/komandgo/pkg/charset"
 	"github.com/vatine/komandgo/pkg/types"
 )
 
@@ -17,6 +19,9 @@ type KomServer struct {
 	userNameMap    map[string]types.ConfNo
 	conferenceLock sync.Mutex
 	conferenceMap  map[string]types.ConfNo
+	settingsLock   sync.Mutex
+	location       *time.Location
+	charset        string
 }
 
 var serverLock sync.Mutex
@@ -30,16 +35,28 @@ func GetServer(name string) (*KomServer, error) {
 
 	s, ok := serverMap[name]
 	if !ok {
-		s = new(KomServer)
-		s.userNameMap = make(map[string]types.ConfNo)
-		s.client, err = internalNewClient(name, s)
+		s = newKomServer()
+		s.client, err = internalNewClient(name, s, nil)
 		if err != nil {
 			return nil, err
 		}
+		if serverMap == nil {
+			serverMap = make(map[string]*KomServer)
+		}
+		serverMap[name] = s
 	}
 	return s, nil
 }
 
+func newKomServer() *KomServer {
+	return &KomServer{
+		userNameMap:   make(map[string]types.ConfNo),
+		conferenceMap: make(map[string]types.ConfNo),
+		location:      time.Local,
+		charset:       charset.Fallback,
+	}
+}
+
 func (ks *KomServer) LookupUser(user string) (types.ConfNo, bool) {
 	ks.personLock.Lock()
 	defer ks.personLock.Unlock()
@@ -53,3 +70,55 @@ func (ks *KomServer) LookupConference(user string) (types.ConfNo, bool) {
 	c, ok := ks.conferenceMap[user]
 	return c, ok
 }
+
+// Remember the number of a person, for later name lookups.
+func (ks *KomServer) addUser(user string, no types.ConfNo) {
+	ks.personLock.Lock()
+	defer ks.personLock.Unlock()
+	ks.userNameMap[user] = no
+}
+
+// Remember the number of a conference, for later name lookups.
+func (ks *KomServer) addConference(name string, no types.ConfNo) {
+	ks.conferenceLock.Lock()
+	defer ks.conferenceLock.Unlock()
+	ks.conferenceMap[name] = no
+}
+
+// Return the time zone the server sends times in, for connections
+// that have not asked for UTC.
+func (ks *KomServer) Location() *time.Location {
+	ks.settingsLock.Lock()
+	defer ks.settingsLock.Unlock()
+	return ks.location
+}
+
+// Set the time zone the server sends times in. This defaults to the
+// local time zone of the client.
+func (ks *KomServer) SetLocation(loc *time.Location) {
+	ks.settingsLock.Lock()
+	defer ks.settingsLock.Unlock()
+	ks.location = loc
+}
+
+// Return the character set the server uses for names, messages and
+// texts without an explicit character set.
+func (ks *KomServer) Charset() string {
+	ks.settingsLock.Lock()
+	defer ks.settingsLock.Unlock()
+	return ks.charset
+}
+
+// Set the character set the server uses, this defaults to ISO-8859-1
+// as that is what Protocol A specifies.
+func (ks *KomServer) SetCharset(name string) error {
+	cs, ok := charset.Canonical(name)
+	if !ok {
+		return charset.CharsetError("Unsupported character set " + name)
+	}
+
+	ks.settingsLock.Lock()
+	defer ks.settingsLock.Unlock()
+	ks.charset = cs
+	return nil
+}
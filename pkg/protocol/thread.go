@@ -0,0 +1,202 @@
+package protocol
+
+// Traversal of comment trees, in the order the Elisp client reads them
+
+import (
+	"errors"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Anything that can fetch the status of a text. A *KomClient is one,
+// and tests (or exporters working from a cache) can provide their own.
+type TextStatSource interface {
+	GetTextStat(text types.TextNo) (types.TextStat, error)
+}
+
+// A text in a thread, as seen by a ThreadIterator.
+type ThreadEntry struct {
+	TextNo   types.TextNo
+	Parent   types.TextNo // zero for the root of the thread
+	Depth    int
+	Footnote bool // true if the text is a footnote to its parent
+	Stat     types.TextStat
+}
+
+// A ThreadIterator walks a comment tree depth first, in
+// "read-next-comment" order: a text is followed by its footnotes, then
+// by each of its comments and everything below them. Texts are
+// fetched lazily, each text is visited at most once (so cycles are
+// harmless), and texts that do not exist or are secret are skipped.
+// It is used like a bufio.Scanner:
+//
+//	it := client.Thread(root)
+//	for it.Next() {
+//		fmt.Println(it.Entry().Depth, it.Entry().TextNo)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ThreadIterator struct {
+	source   TextStatSource
+	pending  []ThreadEntry
+	seen     map[types.TextNo]bool
+	entry    ThreadEntry
+	children int
+	missing  []types.TextNo
+	err      error
+}
+
+// Return an iterator over the thread starting at root.
+func (k *KomClient) Thread(root types.TextNo) *ThreadIterator {
+	return NewThreadIterator(k, root)
+}
+
+// Return an iterator over the thread starting at root, fetching text
+// status from source.
+func NewThreadIterator(source TextStatSource, root types.TextNo) *ThreadIterator {
+	return &ThreadIterator{
+		source:  source,
+		pending: []ThreadEntry{{TextNo: root}},
+		seen:    make(map[types.TextNo]bool),
+	}
+}
+
+// Advance to the next text in the thread, returning false when the
+// thread is exhausted or an error occurred.
+func (it *ThreadIterator) Next() bool {
+	for it.err == nil && len(it.pending) > 0 {
+		last := len(it.pending) - 1
+		entry := it.pending[last]
+		it.pending = it.pending[:last]
+
+		if entry.TextNo == 0 || it.seen[entry.TextNo] {
+			continue
+		}
+		it.seen[entry.TextNo] = true
+
+		stat, err := it.source.GetTextStat(entry.TextNo)
+		if err != nil {
+			var noText NoSuchTextError
+			if errors.As(err, &noText) {
+				it.missing = append(it.missing, entry.TextNo)
+				continue
+			}
+			it.err = err
+			return false
+		}
+		entry.Stat = stat
+		it.entry = entry
+		it.push(entry)
+		return true
+	}
+
+	return false
+}
+
+// Queue the footnotes and comments of a text. The pending list is a
+// stack, so comments are pushed before footnotes, and both in reverse.
+func (it *ThreadIterator) push(parent ThreadEntry) {
+	var comments, footnotes []types.TextNo
+	for _, mi := range parent.Stat.MiscInfo {
+		switch types.InfoType(mi.Selector) {
+		case types.CommentIn:
+			comments = append(comments, mi.CommentedIn)
+		case types.FootnoteIn:
+			footnotes = append(footnotes, mi.FootnotedIn)
+		}
+	}
+
+	before := len(it.pending)
+	for ix := len(comments) - 1; ix >= 0; ix-- {
+		it.pending = append(it.pending, ThreadEntry{TextNo: comments[ix], Parent: parent.TextNo, Depth: parent.Depth + 1})
+	}
+	for ix := len(footnotes) - 1; ix >= 0; ix-- {
+		it.pending = append(it.pending, ThreadEntry{TextNo: footnotes[ix], Parent: parent.TextNo, Depth: parent.Depth + 1, Footnote: true})
+	}
+	it.children = len(it.pending) - before
+}
+
+// Do not descend into the footnotes and comments of the current text.
+func (it *ThreadIterator) SkipChildren() {
+	it.pending = it.pending[:len(it.pending)-it.children]
+	it.children = 0
+}
+
+// The text the iterator is at.
+func (it *ThreadIterator) Entry() ThreadEntry {
+	return it.entry
+}
+
+// The texts that were skipped because they do not exist or are
+// secret, in the order they were encountered.
+func (it *ThreadIterator) Missing() []types.TextNo {
+	return it.missing
+}
+
+// The error that stopped the iteration, if any.
+func (it *ThreadIterator) Err() error {
+	return it.err
+}
+
+// A text in a thread tree, with its footnotes and comments in reading
+// order.
+type ThreadNode struct {
+	Entry    ThreadEntry
+	Children []*ThreadNode
+}
+
+// Build the tree of texts an iterator walks over. If the root text
+// does not exist, the returned tree is nil.
+func BuildThread(it *ThreadIterator) (*ThreadNode, error) {
+	var root *ThreadNode
+	nodes := make(map[types.TextNo]*ThreadNode)
+
+	for it.Next() {
+		node := &ThreadNode{Entry: it.Entry()}
+		nodes[node.Entry.TextNo] = node
+		if parent, ok := nodes[node.Entry.Parent]; ok && node.Entry.Parent != 0 {
+			parent.Children = append(parent.Children, node)
+		} else if root == nil {
+			root = node
+		}
+	}
+
+	return root, it.Err()
+}
+
+// Find the root of the thread a text is in, by following the first
+// comment-to or footnote-to link upwards until reaching a text that is
+// not a comment, or whose parent is not available.
+func ThreadRoot(source TextStatSource, text types.TextNo) (types.TextNo, error) {
+	seen := make(map[types.TextNo]bool)
+	child := types.TextNo(0)
+
+	for text != 0 && !seen[text] {
+		seen[text] = true
+		stat, err := source.GetTextStat(text)
+		if err != nil {
+			var noText NoSuchTextError
+			if child != 0 && errors.As(err, &noText) {
+				return child, nil
+			}
+			return text, err
+		}
+
+		child = text
+		text = 0
+		for _, mi := range stat.MiscInfo {
+			switch types.InfoType(mi.Selector) {
+			case types.CommentTo:
+				text = mi.CommentTo
+			case types.FootnoteTo:
+				text = mi.FootnoteTo
+			}
+			if text != 0 {
+				break
+			}
+		}
+	}
+
+	return child, nil
+}
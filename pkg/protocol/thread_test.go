@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// A TextStatSource backed by a map, texts not in the map do not exist.
+type fakeTexts map[types.TextNo][]types.MiscInfo
+
+func (f fakeTexts) GetTextStat(text types.TextNo) (types.TextStat, error) {
+	misc, ok := f[text]
+	if !ok {
+		return types.TextStat{}, NoSuchTextError{Text: text}
+	}
+	return types.TextStat{MiscInfo: misc}, nil
+}
+
+func commentIn(text types.TextNo) types.MiscInfo {
+	return types.MiscInfo{Selector: uint32(types.CommentIn), CommentedIn: text}
+}
+
+func footnoteIn(text types.TextNo) types.MiscInfo {
+	return types.MiscInfo{Selector: uint32(types.FootnoteIn), FootnotedIn: text}
+}
+
+// 100 has footnote 101 and comments 102 and 105, 102 has comment 103
+// (which has a comment back to 100) and a comment 104 that has been
+// deleted.
+var testThread = fakeTexts{
+	100: {commentIn(102), footnoteIn(101), commentIn(105)},
+	101: {types.FootnoteToMisc(100)},
+	102: {types.CommentToMisc(100), commentIn(103), commentIn(104)},
+	103: {types.CommentToMisc(102), commentIn(100)},
+	105: {types.CommentToMisc(100)},
+}
+
+func TestThreadOrder(t *testing.T) {
+	var order []types.TextNo
+	var depths []int
+
+	it := NewThreadIterator(testThread, 100)
+	for it.Next() {
+		order = append(order, it.Entry().TextNo)
+		depths = append(depths, it.Entry().Depth)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	wantOrder := []types.TextNo{100, 101, 102, 103, 105}
+	wantDepths := []int{0, 1, 1, 2, 1}
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("saw order %v, want %v", order, wantOrder)
+	}
+	if !reflect.DeepEqual(depths, wantDepths) {
+		t.Errorf("saw depths %v, want %v", depths, wantDepths)
+	}
+	if missing := it.Missing(); !reflect.DeepEqual(missing, []types.TextNo{104}) {
+		t.Errorf("saw missing %v, want [104]", missing)
+	}
+}
+
+func TestThreadSkipChildren(t *testing.T) {
+	var order []types.TextNo
+
+	it := NewThreadIterator(testThread, 100)
+	for it.Next() {
+		order = append(order, it.Entry().TextNo)
+		if it.Entry().TextNo == 102 {
+			it.SkipChildren()
+		}
+	}
+
+	want := []types.TextNo{100, 101, 102, 105}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("saw order %v, want %v", order, want)
+	}
+}
+
+type failingTexts struct{}
+
+func (failingTexts) GetTextStat(text types.TextNo) (types.TextStat, error) {
+	return types.TextStat{}, errors.New("connection lost")
+}
+
+func TestThreadError(t *testing.T) {
+	it := NewThreadIterator(failingTexts{}, 100)
+	if it.Next() {
+		t.Errorf("expected no texts")
+	}
+	if it.Err() == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestBuildThread(t *testing.T) {
+	root, err := BuildThread(NewThreadIterator(testThread, 100))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if root == nil || root.Entry.TextNo != 100 || len(root.Children) != 3 {
+		t.Fatalf("unexpected root %+v", root)
+	}
+	if !root.Children[0].Entry.Footnote || root.Children[0].Entry.TextNo != 101 {
+		t.Errorf("saw first child %+v, want footnote 101", root.Children[0].Entry)
+	}
+	if c := root.Children[1]; len(c.Children) != 1 || c.Children[0].Entry.TextNo != 103 {
+		t.Errorf("unexpected children of 102: %+v", c.Children)
+	}
+
+	root, err = BuildThread(NewThreadIterator(testThread, 4711))
+	if root != nil || err != nil {
+		t.Errorf("saw %+v/%v, want an empty tree", root, err)
+	}
+}
+
+func TestThreadRoot(t *testing.T) {
+	orphans := fakeTexts{
+		200: {types.CommentToMisc(199)},
+		300: {types.CommentToMisc(301)},
+		301: {types.CommentToMisc(300)},
+	}
+
+	cases := []struct {
+		source TextStatSource
+		text   types.TextNo
+		want   types.TextNo
+		err    bool
+	}{
+		{testThread, 103, 100, false},
+		{testThread, 101, 100, false},
+		{testThread, 100, 100, false},
+		{orphans, 200, 200, false},
+		{orphans, 300, 301, false},
+		{orphans, 4711, 4711, true},
+	}
+
+	for ix, c := range cases {
+		saw, err := ThreadRoot(c.source, c.text)
+		if saw != c.want || (err != nil) != c.err {
+			t.Errorf("Case #%d, saw %d/%v, want %d", ix, saw, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package protocol
+
+// Finding unread texts, and marking them as read
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// The number of texts marked as read before the read ranges are sent
+// to the server.
+const defaultUnreadBatch = 20
+
+// The largest number of texts local-to-global returns in one call.
+const localToGlobalMax = 255
+
+// The calls the unread engine needs. A *KomClient is one.
+type UnreadSource interface {
+	GetUnreadConfs(person types.ConfNo) ([]types.ConfNo, error)
+	QueryReadTexts(person, conf types.ConfNo) (types.Membership, error)
+	LocalToGlobal(conf types.ConfNo, firstLocal types.TextNo, count uint32) (types.TextMapping, error)
+	SetReadRanges(conf types.ConfNo, ranges []types.ReadRange) error
+	MarkAsRead(conf types.ConfNo, localTexts []types.TextNo) error
+}
+
+// An unread text, as found in a specific conference.
+type UnreadText struct {
+	Conference types.ConfNo
+	LocalNo    types.TextNo
+	GlobalNo   types.TextNo
+}
+
+// An UnreadEngine keeps track of what a person has read. It finds
+// unread texts by combining the read ranges of each membership with
+// the local-to-global mapping of the conference, and marks texts as
+// read locally, sending the updated read ranges to the server in
+// batches. Call Flush when done, to send any remaining updates.
+type UnreadEngine struct {
+	// The number of texts marked as read before the updates are
+	// sent to the server.
+	BatchSize int
+
+	source UnreadSource
+	person types.ConfNo
+
+	lock         sync.Mutex
+	ranges       map[types.ConfNo][]types.ReadRange
+	dirty        map[types.ConfNo][]types.TextNo
+	pending      int
+	locations    map[types.TextNo][]UnreadText
+	readGlobals  map[types.TextNo]bool
+	noReadRanges bool
+}
+
+// Return an unread engine for the logged-in person.
+func (k *KomClient) UnreadEngine() *UnreadEngine {
+	return NewUnreadEngine(k, k.Person())
+}
+
+// Return an unread engine for a person, using source to talk to the
+// server.
+func NewUnreadEngine(source UnreadSource, person types.ConfNo) *UnreadEngine {
+	return &UnreadEngine{
+		BatchSize:   defaultUnreadBatch,
+		source:      source,
+		person:      person,
+		ranges:      make(map[types.ConfNo][]types.ReadRange),
+		dirty:       make(map[types.ConfNo][]types.TextNo),
+		locations:   make(map[types.TextNo][]UnreadText),
+		readGlobals: make(map[types.TextNo]bool),
+	}
+}
+
+// Return the memberships that may have unread texts, highest priority
+// first and then in membership order. Passive memberships are left
+// out.
+func (e *UnreadEngine) Memberships() ([]types.Membership, error) {
+	confs, err := e.source.GetUnreadConfs(e.person)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []types.Membership
+	for _, conf := range confs {
+		m, err := e.source.QueryReadTexts(e.person, conf)
+		if err != nil {
+			var undef UndefinedConferenceError
+			if errors.As(err, &undef) {
+				continue
+			}
+			return rv, err
+		}
+		if m.Type.Passive {
+			continue
+		}
+
+		e.lock.Lock()
+		e.ranges[conf] = m.ReadRanges
+		e.lock.Unlock()
+		rv = append(rv, m)
+	}
+
+	sort.SliceStable(rv, func(i, j int) bool {
+		if rv[i].Priority != rv[j].Priority {
+			return rv[i].Priority > rv[j].Priority
+		}
+		return rv[i].Position < rv[j].Position
+	})
+
+	return rv, nil
+}
+
+// Return the unread texts in a conference, in local number order.
+func (e *UnreadEngine) UnreadIn(conf types.ConfNo) ([]UnreadText, error) {
+	e.lock.Lock()
+	ranges, ok := e.ranges[conf]
+	e.lock.Unlock()
+	if !ok {
+		m, err := e.source.QueryReadTexts(e.person, conf)
+		if err != nil {
+			return nil, err
+		}
+		ranges = m.ReadRanges
+		e.lock.Lock()
+		e.ranges[conf] = ranges
+		e.lock.Unlock()
+	}
+
+	start := types.TextNo(1)
+	if len(ranges) > 0 && ranges[0].FirstRead <= 1 {
+		start = ranges[0].LastRead + 1
+	}
+
+	var rv []UnreadText
+	for {
+		mapping, err := e.source.LocalToGlobal(conf, start, localToGlobalMax)
+		if err != nil {
+			var noLocal NoSuchLocalTextError
+			if errors.As(err, &noLocal) {
+				// Everything has been read.
+				return rv, nil
+			}
+			return rv, err
+		}
+
+		for _, pair := range mapping.Texts {
+			if types.IsRead(ranges, pair.LocalNo) {
+				continue
+			}
+			rv = append(rv, UnreadText{Conference: conf, LocalNo: pair.LocalNo, GlobalNo: pair.GlobalNo})
+		}
+
+		if !mapping.LaterTextsExist || mapping.RangeEnd <= start {
+			break
+		}
+		start = mapping.RangeEnd
+	}
+
+	// Texts already read in another conference are marked as read
+	// here as well.
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	unread := rv[:0]
+	for _, text := range rv {
+		e.locations[text.GlobalNo] = appendLocation(e.locations[text.GlobalNo], text)
+		if e.readGlobals[text.GlobalNo] {
+			e.markLocal(text)
+			continue
+		}
+		unread = append(unread, text)
+	}
+
+	return unread, nil
+}
+
+// Add a location to a list, unless it is already there.
+func appendLocation(locations []UnreadText, text UnreadText) []UnreadText {
+	for _, l := range locations {
+		if l == text {
+			return locations
+		}
+	}
+	return append(locations, text)
+}
+
+// Return an iterator over all unread texts, see UnreadIterator.
+func (e *UnreadEngine) Texts() *UnreadIterator {
+	return &UnreadIterator{engine: e, seen: make(map[types.TextNo]bool)}
+}
+
+// Mark a text as read, in every conference it has been seen in. The
+// updates are sent to the server when BatchSize texts have been
+// marked, or when Flush is called.
+func (e *UnreadEngine) MarkRead(text UnreadText) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.readGlobals[text.GlobalNo] = true
+	e.markLocal(text)
+	for _, l := range e.locations[text.GlobalNo] {
+		e.markLocal(l)
+	}
+
+	if e.pending >= e.BatchSize {
+		return e.flush()
+	}
+	return nil
+}
+
+// Mark a text as read in one conference. The lock must be held.
+func (e *UnreadEngine) markLocal(text UnreadText) {
+	if types.IsRead(e.ranges[text.Conference], text.LocalNo) {
+		return
+	}
+	e.ranges[text.Conference] = types.AddToReadRanges(e.ranges[text.Conference], text.LocalNo)
+	e.dirty[text.Conference] = append(e.dirty[text.Conference], text.LocalNo)
+	e.pending++
+}
+
+// Return true if a text has been read, as far as the engine knows.
+func (e *UnreadEngine) IsRead(text UnreadText) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return types.IsRead(e.ranges[text.Conference], text.LocalNo)
+}
+
+// Send all pending updates to the server.
+func (e *UnreadEngine) Flush() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.flush()
+}
+
+// Send all pending updates, using set-read-ranges if the server has
+// it and mark-as-read otherwise. The lock must be held.
+func (e *UnreadEngine) flush() error {
+	for conf, locals := range e.dirty {
+		var err error
+		if !e.noReadRanges {
+			err = e.source.SetReadRanges(conf, e.ranges[conf])
+			var notImpl NotImplementedError
+			if errors.As(err, &notImpl) {
+				e.noReadRanges = true
+			}
+		}
+		if e.noReadRanges {
+			err = e.source.MarkAsRead(conf, locals)
+		}
+		if err != nil {
+			return err
+		}
+
+		e.pending -= len(locals)
+		delete(e.dirty, conf)
+	}
+
+	return nil
+}
+
+// An UnreadIterator walks over all unread texts, conference by
+// conference in priority order, and in local number order within each
+// conference. Texts that are in several conferences are only visited
+// once. It is used like a bufio.Scanner:
+//
+//	it := client.UnreadEngine().Texts()
+//	for it.Next() {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type UnreadIterator struct {
+	engine      *UnreadEngine
+	memberships []types.Membership
+	started     bool
+	texts       []UnreadText
+	current     UnreadText
+	seen        map[types.TextNo]bool
+	err         error
+}
+
+// Advance to the next unread text, returning false when there are no
+// more unread texts or an error occurred.
+func (it *UnreadIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		it.memberships, it.err = it.engine.Memberships()
+	}
+
+	for it.err == nil {
+		for len(it.texts) > 0 {
+			text := it.texts[0]
+			it.texts = it.texts[1:]
+			if it.seen[text.GlobalNo] || it.engine.IsRead(text) {
+				continue
+			}
+			it.seen[text.GlobalNo] = true
+			it.current = text
+			return true
+		}
+
+		if len(it.memberships) == 0 {
+			return false
+		}
+		conf := it.memberships[0].Conference
+		it.memberships = it.memberships[1:]
+		it.texts, it.err = it.engine.UnreadIn(conf)
+	}
+
+	return false
+}
+
+// The unread text the iterator is at.
+func (it *UnreadIterator) Text() UnreadText {
+	return it.current
+}
+
+// The error that stopped the iteration, if any.
+func (it *UnreadIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,246 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+type fakeConf struct {
+	membership types.Membership
+	texts      map[types.TextNo]types.TextNo // local to global
+	highest    types.TextNo
+}
+
+// An UnreadSource with a handful of conferences, recording the calls
+// that update the server.
+type fakeUnread struct {
+	confs        map[types.ConfNo]*fakeConf
+	noReadRanges bool
+	setRanges    map[types.ConfNo][]types.ReadRange
+	markedRead   map[types.ConfNo][]types.TextNo
+}
+
+func (f *fakeUnread) GetUnreadConfs(person types.ConfNo) ([]types.ConfNo, error) {
+	var rv []types.ConfNo
+	for _, conf := range []types.ConfNo{1, 2, 3, 4} {
+		if _, ok := f.confs[conf]; ok {
+			rv = append(rv, conf)
+		}
+	}
+	return rv, nil
+}
+
+func (f *fakeUnread) QueryReadTexts(person, conf types.ConfNo) (types.Membership, error) {
+	c, ok := f.confs[conf]
+	if !ok {
+		return types.Membership{}, UndefinedConferenceError{Conf: conf}
+	}
+	return c.membership, nil
+}
+
+// Return at most three texts at a time, to exercise paging.
+func (f *fakeUnread) LocalToGlobal(conf types.ConfNo, first types.TextNo, count uint32) (types.TextMapping, error) {
+	c := f.confs[conf]
+	if first > c.highest {
+		return types.TextMapping{}, NoSuchLocalTextError{LocalNo: first}
+	}
+
+	rv := types.TextMapping{RangeBegin: first}
+	local := first
+	for ; local <= c.highest && len(rv.Texts) < 3; local++ {
+		if global, ok := c.texts[local]; ok {
+			rv.Texts = append(rv.Texts, types.TextNumberPair{LocalNo: local, GlobalNo: global})
+		}
+	}
+	rv.RangeEnd = local
+	rv.LaterTextsExist = local <= c.highest
+	return rv, nil
+}
+
+func (f *fakeUnread) SetReadRanges(conf types.ConfNo, ranges []types.ReadRange) error {
+	if f.noReadRanges {
+		return NotImplementedError{}
+	}
+	f.setRanges[conf] = ranges
+	return nil
+}
+
+func (f *fakeUnread) MarkAsRead(conf types.ConfNo, locals []types.TextNo) error {
+	f.markedRead[conf] = append(f.markedRead[conf], locals...)
+	return nil
+}
+
+func readRange(first, last types.TextNo) types.ReadRange {
+	return types.ReadRange{FirstRead: first, LastRead: last}
+}
+
+func textPair(local, global types.TextNo) types.TextNumberPair {
+	return types.TextNumberPair{LocalNo: local, GlobalNo: global}
+}
+
+func newFakeUnread() *fakeUnread {
+	return &fakeUnread{
+		confs: map[types.ConfNo]*fakeConf{
+			1: {
+				membership: types.Membership{Position: 0, Conference: 1, Priority: 100,
+					ReadRanges: []types.ReadRange{readRange(1, 2), readRange(4, 4)}},
+				texts:   map[types.TextNo]types.TextNo{1: 100, 2: 101, 3: 102, 4: 103, 6: 105, 7: 106, 8: 107},
+				highest: 8,
+			},
+			2: {
+				membership: types.Membership{Position: 1, Conference: 2, Priority: 200},
+				texts:      map[types.TextNo]types.TextNo{1: 105, 2: 200},
+				highest:    2,
+			},
+			3: {
+				membership: types.Membership{Position: 2, Conference: 3, Priority: 255,
+					Type: types.MembershipType{Passive: true}},
+				texts:   map[types.TextNo]types.TextNo{1: 300},
+				highest: 1,
+			},
+			4: {
+				membership: types.Membership{Position: 3, Conference: 4, Priority: 100,
+					ReadRanges: []types.ReadRange{readRange(1, 5)}},
+				texts:   map[types.TextNo]types.TextNo{5: 400},
+				highest: 5,
+			},
+		},
+		setRanges:  make(map[types.ConfNo][]types.ReadRange),
+		markedRead: make(map[types.ConfNo][]types.TextNo),
+	}
+}
+
+func TestUnreadOrder(t *testing.T) {
+	engine := NewUnreadEngine(newFakeUnread(), 6)
+
+	var seen []types.TextNo
+	it := engine.Texts()
+	for it.Next() {
+		seen = append(seen, it.Text().GlobalNo)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Conference 2 first (priority 200), then 1, text 105 only once
+	// and nothing from the passive conference 3 or the fully read 4.
+	want := []types.TextNo{105, 200, 102, 106, 107}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("saw %v, want %v", seen, want)
+	}
+}
+
+func TestUnreadMarkRead(t *testing.T) {
+	source := newFakeUnread()
+	engine := NewUnreadEngine(source, 6)
+	engine.BatchSize = 3
+
+	it := engine.Texts()
+	for it.Next() {
+		if err := engine.MarkRead(it.Text()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	// Marking 105 in conference 2, and 200, also marks 105 in
+	// conference 1, so the first batch is sent after 200.
+	wantFirst := []types.ReadRange{readRange(1, 2)}
+	if saw := source.setRanges[2]; !reflect.DeepEqual(saw, wantFirst) {
+		t.Errorf("saw ranges %v for conference 2, want %v", saw, wantFirst)
+	}
+	if len(source.setRanges[1]) == 0 {
+		t.Errorf("expected ranges for conference 1 after the first batch")
+	}
+
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []types.ReadRange{readRange(1, 4), readRange(6, 8)}
+	if saw := source.setRanges[1]; !reflect.DeepEqual(saw, want) {
+		t.Errorf("saw ranges %v for conference 1, want %v", saw, want)
+	}
+}
+
+func TestUnreadMarkAsReadFallback(t *testing.T) {
+	source := newFakeUnread()
+	source.noReadRanges = true
+	engine := NewUnreadEngine(source, 6)
+
+	texts, err := engine.UnreadIn(1)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, text := range texts {
+		engine.MarkRead(text)
+	}
+	if err := engine.Flush(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	want := []types.TextNo{3, 6, 7, 8}
+	if saw := source.markedRead[1]; !reflect.DeepEqual(saw, want) {
+		t.Errorf("saw %v, want %v", saw, want)
+	}
+}
+
+func TestReadMembership(t *testing.T) {
+	cl := fakeClient("=1 3 23 47 19 17 6 97 4 197 1 17 200 2 { 1 5 7 7 } 6 23 47 19 17 6 97 4 197 1 01000000\n")
+	rv := make(chan membershipResponse)
+	cl.asyncMap[1] = membershipCallback(rv)
+	go cl.receiveLoop()
+	seen := <-rv
+	if seen.err != nil {
+		t.Fatalf("unexpected error %v", seen.err)
+	}
+
+	m := seen.memberships[0]
+	if m.Position != 3 || m.Conference != 17 || m.Priority != 200 || m.AddedBy != 6 || !m.Type.Passive {
+		t.Errorf("unexpected membership %+v", m)
+	}
+	if want := []types.ReadRange{readRange(1, 5), readRange(7, 7)}; !reflect.DeepEqual(m.ReadRanges, want) {
+		t.Errorf("saw ranges %v, want %v", m.ReadRanges, want)
+	}
+}
+
+func TestReadTextMapping(t *testing.T) {
+	cases := []struct {
+		response string
+		want     types.TextMapping
+	}{
+		{
+			"=1 1 9 0 0 3 { 2 100 4 102 7 107 }\n",
+			types.TextMapping{RangeBegin: 1, RangeEnd: 9, Texts: []types.TextNumberPair{textPair(2, 100), textPair(4, 102), textPair(7, 107)}},
+		},
+		{
+			"=1 5 8 1 1 5 3 { 200 0 202 }\n",
+			types.TextMapping{RangeBegin: 5, RangeEnd: 8, LaterTextsExist: true, Texts: []types.TextNumberPair{textPair(5, 200), textPair(7, 202)}},
+		},
+	}
+
+	for ix, c := range cases {
+		cl := fakeClient(c.response)
+		rv := make(chan textMappingResponse)
+		cl.asyncMap[1] = textMappingCallback(rv)
+		go cl.receiveLoop()
+		seen := <-rv
+		if seen.err != nil || !reflect.DeepEqual(seen.mapping, c.want) {
+			t.Errorf("Case #%d, saw %+v/%v, want %+v", ix, seen.mapping, seen.err, c.want)
+		}
+	}
+}
+
+func TestUnreadRequests(t *testing.T) {
+	c := fakeClient("")
+	c.asyncLocalToGlobal(17, 1, 255)
+	c.asyncQueryReadTexts(6, 17, true, 0)
+	c.asyncGetMembership(6, 0, 10, false, 0)
+	c.asyncSetReadRanges(17, []types.ReadRange{readRange(1, 5), readRange(7, 7)})
+	c.asyncMarkAsReadConf(17, []types.TextNo{6})
+	want := "0 103 17 1 255\n1 107 6 17 1 0\n2 108 6 0 10 0 0\n3 110 17 2 { 1 5 7 7 }\n4 27 17 1 { 6 }\n"
+	if saw := c.socket.(*bytes.Buffer).String(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+}
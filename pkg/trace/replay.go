@@ -0,0 +1,162 @@
+package trace
+
+// Playing a trace back to a client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A Replay is a connection that plays back the received side of a
+// trace. Each received record is held back until the client has sent
+// as many messages as it had when the record was received, so replies
+// arrive after the requests they answer, just like they did when the
+// trace was recorded.
+//
+// The messages the client sends are compared to the ones in the
+// trace, and the first difference is available from Err. The
+// connection header is only compared up to the user name, as that
+// depends on who runs the client.
+type Replay struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	received [][]byte // the received records, in order
+	need     []int    // messages sent before each received record
+	recorded [][]byte // the messages sent in the trace
+
+	next    int    // the next received record to play
+	pending []byte // the part of the current record not yet read
+	written []byte // bytes written, but not yet a full message
+	sent    int    // messages written
+	closed  bool
+	err     error
+}
+
+// Return a replay of a trace.
+func NewReplay(records []Record) *Replay {
+	rv := &Replay{}
+	rv.cond = sync.NewCond(&rv.lock)
+
+	messages := 0
+	for _, rec := range records {
+		switch rec.Dir {
+		case Sent:
+			messages += bytes.Count(rec.Data, []byte{'\n'})
+		case Received:
+			rv.received = append(rv.received, rec.Data)
+			rv.need = append(rv.need, messages)
+		}
+	}
+
+	sent := Stream(records, Sent)
+	for len(sent) > 0 {
+		ix := bytes.IndexByte(sent, '\n')
+		if ix < 0 {
+			rv.recorded = append(rv.recorded, sent)
+			break
+		}
+		rv.recorded = append(rv.recorded, sent[:ix])
+		sent = sent[ix+1:]
+	}
+
+	return rv
+}
+
+// Read the next received bytes, waiting until the client has sent
+// what it sent before they were received. Returns io.EOF at the end
+// of the trace.
+func (r *Replay) Read(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for len(r.pending) == 0 {
+		if r.closed {
+			return 0, io.ErrClosedPipe
+		}
+		if r.next >= len(r.received) {
+			return 0, io.EOF
+		}
+		if r.sent >= r.need[r.next] {
+			r.pending = r.received[r.next]
+			r.next++
+			continue
+		}
+		r.cond.Wait()
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Accept bytes from the client, comparing each complete message with
+// the trace.
+func (r *Replay) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	r.written = append(r.written, p...)
+	for {
+		ix := bytes.IndexByte(r.written, '\n')
+		if ix < 0 {
+			break
+		}
+		r.compare(r.written[:ix])
+		r.written = r.written[ix+1:]
+		r.sent++
+	}
+
+	r.cond.Broadcast()
+	return len(p), nil
+}
+
+// Compare a message to the one sent at the same point in the trace.
+// The lock must be held.
+func (r *Replay) compare(msg []byte) {
+	if r.err != nil {
+		return
+	}
+	if r.sent >= len(r.recorded) {
+		r.err = fmt.Errorf("Message %d, %q, was not in the trace", r.sent+1, msg)
+		return
+	}
+
+	want := r.recorded[r.sent]
+	if r.sent == 0 && bytes.HasPrefix(msg, []byte("A")) && bytes.HasPrefix(want, []byte("A")) {
+		return
+	}
+	if !bytes.Equal(msg, want) {
+		r.err = fmt.Errorf("Message %d differs, sent %q, trace has %q", r.sent+1, msg, want)
+	}
+}
+
+// The first difference between what the client sent and the trace,
+// if any.
+func (r *Replay) Err() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+// Return true if the whole trace has been played back.
+func (r *Replay) Done() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.next >= len(r.received) && len(r.pending) == 0
+}
+
+// Close the replay, making any waiting Read return.
+func (r *Replay) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
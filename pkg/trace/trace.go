@@ -0,0 +1,217 @@
+// Recording and replaying the bytes sent over a Protocol A
+// connection. A trace is a text file, with one record per line:
+//
+//	# komandgo trace
+//	2026-10-18T15:43:41.123456789Z > "A8Hkomandgo\n"
+//	2026-10-18T15:43:41.130000000Z < "LysKOM\n"
+//
+// Each record has the time it was seen, the direction (">" for bytes
+// sent by the client, "<" for bytes received by it) and the bytes as
+// a Go string literal. Lines starting with "#" are comments.
+//
+// A Tap records a live connection, and a Replay plays a trace back to
+// a client, so that a misparsed reply can be reproduced offline:
+//
+//	records, err := trace.ReadFile("testdata/bug.trace")
+//	client, err := protocol.NewKomClientConn(trace.NewReplay(records))
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The direction of a record.
+type Direction byte
+
+const (
+	Sent     = Direction('>')
+	Received = Direction('<')
+)
+
+// A Record is a chunk of bytes seen on a connection.
+type Record struct {
+	Time time.Time
+	Dir  Direction
+	Data []byte
+}
+
+// The first line of a trace file.
+const header = "# komandgo trace\n"
+
+// A Writer writes records to a trace. It is safe for concurrent use.
+type Writer struct {
+	lock    sync.Mutex
+	w       io.Writer
+	started bool
+	err     error
+	now     func() time.Time
+}
+
+// Return a writer for a trace.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, now: time.Now}
+}
+
+// Write a record, time-stamped with the current time.
+func (w *Writer) Record(dir Direction, data []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if !w.started {
+		w.started = true
+		if _, w.err = io.WriteString(w.w, header); w.err != nil {
+			return w.err
+		}
+	}
+
+	line := fmt.Sprintf("%s %c %s\n", w.now().UTC().Format(time.RFC3339Nano), dir, strconv.Quote(string(data)))
+	_, w.err = io.WriteString(w.w, line)
+	return w.err
+}
+
+// The first error seen writing the trace, if any.
+func (w *Writer) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+// Create a new trace file in a directory, named after the current
+// time, such as "komtrace-20261018T154341.trace". If there already is
+// a trace from the same second, a counter is added to the name, as in
+// "komtrace-20261018T154341-2.trace".
+func CreateFile(dir string) (*os.File, error) {
+	stamp := time.Now().Format("20060102T150405")
+	name := fmt.Sprintf("komtrace-%s.trace", stamp)
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+		name = fmt.Sprintf("komtrace-%s-%d.trace", stamp, n)
+	}
+}
+
+// Parse a single record.
+func parseRecord(line string) (Record, error) {
+	var rv Record
+
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || len(parts[1]) != 1 {
+		return rv, fmt.Errorf("Malformed record %q", line)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return rv, err
+	}
+	rv.Time = t
+
+	rv.Dir = Direction(parts[1][0])
+	if rv.Dir != Sent && rv.Dir != Received {
+		return rv, fmt.Errorf("Unknown direction %q", parts[1])
+	}
+
+	data, err := strconv.Unquote(parts[2])
+	if err != nil {
+		return rv, fmt.Errorf("Malformed data %s: %w", parts[2], err)
+	}
+	rv.Data = []byte(data)
+
+	return rv, nil
+}
+
+// Read all records from a trace.
+func Read(r io.Reader) ([]Record, error) {
+	var rv []Record
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16*1024*1024)
+	line := 0
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rec, err := parseRecord(text)
+		if err != nil {
+			return rv, fmt.Errorf("Line %d: %w", line, err)
+		}
+		rv = append(rv, rec)
+	}
+
+	return rv, s.Err()
+}
+
+// Read all records from a trace file.
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Return all bytes sent in one direction, in order.
+func Stream(records []Record, dir Direction) []byte {
+	var rv []byte
+	for _, rec := range records {
+		if rec.Dir == dir {
+			rv = append(rv, rec.Data...)
+		}
+	}
+	return rv
+}
+
+// A Tap wraps a connection, recording everything read from and
+// written to it. Failing to write the trace does not affect the
+// connection, see Writer.Err.
+type Tap struct {
+	conn  io.ReadWriter
+	trace *Writer
+}
+
+// Return a tap recording the traffic on conn to trace.
+func NewTap(conn io.ReadWriter, trace *Writer) *Tap {
+	return &Tap{conn: conn, trace: trace}
+}
+
+func (t *Tap) Read(p []byte) (int, error) {
+	n, err := t.conn.Read(p)
+	if n > 0 {
+		t.trace.Record(Received, p[:n])
+	}
+	return n, err
+}
+
+// Record the bytes before writing them, so that they are in the trace
+// before any reply to them.
+func (t *Tap) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		t.trace.Record(Sent, p)
+	}
+	return t.conn.Write(p)
+}
+
+// Close the underlying connection, if it can be closed.
+func (t *Tap) Close() error {
+	if c, ok := t.conn.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package trace_test
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/komd"
+	"github.com/vatine/komandgo/pkg/protocol"
+	"github.com/vatine/komandgo/pkg/trace"
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := trace.NewWriter(&buf)
+	w.Record(trace.Sent, []byte("A8Hkomandgo\n"))
+	w.Record(trace.Received, []byte("LysKOM\n"))
+	w.Record(trace.Sent, []byte("1 62 5Hingrid \"x\" 0\n"))
+	if w.Err() != nil {
+		t.Fatalf("unexpected error %v", w.Err())
+	}
+	if !strings.HasPrefix(buf.String(), "# komandgo trace\n") {
+		t.Errorf("trace does not start with the header, %q", buf.String())
+	}
+
+	records, err := trace.Read(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(records) != 3 || records[1].Dir != trace.Received {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if got, want := string(trace.Stream(records, trace.Sent)), "A8Hkomandgo\n1 62 5Hingrid \"x\" 0\n"; got != want {
+		t.Errorf("saw sent stream %q, want %q", got, want)
+	}
+
+	if _, err := trace.Read(strings.NewReader("yesterday > \"A\"\n")); err == nil {
+		t.Errorf("expected an error reading a malformed record")
+	}
+}
+
+// Log in, create a text and read it back, returning what was seen.
+func session(t *testing.T, client *protocol.KomClient) (types.TextNo, string) {
+	t.Helper()
+
+	if err := client.Login("Ingrid Bergman", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	no, err := client.CreateText("Hej\nFrån spåret", []types.MiscInfo{types.RecipientMisc(1)}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating text, %v", err)
+	}
+	body, err := client.GetText(no)
+	if err != nil {
+		t.Fatalf("unexpected error getting text, %v", err)
+	}
+	return no, body
+}
+
+// Record a session with a komd server, then replay it.
+func TestRecordAndReplay(t *testing.T) {
+	store := komd.NewStore()
+	if _, err := store.CreatePerson("Ingrid Bergman", "secret"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	srv := komd.NewServer(store)
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	c, s := net.Pipe()
+	go srv.ServeConn(s)
+	client, err := protocol.NewKomClientConn(trace.NewTap(c, trace.NewWriter(&buf)))
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	no, body := session(t, client)
+	client.Close()
+
+	records, err := trace.Read(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading the trace, %v", err)
+	}
+
+	replay := trace.NewReplay(records)
+	client, err = protocol.NewKomClientConn(replay)
+	if err != nil {
+		t.Fatalf("unexpected error connecting to the replay, %v", err)
+	}
+	defer client.Close()
+	replayNo, replayBody := session(t, client)
+
+	if replayNo != no || replayBody != body {
+		t.Errorf("replay saw %d/%q, want %d/%q", replayNo, replayBody, no, body)
+	}
+	if err := replay.Err(); err != nil {
+		t.Errorf("unexpected difference from the trace, %v", err)
+	}
+	if !replay.Done() {
+		t.Errorf("expected the whole trace to be played back")
+	}
+}
+
+// A client sending something else than the trace is reported.
+func TestReplayMismatch(t *testing.T) {
+	records, err := trace.ReadFile("testdata/login.trace")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	replay := trace.NewReplay(records)
+	client, err := protocol.NewKomClientConn(replay)
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- client.Login("Greta Garbo", "alone", false) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+
+	if replay.Err() == nil {
+		t.Errorf("expected a difference from the trace")
+	}
+}
+
+// A recorded login, with the lookup-z-name reply.
+func TestReplayFile(t *testing.T) {
+	records, err := trace.ReadFile("testdata/login.trace")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	replay := trace.NewReplay(records)
+	client, err := protocol.NewKomClientConn(replay)
+	if err != nil {
+		t.Fatalf("unexpected error connecting, %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Login("Ingrid Bergman", "secret", false); err != nil {
+		t.Fatalf("unexpected error logging in, %v", err)
+	}
+	if client.Person() != 6 {
+		t.Errorf("logged in as %d, want 6", client.Person())
+	}
+	if err := replay.Err(); err != nil {
+		t.Errorf("unexpected difference from the trace, %v", err)
+	}
+}
+
+func TestCreateFile(t *testing.T) {
+	dir := t.TempDir()
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		f, err := trace.CreateFile(dir)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		f.Close()
+		if seen[f.Name()] {
+			t.Errorf("saw %s twice", f.Name())
+		}
+		seen[f.Name()] = true
+	}
+}
@@ -0,0 +1,258 @@
+// Well-known aux-item tags and helpers for creating and finding them
+
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Aux-item tags defined by Protocol A, as well as the client-specific
+// ones that are commonly seen in the wild.
+const (
+	AuxContentType         = uint32(1)
+	AuxFastReply           = uint32(2)
+	AuxCrossReference      = uint32(3)
+	AuxNoComments          = uint32(4)
+	AuxPersonalComment     = uint32(5)
+	AuxRequestConfirmation = uint32(6)
+	AuxReadConfirm         = uint32(7)
+	AuxRedirect            = uint32(8)
+	AuxXFace               = uint32(9)
+	AuxAlternateName       = uint32(10)
+	AuxPGPSignature        = uint32(11)
+	AuxPGPPublicKey        = uint32(12)
+	AuxEMailAddress        = uint32(13)
+	AuxFAQText             = uint32(14)
+	AuxCreatingSoftware    = uint32(15)
+	AuxMXAuthor            = uint32(16)
+	AuxMXFrom              = uint32(17)
+	AuxMXReplyTo           = uint32(18)
+	AuxMXTo                = uint32(19)
+	AuxMXCC                = uint32(20)
+	AuxMXDate              = uint32(21)
+	AuxMXMessageID         = uint32(22)
+	AuxMXInReplyTo         = uint32(23)
+	AuxMXMisc              = uint32(24)
+	AuxMXAllowFilter       = uint32(25)
+	AuxMXRejectForward     = uint32(26)
+	AuxNotifyComments      = uint32(27)
+	AuxFAQForConf          = uint32(28)
+	AuxRecommendedConf     = uint32(29)
+	AuxAllowedContentType  = uint32(30)
+	AuxCanonicalName       = uint32(31)
+	AuxMXListName          = uint32(32)
+	AuxSendCommentsTo      = uint32(33)
+	AuxWorldReadable       = uint32(34)
+	AuxMXRefuseImport      = uint32(35)
+
+	AuxElispClientReadFAQ                = uint32(10000)
+	AuxElispClientRejectedRecommendation = uint32(10001)
+	AuxMXMIMEBelongsTo                   = uint32(10100)
+	AuxMXMIMEPartIn                      = uint32(10101)
+	AuxMXMIMEMisc                        = uint32(10102)
+	AuxMXEnvelopeSender                  = uint32(10103)
+	AuxMXMIMEFileName                    = uint32(10104)
+)
+
+var auxTagNames = map[uint32]string{
+	AuxContentType:         "content-type",
+	AuxFastReply:           "fast-reply",
+	AuxCrossReference:      "cross-reference",
+	AuxNoComments:          "no-comments",
+	AuxPersonalComment:     "personal-comment",
+	AuxRequestConfirmation: "request-confirmation",
+	AuxReadConfirm:         "read-confirm",
+	AuxRedirect:            "redirect",
+	AuxXFace:               "x-face",
+	AuxAlternateName:       "alternate-name",
+	AuxPGPSignature:        "pgp-signature",
+	AuxPGPPublicKey:        "pgp-public-key",
+	AuxEMailAddress:        "e-mail-address",
+	AuxFAQText:             "faq-text",
+	AuxCreatingSoftware:    "creating-software",
+	AuxMXAuthor:            "mx-author",
+	AuxMXFrom:              "mx-from",
+	AuxMXReplyTo:           "mx-reply-to",
+	AuxMXTo:                "mx-to",
+	AuxMXCC:                "mx-cc",
+	AuxMXDate:              "mx-date",
+	AuxMXMessageID:         "mx-message-id",
+	AuxMXInReplyTo:         "mx-in-reply-to",
+	AuxMXMisc:              "mx-misc",
+	AuxMXAllowFilter:       "mx-allow-filter",
+	AuxMXRejectForward:     "mx-reject-forward",
+	AuxNotifyComments:      "notify-comments",
+	AuxFAQForConf:          "faq-for-conf",
+	AuxRecommendedConf:     "recommended-conf",
+	AuxAllowedContentType:  "allowed-content-type",
+	AuxCanonicalName:       "canonical-name",
+	AuxMXListName:          "mx-list-name",
+	AuxSendCommentsTo:      "send-comments-to",
+	AuxWorldReadable:       "world-readable",
+	AuxMXRefuseImport:      "mx-refuse-import",
+
+	AuxElispClientReadFAQ:                "elisp-client-read-faq",
+	AuxElispClientRejectedRecommendation: "elisp-client-rejected-recommendation",
+	AuxMXMIMEBelongsTo:                   "mx-mime-belongs-to",
+	AuxMXMIMEPartIn:                      "mx-mime-part-in",
+	AuxMXMIMEMisc:                        "mx-mime-misc",
+	AuxMXEnvelopeSender:                  "mx-envelope-sender",
+	AuxMXMIMEFileName:                    "mx-mime-file-name",
+}
+
+// Return the protocol name of an aux-item tag, or a string of the
+// form "aux-<tag>" for tags that are not known.
+func AuxTagName(tag uint32) string {
+	if name, ok := auxTagNames[tag]; ok {
+		return name
+	}
+	return fmt.Sprintf("aux-%d", tag)
+}
+
+// Return the aux-item tag with a given protocol name.
+func AuxTagFromName(name string) (uint32, bool) {
+	for tag, n := range auxTagNames {
+		if n == name {
+			return tag, true
+		}
+	}
+	return 0, false
+}
+
+// Return the first non-deleted aux item with a given tag.
+func FindAuxItem(items []AuxItem, tag uint32) (AuxItem, bool) {
+	for _, item := range items {
+		if item.Tag == tag && !item.Flags.Deleted {
+			return item, true
+		}
+	}
+	return AuxItem{}, false
+}
+
+// Return all non-deleted aux items with a given tag.
+func FindAuxItems(items []AuxItem, tag uint32) []AuxItem {
+	var rv []AuxItem
+	for _, item := range items {
+		if item.Tag == tag && !item.Flags.Deleted {
+			rv = append(rv, item)
+		}
+	}
+	return rv
+}
+
+// Create a content-type aux item, for example "text/plain;charset=utf-8".
+func ContentTypeAux(contentType string) AuxItemInput {
+	return NewAuxItemInput(AuxContentType, contentType)
+}
+
+// Create a fast-reply aux item.
+func FastReplyAux(reply string) AuxItemInput {
+	return NewAuxItemInput(AuxFastReply, reply)
+}
+
+// The kinds of objects a cross-reference can point to
+type CrossReferenceKind byte
+
+const (
+	TextReference       = CrossReferenceKind('T')
+	ConferenceReference = CrossReferenceKind('C')
+	PersonReference     = CrossReferenceKind('P')
+)
+
+// A decoded cross-reference aux item
+type CrossReference struct {
+	Kind        CrossReferenceKind
+	Target      uint32
+	Description string
+}
+
+// Create a cross-reference aux item, pointing to a text, conference or
+// person. The description is optional.
+func CrossReferenceAux(kind CrossReferenceKind, target uint32, description string) AuxItemInput {
+	data := fmt.Sprintf("%c%d", kind, target)
+	if description != "" {
+		data = fmt.Sprintf("%s %s", data, description)
+	}
+	return NewAuxItemInput(AuxCrossReference, data)
+}
+
+// Parse the data of a cross-reference aux item.
+func ParseCrossReference(data string) (CrossReference, error) {
+	var rv CrossReference
+
+	if len(data) < 2 {
+		return rv, fmt.Errorf("Cross-reference %q too short", data)
+	}
+	rv.Kind = CrossReferenceKind(data[0])
+	switch rv.Kind {
+	case TextReference, ConferenceReference, PersonReference:
+	default:
+		return rv, fmt.Errorf("Unknown cross-reference kind '%c'", data[0])
+	}
+
+	num := data[1:]
+	if ix := strings.IndexByte(num, ' '); ix >= 0 {
+		rv.Description = num[ix+1:]
+		num = num[:ix]
+	}
+	target, err := strconv.ParseUint(num, 10, 32)
+	if err != nil {
+		return rv, err
+	}
+	rv.Target = uint32(target)
+
+	return rv, nil
+}
+
+// Create a no-comments aux item.
+func NoCommentsAux() AuxItemInput {
+	return NewAuxItemInput(AuxNoComments, "")
+}
+
+// Create a personal-comment aux item.
+func PersonalCommentAux() AuxItemInput {
+	return NewAuxItemInput(AuxPersonalComment, "")
+}
+
+// Create a creating-software aux item.
+func CreatingSoftwareAux(name, version string) AuxItemInput {
+	return NewAuxItemInput(AuxCreatingSoftware, fmt.Sprintf("%s %s", name, version))
+}
+
+// Create a faq-text aux item, to be set on a conference or the server.
+func FAQTextAux(text TextNo) AuxItemInput {
+	return NewAuxItemInput(AuxFAQText, fmt.Sprintf("%d", text))
+}
+
+// Create a faq-for-conf aux item, to be set on a FAQ text.
+func FAQForConfAux(conf ConfNo) AuxItemInput {
+	return NewAuxItemInput(AuxFAQForConf, fmt.Sprintf("%d", conf))
+}
+
+// Create an mx-* aux item, carrying a mail header imported by a mail
+// gateway. The tag must be one of the AuxMX* tags.
+func MXAux(tag uint32, value string) (AuxItemInput, error) {
+	if !strings.HasPrefix(AuxTagName(tag), "mx-") {
+		return AuxItemInput{}, fmt.Errorf("Tag %d is not an mx-* tag", tag)
+	}
+	return NewAuxItemInput(tag, value), nil
+}
+
+// Parse an aux item whose data is a text number, such as faq-text.
+func AuxTextNo(item AuxItem) (TextNo, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(item.data), 10, 32)
+	return TextNo(n), err
+}
+
+// Parse an aux item whose data is a conference number, such as
+// faq-for-conf or recommended-conf.
+func AuxConfNo(item AuxItem) (ConfNo, error) {
+	fields := strings.Fields(item.data)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("Empty aux item data")
+	}
+	n, err := strconv.ParseUint(fields[0], 10, 16)
+	return ConfNo(n), err
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuxTagName(t *testing.T) {
+	cases := []struct {
+		tag  uint32
+		want string
+	}{
+		{AuxContentType, "content-type"},
+		{AuxFAQText, "faq-text"},
+		{AuxMXMessageID, "mx-message-id"},
+		{AuxMXMIMEFileName, "mx-mime-file-name"},
+		{4711, "aux-4711"},
+	}
+
+	for ix, c := range cases {
+		saw := AuxTagName(c.tag)
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %s, want %s", ix, saw, c.want)
+		}
+		if tag, ok := AuxTagFromName(saw); ok && tag != c.tag {
+			t.Errorf("Case #%d, saw tag %d, want %d", ix, tag, c.tag)
+		}
+	}
+}
+
+func TestCrossReference(t *testing.T) {
+	cases := []struct {
+		kind        CrossReferenceKind
+		target      uint32
+		description string
+		want        string
+	}{
+		{TextReference, 4711, "", "T4711"},
+		{ConferenceReference, 17, "Trains", "C17 Trains"},
+		{PersonReference, 6, "David Byers", "P6 David Byers"},
+	}
+
+	for ix, c := range cases {
+		item := CrossReferenceAux(c.kind, c.target, c.description)
+		if item.Tag != AuxCrossReference {
+			t.Errorf("Case #%d, saw tag %d, want %d", ix, item.Tag, AuxCrossReference)
+		}
+		if item.Data() != c.want {
+			t.Errorf("Case #%d, saw data %q, want %q", ix, item.Data(), c.want)
+		}
+
+		ref, err := ParseCrossReference(item.Data())
+		if err != nil {
+			t.Errorf("Case #%d, unexpected error %v", ix, err)
+			continue
+		}
+		want := CrossReference{Kind: c.kind, Target: c.target, Description: c.description}
+		if ref != want {
+			t.Errorf("Case #%d, saw %+v, want %+v", ix, ref, want)
+		}
+	}
+
+	for _, bad := range []string{"", "T", "X17", "Tzz"} {
+		if _, err := ParseCrossReference(bad); err == nil {
+			t.Errorf("Parsing %q, expected an error, saw none", bad)
+		}
+	}
+}
+
+func TestFindAuxItem(t *testing.T) {
+	var deleted, live, other AuxItem
+	deleted.Tag = AuxContentType
+	deleted.Flags.Deleted = true
+	deleted.SetData("text/enriched")
+	live.Tag = AuxContentType
+	live.SetData("text/plain")
+	other.Tag = AuxFastReply
+	other.SetData("Indeed")
+	items := []AuxItem{deleted, other, live}
+
+	item, ok := FindAuxItem(items, AuxContentType)
+	if !ok || item.Data() != "text/plain" {
+		t.Errorf("saw %q (%v), want text/plain", item.Data(), ok)
+	}
+	if _, ok := FindAuxItem(items, AuxNoComments); ok {
+		t.Errorf("found a no-comments item, expected none")
+	}
+	if n := len(FindAuxItems(items, AuxFastReply)); n != 1 {
+		t.Errorf("saw %d fast-reply items, want 1", n)
+	}
+}
+
+func TestAuxItemJSON(t *testing.T) {
+	item := AuxItem{AuxNo: 3, Tag: AuxContentType, Creator: 6, CreatedAt: time.Date(2022, 7, 15, 12, 0, 0, 0, time.UTC)}
+	item.Flags.Inherit = true
+	item.SetData("text/plain")
+
+	b, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var back AuxItem
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(back, item) {
+		t.Errorf("saw %+v, want %+v", back, item)
+	}
+	if back.Data() != "text/plain" {
+		t.Errorf("saw data %q, want text/plain", back.Data())
+	}
+}
+
+func TestMXAux(t *testing.T) {
+	if _, err := MXAux(AuxMXFrom, "someone@example.com"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := MXAux(AuxFastReply, "nope"); err == nil {
+		t.Errorf("expected an error for a non-mx tag, saw none")
+	}
+}
@@ -82,6 +82,74 @@ func (p PrivBits) Repr() string {
 	return fmt.Sprintf("%016b", tmp)
 }
 
+func (f AuxItemFlags) Repr() string {
+	ar := []byte("00000000")
+	if f.Deleted {
+		ar[0] = '1'
+	}
+	if f.Inherit {
+		ar[1] = '1'
+	}
+	if f.Secret {
+		ar[2] = '1'
+	}
+	if f.HideCreator {
+		ar[3] = '1'
+	}
+	if f.DontGarb {
+		ar[4] = '1'
+	}
+	if f.Reserved2 {
+		ar[5] = '1'
+	}
+	if f.Reserved3 {
+		ar[6] = '1'
+	}
+	if f.Reserved4 {
+		ar[7] = '1'
+	}
+
+	return string(ar)
+}
+
+func (f PersonalFlags) Repr() string {
+	if f.UnreadIsSecret {
+		return "10000000"
+	}
+	return "00000000"
+}
+
+func (m MembershipType) Repr() string {
+	ar := []byte("00000000")
+	if m.Invitation {
+		ar[0] = '1'
+	}
+	if m.Passive {
+		ar[1] = '1'
+	}
+	if m.Secret {
+		ar[2] = '1'
+	}
+	if m.PassiveMessageInver {
+		ar[3] = '1'
+	}
+
+	return string(ar)
+}
+
+func ReadRangeArray(rs []ReadRange) string {
+	var b strings.Builder
+	w := &b
+
+	fmt.Fprintf(w, "%d { ", len(rs))
+	for _, v := range rs {
+		fmt.Fprintf(w, "%d %d ", v.FirstRead, v.LastRead)
+	}
+	fmt.Fprintf(w, "}")
+
+	return w.String()
+}
+
 func TextNoArray(ts []TextNo) string {
 	var b strings.Builder
 	w := &b
@@ -95,6 +163,52 @@ func TextNoArray(ts []TextNo) string {
 	return w.String()
 }
 
+func AuxNoArray(as []AuxNo) string {
+	var b strings.Builder
+	w := &b
+
+	fmt.Fprintf(w, "%d { ", len(as))
+	for _, v := range as {
+		fmt.Fprintf(w, "%d ", v)
+	}
+	fmt.Fprintf(w, "}")
+
+	return w.String()
+}
+
+// Return the on-the-wire representation of a misc-info array, as used
+// when creating texts. Only the recipient, comment and footnote items
+// can be sent to the server. The other items, such as local numbers
+// and times, are set by the server and are left out, so a misc-info
+// list from a text status can be passed as is.
+func MiscInfoArray(items []MiscInfo) string {
+	var b strings.Builder
+	w := &b
+
+	var n int
+	for _, item := range items {
+		var value uint32
+		switch InfoType(item.Selector) {
+		case Recipient:
+			value = uint32(item.Recipient)
+		case CCRecipient:
+			value = uint32(item.CCRecipient)
+		case BCCRecipient:
+			value = uint32(item.BCCRecipient)
+		case CommentTo:
+			value = uint32(item.CommentTo)
+		case FootnoteTo:
+			value = uint32(item.FootnoteTo)
+		default:
+			continue
+		}
+		fmt.Fprintf(w, "%d %d ", item.Selector, value)
+		n++
+	}
+
+	return fmt.Sprintf("%d { %s}", n, b.String())
+}
+
 func UInt32Array(ar []uint32) string {
 	var b strings.Builder
 	w := &b
@@ -108,19 +222,8 @@ func UInt32Array(ar []uint32) string {
 	return w.String()
 }
 
+// Return the on-the-wire representation of a time, expressed in the
+// time zone of the time itself.
 func StringTime(when time.Time) string {
-	sec := when.Second()
-	min := when.Minute()
-	hour := when.Hour()
-	mday := when.Day()
-	mon := when.Month() - 1
-	wday := when.Weekday()
-	yday := when.YearDay() - 1
-	year := when.Year() - 1900
-	isdst := 0
-	if when.IsDST() {
-		isdst = 1
-	}
-
-	return fmt.Sprintf("%d %d %d %d %d %d %d %d %d", sec, min, hour, mday, mon, year, wday, yday, isdst)
+	return TimeCodec{Location: when.Location()}.Encode(when)
 }
@@ -3,6 +3,7 @@ package types
 import (
 	"testing"
 
+	"strings"
 	"time"
 )
 
@@ -58,3 +59,73 @@ func TestTimeMarshalling(t *testing.T) {
 		}
 	}
 }
+
+func TestAuxItemFlags(t *testing.T) {
+	cases := []struct {
+		flags AuxItemFlags
+		want  string
+	}{
+		{AuxItemFlags{}, "00000000"},
+		{AuxItemFlags{Deleted: true}, "10000000"},
+		{AuxItemFlags{Inherit: true, DontGarb: true}, "01001000"},
+		{AuxItemFlags{Secret: true, HideCreator: true, Reserved4: true}, "00110001"},
+	}
+
+	for ix, c := range cases {
+		saw := c.flags.Repr()
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %s, want %s", ix, saw, c.want)
+		}
+	}
+}
+
+func TestPersonalFlags(t *testing.T) {
+	cases := []struct {
+		flags PersonalFlags
+		want  string
+	}{
+		{PersonalFlags{}, "00000000"},
+		{PersonalFlags{UnreadIsSecret: true}, "10000000"},
+	}
+
+	for ix, c := range cases {
+		saw := c.flags.Repr()
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %s, want %s", ix, saw, c.want)
+		}
+		if back := ReadPersonalFlags(strings.NewReader(saw)); back != c.flags {
+			t.Errorf("Case #%d, read back %+v, want %+v", ix, back, c.flags)
+		}
+	}
+}
+
+func TestMiscInfoArray(t *testing.T) {
+	cases := []struct {
+		items []MiscInfo
+		want  string
+	}{
+		{nil, "0 { }"},
+		{[]MiscInfo{RecipientMisc(17)}, "1 { 0 17 }"},
+		{
+			[]MiscInfo{RecipientMisc(17), CCRecipientMisc(6), BCCRecipientMisc(9), CommentToMisc(4711), FootnoteToMisc(4712)},
+			"5 { 0 17 1 6 15 9 2 4711 4 4712 }",
+		},
+		{
+			[]MiscInfo{
+				RecipientMisc(17),
+				{Selector: uint32(LocalNo), LocalNo: 99},
+				{Selector: uint32(CommentIn), CommentedIn: 4713},
+				{Selector: uint32(SentAt), SentAt: time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC)},
+				CommentToMisc(4711),
+			},
+			"2 { 0 17 2 4711 }",
+		},
+	}
+
+	for ix, c := range cases {
+		saw := MiscInfoArray(c.items)
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %q, want %q", ix, saw, c.want)
+		}
+	}
+}
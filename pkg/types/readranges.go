@@ -0,0 +1,43 @@
+// Working with the read ranges of a membership
+
+package types
+
+import "sort"
+
+// Return true if a local text number is in a list of read ranges.
+func IsRead(ranges []ReadRange, local TextNo) bool {
+	for _, r := range ranges {
+		if local >= r.FirstRead && local <= r.LastRead {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a local text number to a sorted list of read ranges, merging
+// ranges that become adjacent. The ranges passed in are left as they
+// are, a new list is returned.
+func AddToReadRanges(ranges []ReadRange, local TextNo) []ReadRange {
+	if IsRead(ranges, local) {
+		return ranges
+	}
+
+	rv := make([]ReadRange, 0, len(ranges)+1)
+	rv = append(rv, ranges...)
+	rv = append(rv, ReadRange{FirstRead: local, LastRead: local})
+	sort.Slice(rv, func(i, j int) bool { return rv[i].FirstRead < rv[j].FirstRead })
+
+	merged := rv[:1]
+	for _, r := range rv[1:] {
+		last := &merged[len(merged)-1]
+		if r.FirstRead <= last.LastRead+1 {
+			if r.LastRead > last.LastRead {
+				last.LastRead = r.LastRead
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
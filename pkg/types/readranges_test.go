@@ -0,0 +1,43 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsRead(t *testing.T) {
+	ranges := []ReadRange{{1, 3}, {7, 7}}
+	for local, want := range map[TextNo]bool{1: true, 3: true, 4: false, 7: true, 8: false} {
+		if saw := IsRead(ranges, local); saw != want {
+			t.Errorf("IsRead(%v, %d), saw %v, want %v", ranges, local, saw, want)
+		}
+	}
+}
+
+func TestAddToReadRanges(t *testing.T) {
+	cases := []struct {
+		ranges []ReadRange
+		local  TextNo
+		want   []ReadRange
+	}{
+		{nil, 1, []ReadRange{{1, 1}}},
+		{[]ReadRange{{1, 2}}, 3, []ReadRange{{1, 3}}},
+		{[]ReadRange{{1, 2}, {4, 5}}, 3, []ReadRange{{1, 5}}},
+		{[]ReadRange{{4, 5}}, 1, []ReadRange{{1, 1}, {4, 5}}},
+		{[]ReadRange{{4, 5}}, 5, []ReadRange{{4, 5}}},
+	}
+
+	for ix, c := range cases {
+		if saw := AddToReadRanges(c.ranges, c.local); !reflect.DeepEqual(saw, c.want) {
+			t.Errorf("Case #%d, saw %v, want %v", ix, saw, c.want)
+		}
+	}
+
+	// The ranges passed in are not changed, even with room to grow.
+	ranges := make([]ReadRange, 2, 3)
+	ranges[0], ranges[1] = ReadRange{4, 5}, ReadRange{7, 8}
+	AddToReadRanges(ranges[:1], 1)
+	if want := []ReadRange{{4, 5}, {7, 8}}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("saw %v after adding, want %v", ranges, want)
+	}
+}
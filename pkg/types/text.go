@@ -0,0 +1,245 @@
+// The contents of a LysKOM text, split into subject and body
+
+package types
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"github.com/vatine/komandgo/pkg/charset"
+)
+
+// Content types commonly seen on LysKOM texts. Texts without a
+// content-type aux item are text/x-kom-basic.
+const (
+	ContentKomBasic       = "text/x-kom-basic"
+	ContentPlain          = "text/plain"
+	ContentEnriched       = "text/enriched"
+	ContentMultipartMixed = "multipart/mixed"
+	ContentUserArea       = "x-kom/user-area"
+	ContentOldKomBasic    = "x-kom/basic"
+	ContentOldKomText     = "x-kom/text"
+)
+
+// A Text is the contents of a LysKOM text. On the wire, the subject
+// and the body are separated by the first newline. Strings are kept as
+// UTF-8, Charset names the character set used when talking to the
+// server, and is empty if the server's default should be used.
+type Text struct {
+	Subject     string
+	Body        string
+	ContentType string
+	Charset     string
+}
+
+// Create a text with a given content type. The content type may carry
+// a charset parameter, which then overrides charset.
+func NewText(subject, body, contentType, cs string) Text {
+	rv := Text{
+		Subject:     subject,
+		Body:        body,
+		ContentType: contentType,
+		Charset:     cs,
+	}
+	if rv.ContentType == "" {
+		rv.ContentType = ContentKomBasic
+	}
+
+	if mediaType, params, err := mime.ParseMediaType(rv.ContentType); err == nil {
+		if c, ok := params["charset"]; ok {
+			rv.Charset = c
+			delete(params, "charset")
+			rv.ContentType = mime.FormatMediaType(mediaType, params)
+		}
+	}
+
+	return rv
+}
+
+// Split the contents of a text, as returned by get-text and already
+// converted to UTF-8, into subject and body. The content type is the
+// data of the text's content-type aux item, if it has one.
+func ParseText(contents, contentType string) Text {
+	rv := NewText("", "", contentType, "")
+
+	if ix := strings.IndexByte(contents, '\n'); ix >= 0 {
+		rv.Subject = contents[:ix]
+		rv.Body = contents[ix+1:]
+	} else {
+		rv.Subject = contents
+	}
+
+	return rv
+}
+
+// Return the text as it is sent to and received from the server.
+func (t Text) String() string {
+	return t.Subject + "\n" + t.Body
+}
+
+// Return the media type of the text, without parameters. The
+// pre-MIME x-kom/* names are mapped to their current names.
+func (t Text) MediaType() string {
+	mediaType, _, err := mime.ParseMediaType(t.ContentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(t.ContentType))
+	}
+
+	switch mediaType {
+	case "", ContentOldKomBasic, ContentOldKomText:
+		return ContentKomBasic
+	}
+	return mediaType
+}
+
+// Return true if the text is meant to be read as text, rather than
+// being binary data or a user area.
+func (t Text) IsText() bool {
+	return strings.HasPrefix(t.MediaType(), "text/") || t.IsMultipart()
+}
+
+// Return true if the text is a multipart/* text.
+func (t Text) IsMultipart() bool {
+	return strings.HasPrefix(t.MediaType(), "multipart/")
+}
+
+// Return the full content type, including the charset parameter if
+// the text has a character set.
+func (t Text) FullContentType() string {
+	mediaType, params, err := mime.ParseMediaType(t.ContentType)
+	if err != nil {
+		return t.ContentType
+	}
+	if t.Charset != "" {
+		params["charset"] = t.Charset
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// Return the arguments needed to create the text with create-text:
+// the contents, and aux items carrying the content type. Texts of
+// type text/x-kom-basic without a character set need no aux item, as
+// that is what the server assumes.
+func (t Text) Payload() (string, []AuxItemInput) {
+	if t.MediaType() == ContentKomBasic && t.Charset == "" {
+		return t.String(), nil
+	}
+	return t.String(), []AuxItemInput{ContentTypeAux(t.FullContentType())}
+}
+
+// Return the body of a text as plain text, removing the formatting
+// from text/enriched. Other content types are returned unchanged.
+func (t Text) PlainBody() string {
+	if t.MediaType() != ContentEnriched {
+		return t.Body
+	}
+	return enrichedToPlain(t.Body)
+}
+
+// Convert text/enriched (RFC 1896) to plain text. Formatting commands
+// are dropped, as is the contents of <param> commands, "<<" is a
+// literal "<", and a run of n newlines is n-1 newlines (a lone newline
+// is a space).
+func enrichedToPlain(s string) string {
+	var b strings.Builder
+	param := 0
+
+	for ix := 0; ix < len(s); ix++ {
+		c := s[ix]
+		switch {
+		case c == '<' && ix+1 < len(s) && s[ix+1] == '<':
+			if param == 0 {
+				b.WriteByte('<')
+			}
+			ix++
+		case c == '<':
+			end := strings.IndexByte(s[ix:], '>')
+			if end < 0 {
+				return b.String()
+			}
+			switch strings.ToLower(s[ix+1 : ix+end]) {
+			case "param":
+				param++
+			case "/param":
+				if param > 0 {
+					param--
+				}
+			}
+			ix += end
+		case param > 0:
+			continue
+		case c == '\n':
+			n := 1
+			for ix+1 < len(s) && s[ix+1] == '\n' {
+				n++
+				ix++
+			}
+			if n == 1 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteString(strings.Repeat("\n", n-1))
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// Split the body of a multipart text into its parts. Each part is
+// returned as a Text with an empty subject, and with its content type
+// and character set taken from the part's headers. The body of a
+// multipart text holds the bytes as sent by the server, and each part
+// is converted to UTF-8 from its own character set.
+func (t Text) Parts() ([]Text, error) {
+	mediaType, params, err := mime.ParseMediaType(t.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("Content type %s is not multipart", mediaType)
+	}
+	boundary, ok := params["boundary"]
+	if !ok {
+		return nil, fmt.Errorf("Multipart text without a boundary")
+	}
+
+	var rv []Text
+	mr := multipart.NewReader(strings.NewReader(t.Body), boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return rv, nil
+		}
+		if err != nil {
+			return rv, err
+		}
+
+		// The multipart reader only undoes quoted-printable.
+		var r io.Reader = part
+		if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+			r = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return rv, err
+		}
+
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = ContentPlain
+		}
+		p := NewText("", string(data), contentType, "")
+		if p.Charset != "" {
+			// Bytes not valid in the character set become
+			// replacement characters.
+			p.Body, _ = charset.Decode(p.Body, p.Charset)
+		}
+		rv = append(rv, p)
+	}
+}
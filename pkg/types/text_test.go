@@ -0,0 +1,148 @@
+package types
+
+import (
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	cases := []struct {
+		contents    string
+		contentType string
+		want        Text
+	}{
+		{"Subject\nBody\nmore body", "", Text{"Subject", "Body\nmore body", ContentKomBasic, ""}},
+		{"Only a subject", "text/plain", Text{"Only a subject", "", "text/plain", ""}},
+		{"S\nB", "text/plain; charset=utf-8", Text{"S", "B", "text/plain", "utf-8"}},
+		{"\nNo subject", "x-kom/basic", Text{"", "No subject", "x-kom/basic", ""}},
+	}
+
+	for ix, c := range cases {
+		saw := ParseText(c.contents, c.contentType)
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %+v, want %+v", ix, saw, c.want)
+		}
+	}
+}
+
+func TestTextMediaType(t *testing.T) {
+	cases := []struct {
+		contentType string
+		want        string
+		isText      bool
+	}{
+		{"", ContentKomBasic, true},
+		{"x-kom/basic", ContentKomBasic, true},
+		{"Text/Plain; charset=utf-8", ContentPlain, true},
+		{"multipart/mixed; boundary=xyz", ContentMultipartMixed, true},
+		{"x-kom/user-area", ContentUserArea, false},
+		{"image/png", "image/png", false},
+	}
+
+	for ix, c := range cases {
+		text := Text{ContentType: c.contentType}
+		if saw := text.MediaType(); saw != c.want {
+			t.Errorf("Case #%d, saw %s, want %s", ix, saw, c.want)
+		}
+		if saw := text.IsText(); saw != c.isText {
+			t.Errorf("Case #%d, saw IsText %v, want %v", ix, saw, c.isText)
+		}
+	}
+}
+
+func TestTextPayload(t *testing.T) {
+	cases := []struct {
+		text     Text
+		contents string
+		aux      string
+	}{
+		{NewText("Hej", "Hopp", "", ""), "Hej\nHopp", ""},
+		{NewText("Hej", "Hopp", "", "utf-8"), "Hej\nHopp", "text/x-kom-basic; charset=utf-8"},
+		{NewText("Hej", "Hopp", "text/plain;charset=iso-8859-1", ""), "Hej\nHopp", "text/plain; charset=iso-8859-1"},
+		{NewText("Hej", "Hopp", "text/enriched", ""), "Hej\nHopp", "text/enriched"},
+	}
+
+	for ix, c := range cases {
+		contents, items := c.text.Payload()
+		if contents != c.contents {
+			t.Errorf("Case #%d, saw %q, want %q", ix, contents, c.contents)
+		}
+		switch {
+		case c.aux == "" && len(items) != 0:
+			t.Errorf("Case #%d, unexpected aux items %+v", ix, items)
+		case c.aux != "" && (len(items) != 1 || items[0].Tag != AuxContentType || items[0].Data() != c.aux):
+			t.Errorf("Case #%d, saw aux items %+v, want content type %q", ix, items, c.aux)
+		}
+	}
+}
+
+func TestPlainBody(t *testing.T) {
+	text := NewText("S", "<bold>Hej</bold>\nhopp,\n\n<color><param>red</param>1 <<</color> 2", ContentEnriched, "")
+	want := "Hej hopp,\n1 < 2"
+	if saw := text.PlainBody(); saw != want {
+		t.Errorf("saw %q, want %q", saw, want)
+	}
+
+	text.ContentType = ContentPlain
+	if saw := text.PlainBody(); saw != text.Body {
+		t.Errorf("saw %q, want the body unchanged", saw)
+	}
+}
+
+func TestTextParts(t *testing.T) {
+	body := "--xyz\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n\r\n" +
+		"r\xe4ksm\xf6rg\xe5s\r\n" +
+		"--xyz\r\n\r\n" +
+		"second\r\n" +
+		"--xyz--\r\n"
+	text := NewText("Subject", body, "multipart/mixed; boundary=xyz", "")
+
+	parts, err := text.Parts()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("saw %d parts, want 2", len(parts))
+	}
+	if parts[0].Body != "räksmörgås" || parts[0].MediaType() != ContentPlain || parts[0].Charset != "iso-8859-1" {
+		t.Errorf("unexpected first part %+v", parts[0])
+	}
+	if parts[1].Body != "second" || parts[1].MediaType() != ContentPlain {
+		t.Errorf("unexpected second part %+v", parts[1])
+	}
+
+	// A part that is not valid in its character set, and base64
+	// encoded parts.
+	body = "--xyz\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+		"r\xe4ksm\xf6rg\xe5s\r\n" +
+		"--xyz\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		"cuRrc232cmflcw==\r\n" +
+		"--xyz\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-Transfer-Encoding: BASE64\r\n\r\n" +
+		"iVBO\r\nRw0K\r\n" +
+		"--xyz--\r\n"
+	parts, err = NewText("Subject", body, "multipart/mixed; boundary=xyz", "").Parts()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("saw %d parts, want 3", len(parts))
+	}
+	if want := "r\ufffdksm\ufffdrg\ufffds"; parts[0].Body != want {
+		t.Errorf("saw first part %q, want %q", parts[0].Body, want)
+	}
+	if want := "räksmörgås"; parts[1].Body != want {
+		t.Errorf("saw second part %q, want %q", parts[1].Body, want)
+	}
+	if want := "\x89PNG\r\n"; parts[2].Body != want || parts[2].MediaType() != "image/png" {
+		t.Errorf("saw third part %q (%s), want %q", parts[2].Body, parts[2].MediaType(), want)
+	}
+
+	if _, err := NewText("S", "B", "text/plain", "").Parts(); err == nil {
+		t.Errorf("expected an error splitting a text/plain text")
+	}
+}
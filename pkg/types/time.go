@@ -0,0 +1,109 @@
+// Encoding and decoding of the Protocol A Time type
+
+package types
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// A TimeCodec converts between time.Time and the Protocol A time
+// representation, "sec min hour mday mon year wday yday isdst", where
+// months are counted from zero and years from 1900. The server sends
+// times in its own local time zone, unless the client has asked for
+// UTC with set-connection-time-format. A nil Location means UTC.
+type TimeCodec struct {
+	Location *time.Location
+}
+
+// A TimeCodec for connections that have switched to UTC
+var UTCTimes = TimeCodec{Location: time.UTC}
+
+func (tc TimeCodec) location() *time.Location {
+	if tc.Location == nil {
+		return time.UTC
+	}
+	return tc.Location
+}
+
+// Return the on-the-wire representation of a time, expressed in the
+// codec's time zone.
+func (tc TimeCodec) Encode(when time.Time) string {
+	when = when.In(tc.location())
+
+	isdst := 0
+	if when.IsDST() {
+		isdst = 1
+	}
+
+	return fmt.Sprintf("%d %d %d %d %d %d %d %d %d",
+		when.Second(), when.Minute(), when.Hour(),
+		when.Day(), int(when.Month())-1, when.Year()-1900,
+		int(when.Weekday()), when.YearDay()-1, isdst)
+}
+
+// Read a time from a reader. An error is returned if any of the
+// fields are out of range. If the weekday or day of the year do not
+// match the date, the decoded time is returned together with an
+// error.
+func (tc TimeCodec) Decode(r io.Reader) (time.Time, error) {
+	var sec, min, hour, mday, mon, year, wday, yday, isdst int
+
+	_, err := fmt.Fscan(r, &sec, &min, &hour, &mday, &mon, &year, &wday, &yday, &isdst)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return tc.FromFields(sec, min, hour, mday, mon, year, wday, yday, isdst)
+}
+
+// Build a time from the fields of a Protocol A time, see Decode.
+func (tc TimeCodec) FromFields(sec, min, hour, mday, mon, year, wday, yday, isdst int) (time.Time, error) {
+	switch {
+	case sec < 0 || sec > 61:
+		return time.Time{}, fmt.Errorf("Second %d out of range", sec)
+	case min < 0 || min > 59:
+		return time.Time{}, fmt.Errorf("Minute %d out of range", min)
+	case hour < 0 || hour > 23:
+		return time.Time{}, fmt.Errorf("Hour %d out of range", hour)
+	case mday < 1 || mday > 31:
+		return time.Time{}, fmt.Errorf("Day of month %d out of range", mday)
+	case mon < 0 || mon > 11:
+		return time.Time{}, fmt.Errorf("Month %d out of range", mon)
+	case year < 0:
+		return time.Time{}, fmt.Errorf("Year %d out of range", year)
+	case isdst < 0 || isdst > 1:
+		return time.Time{}, fmt.Errorf("Is-dst %d out of range", isdst)
+	}
+
+	// Leap seconds are not representable, fold them into the
+	// last second of the minute.
+	if sec > 59 {
+		sec = 59
+	}
+
+	loc := tc.location()
+	rv := time.Date(1900+year, time.Month(mon+1), mday, hour, min, sec, 0, loc)
+
+	// During the hour when clocks are set back, the same wall
+	// clock time happens twice, and is-dst tells them apart.
+	if rv.IsDST() != (isdst == 1) {
+		for _, d := range []time.Duration{-time.Hour, time.Hour} {
+			alt := rv.Add(d)
+			if alt.IsDST() == (isdst == 1) && alt.Hour() == hour && alt.Minute() == min {
+				rv = alt
+				break
+			}
+		}
+	}
+
+	if rv.Day() != mday || int(rv.Month()) != mon+1 {
+		return rv, fmt.Errorf("Day %d does not exist in month %d of %d", mday, mon+1, 1900+year)
+	}
+	if int(rv.Weekday()) != wday || rv.YearDay()-1 != yday {
+		return rv, fmt.Errorf("Weekday %d and day of year %d do not match %s", wday, yday, rv.Format("2006-01-02"))
+	}
+
+	return rv, nil
+}
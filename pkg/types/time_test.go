@@ -0,0 +1,70 @@
+package types
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeRoundTrip(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	cases := []struct {
+		codec TimeCodec
+		when  time.Time
+		want  string
+	}{
+		{UTCTimes, time.Date(1997, time.July, 17, 19, 47, 23, 0, time.UTC), "23 47 19 17 6 97 4 197 0"},
+		{TimeCodec{}, time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC), "0 0 0 1 0 100 6 0 0"},
+		{TimeCodec{Location: stockholm}, time.Date(1997, time.July, 19, 22, 6, 49, 0, stockholm), "49 6 22 19 6 97 6 199 1"},
+		{TimeCodec{Location: stockholm}, time.Date(1997, time.July, 19, 20, 6, 49, 0, time.UTC), "49 6 22 19 6 97 6 199 1"},
+		{TimeCodec{Location: stockholm}, time.Date(2021, time.December, 31, 23, 59, 59, 0, stockholm), "59 59 23 31 11 121 5 364 0"},
+		// The two occurrences of 02:30 when leaving summer time
+		{TimeCodec{Location: stockholm}, time.Date(2022, time.October, 30, 0, 30, 0, 0, time.UTC), "0 30 2 30 9 122 0 302 1"},
+		{TimeCodec{Location: stockholm}, time.Date(2022, time.October, 30, 1, 30, 0, 0, time.UTC), "0 30 2 30 9 122 0 302 0"},
+	}
+
+	for ix, c := range cases {
+		saw := c.codec.Encode(c.when)
+		if saw != c.want {
+			t.Errorf("Case #%d, encoded %s, want %s", ix, saw, c.want)
+		}
+
+		back, err := c.codec.Decode(strings.NewReader(saw))
+		if err != nil {
+			t.Errorf("Case #%d, unexpected error %v", ix, err)
+		}
+		if !back.Equal(c.when) {
+			t.Errorf("Case #%d, decoded %s, want %s", ix, back, c.when)
+		}
+	}
+}
+
+func TestTimeDecodeErrors(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantTime bool
+	}{
+		{"0 0 0 1 12 100 6 0 0", false},
+		{"0 0 24 1 0 100 6 0 0", false},
+		{"0 60 0 1 0 100 6 0 0", false},
+		{"0 0 0 0 0 100 6 0 0", false},
+		{"0 0 0 1 0 100 6 0 2", false},
+		{"0 0 0 1 0", false},
+		{"0 0 0 31 1 100 4 61 0", true},
+		{"0 0 0 1 0 100 5 0 0", true},
+	}
+
+	for ix, c := range cases {
+		got, err := UTCTimes.Decode(strings.NewReader(c.in))
+		if err == nil {
+			t.Errorf("Case #%d, expected an error, saw none", ix)
+		}
+		if got.IsZero() == c.wantTime {
+			t.Errorf("Case #%d, saw time %s", ix, got)
+		}
+	}
+}
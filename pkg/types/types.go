@@ -3,6 +3,7 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -12,12 +13,49 @@ type AuxNo uint32
 type SessionNo uint32
 
 type AuxItem struct {
-	AuxNo     AuxNo
-	Tag       uint32
-	Creator   ConfNo
-	CreatedAt time.Time
-	Flags     AuxItemFlags
-	data      string
+	AuxNo        AuxNo
+	Tag          uint32
+	Creator      ConfNo
+	CreatedAt    time.Time
+	Flags        AuxItemFlags
+	InheritLimit uint32
+	data         string
+}
+
+// Return the data carried by the aux item.
+func (a AuxItem) Data() string {
+	return a.data
+}
+
+// Set the data carried by the aux item.
+func (a *AuxItem) SetData(data string) {
+	a.data = data
+}
+
+// The JSON form of an aux item, with the data as a field of its own.
+type auxItemJSON struct {
+	AuxNo        AuxNo
+	Tag          uint32
+	Creator      ConfNo
+	CreatedAt    time.Time
+	Flags        AuxItemFlags
+	InheritLimit uint32
+	Data         string
+}
+
+// Encode an aux item as JSON, including the data.
+func (a AuxItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(auxItemJSON{a.AuxNo, a.Tag, a.Creator, a.CreatedAt, a.Flags, a.InheritLimit, a.data})
+}
+
+// Decode an aux item encoded by MarshalJSON.
+func (a *AuxItem) UnmarshalJSON(b []byte) error {
+	var j auxItemJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*a = AuxItem{j.AuxNo, j.Tag, j.Creator, j.CreatedAt, j.Flags, j.InheritLimit, j.Data}
+	return nil
 }
 
 type AuxItemInput struct {
@@ -27,6 +65,22 @@ type AuxItemInput struct {
 	data         string
 }
 
+// Create an aux item input with the given tag and data, with all
+// flags cleared and no inheritance.
+func NewAuxItemInput(tag uint32, data string) AuxItemInput {
+	return AuxItemInput{Tag: tag, data: data}
+}
+
+// Return the data carried by the aux item input.
+func (a AuxItemInput) Data() string {
+	return a.data
+}
+
+// Set the data carried by the aux item input.
+func (a *AuxItemInput) SetData(data string) {
+	a.data = data
+}
+
 type AuxItemFlags struct {
 	Deleted     bool
 	Inherit     bool
@@ -136,10 +190,13 @@ type Conference struct {
 	Presentation        TextNo
 	Supervisor          ConfNo
 	PermittedSubmitters ConfNo
+	SuperConf           ConfNo
 	MsgOfDay            TextNo
 	Nice                uint32
 	KeepCommented       uint32
 	NoOfMembers         uint32
+	FirstLocalNo        TextNo
+	NoOfTexts           uint32
 	Expire              uint32
 	AuxItems            []AuxItem
 }
@@ -203,6 +260,21 @@ type ReadRange struct {
 	LastRead  TextNo
 }
 
+// A local-to-global mapping for a range of local text numbers in a
+// conference. Local numbers in the range without a pair in Texts have
+// no (visible) text.
+type TextMapping struct {
+	RangeBegin      TextNo
+	RangeEnd        TextNo
+	LaterTextsExist bool
+	Texts           []TextNumberPair
+}
+
+type TextNumberPair struct {
+	LocalNo  TextNo
+	GlobalNo TextNo
+}
+
 type Membership struct {
 	Position     uint32
 	LastTimeRead time.Time
@@ -253,6 +325,27 @@ type MiscInfo struct {
 	BCCRecipient ConfNo
 }
 
+// Misc-info items used when creating a text
+func RecipientMisc(conf ConfNo) MiscInfo {
+	return MiscInfo{Selector: uint32(Recipient), Recipient: conf}
+}
+
+func CCRecipientMisc(conf ConfNo) MiscInfo {
+	return MiscInfo{Selector: uint32(CCRecipient), CCRecipient: conf}
+}
+
+func BCCRecipientMisc(conf ConfNo) MiscInfo {
+	return MiscInfo{Selector: uint32(BCCRecipient), BCCRecipient: conf}
+}
+
+func CommentToMisc(text TextNo) MiscInfo {
+	return MiscInfo{Selector: uint32(CommentTo), CommentTo: text}
+}
+
+func FootnoteToMisc(text TextNo) MiscInfo {
+	return MiscInfo{Selector: uint32(FootnoteTo), FootnoteTo: text}
+}
+
 type InfoType uint8
 
 const (
@@ -266,7 +359,7 @@ const (
 	ReceiveTime
 	SentBy
 	SentAt
-	BCCRecipient
+	BCCRecipient = InfoType(15)
 )
 
 type TextStatOld struct {
@@ -381,3 +474,65 @@ type InfoOld struct {
 	KomNewsConference                ConfNo
 	MOTDOfLyskom                     TextNo
 }
+
+type Info struct {
+	Version                          uint32
+	ConferencePresentationConference ConfNo
+	PersonPresentationConference     ConfNo
+	MOTDConference                   ConfNo
+	KomNewsConference                ConfNo
+	MOTDOfLyskom                     TextNo
+	AuxItems                         []AuxItem
+}
+
+// Return the parts of the server information that can be set with
+// the set-info call.
+func (i Info) Old() InfoOld {
+	return InfoOld{
+		Version:                          i.Version,
+		ConferencePresentationConference: i.ConferencePresentationConference,
+		PersonPresentationConference:     i.PersonPresentationConference,
+		MOTDConference:                   i.MOTDConference,
+		KomNewsConference:                i.KomNewsConference,
+		MOTDOfLyskom:                     i.MOTDOfLyskom,
+	}
+}
+
+type StaticServerInfo struct {
+	BootTime        time.Time
+	SaveTime        time.Time
+	DBStatus        string
+	ExistingTexts   uint32
+	HighestTextNo   TextNo
+	ExistingConfs   uint32
+	ExistingPersons uint32
+	HighestConfNo   ConfNo
+}
+
+type StatsDescription struct {
+	What []string
+	When []uint32
+}
+
+// Return the measurement intervals as durations. An interval of zero
+// means the current value of the measurement.
+func (sd StatsDescription) Intervals() []time.Duration {
+	var rv []time.Duration
+	for _, w := range sd.When {
+		rv = append(rv, time.Duration(w)*time.Second)
+	}
+	return rv
+}
+
+type Stats struct {
+	Average     float64
+	AscentRate  float64
+	DescentRate float64
+}
+
+// A single measurement, averaged over a specific interval
+type Measurement struct {
+	Name     string
+	Interval time.Duration
+	Stats    Stats
+}
@@ -25,7 +25,6 @@ func ReadExtendedConfType(r io.Reader) ExtendedConfType {
 	var rv ExtendedConfType
 
 	fmt.Fscanf(r, "%08b", &tmp)
-	fmt.Printf("%02x\n", tmp)
 	rv.RdProt = (tmp & 0x80) != 0
 	rv.Original = (tmp & 0x40) != 0
 	rv.Secret = (tmp & 0x20) != 0
@@ -44,7 +43,49 @@ func ReadPersonalFlags(r io.Reader) PersonalFlags {
 
 	fmt.Fscanf(r, "%08b", &tmp)
 
-	rv.UnreadIsSecret = (tmp != 0)
+	rv.UnreadIsSecret = (tmp & 0x80) != 0
+
+	return rv
+}
+
+func ReadAuxItemFlags(r io.Reader) AuxItemFlags {
+	var tmp uint8
+	var rv AuxItemFlags
+
+	fmt.Fscanf(r, "%08b", &tmp)
+	rv.Deleted = (tmp & 0x80) != 0
+	rv.Inherit = (tmp & 0x40) != 0
+	rv.Secret = (tmp & 0x20) != 0
+	rv.HideCreator = (tmp & 0x10) != 0
+	rv.DontGarb = (tmp & 0x08) != 0
+	rv.Reserved2 = (tmp & 0x04) != 0
+	rv.Reserved3 = (tmp & 0x02) != 0
+	rv.Reserved4 = (tmp & 0x01) != 0
+
+	return rv
+}
+
+func ReadMembershipType(r io.Reader) MembershipType {
+	var tmp uint8
+	var rv MembershipType
+
+	fmt.Fscanf(r, "%08b", &tmp)
+	rv.Invitation = (tmp & 0x80) != 0
+	rv.Passive = (tmp & 0x40) != 0
+	rv.Secret = (tmp & 0x20) != 0
+	rv.PassiveMessageInver = (tmp & 0x10) != 0
+
+	return rv
+}
+
+func ReadSessionFlags(r io.Reader) SessionFlags {
+	var tmp uint8
+	var rv SessionFlags
+
+	fmt.Fscanf(r, "%08b", &tmp)
+	rv.Invisible = (tmp & 0x80) != 0
+	rv.UserActiveUsed = (tmp & 0x40) != 0
+	rv.UserAbsent = (tmp & 0x20) != 0
 
 	return rv
 }
@@ -75,17 +116,3 @@ func ReadUInt32Array(r io.Reader) ([]uint32, error) {
 
 	return rv, err
 }
-
-// func ReadTime(r io.Reader) time.Time {
-// 	sec := int(readUInt32(r))
-// 	min := int(readUInt32(r))
-// 	hour := int(readUInt32(r))
-// 	mday := int(readUInt32(r))
-// 	mon := int(readUInt32(r))
-// 	year := int(readUInt32(r))
-// 	_ = int(readUInt32(r))
-// 	_ = int(readUInt32(r))
-// 	_ = int(readUInt32(r))
-//
-// 	return time.Date(1900+year, time.Month(mon), mday, hour, min, sec, 0, time.UTC)
-// }
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"bytes"
 	"testing"
 
 	"strings"
@@ -103,3 +104,35 @@ func TestReadUint32Array(t *testing.T) {
 		}
 	}
 }
+
+func TestReadAuxItemFlags(t *testing.T) {
+	cases := []struct {
+		in   string
+		want AuxItemFlags
+	}{
+		{"00000000", AuxItemFlags{}},
+		{"10000000", AuxItemFlags{Deleted: true}},
+		{"01001000", AuxItemFlags{Inherit: true, DontGarb: true}},
+		{" 00110001", AuxItemFlags{Secret: true, HideCreator: true, Reserved4: true}},
+	}
+
+	for ix, c := range cases {
+		saw := ReadAuxItemFlags(strings.NewReader(c.in))
+		if saw != c.want {
+			t.Errorf("Case #%d, saw %+v, want %+v", ix, saw, c.want)
+		}
+	}
+}
+
+func FuzzReadUInt32Array(f *testing.F) {
+	for _, seed := range []string{"3 { 1 2 3 }", "0 { }", "0 *", "4294967295 { 1", "2 { 1 x }", ""} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rv, err := ReadUInt32Array(bytes.NewReader(data))
+		if err == nil && len(rv) > len(data) {
+			t.Errorf("read %d numbers from %d bytes", len(rv), len(data))
+		}
+	})
+}
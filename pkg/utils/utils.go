@@ -23,8 +23,6 @@ func ReadByte(r io.Reader) (byte, error) {
 			return buf[0], nil
 		}
 	}
-
-	return 0, nil
 }
 
 func ReadUInt32FromString(s string, start int) (uint32, int) {
@@ -82,17 +80,25 @@ func ReadDelimitedList(start, end byte, r io.Reader) (string, error) {
 			return string(rv), nil
 		}
 	}
-
-	return "", nil
 }
 
-func ParseConfType(s string, start int) types.ConfType {
+// Parse a 4-bit conference type at an offset in a string.
+func ParseConfType(s string, start int) (types.ConfType, error) {
 	var rv types.ConfType
 
+	if start < 0 || len(s)-start < 4 {
+		return rv, fmt.Errorf("Truncated conference type at %d", start)
+	}
+	for _, c := range s[start : start+4] {
+		if c != '0' && c != '1' {
+			return rv, fmt.Errorf("Malformed conference type %q", s[start:start+4])
+		}
+	}
+
 	rv.RdProt = (s[start+0] == '1')
 	rv.Original = (s[start+1] == '1')
 	rv.Secret = (s[start+2] == '1')
 	rv.LetterBox = (s[start+3] == '1')
 
-	return rv
+	return rv, nil
 }
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/vatine/komandgo/pkg/types"
@@ -37,10 +39,16 @@ func TestParseConfType(t *testing.T) {
 		source string
 		offset int
 		want   types.ConfType
-	}{}
+	}{
+		{"1000", 0, types.ConfType{RdProt: true}},
+		{" 14HIngrid Bergman 0101 6", 19, types.ConfType{Original: true, LetterBox: true}},
+	}
 
 	for ix, c := range cases {
-		got := ParseConfType(c.source, c.offset)
+		got, err := ParseConfType(c.source, c.offset)
+		if err != nil {
+			t.Errorf("Case #%d, unexpected error %v", ix, err)
+		}
 
 		if got.RdProt != c.want.RdProt {
 			t.Errorf("Case #%d, RdProt saw %v, want %v", ix, got.RdProt, c.want.RdProt)
@@ -55,4 +63,53 @@ func TestParseConfType(t *testing.T) {
 			t.Errorf("Case #%d, LetterBox saw %v, want %v", ix, got.LetterBox, c.want.LetterBox)
 		}
 	}
+
+	for _, s := range []string{"", "101", "10x1"} {
+		if _, err := ParseConfType(s, 0); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func FuzzReadDelimitedList(f *testing.F) {
+	for _, seed := range []string{"{ 1 2 3 }", "{ }", "{", "", "x }", "{ 3HHej }"} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := ReadDelimitedList('{', '}', bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+			t.Errorf("saw %q, want a list delimited by braces", s)
+		}
+	})
+}
+
+func FuzzParseConfType(f *testing.F) {
+	f.Add("1000", 0)
+	f.Add(" 14HIngrid Bergman 0101 6", 19)
+	f.Add("10", 1)
+	f.Add("1111", -1)
+
+	f.Fuzz(func(t *testing.T, s string, start int) {
+		ParseConfType(s, start)
+	})
+}
+
+func FuzzReadUInt32FromString(f *testing.F) {
+	f.Add("123 321", 3)
+	f.Add("", 0)
+	f.Add("x", 0)
+
+	f.Fuzz(func(t *testing.T, s string, start int) {
+		if start < 0 {
+			return
+		}
+		_, next := ReadUInt32FromString(s, start)
+		if next < start || (next > len(s) && start <= len(s)) {
+			t.Errorf("ReadUInt32FromString(%q, %d) ended at %d", s, start, next)
+		}
+	})
 }
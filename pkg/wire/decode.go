@@ -0,0 +1,217 @@
+package wire
+
+// Decoding the Protocol A types that are made up of several tokens,
+// in the same style as the single-token reads: the first error is
+// remembered in the Args, and zero values are returned after it.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+// Read a Time, using a codec for the time zone.
+func (a *Args) Time(tc types.TimeCodec) time.Time {
+	var f [9]int
+	for ix := range f {
+		f[ix] = int(a.UInt32())
+	}
+	if a.err != nil {
+		return time.Time{}
+	}
+
+	rv, err := tc.FromFields(f[0], f[1], f[2], f[3], f[4], f[5], f[6], f[7], f[8])
+	if err != nil {
+		a.err = fmt.Errorf("Argument %d: %w", a.pos, err)
+		return time.Time{}
+	}
+	return rv
+}
+
+// Read an Any-Conf-Type, which is either a four-bit Conf-Type or an
+// eight-bit Extended-Conf-Type.
+func (a *Args) ConfType() types.ExtendedConfType {
+	tok, ok := a.next()
+	if !ok {
+		return types.ExtendedConfType{}
+	}
+	if (len(tok) != 4 && len(tok) != 8) || strings.Trim(tok, "01") != "" {
+		a.err = fmt.Errorf("Argument %d: %q is not a conference type", a.pos, tok)
+		return types.ExtendedConfType{}
+	}
+	return types.ReadExtendedConfType(strings.NewReader((tok + "0000")[:8]))
+}
+
+// Read a Priv-Bits.
+func (a *Args) PrivBits() types.PrivBits {
+	return types.ReadPrivBits(strings.NewReader(a.Bits(16)))
+}
+
+// Read a Personal-Flags.
+func (a *Args) PersonalFlags() types.PersonalFlags {
+	return types.ReadPersonalFlags(strings.NewReader(a.Bits(8)))
+}
+
+// Read an Aux-Item-Flags.
+func (a *Args) AuxItemFlags() types.AuxItemFlags {
+	return types.ReadAuxItemFlags(strings.NewReader(a.Bits(8)))
+}
+
+// Read a Membership-Type.
+func (a *Args) MembershipType() types.MembershipType {
+	return types.ReadMembershipType(strings.NewReader(a.Bits(8)))
+}
+
+// Read an ARRAY INT32.
+func (a *Args) UInt32Array() []uint32 {
+	var rv []uint32
+
+	n, present := a.ArrayStart()
+	if !present {
+		return nil
+	}
+	for ix := uint32(0); ix < n && a.err == nil; ix++ {
+		rv = append(rv, a.UInt32())
+	}
+	a.ArrayEnd()
+
+	return rv
+}
+
+// Read an ARRAY Aux-Item.
+func (a *Args) AuxItems(tc types.TimeCodec) []types.AuxItem {
+	var rv []types.AuxItem
+
+	n, present := a.ArrayStart()
+	if !present {
+		return nil
+	}
+	for ix := uint32(0); ix < n && a.err == nil; ix++ {
+		var item types.AuxItem
+		item.AuxNo = types.AuxNo(a.UInt32())
+		item.Tag = a.UInt32()
+		item.Creator = types.ConfNo(a.UInt32())
+		item.CreatedAt = a.Time(tc)
+		item.Flags = a.AuxItemFlags()
+		item.InheritLimit = a.UInt32()
+		item.SetData(a.String())
+		rv = append(rv, item)
+	}
+	a.ArrayEnd()
+
+	return rv
+}
+
+// Read an ARRAY Aux-Item-Input.
+func (a *Args) AuxItemInputs() []types.AuxItemInput {
+	var rv []types.AuxItemInput
+
+	n, present := a.ArrayStart()
+	if !present {
+		return nil
+	}
+	for ix := uint32(0); ix < n && a.err == nil; ix++ {
+		tag := a.UInt32()
+		flags := a.AuxItemFlags()
+		limit := a.UInt32()
+		item := types.NewAuxItemInput(tag, a.String())
+		item.Flags = flags
+		item.InheritLimit = limit
+		rv = append(rv, item)
+	}
+	a.ArrayEnd()
+
+	return rv
+}
+
+// Read an ARRAY Misc-Info. Decoding stops at the first item with an
+// unknown selector, so the items returned are the ones before it.
+func (a *Args) MiscInfos(tc types.TimeCodec) []types.MiscInfo {
+	var rv []types.MiscInfo
+
+	n, present := a.ArrayStart()
+	if !present {
+		return nil
+	}
+	for ix := uint32(0); ix < n && a.err == nil; ix++ {
+		mi := types.MiscInfo{Selector: a.UInt32()}
+		switch types.InfoType(mi.Selector) {
+		case types.Recipient:
+			mi.Recipient = types.ConfNo(a.UInt32())
+		case types.CCRecipient:
+			mi.CCRecipient = types.ConfNo(a.UInt32())
+		case types.CommentTo:
+			mi.CommentTo = types.TextNo(a.UInt32())
+		case types.CommentIn:
+			mi.CommentedIn = types.TextNo(a.UInt32())
+		case types.FootnoteTo:
+			mi.FootnoteTo = types.TextNo(a.UInt32())
+		case types.FootnoteIn:
+			mi.FootnotedIn = types.TextNo(a.UInt32())
+		case types.LocalNo:
+			mi.LocalNo = types.TextNo(a.UInt32())
+		case types.ReceiveTime:
+			mi.ReceivedAt = a.Time(tc)
+		case types.SentBy:
+			mi.Sender = types.ConfNo(a.UInt32())
+		case types.SentAt:
+			mi.SentAt = a.Time(tc)
+		case types.BCCRecipient:
+			mi.BCCRecipient = types.ConfNo(a.UInt32())
+		default:
+			if a.err == nil {
+				a.err = fmt.Errorf("Argument %d: unknown misc-info selector %d", a.pos, mi.Selector)
+			}
+		}
+		if a.err != nil {
+			return rv
+		}
+		rv = append(rv, mi)
+	}
+	a.ArrayEnd()
+
+	return rv
+}
+
+// Read a Membership.
+func (a *Args) Membership(tc types.TimeCodec) types.Membership {
+	var rv types.Membership
+
+	rv.Position = a.UInt32()
+	rv.LastTimeRead = a.Time(tc)
+	rv.Conference = types.ConfNo(a.UInt32())
+	rv.Priority = a.UInt8()
+
+	n, present := a.ArrayStart()
+	if present {
+		for ix := uint32(0); ix < n && a.err == nil; ix++ {
+			first := types.TextNo(a.UInt32())
+			last := types.TextNo(a.UInt32())
+			rv.ReadRanges = append(rv.ReadRanges, types.ReadRange{FirstRead: first, LastRead: last})
+		}
+		a.ArrayEnd()
+	}
+
+	rv.AddedBy = types.ConfNo(a.UInt32())
+	rv.AddedAt = a.Time(tc)
+	rv.Type = a.MembershipType()
+
+	return rv
+}
+
+// Read a Text-Stat.
+func (a *Args) TextStat(tc types.TimeCodec) types.TextStat {
+	var rv types.TextStat
+
+	rv.CreationTime = a.Time(tc)
+	rv.Author = types.ConfNo(a.UInt32())
+	rv.Lines = a.UInt32()
+	rv.Chars = a.UInt32()
+	rv.Marks = a.UInt16()
+	rv.MiscInfo = a.MiscInfos(tc)
+	rv.AuxItems = a.AuxItems(tc)
+
+	return rv
+}
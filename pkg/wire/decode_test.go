@@ -0,0 +1,105 @@
+package wire
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vatine/komandgo/pkg/types"
+)
+
+func TestDecode(t *testing.T) {
+	when := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	stamp := types.TimeCodec{}.Encode(when)
+
+	item := types.AuxItem{AuxNo: 1, Tag: 1, Creator: 6, CreatedAt: when, Flags: types.AuxItemFlags{Inherit: true}}
+	item.SetData("text/plain")
+	wantStat := types.TextStat{
+		CreationTime: when,
+		Author:       6,
+		Lines:        1,
+		Chars:        3,
+		MiscInfo: []types.MiscInfo{
+			types.RecipientMisc(17),
+			{Selector: uint32(types.LocalNo), LocalNo: 8},
+			{Selector: uint32(types.SentAt), SentAt: when},
+		},
+		AuxItems: []types.AuxItem{item},
+	}
+
+	tokens, err := Tokens(stamp + " 6 1 3 0 3 { 0 17 6 8 9 " + stamp + " } 1 { 1 1 6 " + stamp + " 01000000 0 10Htext/plain }")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	a := NewArgs(tokens)
+	if stat := a.TextStat(types.TimeCodec{}); a.Err() != nil || !a.Done() || !reflect.DeepEqual(stat, wantStat) {
+		t.Errorf("saw %+v/%v, want %+v", stat, a.Err(), wantStat)
+	}
+
+	wantMembership := types.Membership{
+		Position:     2,
+		LastTimeRead: when,
+		Conference:   17,
+		Priority:     255,
+		ReadRanges:   []types.ReadRange{{FirstRead: 1, LastRead: 8}},
+		AddedBy:      6,
+		AddedAt:      when,
+		Type:         types.MembershipType{Passive: true},
+	}
+	tokens, _ = Tokens("2 " + stamp + " 17 255 1 { 1 8 } 6 " + stamp + " 01000000")
+	a = NewArgs(tokens)
+	if m := a.Membership(types.TimeCodec{}); a.Err() != nil || !a.Done() || !reflect.DeepEqual(m, wantMembership) {
+		t.Errorf("saw %+v/%v, want %+v", m, a.Err(), wantMembership)
+	}
+
+	tokens, _ = Tokens("1001 0100 2 { 6 17 } 1 { 1 00000000 0 3Hfoo }")
+	a = NewArgs(tokens)
+	confType, old := a.ConfType(), a.ConfType()
+	numbers, inputs := a.UInt32Array(), a.AuxItemInputs()
+	if a.Err() != nil || !a.Done() {
+		t.Fatalf("unexpected error %v", a.Err())
+	}
+	if want := (types.ExtendedConfType{RdProt: true, LetterBox: true}); confType != want {
+		t.Errorf("saw conference type %+v, want %+v", confType, want)
+	}
+	if want := (types.ExtendedConfType{Original: true}); old != want {
+		t.Errorf("saw old conference type %+v, want %+v", old, want)
+	}
+	if want := []uint32{6, 17}; !reflect.DeepEqual(numbers, want) {
+		t.Errorf("saw %v, want %v", numbers, want)
+	}
+	if len(inputs) != 1 || inputs[0].Tag != 1 || inputs[0].Data() != "foo" {
+		t.Errorf("saw aux item inputs %+v", inputs)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	cases := []struct {
+		msg    string
+		decode func(a *Args)
+	}{
+		{"0 0 25 1 4 124 3 121 0", func(a *Args) { a.Time(types.TimeCodec{}) }},
+		{"0 0 12 1 12 124 3 121 0", func(a *Args) { a.Time(types.TimeCodec{}) }},
+		{"0 0 12", func(a *Args) { a.Time(types.TimeCodec{}) }},
+		{"101", func(a *Args) { a.ConfType() }},
+		{"2 { 0 17 99 4711 }", func(a *Args) { a.MiscInfos(types.TimeCodec{}) }},
+		{"1 { 9 17 }", func(a *Args) { a.MiscInfos(types.TimeCodec{}) }},
+		{"1 { 1 1 6 }", func(a *Args) { a.AuxItems(types.TimeCodec{}) }},
+	}
+
+	for _, c := range cases {
+		tokens, _ := Tokens(c.msg)
+		a := NewArgs(tokens)
+		c.decode(a)
+		if a.Err() == nil {
+			t.Errorf("expected an error decoding %q", c.msg)
+		}
+	}
+
+	// Decoding stops at an unknown selector, returning the items
+	// before it.
+	tokens, _ := Tokens("2 { 0 17 99 4711 }")
+	if misc := NewArgs(tokens).MiscInfos(types.TimeCodec{}); !reflect.DeepEqual(misc, []types.MiscInfo{types.RecipientMisc(17)}) {
+		t.Errorf("saw %+v, want the recipient", misc)
+	}
+}
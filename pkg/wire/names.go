@@ -0,0 +1,261 @@
+package wire
+
+// Names of calls, error codes and asynchronous messages, for showing
+// them to humans.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Call names, by call number.
+var callNames = map[uint32]string{
+	0:   "login-old",
+	1:   "logout",
+	2:   "change-conference",
+	3:   "change-name",
+	4:   "change-what-i-am-doing",
+	5:   "create-person-old",
+	6:   "get-person-stat-old",
+	7:   "set-priv-bits",
+	8:   "set-passwd",
+	9:   "query-read-texts-old",
+	10:  "create-conf-old",
+	11:  "delete-conf",
+	12:  "lookup-name",
+	13:  "get-conf-stat-older",
+	14:  "add-member-old",
+	15:  "sub-member",
+	16:  "set-presentation",
+	17:  "set-etc-motd",
+	18:  "set-supervisor",
+	19:  "set-permitted-submitters",
+	20:  "set-super-conf",
+	21:  "set-conf-type",
+	22:  "set-garb-nice",
+	23:  "get-marks",
+	24:  "mark-text-old",
+	25:  "get-text",
+	26:  "get-text-stat-old",
+	27:  "mark-as-read",
+	28:  "create-text-old",
+	29:  "delete-text",
+	30:  "add-recipient",
+	31:  "sub-recipient",
+	32:  "add-comment",
+	33:  "sub-comment",
+	34:  "get-map",
+	35:  "get-time",
+	36:  "get-info-old",
+	37:  "add-footnote",
+	38:  "sub-footnote",
+	39:  "who-is-on-old",
+	40:  "set-unread",
+	41:  "set-motd-of-lyskom",
+	42:  "enable",
+	43:  "sync-kom",
+	44:  "shutdown-kom",
+	45:  "broadcast",
+	46:  "get-membership-old",
+	47:  "get-created-texts",
+	48:  "get-members-old",
+	49:  "get-person-stat",
+	50:  "get-conf-stat-old",
+	51:  "who-is-on",
+	52:  "get-unread-confs",
+	53:  "send-message",
+	54:  "get-session-info",
+	55:  "disconnect",
+	56:  "who-am-i",
+	57:  "set-user-area",
+	58:  "get-last-text",
+	59:  "create-anonymous-text-old",
+	60:  "find-next-text-no",
+	61:  "find-previous-text-no",
+	62:  "login",
+	63:  "who-is-on-ident",
+	64:  "get-session-info-ident",
+	65:  "re-lookup-person",
+	66:  "re-lookup-conf",
+	67:  "lookup-person",
+	68:  "lookup-conf",
+	69:  "set-client-version",
+	70:  "get-client-name",
+	71:  "get-client-version",
+	72:  "mark-text",
+	73:  "unmark-text",
+	74:  "re-z-lookup",
+	75:  "get-version-info",
+	76:  "lookup-z-name",
+	77:  "set-last-read",
+	78:  "get-uconf-stat",
+	79:  "set-info",
+	80:  "accept-async",
+	81:  "query-async",
+	82:  "user-active",
+	83:  "who-is-on-dynamic",
+	84:  "get-static-session-info",
+	85:  "get-collate-table",
+	86:  "create-text",
+	87:  "create-anonymous-text",
+	88:  "create-conf",
+	89:  "create-person",
+	90:  "get-text-stat",
+	91:  "get-conf-stat",
+	92:  "modify-text-info",
+	93:  "modify-conf-info",
+	94:  "get-info",
+	95:  "modify-system-info",
+	96:  "query-predefined-aux-items",
+	97:  "set-expire",
+	98:  "query-read-texts-10",
+	99:  "get-membership-10",
+	100: "add-member",
+	101: "get-members",
+	102: "set-membership-type",
+	103: "local-to-global",
+	104: "map-created-texts",
+	105: "set-keep-commented",
+	106: "set-pers-flags",
+	107: "query-read-texts",
+	108: "get-membership",
+	109: "mark-as-unread",
+	110: "set-read-ranges",
+	111: "get-stats-description",
+	112: "get-stats",
+	113: "get-boottime-info",
+	114: "first-unused-conf-no",
+	115: "first-unused-text-no",
+	116: "find-next-conf-no",
+	117: "find-previous-conf-no",
+	118: "get-scheduling",
+	119: "set-scheduling",
+	120: "set-connection-time-format",
+	121: "local-to-global-reverse",
+	122: "map-created-texts-reverse",
+}
+
+// Error names, by error code.
+var errorNames = map[uint32]string{
+	0:  "no-error",
+	2:  "not-implemented",
+	3:  "obsolete-call",
+	4:  "invalid-password",
+	5:  "string-too-long",
+	6:  "login-first",
+	7:  "login-disallowed",
+	8:  "conference-zero",
+	9:  "undefined-conference",
+	10: "undefined-person",
+	11: "access-denied",
+	12: "permission-denied",
+	13: "not-member",
+	14: "no-such-text",
+	15: "text-zero",
+	16: "no-such-local-text",
+	17: "local-text-zero",
+	18: "bad-name",
+	19: "index-out-of-range",
+	20: "conference-exists",
+	21: "person-exists",
+	22: "secret-public",
+	23: "letterbox",
+	24: "ldb-error",
+	25: "illegal-misc",
+	26: "illegal-info-type",
+	27: "already-recipient",
+	28: "already-comment",
+	29: "already-footnote",
+	30: "not-recipient",
+	31: "not-comment",
+	32: "not-footnote",
+	33: "recipient-limit",
+	34: "comment-limit",
+	35: "footnote-limit",
+	36: "mark-limit",
+	37: "not-author",
+	38: "no-connect",
+	39: "out-of-memory",
+	40: "server-is-crazy",
+	41: "client-is-crazy",
+	42: "undefined-session",
+	43: "regexp-error",
+	44: "not-marked",
+	45: "temporary-failure",
+	46: "long-array",
+	47: "anonymous-rejected",
+	48: "illegal-aux-item",
+	49: "aux-item-permission",
+	50: "unknown-async",
+	51: "internal-error",
+	52: "feature-disabled",
+	53: "message-not-sent",
+	54: "invalid-membership-type",
+	55: "invalid-range",
+	56: "invalid-range-list",
+	57: "undefined-measurement",
+	58: "priority-denied",
+	59: "weight-denied",
+	60: "weight-zero",
+	61: "bad-bool",
+}
+
+// Asynchronous message names, by message number.
+var asyncNames = map[uint32]string{
+	0:  "async-new-text-old",
+	5:  "async-new-name",
+	6:  "async-i-am-on",
+	7:  "async-sync-db",
+	8:  "async-leave-conf",
+	9:  "async-login",
+	11: "async-rejected-connection",
+	12: "async-send-message",
+	13: "async-logout",
+	14: "async-deleted-text",
+	15: "async-new-text",
+	16: "async-new-recipient",
+	17: "async-sub-recipient",
+	18: "async-new-membership",
+	19: "async-new-user-area",
+	20: "async-new-presentation",
+	21: "async-new-motd",
+	22: "async-text-aux-changed",
+}
+
+func name(names map[uint32]string, no uint32) string {
+	if n, ok := names[no]; ok {
+		return n
+	}
+	return fmt.Sprintf("unknown-%d", no)
+}
+
+// Return the name of a call, such as "get-text-stat" for 90.
+func CallName(call uint32) string {
+	return name(callNames, call)
+}
+
+// Return the name of an error code, such as "no-such-text" for 14.
+func ErrorName(code uint32) string {
+	return name(errorNames, code)
+}
+
+// Return the name of an asynchronous message, such as "async-login"
+// for 9.
+func AsyncName(msgNo uint32) string {
+	return name(asyncNames, msgNo)
+}
+
+// Return the number of a call, given either its name or its number.
+func CallNumber(s string) (uint32, bool) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), true
+	}
+	s = strings.ToLower(strings.TrimSpace(s))
+	for no, n := range callNames {
+		if n == s {
+			return no, true
+		}
+	}
+	return 0, false
+}
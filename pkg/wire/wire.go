@@ -0,0 +1,355 @@
+// Reading and tokenising Protocol A messages, shared by the servers,
+// proxies and test tools that need to look at the raw protocol.
+package wire
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Read a message up to the terminating newline, without stopping at
+// newlines inside Hollerith strings. The newline is not included.
+func ReadMessage(r *bufio.Reader) (string, error) {
+	var b []byte
+	n := 0
+	inNumber := false
+
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return string(b), err
+		}
+		switch {
+		case c == '\n':
+			return string(b), nil
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			inNumber = true
+			b = append(b, c)
+		case c == 'H' && inNumber:
+			b = append(b, c)
+			for ; n > 0; n-- {
+				c, err := r.ReadByte()
+				if err != nil {
+					return string(b), err
+				}
+				b = append(b, c)
+			}
+			inNumber = false
+		default:
+			n = 0
+			inNumber = false
+			b = append(b, c)
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// Split a message into tokens on whitespace. Hollerith strings are
+// decoded into a single token, and array braces are tokens of their
+// own.
+func Tokens(msg string) ([]string, error) {
+	var rv []string
+
+	ix := 0
+	for ix < len(msg) {
+		switch c := msg[ix]; {
+		case isSpace(c):
+			ix++
+		case c >= '0' && c <= '9':
+			start := ix
+			n := 0
+			for ix < len(msg) && msg[ix] >= '0' && msg[ix] <= '9' {
+				if n <= len(msg) {
+					n = n*10 + int(msg[ix]-'0')
+				}
+				ix++
+			}
+			if ix < len(msg) && msg[ix] == 'H' {
+				ix++
+				if n > len(msg)-ix {
+					return rv, fmt.Errorf("Hollerith string at %d is truncated", start)
+				}
+				rv = append(rv, msg[ix:ix+n])
+				ix += n
+				continue
+			}
+			for ix < len(msg) && !isSpace(msg[ix]) {
+				ix++
+			}
+			rv = append(rv, msg[start:ix])
+		default:
+			start := ix
+			for ix < len(msg) && !isSpace(msg[ix]) {
+				ix++
+			}
+			rv = append(rv, msg[start:ix])
+		}
+	}
+
+	return rv, nil
+}
+
+// A request, as sent by a client: "<id> <call> <args>". Args holds the
+// tokens after the call number.
+type Request struct {
+	ID   uint32
+	Call uint32
+	Args []string
+	Raw  string
+}
+
+// Parse a request.
+func ParseRequest(msg string) (Request, error) {
+	rv := Request{Raw: msg}
+
+	tokens, err := Tokens(msg)
+	if err != nil {
+		return rv, err
+	}
+	if len(tokens) < 2 {
+		return rv, fmt.Errorf("Request %q too short", msg)
+	}
+	id, err := strconv.ParseUint(tokens[0], 10, 32)
+	if err != nil {
+		return rv, fmt.Errorf("Bad request id %q", tokens[0])
+	}
+	call, err := strconv.ParseUint(tokens[1], 10, 32)
+	if err != nil {
+		return rv, fmt.Errorf("Bad call number %q", tokens[1])
+	}
+	rv.ID = uint32(id)
+	rv.Call = uint32(call)
+	rv.Args = tokens[2:]
+
+	return rv, nil
+}
+
+// The kinds of messages a server sends.
+type ReplyKind byte
+
+// ReplyProtocolError is not sent as such, it stands for "%%", sent
+// when the server could not parse a request.
+const (
+	ReplyOK            = ReplyKind('=')
+	ReplyError         = ReplyKind('%')
+	ReplyAsync         = ReplyKind(':')
+	ReplyProtocolError = ReplyKind('#')
+)
+
+// A reply, or asynchronous message, as sent by a server. For OK
+// replies, Args holds the tokens after the request id. For errors,
+// Code and Status hold the error code and status. For asynchronous
+// messages, Code holds the message number and Args its arguments.
+// Protocol errors ("%% text") have no id, and the text in Args.
+type Reply struct {
+	Kind   ReplyKind
+	ID     uint32
+	Code   uint32
+	Status uint32
+	Args   []string
+	Raw    string
+}
+
+// Parse a reply or asynchronous message.
+func ParseReply(msg string) (Reply, error) {
+	rv := Reply{Raw: msg}
+
+	if strings.HasPrefix(msg, "%%") {
+		rv.Kind = ReplyProtocolError
+		rv.Args = []string{strings.TrimSpace(msg[2:])}
+		return rv, nil
+	}
+	if msg == "" {
+		return rv, fmt.Errorf("Empty reply")
+	}
+
+	rv.Kind = ReplyKind(msg[0])
+	tokens, err := Tokens(msg[1:])
+	if err != nil {
+		return rv, err
+	}
+	a := NewArgs(tokens)
+
+	switch rv.Kind {
+	case ReplyOK:
+		rv.ID = a.UInt32()
+		rv.Args = tokens[a.pos:]
+	case ReplyError:
+		rv.ID = a.UInt32()
+		rv.Code = a.UInt32()
+		rv.Status = a.UInt32()
+	case ReplyAsync:
+		a.UInt32() // number of arguments
+		rv.Code = a.UInt32()
+		rv.Args = tokens[a.pos:]
+	default:
+		return rv, fmt.Errorf("Unknown reply %q", msg)
+	}
+
+	if a.Err() != nil {
+		return rv, fmt.Errorf("Malformed reply %q: %w", msg, a.Err())
+	}
+	return rv, nil
+}
+
+// Return a message with Hollerith strings shown as quoted Go strings,
+// and the rest as is, for showing it to humans.
+func Format(msg string) string {
+	var b strings.Builder
+
+	ix := 0
+	for ix < len(msg) {
+		c := msg[ix]
+		if c < '0' || c > '9' || (ix > 0 && !isSpace(msg[ix-1])) {
+			b.WriteByte(c)
+			ix++
+			continue
+		}
+
+		start := ix
+		n := 0
+		for ix < len(msg) && msg[ix] >= '0' && msg[ix] <= '9' {
+			if n <= len(msg) {
+				n = n*10 + int(msg[ix]-'0')
+			}
+			ix++
+		}
+		if ix < len(msg) && msg[ix] == 'H' && n <= len(msg)-ix-1 {
+			ix++
+			b.WriteString(strconv.Quote(msg[ix : ix+n]))
+			ix += n
+			continue
+		}
+		b.WriteString(msg[start:ix])
+	}
+
+	return b.String()
+}
+
+// Args decodes the arguments of a request, token by token. The first
+// error is remembered and all later reads return zero values, so a
+// call can read all its arguments and check Err once at the end.
+type Args struct {
+	tokens []string
+	pos    int
+	err    error
+}
+
+// Return a decoder for a list of tokens.
+func NewArgs(tokens []string) *Args {
+	return &Args{tokens: tokens}
+}
+
+func (a *Args) next() (string, bool) {
+	if a.err != nil {
+		return "", false
+	}
+	if a.pos >= len(a.tokens) {
+		a.err = fmt.Errorf("Too few arguments, expected more than %d", len(a.tokens))
+		return "", false
+	}
+	a.pos++
+	return a.tokens[a.pos-1], true
+}
+
+func (a *Args) uint(bits int) uint64 {
+	tok, ok := a.next()
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(tok, 10, bits)
+	if err != nil {
+		a.err = fmt.Errorf("Argument %d: %q is not a %d-bit number", a.pos, tok, bits)
+		return 0
+	}
+	return n
+}
+
+// Read an INT32.
+func (a *Args) UInt32() uint32 {
+	return uint32(a.uint(32))
+}
+
+// Read an INT16.
+func (a *Args) UInt16() uint16 {
+	return uint16(a.uint(16))
+}
+
+// Read an INT8.
+func (a *Args) UInt8() uint8 {
+	return uint8(a.uint(8))
+}
+
+// Read a BOOL.
+func (a *Args) Bool() bool {
+	return a.uint(1) != 0
+}
+
+// Read a string. Hollerith strings have already been decoded by
+// Tokens, so this is any single token.
+func (a *Args) String() string {
+	tok, _ := a.next()
+	return tok
+}
+
+// Read a BITSTRING of a given length, returning the bits as a string
+// of '0' and '1'.
+func (a *Args) Bits(n int) string {
+	tok, ok := a.next()
+	if !ok {
+		return ""
+	}
+	if len(tok) != n {
+		a.err = fmt.Errorf("Argument %d: %q is not a %d-bit bitstring", a.pos, tok, n)
+		return ""
+	}
+	for ix := 0; ix < len(tok); ix++ {
+		if tok[ix] != '0' && tok[ix] != '1' {
+			a.err = fmt.Errorf("Argument %d: %q is not a bitstring", a.pos, tok)
+			return ""
+		}
+	}
+	return tok
+}
+
+// Read the start of an array, returning the number of elements and
+// whether the elements follow (rather than "*").
+func (a *Args) ArrayStart() (uint32, bool) {
+	n := a.UInt32()
+	tok, ok := a.next()
+	if !ok {
+		return 0, false
+	}
+	switch tok {
+	case "{":
+		return n, true
+	case "*":
+		return n, false
+	}
+	a.err = fmt.Errorf("Argument %d: expected array start, saw %q", a.pos, tok)
+	return 0, false
+}
+
+// Read the end of an array.
+func (a *Args) ArrayEnd() {
+	tok, ok := a.next()
+	if ok && tok != "}" {
+		a.err = fmt.Errorf("Argument %d: expected array end, saw %q", a.pos, tok)
+	}
+}
+
+// Return true if all tokens have been read.
+func (a *Args) Done() bool {
+	return a.pos >= len(a.tokens)
+}
+
+// The first error seen while decoding, if any.
+func (a *Args) Err() error {
+	return a.err
+}
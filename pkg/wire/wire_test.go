@@ -0,0 +1,166 @@
+package wire
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	cases := []struct {
+		msg  string
+		want []string
+	}{
+		{"1 82", []string{"1", "82"}},
+		{"3 62 6 6Hsecret 0", []string{"3", "62", "6", "secret", "0"}},
+		{"4 86 10HHej\nhopp!! 0 { } 1 { 1 00000000 0 0H }", []string{"4", "86", "Hej\nhopp!!", "0", "{", "}", "1", "{", "1", "00000000", "0", "", "}"}},
+	}
+
+	for ix, c := range cases {
+		saw, err := Tokens(c.msg)
+		if err != nil || !reflect.DeepEqual(saw, c.want) {
+			t.Errorf("Case #%d, saw %q/%v, want %q", ix, saw, err, c.want)
+		}
+	}
+
+	if _, err := Tokens("1 4 10Hshort"); err == nil {
+		t.Errorf("expected an error for a truncated Hollerith string")
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("1 4 5Hab\ncd\n2 82\n"))
+	for _, want := range []string{"1 4 5Hab\ncd", "2 82"} {
+		saw, err := ReadMessage(r)
+		if err != nil || saw != want {
+			t.Errorf("saw %q/%v, want %q", saw, err, want)
+		}
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	req, err := ParseRequest("17 62 6 6Hsecret 0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if req.ID != 17 || req.Call != 62 || !reflect.DeepEqual(req.Args, []string{"6", "secret", "0"}) {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	for _, msg := range []string{"17", "x 62", "17 y"} {
+		if _, err := ParseRequest(msg); err == nil {
+			t.Errorf("expected an error parsing %q", msg)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	tokens, _ := Tokens("4711 1 3Hfoo 10000000 2 { 1 5 } 0 *")
+	a := NewArgs(tokens)
+
+	if n := a.UInt32(); n != 4711 {
+		t.Errorf("saw %d, want 4711", n)
+	}
+	if !a.Bool() {
+		t.Errorf("expected true")
+	}
+	if s := a.String(); s != "foo" {
+		t.Errorf("saw %q, want foo", s)
+	}
+	if bits := a.Bits(8); bits != "10000000" {
+		t.Errorf("saw %q, want 10000000", bits)
+	}
+	n, present := a.ArrayStart()
+	if n != 2 || !present {
+		t.Errorf("saw %d/%v, want 2 elements", n, present)
+	}
+	a.UInt16()
+	a.UInt16()
+	a.ArrayEnd()
+	if n, present := a.ArrayStart(); n != 0 || present {
+		t.Errorf("saw %d/%v, want an empty array", n, present)
+	}
+	if err := a.Err(); err != nil || !a.Done() {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	a.UInt32()
+	if a.Err() == nil {
+		t.Errorf("expected an error reading past the end")
+	}
+
+	a = NewArgs([]string{"70000", "12"})
+	a.UInt16()
+	if a.Err() == nil {
+		t.Errorf("expected an error for a too large INT16")
+	}
+	if n := a.UInt32(); n != 0 {
+		t.Errorf("saw %d after an error, want 0", n)
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	cases := []struct {
+		msg  string
+		want Reply
+	}{
+		{"=5", Reply{Kind: ReplyOK, ID: 5, Args: []string{}}},
+		{"=6 1 { 6HIngrid 1001 6 }", Reply{Kind: ReplyOK, ID: 6, Args: []string{"1", "{", "Ingrid", "1001", "6", "}"}}},
+		{"%7 14 4711", Reply{Kind: ReplyError, ID: 7, Code: 14, Status: 4711}},
+		{":2 9 6 17", Reply{Kind: ReplyAsync, Code: 9, Args: []string{"6", "17"}}},
+		{"%% Bad request id", Reply{Kind: ReplyProtocolError, Args: []string{"Bad request id"}}},
+	}
+
+	for ix, c := range cases {
+		c.want.Raw = c.msg
+		saw, err := ParseReply(c.msg)
+		if err != nil || !reflect.DeepEqual(saw, c.want) {
+			t.Errorf("Case #%d, saw %+v/%v, want %+v", ix, saw, err, c.want)
+		}
+	}
+
+	for _, msg := range []string{"", "%7 14", "!5", "=x"} {
+		if _, err := ParseReply(msg); err == nil {
+			t.Errorf("expected an error parsing %q", msg)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		msg  string
+		want string
+	}{
+		{"1 82", "1 82"},
+		{"3 62 6 6Hsecret 0", `3 62 6 "secret" 0`},
+		{"=4 10HHej\nhopp!! 0 { }", `=4 "Hej\nhopp!!" 0 { }`},
+		{"5 4 10Hshort", "5 4 10Hshort"},
+	}
+
+	for ix, c := range cases {
+		if saw := Format(c.msg); saw != c.want {
+			t.Errorf("Case #%d, saw %q, want %q", ix, saw, c.want)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	if saw := CallName(90); saw != "get-text-stat" {
+		t.Errorf("saw %q, want get-text-stat", saw)
+	}
+	if saw := ErrorName(14); saw != "no-such-text" {
+		t.Errorf("saw %q, want no-such-text", saw)
+	}
+	if saw := AsyncName(4711); saw != "unknown-4711" {
+		t.Errorf("saw %q, want unknown-4711", saw)
+	}
+	for _, s := range []string{"62", "login", " Login"} {
+		if no, ok := CallNumber(s); !ok || no != 62 {
+			t.Errorf("CallNumber(%q) is %d/%v, want 62", s, no, ok)
+		}
+	}
+	if _, ok := CallNumber("no-such-call"); ok {
+		t.Errorf("expected no call number for an unknown name")
+	}
+}